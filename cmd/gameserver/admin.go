@@ -0,0 +1,626 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// AdminAction is the audit record written for every command an admin runs, including failed logins.
+type AdminAction struct {
+	Admin     string    `json:"admin" bson:"admin"`
+	Address   string    `json:"address" bson:"address"`
+	Command   string    `json:"command" bson:"command"`
+	Arguments []string  `json:"arguments" bson:"arguments"`
+	Success   bool      `json:"success" bson:"success"`
+	Result    string    `json:"result" bson:"result"`
+	At        time.Time `json:"at" bson:"at"`
+}
+
+// the admin console only listens on loopback, reach it through an ssh tunnel
+var ADMIN_ADDRESS = "127.0.0.1:20002"
+
+// admins and their tokens, e.g. COGO_ADMINS="alice:long-random-token,bob:other-token"
+var ADMIN_TOKENS_ENV = "COGO_ADMINS"
+var ADMIN_AUTH_ATTEMPTS = 3
+var ADMIN_IDLE_TIMEOUT = 15 * time.Minute
+
+// how many copies of an item one grant-item or revoke-item may move
+var ADMIN_MAX_ITEM_COUNT = 1000
+
+var adminTokens = loadAdminTokens(os.Getenv(ADMIN_TOKENS_ENV))
+
+// adminAuditLog persists an audit record, tests swap it out to run commands without a database.
+var adminAuditLog = saveAdminAction
+
+var errAdminUsage = errors.New("wrong arguments")
+
+var ADMIN_COMMANDS = map[string]string{
+	"players":           "players",
+	"profile":           "profile <player>",
+	"grant-item":        "grant-item <player> <itemID> [count]",
+	"revoke-item":       "revoke-item <player> <itemID> [count]",
+	"grant-bits":        "grant-bits <player> <amount>",
+	"revoke-bits":       "revoke-bits <player> <amount>",
+	"grant-exp":         "grant-exp <player> <amount>",
+	"revoke-exp":        "revoke-exp <player> <amount>",
+	"teleport":          "teleport <player> <regionID> <levelID> [x y z]",
+	"kick":              "kick <player> <reason>",
+	"mute":              "mute <player> <duration> <reason>",
+	"suspend":           "suspend <player> <duration> <reason>",
+	"ban":               "ban <player> <reason>",
+	"unban":             "unban <player> <note>",
+	"ban-ip":            "ban-ip <ip> <duration|permanent> <reason>",
+	"unban-ip":          "unban-ip <ip> <note>",
+	"history":           "history <player>",
+	"appeal-note":       "appeal-note <sanctionID> <note>",
+	"shopkeeper-create": "shopkeeper-create <npcID> [bits]",
+	"shopkeeper-purse":  "shopkeeper-purse <npcID> <bits>",
+	"catalogue-add":     "catalogue-add <npcID> <itemID> <price>",
+	"catalogue-price":   "catalogue-price <npcID> <entryID> <price>",
+	"catalogue-remove":  "catalogue-remove <npcID> <entryID>",
+	"reload":            "reload",
+	"broadcast":         "broadcast <message>",
+	"help":              "help",
+	"quit":              "quit",
+}
+
+// loadAdminTokens keeps only a hash of every token so they never sit in memory in the clear.
+func loadAdminTokens(spec string) map[string][32]byte {
+	tokens := make(map[string][32]byte)
+	for _, entry := range strings.Split(spec, ",") {
+		name, token, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if !found || name == "" || token == "" {
+			continue
+		}
+		tokens[name] = sha256.Sum256([]byte(token))
+	}
+	return tokens
+}
+func authenticateAdmin(name string, token string) bool {
+	expected, found := adminTokens[name]
+	provided := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(expected[:], provided[:]) == 1 && found
+}
+func adminListener(address string, mongoClient *mongo.Client) {
+	listenerConnection, err := net.Listen("tcp", address)
+	if err != nil {
+		fmt.Println(Failure(err))
+		return
+	}
+	defer listenerConnection.Close()
+	fmt.Println(Success("Admin console listening on ", address))
+	for {
+		adminConnection, err := listenerConnection.Accept()
+		if err != nil {
+			fmt.Println(Failure(err))
+			return
+		}
+		go handleAdminConnection(adminConnection, mongoClient)
+	}
+}
+
+// handleAdminConnection runs a line based console: "auth <name> <token>" first, then one command
+// per line answered with "OK <result>" or "ERR <reason>".
+func handleAdminConnection(adminConnection net.Conn, mongoClient *mongo.Client) {
+	defer adminConnection.Close()
+	address := adminConnection.RemoteAddr().String()
+	reader := bufio.NewReader(adminConnection)
+	reply := func(ok bool, result string) {
+		status := "OK"
+		if !ok {
+			status = "ERR"
+		}
+		//results are single line so a client can read one reply per line
+		fmt.Fprintf(adminConnection, "%s %s\n", status, strings.ReplaceAll(result, "\n", " "))
+	}
+	admin := ""
+	for attempt := 0; admin == "" && attempt < ADMIN_AUTH_ATTEMPTS; attempt++ {
+		adminConnection.SetReadDeadline(time.Now().Add(ADMIN_IDLE_TIMEOUT))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "auth" && authenticateAdmin(fields[1], fields[2]) {
+			admin = fields[1]
+			break
+		}
+		name := ""
+		if len(fields) > 1 {
+			name = fields[1]
+		}
+		fmt.Println(Warn("Admin authentication failed for ", name, " from ", address))
+		adminAuditLog(AdminAction{Admin: name, Address: address, Command: "auth", Result: "authentication failed", At: time.Now().UTC()}, mongoClient)
+		reply(false, "authentication failed")
+	}
+	if admin == "" {
+		return
+	}
+	fmt.Println(Info("Admin ", admin, " connected from ", address))
+	reply(true, "welcome "+admin)
+	for {
+		adminConnection.SetReadDeadline(time.Now().Add(ADMIN_IDLE_TIMEOUT))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" {
+			reply(true, "bye")
+			return
+		}
+		result, err := runAdminCommand(admin, fields, mongoClient)
+		action := AdminAction{Admin: admin, Address: address, Command: fields[0], Arguments: fields[1:], Success: err == nil, Result: result, At: time.Now().UTC()}
+		if err != nil {
+			action.Result = err.Error()
+		}
+		if fields[0] != "help" && fields[0] != "players" && fields[0] != "profile" && fields[0] != "history" {
+			fmt.Println(Info("Admin ", admin, " ran ", strings.Join(fields, " "), " : ", action.Result))
+		}
+		adminAuditLog(action, mongoClient)
+		reply(err == nil, action.Result)
+	}
+}
+
+// runAdminCommand dispatches one console line. The database helpers panic when MongoDB is
+// unreachable, an admin typo must never take the server down with it.
+func runAdminCommand(admin string, fields []string, mongoClient *mongo.Client) (result string, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result, err = "", fmt.Errorf("command failed : %v", recovered)
+		}
+	}()
+	command, arguments := fields[0], fields[1:]
+	usage, known := ADMIN_COMMANDS[command]
+	if !known {
+		return "", errors.New("unknown command, try help")
+	}
+	// the <angled> arguments in the usage are required, the [bracketed] ones optional
+	if required := strings.Count(usage, "<"); len(arguments) < required {
+		return "", fmt.Errorf("%w, usage : %s", errAdminUsage, usage)
+	}
+	switch command {
+	case "help":
+		commands := make([]string, 0, len(ADMIN_COMMANDS))
+		for _, usage := range ADMIN_COMMANDS {
+			commands = append(commands, usage)
+		}
+		sort.Strings(commands)
+		return strings.Join(commands, " | "), nil
+	case "players":
+		online := getOnlinePlayers()
+		sort.Slice(online, func(i, j int) bool { return online[i].ConnectTime.Before(online[j].ConnectTime) })
+		onlineJSON, _ := json.Marshal(online)
+		return string(onlineJSON), nil
+	case "reload":
+		return reloadMasterTables(mongoClient)
+	case "ban-ip":
+		duration, err := parseSanctionDuration(arguments[1])
+		if err != nil {
+			return "", err
+		}
+		sanction := Sanction{Scope: SCOPE_IP, Target: arguments[0], Reason: strings.Join(arguments[2:], " "), Issuer: admin}
+		return formatSanction(issueSanction(sanction, duration, mongoClient))
+	case "unban-ip":
+		lifted, err := liftSanctions(bson.M{"scope": SCOPE_IP, "target": arguments[0]}, admin, strings.Join(arguments[1:], " "), mongoClient)
+		return fmt.Sprintf("lifted %d sanctions", lifted), err
+	case "appeal-note":
+		sanctionID, err := uuid.Parse(arguments[0])
+		if err != nil {
+			return "", errors.New("invalid sanction id")
+		}
+		if err := addAppealNote(sanctionID, admin, strings.Join(arguments[1:], " "), mongoClient); err != nil {
+			return "", err
+		}
+		return "note added", nil
+	case "broadcast":
+		return broadcastServerMessage(admin, strings.Join(arguments, " ")), nil
+	case "shopkeeper-create", "shopkeeper-purse":
+		bits := 0.0
+		if len(arguments) > 1 {
+			if bits, err = parseAdminAmount(arguments[1]); err != nil {
+				return "", err
+			}
+		}
+		if command == "shopkeeper-create" {
+			return createShopkeeper(arguments[0], bits, mongoClient)
+		}
+		return updateShopkeeper(arguments[0], bson.M{"$set": bson.M{"purse.bits": bits}}, nil, mongoClient)
+	case "catalogue-add":
+		price, err := parseAdminAmount(arguments[2])
+		if err != nil {
+			return "", err
+		}
+		item, found := currentWorld().Items[arguments[1]]
+		if !found {
+			return "", errors.New("unknown item")
+		}
+		entry := ShopItem{Item_uuid: uuid.New(), Item: item, Price: price}
+		return updateShopkeeper(arguments[0], bson.M{"$push": bson.M{"catalogue": entry}}, nil, mongoClient)
+	case "catalogue-price", "catalogue-remove":
+		entryID, err := uuid.Parse(arguments[1])
+		if err != nil {
+			return "", errors.New("invalid catalogue entry id")
+		}
+		if command == "catalogue-remove" {
+			return updateShopkeeper(arguments[0], bson.M{"$pull": bson.M{"catalogue": bson.M{"uuid": entryID}}}, nil, mongoClient)
+		}
+		price, err := parseAdminAmount(arguments[2])
+		if err != nil {
+			return "", err
+		}
+		filters := options.ArrayFilters{Filters: []interface{}{bson.M{"entry.uuid": entryID}}}
+		return updateShopkeeper(arguments[0], bson.M{"$set": bson.M{"catalogue.$[entry].price": price}}, &filters, mongoClient)
+	}
+
+	// everything else acts on a player
+	user, found := resolvePlayer(arguments[0], mongoClient)
+	if !found {
+		return "", errors.New("player does not exist")
+	}
+	accountID := user.Account_id
+	switch command {
+	case "profile":
+		profile, _ := getProfile(accountID, mongoClient)
+		inspection := struct {
+			User    *User             `json:"user"`
+			Profile *Profile          `json:"profile"`
+			Online  *PlayerConnection `json:"online"`
+		}{User: user, Profile: profile}
+		if entry, online := getPlayerConnection(accountID); online {
+			inspection.Online = &entry
+		}
+		//never hand the password hash out, not even to admins
+		inspection.User.Password = ""
+		inspectionJSON, _ := json.Marshal(inspection)
+		return string(inspectionJSON), nil
+	case "grant-item", "revoke-item":
+		count := 1
+		if len(arguments) > 2 {
+			if count, err = parseAdminCount(arguments[2]); err != nil {
+				return "", err
+			}
+		}
+		if command == "grant-item" {
+			return adminGrantItem(accountID, arguments[1], count, mongoClient)
+		}
+		return adminRevokeItem(accountID, arguments[1], count, mongoClient)
+	case "grant-bits", "revoke-bits", "grant-exp", "revoke-exp":
+		amount, err := parseAdminAmount(arguments[1])
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(command, "revoke") {
+			amount = -amount
+		}
+		if strings.HasSuffix(command, "bits") {
+			return adminAdjustBits(accountID, amount, mongoClient)
+		}
+		return adminAdjustEXP(accountID, amount, mongoClient)
+	case "teleport":
+		var position Position
+		if len(arguments) >= 6 {
+			coordinates := make([]float64, 3)
+			for i := range coordinates {
+				if coordinates[i], err = strconv.ParseFloat(arguments[3+i], 64); err != nil {
+					return "", errors.New("invalid position")
+				}
+			}
+			position = Position{Position_x: coordinates[0], Position_y: coordinates[1], Position_z: coordinates[2]}
+		}
+		return adminTeleport(accountID, arguments[1], arguments[2], position, mongoClient)
+	case "kick":
+		if !kickPlayer(accountID, strings.Join(arguments[1:], " ")) {
+			return "", errPlayerOffline
+		}
+		return "kicked " + user.User_id, nil
+	case "mute":
+		duration, err := time.ParseDuration(arguments[1])
+		if err != nil || duration <= 0 {
+			return "", errors.New("invalid duration")
+		}
+		if !mutePlayer(accountID, duration, strings.Join(arguments[2:], " "), mongoClient) {
+			return "", errors.New("could not save mute")
+		}
+		return "muted " + user.User_id + " for " + duration.String(), nil
+	case "suspend", "ban":
+		duration := time.Duration(0)
+		reason := arguments[1:]
+		if command == "suspend" {
+			if duration, err = time.ParseDuration(arguments[1]); err != nil || duration <= 0 {
+				return "", errors.New("invalid duration")
+			}
+			reason = arguments[2:]
+		}
+		sanction := Sanction{Scope: SCOPE_ACCOUNT, Account_id: accountID, Target: user.User_id, Reason: strings.Join(reason, " "), Issuer: admin}
+		return formatSanction(issueSanction(sanction, duration, mongoClient))
+	case "unban":
+		lifted, err := liftSanctions(bson.M{"scope": SCOPE_ACCOUNT, "uuid": accountID}, admin, strings.Join(arguments[1:], " "), mongoClient)
+		return fmt.Sprintf("lifted %d sanctions", lifted), err
+	case "history":
+		historyJSON, _ := json.Marshal(getModerationHistory(accountID, mongoClient))
+		return string(historyJSON), nil
+	}
+	return "", errors.New("unknown command, try help")
+}
+func formatSanction(sanction Sanction, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	sanctionJSON, _ := json.Marshal(sanction)
+	return string(sanctionJSON), nil
+}
+func parseAdminCount(countSTR string) (int, error) {
+	count, err := strconv.Atoi(countSTR)
+	if err != nil || count < 1 || count > ADMIN_MAX_ITEM_COUNT {
+		return 0, errors.New("invalid count")
+	}
+	return count, nil
+}
+func parseAdminAmount(amountSTR string) (float64, error) {
+	amount, err := strconv.ParseFloat(amountSTR, 64)
+	if err != nil || amount < 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0, errors.New("invalid amount")
+	}
+	return amount, nil
+}
+
+// resolvePlayer finds an account by username or by account id.
+func resolvePlayer(target string, mongoClient *mongo.Client) (*User, bool) {
+	if accountID, err := uuid.Parse(target); err == nil {
+		user, found := getUsersByAccount([]uuid.UUID{accountID}, mongoClient)[accountID]
+		return user, found
+	}
+	return getUser(target, mongoClient)
+}
+func saveAdminAction(action AdminAction, mongoClient *mongo.Client) {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		fmt.Println(Failure("Admin action was not recorded : ", err))
+		return
+	}
+	if _, err := mongoClient.Database("player").Collection("admin_log").InsertOne(cxt, action); err != nil {
+		fmt.Println(Failure("Admin action was not recorded : ", err))
+	}
+}
+func adminGrantItem(accountID uuid.UUID, itemID string, count int, mongoClient *mongo.Client) (string, error) {
+	if _, found := currentWorld().Items[itemID]; !found {
+		return "", errors.New("unknown item")
+	}
+	items := make([]string, count)
+	for i := range items {
+		items[i] = itemID
+	}
+	change := bson.M{"$push": bson.M{"items.collection": bson.M{"$each": items}}}
+	if err := updateProfile(bson.M{"uuid": accountID}, change, mongoClient); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("granted %d %s", count, itemID), nil
+}
+func adminRevokeItem(accountID uuid.UUID, itemID string, count int, mongoClient *mongo.Client) (string, error) {
+	for attempt := 0; attempt < REWARD_RETRY_LIMIT; attempt++ {
+		profile, _ := getProfile(accountID, mongoClient)
+		if profile == nil {
+			return "", errors.New("profile does not exist")
+		}
+		collection, removed := removeItemsFromCollection(profile.Items.Collection, map[string]int{itemID: count})
+		if !removed {
+			return "", fmt.Errorf("player has fewer than %d %s", count, itemID)
+		}
+		match := bson.M{"uuid": accountID, "items.collection": profile.Items.Collection}
+		err := updateProfile(match, bson.M{"$set": bson.M{"items.collection": collection}}, mongoClient)
+		if err == nil {
+			return fmt.Sprintf("revoked %d %s", count, itemID), nil
+		}
+		if err != errDocumentChanged {
+			return "", err
+		}
+	}
+	return "", errors.New("profile kept changing, try again")
+}
+
+// adminAdjustBits adds delta bits, a revoke never takes the purse below zero.
+func adminAdjustBits(accountID uuid.UUID, delta float64, mongoClient *mongo.Client) (string, error) {
+	match := bson.M{"uuid": accountID}
+	if delta < 0 {
+		match["purse.bits"] = bson.M{"$gte": -delta}
+	}
+	if err := updateProfile(match, bson.M{"$inc": bson.M{"purse.bits": delta}}, mongoClient); err == errDocumentChanged {
+		return "", errors.New("player does not have that many bits")
+	} else if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("adjusted bits by %v", delta), nil
+}
+
+// adminAdjustEXP grants EXP the way battles do, levelling up as needed. Revoking only takes EXP
+// off the current level and never levels a player down.
+func adminAdjustEXP(accountID uuid.UUID, delta float64, mongoClient *mongo.Client) (string, error) {
+	for attempt := 0; attempt < REWARD_RETRY_LIMIT; attempt++ {
+		profile, _ := getProfile(accountID, mongoClient)
+		if profile == nil {
+			return "", errors.New("profile does not exist")
+		}
+		var progress interface{}
+		if delta >= 0 {
+			progress, _ = calculateEXPProgress(profile, delta)
+		} else {
+			revoked := -delta
+			if revoked > profile.Current_EXP {
+				revoked = profile.Current_EXP
+			}
+			progress = bson.M{"current_exp": profile.Current_EXP - revoked, "total_exp": profile.Total_EXP - revoked}
+		}
+		err := updateProfile(bson.M{"uuid": accountID, "total_exp": profile.Total_EXP}, bson.M{"$set": progress}, mongoClient)
+		if err == nil {
+			return fmt.Sprintf("adjusted EXP by %v", delta), nil
+		}
+		if err != errDocumentChanged {
+			return "", err
+		}
+	}
+	return "", errors.New("profile kept changing, try again")
+}
+
+// updateProfile applies one change to a profile and reports errDocumentChanged when match found nothing.
+func updateProfile(match bson.M, change bson.M, mongoClient *mongo.Client) error {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	updateResponse, err := mongoClient.Database("player").Collection("profiles").UpdateOne(cxt, match, change)
+	if err != nil {
+		return err
+	}
+	if updateResponse.MatchedCount != 1 {
+		return errDocumentChanged
+	}
+	return nil
+}
+
+// adminTeleport moves a player anywhere, online players are sent the new region like after travelling.
+func adminTeleport(accountID uuid.UUID, regionID string, levelID string, position Position, mongoClient *mongo.Client) (string, error) {
+	if _, found := currentWorld().Levels[levelID]; !found {
+		return "", errors.New("unknown level")
+	}
+	profile, _ := getProfile(accountID, mongoClient)
+	if profile == nil {
+		return "", errors.New("profile does not exist")
+	}
+	change := bson.M{"$set": bson.M{"last_level": levelID, "last_region": regionID, "last_position": position}}
+	if err := updateProfile(bson.M{"uuid": accountID}, change, mongoClient); err != nil {
+		return "", err
+	}
+	if _, online := getPlayerConnection(accountID); online {
+		setPlayerPosition(accountID, position)
+		setPresenceLevel(accountID, levelID, mongoClient)
+		broadcastPosition(accountID, levelID, position)
+		notifyLevelRoom(profile.LastLevel, accountID, "LEFT")
+		notifyLevelRoom(levelID, accountID, "ENTERED")
+		result := TravelResult{Position: position, Region: getRegionData(regionID, levelID, mongoClient)}
+		resultJSON, _ := json.Marshal(result)
+		Push(accountID, createSimpleDeliveryPacket(uuid.New().String(), "TV#", "TRAVEL", "TRAVEL$1;"+string(resultJSON)))
+	}
+	return "teleported to " + levelID, nil
+}
+
+// kickPlayer tells the player why and closes their connection once the notice is flushed.
+func kickPlayer(accountID uuid.UUID, reason string) bool {
+	entry, online := getPlayerConnection(accountID)
+	if !online {
+		return false
+	}
+	Push(accountID, createSimpleDeliveryPacket(uuid.New().String(), "KK#", "KICK", "KICK$1;"+reason))
+	entry.Connection.Close()
+	return true
+}
+
+// broadcastServerMessage shows a system chat message to everyone online.
+func broadcastServerMessage(admin string, message string) string {
+	chatMessage := ChatMessage{Message_id: uuid.New(), Channel: "system", Sender: "Server", Content: message, Sent_at: time.Now().UTC()}
+	messageJSON, _ := json.Marshal(chatMessage)
+	delivered := 0
+	for _, player := range getOnlinePlayers() {
+		if Push(player.Account_id, createSimpleDeliveryPacket(uuid.New().String(), "CM#", "CHAT", string(messageJSON))) == nil {
+			delivered++
+		}
+	}
+	return fmt.Sprintf("delivered to %d players", delivered)
+}
+func createShopkeeper(npcID string, bits float64, mongoClient *mongo.Client) (string, error) {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	if _, exists := getShopkeeper(npcID); exists {
+		return "", errors.New("shopkeeper already exists")
+	}
+	shopkeeper := ShopKeeper{NpcID: npcID, Catalogue: []ShopItem{}, Purse: Purse{Bits: bits}}
+	if _, err := mongoClient.Database("world").Collection("shopkeepers").InsertOne(cxt, shopkeeper); err != nil {
+		return "", err
+	}
+	cacheShopkeeper(shopkeeper)
+	return "created shopkeeper " + npcID, nil
+}
+
+// updateShopkeeper applies a change to a shopkeeper and caches the result, so players see it on their next SH#.
+func updateShopkeeper(npcID string, change bson.M, arrayFilters *options.ArrayFilters, mongoClient *mongo.Client) (string, error) {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if arrayFilters != nil {
+		updateOptions.SetArrayFilters(*arrayFilters)
+	}
+	var shopkeeper ShopKeeper
+	err := mongoClient.Database("world").Collection("shopkeepers").FindOneAndUpdate(cxt, bson.M{"npcID": npcID}, change, updateOptions).Decode(&shopkeeper)
+	if err == mongo.ErrNoDocuments {
+		return "", errors.New("shopkeeper does not exist")
+	}
+	if err != nil {
+		return "", err
+	}
+	cacheShopkeeper(shopkeeper)
+	shopkeeperJSON, _ := json.Marshal(shopkeeper)
+	return string(shopkeeperJSON), nil
+}
+
+// cacheShopkeeper swaps in a copy of the shopkeeper table rather than writing to the one handlers read.
+func cacheShopkeeper(shopkeeper ShopKeeper) {
+	ALLshopkeepersMutex.Lock()
+	defer ALLshopkeepersMutex.Unlock()
+	table := make(map[string]ShopKeeper, len(ALLshopkeepers)+1)
+	for npcID, entry := range ALLshopkeepers {
+		table[npcID] = entry
+	}
+	table[shopkeeper.NpcID] = shopkeeper
+	ALLshopkeepers = table
+}
+func getShopkeeper(npcID string) (ShopKeeper, bool) {
+	ALLshopkeepersMutex.RLock()
+	defer ALLshopkeepersMutex.RUnlock()
+	shopkeeper, found := ALLshopkeepers[npcID]
+	return shopkeeper, found
+}
+
+// reloadMasterTables swaps in fresh world data and shopkeepers. A failed world data load keeps
+// serving the previous version.
+func reloadMasterTables(mongoClient *mongo.Client) (string, error) {
+	previous := currentWorld().Version
+	world, changed, err := reloadWorldData(mongoClient)
+	if err != nil {
+		return "", err
+	}
+	shopkeepers := getShopkeepersGlobalAndCache(mongoClient)
+	version := "version " + world.Version + " unchanged"
+	if changed {
+		version = "version " + previous + " -> " + world.Version
+	}
+	return fmt.Sprintf("%s, %s, %d shopkeepers", version, world.Summary(), len(shopkeepers)), nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func startAdminConsole(t *testing.T) (net.Conn, *bufio.Reader, *[]AdminAction) {
+	tokens, auditLog := adminTokens, adminAuditLog
+	adminTokens = loadAdminTokens("alice:secret-token")
+	var actions []AdminAction
+	adminAuditLog = func(action AdminAction, mongoClient *mongo.Client) { actions = append(actions, action) }
+	t.Cleanup(func() { adminTokens, adminAuditLog = tokens, auditLog })
+	server, console := net.Pipe()
+	go handleAdminConnection(server, nil)
+	t.Cleanup(func() { console.Close() })
+	return console, bufio.NewReader(console), &actions
+}
+func adminCommand(t *testing.T, console net.Conn, reader *bufio.Reader, line string) string {
+	if _, err := io.WriteString(console, line+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(reply)
+}
+
+func TestLoadAdminTokens(t *testing.T) {
+	tokens := adminTokens
+	defer func() { adminTokens = tokens }()
+	adminTokens = loadAdminTokens("alice:secret-token, broken,:empty,bob:")
+	if len(adminTokens) != 1 {
+		t.Fatalf("expected only alice to be loaded, got %d admins", len(adminTokens))
+	}
+	if !authenticateAdmin("alice", "secret-token") || authenticateAdmin("alice", "guess") || authenticateAdmin("mallory", "secret-token") {
+		t.Errorf("unexpected authentication result")
+	}
+}
+
+func TestAdminConsoleRequiresAuthentication(t *testing.T) {
+	console, reader, actions := startAdminConsole(t)
+	for i := 0; i < ADMIN_AUTH_ATTEMPTS; i++ {
+		if reply := adminCommand(t, console, reader, "auth alice wrong"); reply != "ERR authentication failed" {
+			t.Fatalf("unexpected reply %q", reply)
+		}
+	}
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Errorf("console stayed open after repeated failures")
+	}
+	if len(*actions) != ADMIN_AUTH_ATTEMPTS || (*actions)[0].Admin != "alice" || (*actions)[0].Success {
+		t.Errorf("failed logins were not audited : %+v", *actions)
+	}
+}
+
+func TestAdminConsoleCommands(t *testing.T) {
+	console, reader, actions := startAdminConsole(t)
+	if reply := adminCommand(t, console, reader, "auth alice secret-token"); reply != "OK welcome alice" {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+	if reply := adminCommand(t, console, reader, "help"); !strings.Contains(reply, "teleport <player> <regionID> <levelID> [x y z]") {
+		t.Errorf("help is missing commands : %q", reply)
+	}
+	if reply := adminCommand(t, console, reader, "players"); !strings.HasPrefix(reply, "OK [") {
+		t.Errorf("unexpected players reply %q", reply)
+	}
+	if reply := adminCommand(t, console, reader, "teleport alice 001"); !strings.HasPrefix(reply, "ERR wrong arguments, usage : teleport") {
+		t.Errorf("missing arguments were accepted : %q", reply)
+	}
+	if reply := adminCommand(t, console, reader, "catalogue-add NPC0 NoSuchItem 10"); reply != "ERR unknown item" {
+		t.Errorf("unexpected reply %q", reply)
+	}
+	if reply := adminCommand(t, console, reader, "fly"); reply != "ERR unknown command, try help" {
+		t.Errorf("unexpected reply %q", reply)
+	}
+	// a database call without a database panics, the console turns it into an error
+	if reply := adminCommand(t, console, reader, "profile someone"); !strings.HasPrefix(reply, "ERR command failed") {
+		t.Errorf("unexpected reply %q", reply)
+	}
+	if reply := adminCommand(t, console, reader, "quit"); reply != "OK bye" {
+		t.Errorf("unexpected reply %q", reply)
+	}
+	if len(*actions) != 6 {
+		t.Fatalf("expected every command but quit to be audited, got %d", len(*actions))
+	}
+	last := (*actions)[5]
+	if last.Admin != "alice" || last.Command != "profile" || last.Arguments[0] != "someone" || last.Success {
+		t.Errorf("unexpected audit record %+v", last)
+	}
+}
+
+func TestKickPlayerFlushesNotice(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	accountID := uuid.New()
+	bindPlayerConnection(accountID, newOutboundQueue(server))
+	defer dropPlayerConnection(server)
+	go func() {
+		if !kickPlayer(accountID, "Spamming") {
+			t.Errorf("online player was not kicked")
+		}
+	}()
+	received, _ := io.ReadAll(client)
+	if !strings.Contains(string(received), "KICK$1;Spamming") {
+		t.Errorf("kick notice was not delivered before closing : %q", received)
+	}
+	if kickPlayer(uuid.New(), "offline") {
+		t.Errorf("offline player was reported kicked")
+	}
+}
+
+func TestCacheShopkeeperSwapsTable(t *testing.T) {
+	original := ALLshopkeepers
+	defer func() { ALLshopkeepers = original }()
+	ALLshopkeepers = map[string]ShopKeeper{"NPC0": {NpcID: "NPC0"}}
+	before := ALLshopkeepers
+	cacheShopkeeper(ShopKeeper{NpcID: "NPC1", Purse: Purse{Bits: 50}})
+	if len(before) != 1 {
+		t.Errorf("the table handlers were reading was modified")
+	}
+	if len(ALLshopkeepers) != 2 || ALLshopkeepers["NPC1"].Purse.Bits != 50 {
+		t.Errorf("unexpected shopkeepers %+v", ALLshopkeepers)
+	}
+}
+
+func TestParseAdminAmountsAndCounts(t *testing.T) {
+	for _, amount := range []string{"NaN", "Inf", "-Inf", "-1", "ten"} {
+		if _, err := parseAdminAmount(amount); err == nil {
+			t.Errorf("amount %q was accepted", amount)
+		}
+	}
+	if amount, err := parseAdminAmount("12.5"); err != nil || amount != 12.5 {
+		t.Errorf("unexpected amount %v %v", amount, err)
+	}
+	for _, count := range []string{"0", "-3", "1e9", strconv.Itoa(ADMIN_MAX_ITEM_COUNT + 1)} {
+		if _, err := parseAdminCount(count); err == nil {
+			t.Errorf("count %q was accepted", count)
+		}
+	}
+	if count, err := parseAdminCount(strconv.Itoa(ADMIN_MAX_ITEM_COUNT)); err != nil || count != ADMIN_MAX_ITEM_COUNT {
+		t.Errorf("unexpected count %v %v", count, err)
+	}
+}
+
+func TestShopkeepersAreReadWhileCached(t *testing.T) {
+	original := ALLshopkeepers
+	defer func() { ALLshopkeepers = original }()
+	ALLshopkeepers = map[string]ShopKeeper{}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			cacheShopkeeper(ShopKeeper{NpcID: "NPC" + strconv.Itoa(i)})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		getShopkeeper("NPC0")
+	}
+	wg.Wait()
+	if shopkeeper, found := getShopkeeper("NPC99"); !found || shopkeeper.NpcID != "NPC99" {
+		t.Errorf("unexpected shopkeeper %+v", shopkeeper)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var MIN_USERNAME_LENGTH = 3
+var MAX_USERNAME_LENGTH = 20
+var MIN_PASSWORD_LENGTH = 8
+
+// bcrypt ignores everything past 72 bytes, longer passwords are refused rather than silently cut
+var MAX_PASSWORD_LENGTH = 72
+var RESERVED_USERNAMES = []string{"admin", "administrator", "gm", "moderator", "server", "system"}
+
+// failed logins in a row before every further attempt has to wait, doubling each time
+var LOGIN_BACKOFF_AFTER = 3
+var LOGIN_BACKOFF_BASE = time.Second
+var LOGIN_BACKOFF_MAX = 5 * time.Minute
+
+// failed logins in a row from one address before the account is locked for that address
+var LOGIN_LOCKOUT_AFTER = 10
+var LOGIN_LOCKOUT_DURATION = 15 * time.Minute
+
+// failures older than this are forgotten
+var LOGIN_FAILURE_MEMORY = time.Hour
+
+// addresses the login and registration limits do not apply to, e.g. load test hosts
+var RATE_LIMIT_EXEMPT_ENV = "COGO_RATE_LIMIT_EXEMPT"
+
+var errUsernameTaken = errors.New("username is not available")
+
+// the server error code for dropping an index that does not exist
+const INDEX_NOT_FOUND = 27
+
+type loginFailures struct {
+	Count       int
+	Last        time.Time
+	LockedUntil time.Time
+}
+
+// LoginGuard throttles L0# and R0# per address and per account, and backs off and locks accounts
+// after repeated failed logins. Failures are counted per address and account, so failing logins
+// from one address cannot lock the owner out from another.
+type LoginGuard struct {
+	mutex              sync.Mutex
+	failures           map[string]*loginFailures
+	addressLimiter     *RateLimiter
+	accountLimiter     *RateLimiter
+	registrationLimits *RateLimiter
+	exempt             map[string]bool
+}
+
+func newLoginGuard(exempt string) *LoginGuard {
+	guard := &LoginGuard{
+		failures:           make(map[string]*loginFailures),
+		addressLimiter:     newRateLimiter(20, time.Minute),
+		accountLimiter:     newRateLimiter(10, time.Minute),
+		registrationLimits: newRateLimiter(5, 10*time.Minute),
+		exempt:             make(map[string]bool),
+	}
+	for _, address := range strings.Split(exempt, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			guard.exempt[address] = true
+		}
+	}
+	return guard
+}
+
+var loginGuard = newLoginGuard(os.Getenv(RATE_LIMIT_EXEMPT_ENV))
+
+// loginDelay is how long the account has to wait after count failures in a row.
+func loginDelay(count int) time.Duration {
+	if count < LOGIN_BACKOFF_AFTER {
+		return 0
+	}
+	delay := time.Duration(float64(LOGIN_BACKOFF_BASE) * math.Pow(2, float64(count-LOGIN_BACKOFF_AFTER)))
+	if delay > LOGIN_BACKOFF_MAX || delay <= 0 {
+		return LOGIN_BACKOFF_MAX
+	}
+	return delay
+}
+
+// CheckLogin returns "" when the attempt may go ahead, otherwise a "<status>;<reason>;<retry at>"
+// refusal in the same shape as a sanction.
+func (guard *LoginGuard) CheckLogin(username string, ip string, now time.Time) string {
+	if !guard.exempt[ip] && !guard.addressLimiter.Allow(ip) {
+		return "throttled;Too many login attempts;" + now.Add(guard.addressLimiter.Window).UTC().Format(time.RFC3339)
+	}
+	if !guard.exempt[ip] && !guard.accountLimiter.Allow(strings.ToLower(username)) {
+		return "throttled;Too many login attempts;" + now.Add(guard.accountLimiter.Window).UTC().Format(time.RFC3339)
+	}
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+	failures, found := guard.failures[loginFailureKey(username, ip)]
+	if !found {
+		return ""
+	}
+	if now.Before(failures.LockedUntil) {
+		return "locked;Too many failed logins;" + failures.LockedUntil.UTC().Format(time.RFC3339)
+	}
+	if retryAt := failures.Last.Add(loginDelay(failures.Count)); now.Before(retryAt) {
+		return "throttled;Too many failed logins;" + retryAt.UTC().Format(time.RFC3339)
+	}
+	return ""
+}
+
+// CheckRegistration reports whether the address may register another account.
+func (guard *LoginGuard) CheckRegistration(ip string) bool {
+	return guard.exempt[ip] || guard.registrationLimits.Allow(ip)
+}
+func (guard *LoginGuard) Failed(username string, ip string, now time.Time) {
+	key := loginFailureKey(username, ip)
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+	failures, found := guard.failures[key]
+	if !found || now.Sub(failures.Last) > LOGIN_FAILURE_MEMORY || !failures.LockedUntil.IsZero() && now.After(failures.LockedUntil) {
+		failures = &loginFailures{}
+		guard.failures[key] = failures
+	}
+	failures.Count++
+	failures.Last = now
+	if failures.Count >= LOGIN_LOCKOUT_AFTER {
+		failures.LockedUntil = now.Add(LOGIN_LOCKOUT_DURATION)
+		fmt.Println(Warn("Account locked for ", ip, " after ", failures.Count, " failed logins : ", username))
+	}
+	//guessing many usernames must not grow the map forever
+	if len(guard.failures) > 10000 {
+		for key, entry := range guard.failures {
+			if now.Sub(entry.Last) > LOGIN_FAILURE_MEMORY && now.After(entry.LockedUntil) {
+				delete(guard.failures, key)
+			}
+		}
+	}
+}
+func (guard *LoginGuard) Succeeded(username string, ip string) {
+	guard.mutex.Lock()
+	delete(guard.failures, loginFailureKey(username, ip))
+	guard.mutex.Unlock()
+}
+
+// loginFailureKey matches usernames case-insensitively, like the user_id index.
+func loginFailureKey(username string, ip string) string {
+	return ip + "|" + strings.ToLower(username)
+}
+
+// validateUsername enforces 3-20 letters, digits or underscores starting with a letter.
+func validateUsername(username string) string {
+	if len(username) < MIN_USERNAME_LENGTH || len(username) > MAX_USERNAME_LENGTH {
+		return fmt.Sprintf("Username must be %d to %d characters", MIN_USERNAME_LENGTH, MAX_USERNAME_LENGTH)
+	}
+	for index, character := range username {
+		if character > unicode.MaxASCII || !(unicode.IsLetter(character) || index > 0 && (unicode.IsDigit(character) || character == '_')) {
+			return "Username may only use letters, digits and underscores and must start with a letter"
+		}
+	}
+	for _, reserved := range RESERVED_USERNAMES {
+		if strings.EqualFold(username, reserved) {
+			return "Username is not available"
+		}
+	}
+	return ""
+}
+
+// validatePassword asks for 8-72 bytes with at least one letter and one digit, unlike the username.
+func validatePassword(username string, password string) string {
+	if len(password) < MIN_PASSWORD_LENGTH || len(password) > MAX_PASSWORD_LENGTH {
+		return fmt.Sprintf("Password must be %d to %d characters", MIN_PASSWORD_LENGTH, MAX_PASSWORD_LENGTH)
+	}
+	hasLetter, hasDigit := false, false
+	for _, character := range password {
+		hasLetter = hasLetter || unicode.IsLetter(character)
+		hasDigit = hasDigit || unicode.IsDigit(character)
+	}
+	if !hasLetter || !hasDigit {
+		return "Password must contain a letter and a digit"
+	}
+	if strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return "Password must not contain the username"
+	}
+	return ""
+}
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// compared against when the user does not exist so a login takes as long either way
+var dummyPasswordHash, _ = hashPassword("not-a-real-password-0")
+
+// checkPassword compares against a bcrypt hash. Accounts created before passwords were hashed
+// still hold the plain text, needsRehash tells the caller to replace it after a successful login.
+func checkPassword(stored string, password string) (valid bool, needsRehash bool) {
+	if strings.HasPrefix(stored, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+	}
+	bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+	valid = stored != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	return valid, valid
+}
+func updatePasswordHash(user *User, password string, mongoClient *mongo.Client) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		fmt.Println(Failure(err))
+		return
+	}
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	users := mongoClient.Database("player").Collection("users")
+	if _, err := users.UpdateOne(cxt, bson.M{"uuid": user.Account_id, "password": user.Password}, bson.M{"$set": bson.M{"password": hash}}); err != nil {
+		fmt.Println(Failure(err))
+	}
+}
+
+// ensureUserIndexes makes user_id unique regardless of case so two registrations racing for a name,
+// or for the same name in different case, cannot both win. The case-sensitive index older servers
+// created is dropped once its replacement exists.
+func ensureUserIndexes(mongoClient *mongo.Client) {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	users := mongoClient.Database("player").Collection("users")
+	caseInsensitive := &options.Collation{Locale: "en", Strength: 2}
+	index := mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true).SetCollation(caseInsensitive).SetName("user_id_unique_ci")}
+	if _, err := users.Indexes().CreateOne(cxt, index); err != nil {
+		fmt.Println(Failure("Could not create unique user_id index, resolve duplicate usernames : ", err))
+		return
+	}
+	if _, err := users.Indexes().DropOne(cxt, "user_id_unique"); err != nil {
+		if commandError, ok := err.(mongo.CommandError); !ok || commandError.Code != INDEX_NOT_FOUND {
+			fmt.Println(Failure("Could not drop the case-sensitive user_id index : ", err))
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginDelayDoubles(t *testing.T) {
+	for count, expected := range map[int]time.Duration{0: 0, 2: 0, 3: time.Second, 4: 2 * time.Second, 6: 8 * time.Second, 40: LOGIN_BACKOFF_MAX} {
+		if delay := loginDelay(count); delay != expected {
+			t.Errorf("%d failures waited %s, expected %s", count, delay, expected)
+		}
+	}
+}
+
+func TestLoginGuardBacksOffAndLocks(t *testing.T) {
+	guard := newLoginGuard("")
+	guard.accountLimiter = newRateLimiter(1000, time.Minute)
+	guard.addressLimiter = newRateLimiter(1000, time.Minute)
+	now := time.Now()
+	for i := 0; i < LOGIN_BACKOFF_AFTER; i++ {
+		if refusal := guard.CheckLogin("Wizard", "203.0.113.7", now); refusal != "" {
+			t.Fatalf("attempt %d was refused : %s", i, refusal)
+		}
+		guard.Failed("Wizard", "203.0.113.7", now)
+	}
+	if refusal := guard.CheckLogin("wizard", "203.0.113.7", now); !strings.HasPrefix(refusal, "throttled;") {
+		t.Errorf("expected a backoff after %d failures, got %q", LOGIN_BACKOFF_AFTER, refusal)
+	}
+	if refusal := guard.CheckLogin("wizard", "203.0.113.7", now.Add(loginDelay(LOGIN_BACKOFF_AFTER))); refusal != "" {
+		t.Errorf("attempt after the backoff was refused : %s", refusal)
+	}
+	for i := LOGIN_BACKOFF_AFTER; i < LOGIN_LOCKOUT_AFTER; i++ {
+		guard.Failed("wizard", "203.0.113.7", now)
+	}
+	later := now.Add(LOGIN_BACKOFF_MAX)
+	if refusal := guard.CheckLogin("wizard", "203.0.113.7", later); !strings.HasPrefix(refusal, "locked;Too many failed logins;") {
+		t.Errorf("expected the account to be locked, got %q", refusal)
+	}
+	if refusal := guard.CheckLogin("sorcerer", "203.0.113.7", later); refusal != "" {
+		t.Errorf("other accounts were locked too : %s", refusal)
+	}
+	if refusal := guard.CheckLogin("wizard", "198.51.100.9", later); refusal != "" {
+		t.Errorf("failures from one address locked the account everywhere : %s", refusal)
+	}
+	// a failure after the lock ran out starts counting from scratch
+	guard.Failed("wizard", "203.0.113.7", now.Add(LOGIN_LOCKOUT_DURATION+time.Second))
+	if refusal := guard.CheckLogin("wizard", "203.0.113.7", now.Add(LOGIN_LOCKOUT_DURATION+time.Second)); refusal != "" {
+		t.Errorf("lock did not expire : %s", refusal)
+	}
+	guard.Succeeded("wizard", "203.0.113.7")
+	if len(guard.failures) != 0 {
+		t.Errorf("a successful login did not clear failures")
+	}
+}
+
+func TestLoginGuardRateLimits(t *testing.T) {
+	guard := newLoginGuard("198.51.100.1")
+	guard.addressLimiter = newRateLimiter(2, time.Minute)
+	now := time.Now()
+	for _, username := range []string{"a", "b"} {
+		if refusal := guard.CheckLogin(username, "203.0.113.7", now); refusal != "" {
+			t.Fatalf("attempt was refused : %s", refusal)
+		}
+	}
+	if refusal := guard.CheckLogin("c", "203.0.113.7", now); !strings.HasPrefix(refusal, "throttled;Too many login attempts;") {
+		t.Errorf("address limit was not applied, got %q", refusal)
+	}
+	for i := 0; i < 5; i++ {
+		if refusal := guard.CheckLogin("c", "198.51.100.1", now); refusal != "" {
+			t.Errorf("exempt address was throttled : %s", refusal)
+		}
+	}
+	guard.registrationLimits = newRateLimiter(1, time.Hour)
+	if !guard.CheckRegistration("203.0.113.7") || guard.CheckRegistration("203.0.113.7") || !guard.CheckRegistration("198.51.100.1") {
+		t.Errorf("unexpected registration limits")
+	}
+}
+
+func TestUsernamePolicy(t *testing.T) {
+	for username, valid := range map[string]bool{"Merlin": true, "dark_mage_42": true, "ab": false, "9lives": false, "_mage": false, "mage!": false, "mågus": false, "Admin": false, strings.Repeat("a", 21): false} {
+		if reason := validateUsername(username); (reason == "") != valid {
+			t.Errorf("%q : unexpected result %q", username, reason)
+		}
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	for password, valid := range map[string]bool{"correct horse 1": true, "short1": false, "allletters": false, "12345678": false, "merlin123": false, strings.Repeat("a1", 37): false} {
+		if reason := validatePassword("Merlin", password); (reason == "") != valid {
+			t.Errorf("%q : unexpected result %q", password, reason)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := hashPassword("correct horse 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, rehash := checkPassword(hash, "correct horse 1"); !valid || rehash {
+		t.Errorf("hashed password did not verify")
+	}
+	if valid, _ := checkPassword(hash, "wrong horse 1"); valid {
+		t.Errorf("wrong password verified")
+	}
+	if valid, rehash := checkPassword("legacy-plain-1", "legacy-plain-1"); !valid || !rehash {
+		t.Errorf("plain text password should verify and ask for a rehash")
+	}
+	if valid, _ := checkPassword("", ""); valid {
+		t.Errorf("an empty stored password must never verify")
+	}
+}
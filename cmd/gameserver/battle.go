@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/google/uuid"
+)
+
+type BattleAction struct {
+	Turn       int       `json:"turn" default:"0" bson:"turn"`
+	Account_id uuid.UUID `json:"uuid" default:"" bson:"uuid"`
+	Type       string    `json:"type" default:"" bson:"type"`
+	Value      string    `json:"value" default:"" bson:"value"`
+}
+
+// reserveBattleTurn takes the player's action for the current turn and reports why they may not act,
+// "" when the action was reserved. Every participant gets one action per turn, a reserved action is
+// either recorded with recordBattleTurn or handed back with releaseBattleTurn.
+func reserveBattleTurn(battleID uuid.UUID, accountID uuid.UUID) string {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	battle, found := sessions.Battles[battleID]
+	if !found || battle.Status != 0 {
+		return "Battle is not active"
+	}
+	if !containsAccount(battle.Participants, accountID) {
+		return "Not part of this battle"
+	}
+	if containsAccount(battle.Acted, accountID) {
+		return "Already acted this turn"
+	}
+	battle.Acted = append(battle.Acted, accountID)
+	sessions.Battles[battleID] = battle
+	return ""
+}
+
+// releaseBattleTurn hands back a reserved action whose effects could not be applied.
+func releaseBattleTurn(battleID uuid.UUID, accountID uuid.UUID) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	battle, found := sessions.Battles[battleID]
+	if !found {
+		return
+	}
+	acted := make([]uuid.UUID, 0, len(battle.Acted))
+	for _, actedID := range battle.Acted {
+		if actedID != accountID {
+			acted = append(acted, actedID)
+		}
+	}
+	battle.Acted = acted
+	sessions.Battles[battleID] = battle
+}
+
+// recordBattleTurn logs a reserved action and returns the turn the battle is on afterwards. The turn
+// moves on once every participant's action for it has been recorded.
+func recordBattleTurn(battleID uuid.UUID, accountID uuid.UUID, action BattleAction) int {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	battle, found := sessions.Battles[battleID]
+	if !found {
+		return 0
+	}
+	action.Turn = battle.Turn
+	action.Account_id = accountID
+	battle.Actions = append(battle.Actions, action)
+	if !containsAccount(battle.Acted, accountID) {
+		battle.Acted = append(battle.Acted, accountID)
+	}
+	recorded := 0
+	for _, logged := range battle.Actions {
+		if logged.Turn == battle.Turn {
+			recorded++
+		}
+	}
+	if recorded >= len(battle.Participants) {
+		battle.Turn++
+		battle.Acted = nil
+	}
+	sessions.Battles[battleID] = battle
+	return battle.Turn
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type ChatMessage struct {
+	Message_id uuid.UUID `json:"message_id" default:""`
+	Channel    string    `json:"channel" default:""`
+	Sender_id  uuid.UUID `json:"sender_id" default:""`
+	Sender     string    `json:"sender" default:""`
+	Recipient  string    `json:"recipient" default:""`
+	Content    string    `json:"content" default:""`
+	Sent_at    time.Time `json:"sent_at" default:""`
+}
+type ChatSettings struct {
+	Account_id uuid.UUID   `json:"uuid" bson:"uuid,omitempty"`
+	Blocked    []uuid.UUID `json:"blocked" default:"" bson:"blocked"`
+	MutedUntil time.Time   `json:"muted_until" default:"" bson:"muted_until"`
+	MuteReason string      `json:"mute_reason" default:"" bson:"mute_reason"`
+}
+
+// ChatFilter inspects an outgoing message and returns the text to deliver,
+// or false when the message must be rejected altogether.
+type ChatFilter func(message string) (string, bool)
+
+var CHAT_CHANNELS = map[string]bool{"say": true, "party": true, "whisper": true, "global": true}
+var MAX_CHAT_LENGTH = 256
+var CHAT_CENSORED_WORDS = []string{}
+var chatFilter ChatFilter = censorChatFilter
+var chatRateLimiter = newRateLimiter(5, 10*time.Second)
+
+var chatSettingsCache = make(map[uuid.UUID]*ChatSettings)
+var chatNameCache = make(map[uuid.UUID]string)
+var chatMutex sync.Mutex
+
+// censorChatFilter masks every word in CHAT_CENSORED_WORDS. Swap chatFilter to plug in a real profanity service.
+// Words are matched case-insensitively on the message itself, lowering a copy first shifts byte offsets
+// for characters whose lower case encodes to a different length.
+func censorChatFilter(message string) (string, bool) {
+	filtered := message
+	for _, word := range CHAT_CENSORED_WORDS {
+		if word == "" {
+			continue
+		}
+		pattern := regexp.MustCompile("(?i)" + regexp.QuoteMeta(word))
+		filtered = pattern.ReplaceAllStringFunc(filtered, func(match string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(match))
+		})
+	}
+	return filtered, true
+}
+func sanitizeChatMessage(message string) (string, string) {
+	cleaned := strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, message))
+	if cleaned == "" {
+		return "", "Message is empty"
+	}
+	if utf8.RuneCountInString(cleaned) > MAX_CHAT_LENGTH {
+		return "", "Message is too long"
+	}
+	filtered, allowed := chatFilter(cleaned)
+	if !allowed {
+		return "", "Message was rejected by the filter"
+	}
+	return filtered, ""
+}
+
+func getChatSettings(accountID uuid.UUID, mongoClient *mongo.Client) *ChatSettings {
+	chatMutex.Lock()
+	if settings, found := chatSettingsCache[accountID]; found {
+		chatMutex.Unlock()
+		return settings
+	}
+	chatMutex.Unlock()
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	database := mongoClient.Database("player")
+	chatSettings := database.Collection("chat_settings")
+	settings := &ChatSettings{Account_id: accountID, Blocked: []uuid.UUID{}}
+	if err := chatSettings.FindOne(cxt, bson.M{"uuid": accountID}).Decode(settings); err != nil && err != mongo.ErrNoDocuments {
+		fmt.Println(Failure(err))
+	}
+	chatMutex.Lock()
+	defer chatMutex.Unlock()
+	chatSettingsCache[accountID] = settings
+	return settings
+}
+func saveChatSettings(settings ChatSettings, mongoClient *mongo.Client) bool {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	database := mongoClient.Database("player")
+	chatSettings := database.Collection("chat_settings")
+	match := bson.M{"uuid": settings.Account_id}
+	change := bson.M{"$set": settings}
+	_, err := chatSettings.UpdateOne(cxt, match, change, options.Update().SetUpsert(true))
+	if err != nil {
+		fmt.Println(Failure(err))
+		return false
+	}
+	return true
+}
+func getChatName(accountID uuid.UUID, mongoClient *mongo.Client) string {
+	chatMutex.Lock()
+	name, found := chatNameCache[accountID]
+	chatMutex.Unlock()
+	if found {
+		return name
+	}
+	profile, _ := getProfile(accountID, mongoClient)
+	if profile == nil {
+		return ""
+	}
+	chatMutex.Lock()
+	defer chatMutex.Unlock()
+	chatNameCache[accountID] = profile.Name
+	return profile.Name
+}
+func isChatBlocked(recipientID uuid.UUID, senderID uuid.UUID, mongoClient *mongo.Client) bool {
+	settings := getChatSettings(recipientID, mongoClient)
+	chatMutex.Lock()
+	defer chatMutex.Unlock()
+	return containsAccount(settings.Blocked, senderID)
+}
+func setChatBlocked(accountID uuid.UUID, targetID uuid.UUID, blocked bool, mongoClient *mongo.Client) string {
+	if accountID == targetID {
+		return "CHAT$0;Cannot block yourself"
+	}
+	settings := getChatSettings(accountID, mongoClient)
+	chatMutex.Lock()
+	settings.Blocked = removePartyMember(settings.Blocked, targetID)
+	if blocked {
+		settings.Blocked = append(settings.Blocked, targetID)
+	}
+	snapshot := *settings
+	chatMutex.Unlock()
+	if !saveChatSettings(snapshot, mongoClient) {
+		return "CHAT$0;Could not save block list"
+	}
+	return "CHAT$1"
+}
+
+// mutePlayer silences an account on every channel until the given duration runs out.
+func mutePlayer(accountID uuid.UUID, duration time.Duration, reason string, mongoClient *mongo.Client) bool {
+	settings := getChatSettings(accountID, mongoClient)
+	chatMutex.Lock()
+	settings.MutedUntil = time.Now().Add(duration)
+	settings.MuteReason = reason
+	snapshot := *settings
+	chatMutex.Unlock()
+	return saveChatSettings(snapshot, mongoClient)
+}
+func getChatRecipients(senderID uuid.UUID, channel string, target string, mongoClient *mongo.Client) ([]uuid.UUID, string) {
+	var recipients []uuid.UUID
+	switch channel {
+	case "say":
+		sender, online := getPlayerConnection(senderID)
+		if !online || sender.LevelID == "" {
+			return nil, "Not in a level"
+		}
+		for _, player := range getOnlinePlayers() {
+			if player.LevelID == sender.LevelID {
+				recipients = append(recipients, player.Account_id)
+			}
+		}
+	case "party":
+		party, found := getParty(senderID)
+		if !found {
+			return nil, "Not in a party"
+		}
+		recipients = party.Members
+	case "whisper":
+		user, found := getUser(target, mongoClient)
+		if !found {
+			return nil, "Player does not exist"
+		}
+		if _, online := getPlayerConnection(user.Account_id); !online {
+			return nil, "Player is offline"
+		}
+		recipients = []uuid.UUID{user.Account_id, senderID}
+	case "global":
+		for _, player := range getOnlinePlayers() {
+			recipients = append(recipients, player.Account_id)
+		}
+	}
+	return recipients, ""
+}
+
+// sendChatMessage validates a message and queues it on every recipient's connection.
+func sendChatMessage(senderID uuid.UUID, channel string, target string, message string, mongoClient *mongo.Client) string {
+	if !CHAT_CHANNELS[channel] {
+		return "CHAT$0;Unknown channel"
+	}
+	settings := getChatSettings(senderID, mongoClient)
+	chatMutex.Lock()
+	mutedUntil := settings.MutedUntil
+	chatMutex.Unlock()
+	if time.Now().Before(mutedUntil) {
+		return "CHAT$0;Muted until " + mutedUntil.UTC().Format(time.RFC3339)
+	}
+	if !chatRateLimiter.Allow(senderID.String()) {
+		return "CHAT$0;Slow down"
+	}
+	content, reason := sanitizeChatMessage(message)
+	if reason != "" {
+		return "CHAT$0;" + reason
+	}
+	recipients, reason := getChatRecipients(senderID, channel, target, mongoClient)
+	if reason != "" {
+		return "CHAT$0;" + reason
+	}
+	chatMessage := ChatMessage{
+		Message_id: uuid.New(),
+		Channel:    channel,
+		Sender_id:  senderID,
+		Sender:     getChatName(senderID, mongoClient),
+		Recipient:  target,
+		Content:    content,
+		Sent_at:    time.Now().UTC(),
+	}
+	messageJSON, _ := json.Marshal(chatMessage)
+	for _, recipientID := range recipients {
+		if recipientID != senderID && isChatBlocked(recipientID, senderID, mongoClient) {
+			continue
+		}
+		packet := createSimpleDeliveryPacket(uuid.New().String(), "CM#", "CHAT", string(messageJSON))
+		Push(recipientID, packet)
+	}
+	return "CHAT$1"
+}
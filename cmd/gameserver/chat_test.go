@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessPaddedPacketKeepsQuestionMarks(t *testing.T) {
+	items := processPaddedPacket("req?acc?whisper?bob?are you there? hello?", 5)
+	if items[2] != "whisper" || items[3] != "bob" || items[4] != "are you there? hello?" {
+		t.Errorf("unexpected split: %q", items)
+	}
+	if items := processPaddedPacket("req?acc?global", 5); len(items) != 5 || items[2] != "global" || items[4] != "" {
+		t.Errorf("missing fields were not padded: %q", items)
+	}
+}
+
+func TestSanitizeChatMessage(t *testing.T) {
+	CHAT_CENSORED_WORDS = []string{"darn"}
+	defer func() { CHAT_CENSORED_WORDS = []string{} }()
+
+	if content, reason := sanitizeChatMessage("  Well DARN it\x07 "); reason != "" || content != "Well **** it" {
+		t.Errorf("unexpected sanitized message %q (%s)", content, reason)
+	}
+	//Ⱥ lowers to a shorter encoding, offsets taken from a lowered copy would not line up with the message
+	if content, reason := sanitizeChatMessage("ȺȺȺȺ darn"); reason != "" || content != "ȺȺȺȺ ****" {
+		t.Errorf("unexpected sanitized message %q (%s)", content, reason)
+	}
+	if _, reason := sanitizeChatMessage(strings.Repeat("a", MAX_CHAT_LENGTH+1)); reason == "" {
+		t.Errorf("expected an over-long message to be rejected")
+	}
+	if _, reason := sanitizeChatMessage("   "); reason == "" {
+		t.Errorf("expected an empty message to be rejected")
+	}
+}
+
+func TestRateLimiterAllowsBurstThenBlocks(t *testing.T) {
+	limiter := newRateLimiter(3, time.Hour)
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("player") {
+			t.Fatalf("message %d should have been allowed", i)
+		}
+	}
+	if limiter.Allow("player") {
+		t.Errorf("expected the fourth message to be limited")
+	}
+	if !limiter.Allow("someone else") {
+		t.Errorf("limits must be tracked per key")
+	}
+}
+
+func TestRateLimiterDropsIdleBuckets(t *testing.T) {
+	limiter := newRateLimiter(1, 20*time.Millisecond)
+	limiter.Allow("first")
+	limiter.Allow("second")
+	time.Sleep(30 * time.Millisecond)
+	if !limiter.Allow("third") {
+		t.Fatalf("a new key was limited")
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if _, found := limiter.buckets["first"]; found || len(limiter.buckets) != 1 {
+		t.Errorf("idle buckets were kept : %d buckets", len(limiter.buckets))
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CODEC_NONE   = ""
+	CODEC_ZSTD   = "zstd"
+	CODEC_SNAPPY = "snappy"
+)
+
+// chained payloads smaller than this are sent as they are, compressing them does not pay off
+var COMPRESSION_THRESHOLD = 1024
+
+// how often the per service compression ratios are logged
+var COMPRESSION_METRICS_INTERVAL = 5 * time.Minute
+
+// codecs in the order the server prefers them when a client supports several
+var SUPPORTED_CODECS = []string{CODEC_ZSTD, CODEC_SNAPPY}
+
+var zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+
+type CompressionMetrics struct {
+	Responses  int     `json:"responses" default:"0"`
+	Compressed int     `json:"compressed" default:"0"`
+	Raw_bytes  int     `json:"raw_bytes" default:"0"`
+	Sent_bytes int     `json:"sent_bytes" default:"0"`
+	Ratio      float64 `json:"ratio" default:"1"`
+}
+
+var compressionMetrics = make(map[string]*CompressionMetrics)
+var compressionMetricsMutex sync.Mutex
+
+// negotiateCodec picks the first server supported codec out of the list the client offered.
+func negotiateCodec(offered string) string {
+	for _, codec := range SUPPORTED_CODECS {
+		for _, candidate := range strings.Split(offered, ",") {
+			if strings.EqualFold(strings.TrimSpace(candidate), codec) {
+				return codec
+			}
+		}
+	}
+	return CODEC_NONE
+}
+func setConnectionCodec(clientConnection net.Conn, codec string) bool {
+	outbound, queued := clientConnection.(*outboundQueue)
+	if !queued {
+		return false
+	}
+	outbound.codec.Store(codec)
+	return true
+}
+func getConnectionCodec(clientConnection net.Conn) string {
+	if outbound, queued := clientConnection.(*outboundQueue); queued {
+		if codec, set := outbound.codec.Load().(string); set {
+			return codec
+		}
+	}
+	return CODEC_NONE
+}
+
+// compressPayload encodes a chained payload with codec. Compressed payloads are marked with "!codec!"
+// followed by the base64 of the compressed bytes so they stay safe inside the text protocol.
+func compressPayload(payload []byte, codec string) ([]byte, bool) {
+	if codec == CODEC_NONE || len(payload) < COMPRESSION_THRESHOLD {
+		return payload, false
+	}
+	var compressed []byte
+	switch codec {
+	case CODEC_ZSTD:
+		compressed = zstdEncoder.EncodeAll(payload, nil)
+	case CODEC_SNAPPY:
+		compressed = snappy.Encode(nil, payload)
+	default:
+		return payload, false
+	}
+	encoded := []byte("!" + codec + "!" + base64.StdEncoding.EncodeToString(compressed))
+	if len(encoded) >= len(payload) {
+		return payload, false
+	}
+	return encoded, true
+}
+func recordCompression(serviceType string, rawBytes int, sentBytes int, compressed bool) {
+	compressionMetricsMutex.Lock()
+	defer compressionMetricsMutex.Unlock()
+	metrics, found := compressionMetrics[serviceType]
+	if !found {
+		metrics = &CompressionMetrics{}
+		compressionMetrics[serviceType] = metrics
+	}
+	metrics.Responses++
+	if compressed {
+		metrics.Compressed++
+	}
+	metrics.Raw_bytes += rawBytes
+	metrics.Sent_bytes += sentBytes
+	metrics.Ratio = float64(metrics.Sent_bytes) / float64(metrics.Raw_bytes)
+}
+func getCompressionMetrics() map[string]CompressionMetrics {
+	compressionMetricsMutex.Lock()
+	defer compressionMetricsMutex.Unlock()
+	snapshot := make(map[string]CompressionMetrics, len(compressionMetrics))
+	for serviceType, metrics := range compressionMetrics {
+		snapshot[serviceType] = *metrics
+	}
+	return snapshot
+}
+func runCompressionMetricsLog() {
+	ticker := time.NewTicker(COMPRESSION_METRICS_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		metrics := getCompressionMetrics()
+		serviceTypes := make([]string, 0, len(metrics))
+		for serviceType := range metrics {
+			serviceTypes = append(serviceTypes, serviceType)
+		}
+		sort.Strings(serviceTypes)
+		for _, serviceType := range serviceTypes {
+			entry := metrics[serviceType]
+			fmt.Println(Info(fmt.Sprintf("Compression (%s) : %d/%d responses compressed, %d -> %d bytes, ratio %.2f",
+				serviceType, entry.Compressed, entry.Responses, entry.Raw_bytes, entry.Sent_bytes, entry.Ratio)))
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateCodec(t *testing.T) {
+	if codec := negotiateCodec("snappy, ZSTD"); codec != CODEC_ZSTD {
+		t.Errorf("expected the server preference to win, got %q", codec)
+	}
+	if codec := negotiateCodec("snappy"); codec != CODEC_SNAPPY {
+		t.Errorf("expected snappy, got %q", codec)
+	}
+	if codec := negotiateCodec("gzip"); codec != CODEC_NONE {
+		t.Errorf("expected no codec, got %q", codec)
+	}
+}
+
+func TestCompressPayloadRoundTrip(t *testing.T) {
+	payload := []byte(strings.Repeat(`{\"item_id\":\"WizardHat\",\"price\":12},`, 200))
+	if small, compressed := compressPayload(payload[:100], CODEC_ZSTD); compressed || !bytes.Equal(small, payload[:100]) {
+		t.Errorf("expected payloads under the threshold to stay uncompressed")
+	}
+	decoder, _ := zstd.NewReader(nil)
+	defer decoder.Close()
+	for _, codec := range SUPPORTED_CODECS {
+		encoded, compressed := compressPayload(payload, codec)
+		if !compressed || len(encoded) >= len(payload) {
+			t.Fatalf("expected %s to shrink the payload, got %d bytes", codec, len(encoded))
+		}
+		prefix := "!" + codec + "!"
+		if !strings.HasPrefix(string(encoded), prefix) {
+			t.Fatalf("expected the %s marker, got %q", codec, encoded[:10])
+		}
+		raw, _ := base64.StdEncoding.DecodeString(string(encoded[len(prefix):]))
+		var decoded []byte
+		if codec == CODEC_ZSTD {
+			decoded, _ = decoder.DecodeAll(raw, nil)
+		} else {
+			decoded, _ = snappy.Decode(nil, raw)
+		}
+		if !bytes.Equal(decoded, payload) {
+			t.Errorf("expected %s to round trip", codec)
+		}
+	}
+}
+
+func TestConnectionCodec(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	outbound := newOutboundQueue(server)
+	defer outbound.Close()
+	if codec := getConnectionCodec(outbound); codec != CODEC_NONE {
+		t.Errorf("expected connections to start uncompressed, got %q", codec)
+	}
+	setConnectionCodec(outbound, CODEC_SNAPPY)
+	if codec := getConnectionCodec(outbound); codec != CODEC_SNAPPY {
+		t.Errorf("expected the negotiated codec, got %q", codec)
+	}
+}
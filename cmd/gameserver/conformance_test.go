@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"CoGo/internal/pkg/client"
+	"CoGo/internal/pkg/mongodb"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The conformance suite drives handleTCPConnection through the client SDK over an in-process pipe.
+// Transport contracts (framing, SOS/OK, compression and fragments) run without a database, gameplay
+// opcodes run against the in-memory deployment from internal/pkg/mongodb with a small test world.
+
+func startConformanceServer(t *testing.T) (*client.Client, *outboundQueue) {
+	return startConformanceServerWith(t, nil)
+}
+func startConformanceServerWith(t *testing.T, mongoClient *mongo.Client) (*client.Client, *outboundQueue) {
+	server, connection := net.Pipe()
+	queue := newOutboundQueue(server)
+	cxt, cancel := context.WithCancel(context.Background())
+	go handleTCPConnection(queue, cxt, mongoClient)
+	sdk := client.New(connection)
+	sdk.AccountID = uuid.New()
+	sdk.Timeout = time.Second
+	t.Cleanup(func() {
+		sdk.Close()
+		cancel()
+	})
+	return sdk, queue
+}
+func waitForPush(t *testing.T, sdk *client.Client) client.Packet {
+	select {
+	case packet := <-sdk.Pushes:
+		return packet
+	case <-time.After(time.Second):
+		t.Fatal("no packet pushed")
+	}
+	return client.Packet{}
+}
+
+func TestConformanceCompressionNegotiation(t *testing.T) {
+	sdk, queue := startConformanceServer(t)
+	codec, err := sdk.NegotiateCompression("gzip")
+	if err != nil || codec != "none" {
+		t.Fatalf("expected no codec for unsupported offers, got %q (%v)", codec, err)
+	}
+	codec, err = sdk.NegotiateCompression("snappy,zstd")
+	if err != nil || codec != CODEC_ZSTD {
+		t.Fatalf("expected the server's preferred codec, got %q (%v)", codec, err)
+	}
+	if getConnectionCodec(queue) != CODEC_ZSTD {
+		t.Errorf("connection codec was not stored")
+	}
+}
+
+func TestConformanceSOSAndOK(t *testing.T) {
+	sdk, _ := startConformanceServer(t)
+	sdk.AutoAck = false
+	response, err := sdk.Request("ZN#", sdk.AccountID.String(), "zstd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.PacketCode != "ZN#" || response.ServiceType != "COMPRESSION" {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	sdk.Send("SOS#", response.PacketID.String(), sdk.AccountID.String())
+	if resent := waitForPush(t, sdk); resent != response {
+		t.Errorf("SOS resent %+v instead of %+v", resent, response)
+	}
+
+	sdk.Send("OK#", response.PacketID.String(), sdk.AccountID.String())
+	// requests are handled in order, so once this answers the OK has been processed
+	barrier, err := sdk.Request("ZN#", sdk.AccountID.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, cached := getCachedPacket(sdk.AccountID, response.PacketID); cached {
+		t.Errorf("acknowledged packet is still cached")
+	}
+	if _, cached := getCachedPacket(sdk.AccountID, barrier.PacketID); !cached {
+		t.Errorf("unacknowledged packet was not cached")
+	}
+	dropPacketCache(sdk.AccountID)
+}
+
+func TestConformanceChainedResponses(t *testing.T) {
+	for _, codec := range []string{"", CODEC_ZSTD, CODEC_SNAPPY} {
+		sdk, queue := startConformanceServer(t)
+		if codec != "" {
+			if negotiated, err := sdk.NegotiateCompression(codec); err != nil || negotiated != codec {
+				t.Fatalf("could not negotiate %s: %q (%v)", codec, negotiated, err)
+			}
+		}
+		content, _ := json.Marshal(map[string]string{"description": strings.Repeat("A winding path through the forest ✓ ", 200)})
+		packet := createMultiDeliveryPacket(uuid.New().String(), "LL#", "LEVEL", content)
+		messageID := chainWriteResponse(sdk.AccountID, packet.PacketID.String(), packet, 500, queue, false)
+
+		received := waitForPush(t, sdk)
+		if received.PacketID != packet.PacketID || received.Content != packet.Content {
+			t.Fatalf("codec %q: chained packet did not round trip", codec)
+		}
+		var payload map[string]string
+		if err := received.Decode(&payload); err != nil || !strings.HasPrefix(payload["description"], "A winding path") {
+			t.Errorf("codec %q: payload did not decode: %v", codec, err)
+		}
+
+		// an empty fragment list asks for the whole buffered message again
+		sdk.RequestFragments(messageID, nil)
+		if resent := waitForPush(t, sdk); resent.PacketID != packet.PacketID {
+			t.Errorf("codec %q: re-requested message was not resent", codec)
+		}
+		dropRetransmitBuffer(sdk.AccountID)
+		dropPacketCache(sdk.AccountID)
+	}
+}
+
+func TestConformanceUnknownFragmentReRequest(t *testing.T) {
+	sdk, _ := startConformanceServer(t)
+	response, err := sdk.Request("FQ#", sdk.AccountID.String(), uuid.New().String(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, detail := response.Result(); ok || detail != "Message is no longer buffered" {
+		t.Errorf("unexpected response %q", response.Content)
+	}
+	dropPacketCache(sdk.AccountID)
+}
+
+func TestConformancePipelinedRequests(t *testing.T) {
+	sdk, _ := startConformanceServer(t)
+	// both requests land in one write, the second must not be lost with the first read's buffer
+	first, second := uuid.New(), uuid.New()
+	pipelined := client.EncodeRequest("FQ#", first.String(), sdk.AccountID.String(), uuid.New().String(), "") +
+		client.EncodeRequest("FQ#", second.String(), sdk.AccountID.String(), uuid.New().String(), "")
+	go sdk.Send(strings.TrimSuffix(pipelined, "\n"))
+	for _, requestID := range []uuid.UUID{first, second} {
+		if packet := waitForPush(t, sdk); packet.PacketID != requestID {
+			t.Errorf("expected response to %s, got %s", requestID, packet.PacketID)
+		}
+	}
+	dropPacketCache(sdk.AccountID)
+}
+
+func TestConformanceWorldVersion(t *testing.T) {
+	defer setWorldData(currentWorld())
+	world := newWorldData()
+	world.Version = worldVersion(world)
+	setWorldData(world)
+	sdk, _ := startConformanceServer(t)
+	version, err := sdk.FetchWorldVersion()
+	if err != nil || version != world.Version || sdk.WorldVersion() != world.Version {
+		t.Errorf("expected version %s, got %q (%v)", world.Version, version, err)
+	}
+}
+
+var exemptPipeAddress sync.Once
+
+// newGameplayDatabase returns an empty in-memory database and serves a world with one level holding a wolf.
+func newGameplayDatabase(t *testing.T) *mongo.Client {
+	mongoClient, err := mongodb.NewMemoryClient(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureUserIndexes(mongoClient)
+	// every test connection registers from the same pipe address
+	exemptPipeAddress.Do(func() { loginGuard.exempt["pipe"] = true })
+	sessionsMutex.Lock()
+	if sessions.Battles == nil {
+		sessions.Battles = make(map[uuid.UUID]BattleSession)
+	}
+	sessionsMutex.Unlock()
+
+	previous := currentWorld()
+	t.Cleanup(func() { setWorldData(previous) })
+	world := newWorldData()
+	world.Items["WizardRobe"] = Item{Item_id: "WizardRobe", Item_type: "loadout.body", Stats: Stats{Defense: 2}}
+	world.Items["WizardHat"] = Item{Item_id: "WizardHat", Item_type: "loadout.head"}
+	world.Items["Fang"] = Item{Item_id: "Fang", Item_type: "material"}
+	world.Spells["Fireball"] = Spell{Spell_id: "Fireball"}
+	world.Spells["Scorch"] = Spell{Spell_id: "Scorch"}
+	world.Monsters["Wolf"] = Monster{MobID: "Wolf", GoldGain: 7, ExperienceGain: 30,
+		DropTable: []LootDrop{{Item_id: "Fang", FirstKillGuaranteed: true, MinQuantity: 1, MaxQuantity: 1}}}
+	world.Levels["00001"] = Level{LevelID: "00001", Monsters: []string{"Wolf"}, Residents: []string{"Elder"}}
+	world.Regions["001"] = Region{RegionID: "001", Levels: []string{"00001"}}
+	world.Version = worldVersion(world)
+	setWorldData(world)
+	return mongoClient
+}
+
+// startPlayer registers and logs in a fresh account on its own connection.
+func startPlayer(t *testing.T, mongoClient *mongo.Client) (*client.Client, string) {
+	sdk, _ := startConformanceServerWith(t, mongoClient)
+	// password hashing is slow under the race detector, do not let it trip an SOS
+	sdk.Timeout = 10 * time.Second
+	username := "player" + strings.ReplaceAll(uuid.NewString(), "-", "")[:10]
+	password := "correct-horse-" + uuid.NewString()
+	registered, err := sdk.Register(username, password)
+	if err != nil || !strings.HasPrefix(registered.Content, "RS#") {
+		t.Fatalf("registration failed: %+v (%v)", registered, err)
+	}
+	sdk.AccountID = uuid.Nil
+	loggedIn, err := sdk.Login(username, password)
+	if err != nil || loggedIn.PacketCode != "LS#" || sdk.AccountID == uuid.Nil {
+		t.Fatalf("login failed: %+v (%v)", loggedIn, err)
+	}
+	return sdk, username
+}
+func fetchProfile(t *testing.T, sdk *client.Client) Profile {
+	response, err := sdk.Profile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var profile Profile
+	if err := response.Decode(&profile); err != nil {
+		t.Fatalf("profile did not decode: %v", err)
+	}
+	return profile
+}
+
+func TestConformanceRegisterAndLogin(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	sdk, username := startPlayer(t, mongoClient)
+	if push := waitForPush(t, sdk); push.PacketCode != "WV#" || sdk.WorldVersion() != currentWorld().Version {
+		t.Errorf("login did not report the world version: %+v", push)
+	}
+	if duplicate, err := sdk.Register(username, "another-long-password"); err != nil || !strings.HasPrefix(duplicate.Content, "RF#") {
+		t.Errorf("duplicate registration was accepted: %+v (%v)", duplicate, err)
+	}
+	if duplicate, err := sdk.Register(strings.ToUpper(username[:1])+username[1:], "another-long-password"); err != nil || !strings.HasPrefix(duplicate.Content, "RF#") {
+		t.Errorf("registration differing only in case was accepted: %+v (%v)", duplicate, err)
+	}
+	if failed, err := sdk.Login(username, "wrong-password-entirely"); err != nil || failed.PacketCode != "LF#" {
+		t.Errorf("wrong password logged in: %+v (%v)", failed, err)
+	}
+	profile := fetchProfile(t, sdk)
+	if profile.Account_id != sdk.AccountID || profile.Name != username || profile.LastLevel != "00001" {
+		t.Errorf("unexpected fresh profile %+v", profile)
+	}
+	if len(profile.Items.Collection) != 2 || len(profile.SpellIndex) != 2 {
+		t.Errorf("starting items and spells were not granted: %v %v", profile.Items.Collection, profile.SpellIndex)
+	}
+}
+
+func TestConformanceInventoryAndLoadout(t *testing.T) {
+	sdk, _ := startPlayer(t, newGameplayDatabase(t))
+	if added, err := sdk.AddItem("Fang"); err != nil || added.Content != "Item added successfully!" {
+		t.Errorf("item was not added: %+v (%v)", added, err)
+	}
+	if added, _ := sdk.AddItem("Dragon"); added.Content != "Item does not exist!" {
+		t.Errorf("unknown item was added: %+v", added)
+	}
+	if equipped, err := sdk.Equip("WizardRobe"); err != nil || equipped.Content != "EQUIP$1" {
+		t.Errorf("item was not equipped: %+v (%v)", equipped, err)
+	}
+	profile := fetchProfile(t, sdk)
+	if profile.Loadout.Body != "WizardRobe" || profile.Stats.Defense != 3 || !containsString(profile.Items.Collection, "Fang") {
+		t.Errorf("unexpected profile after equipping %+v %+v %v", profile.Loadout, profile.Stats, profile.Items.Collection)
+	}
+}
+
+func TestConformanceBattleRewards(t *testing.T) {
+	sdk, _ := startPlayer(t, newGameplayDatabase(t))
+	started, err := sdk.ReadyForBattle("00001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var battle BattlePacket
+	if err := started.Decode(&battle); err != nil || battle.BattleID == uuid.Nil || len(*battle.Monsters) != 1 {
+		t.Fatalf("unexpected battle %+v (%v)", battle, err)
+	}
+	finished, err := sdk.FinishBattle(battle.BattleID, []int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Split(string(finished.Payload()), "|")
+	if len(fields) < 5 || fields[0] != "30" || fields[1] != "7" || fields[2] != "True" || fields[len(fields)-1] != `["Fang"]` {
+		t.Fatalf("unexpected battle finish %q", finished.Content)
+	}
+	profile := fetchProfile(t, sdk)
+	if profile.Purse.Bits != 7 || profile.Total_EXP != 30 || profile.MonsterKills["Wolf"] != 1 || !containsString(profile.Items.Collection, "Fang") {
+		t.Errorf("rewards were not written: %+v", profile)
+	}
+	// a battle is only rewarded once
+	if again, _ := sdk.FinishBattle(battle.BattleID, []int{1}); strings.Split(string(again.Payload()), "|")[2] != "False" {
+		t.Errorf("battle was rewarded twice: %q", again.Payload())
+	}
+}
+
+// startImpostor opens a connection that never logged in and claims another player's account id.
+func startImpostor(t *testing.T, mongoClient *mongo.Client, accountID uuid.UUID) *client.Client {
+	sdk, _ := startConformanceServerWith(t, mongoClient)
+	sdk.AccountID = accountID
+	return sdk
+}
+func expectUnbound(t *testing.T, code string, response client.Packet, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", code, err)
+	}
+	if ok, detail := response.Result(); ok || detail != "Not logged in on this connection" {
+		t.Errorf("%s was accepted from a connection the account did not log in on: %q", code, response.Content)
+	}
+}
+
+func TestConformanceTradeRequiresTheLoggedInConnection(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	victim, _ := startPlayer(t, mongoClient)
+	partner, _ := startPlayer(t, mongoClient)
+	impostor := startImpostor(t, mongoClient, victim.AccountID)
+
+	response, err := impostor.Request("TR#", victim.AccountID.String(), partner.AccountID.String())
+	expectUnbound(t, "TR#", response, err)
+	response, err = impostor.Request("TO#", victim.AccountID.String(), "WizardRobe", "0")
+	expectUnbound(t, "TO#", response, err)
+	response, err = impostor.Request("TC#", victim.AccountID.String(), "")
+	expectUnbound(t, "TC#", response, err)
+	tradesMutex.Lock()
+	_, found := getTrade(victim.AccountID)
+	tradesMutex.Unlock()
+	if found {
+		t.Errorf("the impostor opened a trade for the victim")
+	}
+
+	// the victim's own connection still trades
+	if response, err := victim.Request("TR#", victim.AccountID.String(), partner.AccountID.String()); err != nil || !strings.HasPrefix(response.Content, "TRADE$1") {
+		t.Errorf("the logged in player could not request a trade: %+v (%v)", response, err)
+	}
+	cancelTrade(victim.AccountID, "test over", mongoClient)
+}
+
+func TestConformanceMarketRequiresTheLoggedInConnection(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	victim, _ := startPlayer(t, mongoClient)
+	impostor := startImpostor(t, mongoClient, victim.AccountID)
+
+	response, err := impostor.Request("ML#", victim.AccountID.String(), "WizardRobe", "10", LISTING_FIXED, "")
+	expectUnbound(t, "ML#", response, err)
+	for _, code := range []string{"MB#", "MC#", "MD#"} {
+		response, err := impostor.Request(code, victim.AccountID.String(), uuid.NewString(), "10")
+		expectUnbound(t, code, response, err)
+	}
+	if profile := fetchProfile(t, victim); !containsString(profile.Items.Collection, "WizardRobe") {
+		t.Errorf("the impostor listed the victim's item: %v", profile.Items.Collection)
+	}
+}
+
+func TestConformanceMailRequiresTheLoggedInConnection(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	victim, _ := startPlayer(t, mongoClient)
+	impostor := startImpostor(t, mongoClient, victim.AccountID)
+	message := newMail(victim.AccountID, "Sold", "Your Wizard Hat sold", []string{"Fang"}, 40)
+	if err := deliverMail(mongoClient, message); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := impostor.Request("MM#", victim.AccountID.String())
+	expectUnbound(t, "MM#", response, err)
+	response, err = impostor.Request("MX#", victim.AccountID.String(), message.Mail_id.String())
+	expectUnbound(t, "MX#", response, err)
+	if mailbox := listMail(victim.AccountID, mongoClient); !strings.Contains(mailbox, message.Mail_id.String()) {
+		t.Errorf("the impostor claimed the victim's mail: %q", mailbox)
+	}
+}
+
+func TestConformanceChatRequiresTheLoggedInConnection(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	victim, _ := startPlayer(t, mongoClient)
+	_, friendName := startPlayer(t, mongoClient)
+	friend, _ := getUser(friendName, mongoClient)
+	impostor := startImpostor(t, mongoClient, victim.AccountID)
+
+	response, err := impostor.Request("CH#", victim.AccountID.String(), "global", "", "sent as someone else")
+	expectUnbound(t, "CH#", response, err)
+	response, err = impostor.Request("CB#", victim.AccountID.String(), friendName)
+	expectUnbound(t, "CB#", response, err)
+	if isChatBlocked(victim.AccountID, friend.Account_id, mongoClient) {
+		t.Errorf("the impostor edited the victim's block list")
+	}
+}
+
+func TestConformancePartyRequiresTheLoggedInConnection(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	victim, _ := startPlayer(t, mongoClient)
+	impostor := startImpostor(t, mongoClient, victim.AccountID)
+
+	for _, code := range []string{"PC#", "PL#", "PR#"} {
+		response, err := impostor.Request(code, victim.AccountID.String())
+		expectUnbound(t, code, response, err)
+	}
+	response, err := impostor.Request("PA#", victim.AccountID.String(), uuid.NewString())
+	expectUnbound(t, "PA#", response, err)
+	response, err = impostor.Request("PS#", victim.AccountID.String(), "equal")
+	expectUnbound(t, "PS#", response, err)
+	if _, found := getParty(victim.AccountID); found {
+		t.Errorf("the impostor put the victim in a party")
+	}
+}
+
+// waitForSnapshot skips other pushes until the next SN# snapshot and decodes it.
+func waitForSnapshot(t *testing.T, sdk *client.Client, received map[uint32]Snapshot) Snapshot {
+	t.Helper()
+	for {
+		packet := waitForPush(t, sdk)
+		if packet.PacketCode != "SN#" {
+			continue
+		}
+		encoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(packet.Content, "SNAPSHOT$"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshot, err := decodeSnapshot(encoded, func(sequence uint32) (Snapshot, bool) {
+			baseline, found := received[sequence]
+			return baseline, found
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		received[snapshot.Sequence] = snapshot
+		return snapshot
+	}
+}
+
+func TestConformanceSnapshotsFollowHeartbeats(t *testing.T) {
+	sdk, _ := startPlayer(t, newGameplayDatabase(t))
+	networkID := getNetworkID(sdk.AccountID)
+	received := make(map[uint32]Snapshot)
+
+	sdk.Heartbeat(1, 0, 1)
+	first := waitForSnapshot(t, sdk, received)
+	if first.Entities[networkID] != quantizePosition(Position{Position_x: 1, Position_z: 1}) {
+		t.Fatalf("the snapshot does not hold the player : %+v", first)
+	}
+	sdk.AckSnapshot(first.Sequence)
+	sdk.Heartbeat(2, 0, 1)
+	second := waitForSnapshot(t, sdk, received)
+	if second.Sequence != first.Sequence+1 || second.Entities[networkID] != quantizePosition(Position{Position_x: 2, Position_z: 1}) {
+		t.Errorf("unexpected snapshot after the ack : %+v", second)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PlayerConnection struct {
+	Account_id    uuid.UUID      `json:"uuid" default:""`
+	Connection    *outboundQueue `json:"-"`
+	ConnectTime   time.Time      `json:"connect_time" default:""`
+	LevelID       string         `json:"level_id" default:""`
+	Status        string         `json:"status" default:""`
+	LastPosition  Position       `json:"last_position" default:""`
+	LastHeartbeat time.Time      `json:"last_heartbeat" default:""`
+	PositionDirty bool           `json:"-"`
+	MoveBanked    float64        `json:"-"`
+	Violations    int            `json:"-"`
+}
+
+// outboundQueue is a net.Conn whose writes are queued and flushed in order by a single writer goroutine.
+// Every response and push for a connection goes through it, so concurrent writers can never interleave.
+type outboundQueue struct {
+	net.Conn
+	queue chan []byte
+	done  chan struct{}
+	once  sync.Once
+	codec atomic.Value
+}
+
+var OUTBOUND_QUEUE_SIZE = 64
+
+// how long a writer waits for room in a full queue before the client is treated as a slow consumer
+var OUTBOUND_ENQUEUE_TIMEOUT = 2 * time.Second
+
+// how long a single socket write may take before the connection is dropped
+var OUTBOUND_WRITE_TIMEOUT = 5 * time.Second
+
+var errPlayerOffline = errors.New("player is not connected")
+var errSlowConsumer = errors.New("outbound queue stayed full, client disconnected")
+
+// every account that has identified itself on a live TCP connection
+var playerConnections = make(map[uuid.UUID]*PlayerConnection)
+var playerConnectionsMutex sync.RWMutex
+
+// bindPlayerConnection binds an account to the connection it logged in on, replacing an older session.
+// Packets carry the account id themselves, so only a successful login may bind one.
+func bindPlayerConnection(accountID uuid.UUID, clientConnection net.Conn) *PlayerConnection {
+	outbound, queued := clientConnection.(*outboundQueue)
+	if !queued {
+		outbound = newOutboundQueue(clientConnection)
+	}
+	playerConnectionsMutex.Lock()
+	defer playerConnectionsMutex.Unlock()
+	if entry, found := playerConnections[accountID]; found && entry.Connection == outbound {
+		return entry
+	}
+	entry := &PlayerConnection{
+		Account_id:  accountID,
+		Connection:  outbound,
+		ConnectTime: time.Now(),
+	}
+	playerConnections[accountID] = entry
+	return entry
+}
+
+// markPlayerOnline reports whether the account is bound to this connection and whether this is the
+// first time it has been seen on it since logging in.
+func markPlayerOnline(accountID uuid.UUID, clientConnection net.Conn) (bool, bool) {
+	playerConnectionsMutex.Lock()
+	defer playerConnectionsMutex.Unlock()
+	entry, found := playerConnections[accountID]
+	if !found || net.Conn(entry.Connection) != clientConnection {
+		return false, false
+	}
+	if entry.Status != "" {
+		return true, false
+	}
+	entry.Status = PRESENCE_ONLINE
+	return true, true
+}
+func setPlayerLevel(accountID uuid.UUID, levelID string) {
+	playerConnectionsMutex.Lock()
+	defer playerConnectionsMutex.Unlock()
+	if entry, found := playerConnections[accountID]; found {
+		entry.LevelID = levelID
+	}
+}
+func setPlayerPosition(accountID uuid.UUID, position Position) {
+	playerConnectionsMutex.Lock()
+	defer playerConnectionsMutex.Unlock()
+	if entry, found := playerConnections[accountID]; found {
+		entry.LastPosition = position
+		entry.LastHeartbeat = time.Now()
+		entry.MoveBanked = MOVE_TOLERANCE
+		entry.PositionDirty = false
+	}
+}
+
+func setPlayerStatus(accountID uuid.UUID, status string) bool {
+	playerConnectionsMutex.Lock()
+	defer playerConnectionsMutex.Unlock()
+	if entry, found := playerConnections[accountID]; found && entry.Status != status {
+		entry.Status = status
+		return true
+	}
+	return false
+}
+func getPlayerConnection(accountID uuid.UUID) (PlayerConnection, bool) {
+	playerConnectionsMutex.RLock()
+	defer playerConnectionsMutex.RUnlock()
+	if entry, found := playerConnections[accountID]; found {
+		return *entry, true
+	}
+	return PlayerConnection{}, false
+}
+
+// dropPlayerConnection forgets every account bound to a closed connection and returns their last state.
+func dropPlayerConnection(clientConnection net.Conn) []PlayerConnection {
+	playerConnectionsMutex.Lock()
+	defer playerConnectionsMutex.Unlock()
+	var dropped []PlayerConnection
+	for accountID, entry := range playerConnections {
+		if net.Conn(entry.Connection) == clientConnection {
+			delete(playerConnections, accountID)
+			dropped = append(dropped, *entry)
+		}
+	}
+	return dropped
+}
+func getOnlinePlayers() []PlayerConnection {
+	playerConnectionsMutex.RLock()
+	defer playerConnectionsMutex.RUnlock()
+	online := make([]PlayerConnection, 0, len(playerConnections))
+	for _, entry := range playerConnections {
+		online = append(online, *entry)
+	}
+	return online
+}
+
+// Push sends an unsolicited packet to an online player from any goroutine (battles, chat, parties, admin).
+// Pushes are not requested by the client, so they are never added to the SOS packet cache.
+func Push(accountID uuid.UUID, packet Packet) error {
+	entry, online := getPlayerConnection(accountID)
+	if !online {
+		return errPlayerOffline
+	}
+	if packet.Chain {
+		chainWriteResponse(accountID, packet.PacketID.String(), packet, PACKET_SIZE, entry.Connection, true)
+	} else {
+		writeResponse(accountID, packet.PacketID.String(), packet, entry.Connection, true)
+	}
+	fmt.Println(Info("Pushed ", packet.ServiceType, " to ", accountID))
+	return nil
+}
+
+func newOutboundQueue(clientConnection net.Conn) *outboundQueue {
+	outbound := &outboundQueue{
+		Conn:  clientConnection,
+		queue: make(chan []byte, OUTBOUND_QUEUE_SIZE),
+		done:  make(chan struct{}),
+	}
+	go outbound.run()
+	return outbound
+}
+
+// Write queues a message for the writer goroutine. A full queue blocks the caller for up to
+// OUTBOUND_ENQUEUE_TIMEOUT, after which the client is disconnected as a slow consumer.
+func (outbound *outboundQueue) Write(data []byte) (int, error) {
+	message := append([]byte(nil), data...)
+	select {
+	case <-outbound.done:
+		return 0, net.ErrClosed
+	case outbound.queue <- message:
+		return len(data), nil
+	default:
+	}
+	timer := time.NewTimer(OUTBOUND_ENQUEUE_TIMEOUT)
+	defer timer.Stop()
+	select {
+	case <-outbound.done:
+		return 0, net.ErrClosed
+	case outbound.queue <- message:
+		return len(data), nil
+	case <-timer.C:
+		fmt.Println(Warn("Disconnecting slow consumer : ", outbound.RemoteAddr()))
+		outbound.disconnect()
+		return 0, errSlowConsumer
+	}
+}
+
+// Close stops accepting writes, flushes what is already queued and then closes the socket.
+func (outbound *outboundQueue) Close() error {
+	outbound.once.Do(func() { close(outbound.done) })
+	return nil
+}
+func (outbound *outboundQueue) disconnect() {
+	outbound.Close()
+	outbound.Conn.Close()
+}
+func (outbound *outboundQueue) run() {
+	defer outbound.Conn.Close()
+	for {
+		select {
+		case message := <-outbound.queue:
+			if !outbound.flush(message) {
+				return
+			}
+		case <-outbound.done:
+			for {
+				select {
+				case message := <-outbound.queue:
+					if !outbound.flush(message) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+func (outbound *outboundQueue) flush(message []byte) bool {
+	outbound.Conn.SetWriteDeadline(time.Now().Add(OUTBOUND_WRITE_TIMEOUT))
+	if _, err := outbound.Conn.Write(message); err != nil {
+		fmt.Println(Failure(err))
+		outbound.disconnect()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOutboundQueuePreservesOrder(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	outbound := newOutboundQueue(server)
+
+	go func() {
+		outbound.Write([]byte("first;"))
+		outbound.Write([]byte("second;"))
+		outbound.Close()
+	}()
+
+	received, _ := io.ReadAll(client)
+	if string(received) != "first;second;" {
+		t.Errorf("unexpected stream %q", received)
+	}
+}
+
+func TestOutboundQueueDisconnectsSlowConsumer(t *testing.T) {
+	queueSize, enqueueTimeout := OUTBOUND_QUEUE_SIZE, OUTBOUND_ENQUEUE_TIMEOUT
+	OUTBOUND_QUEUE_SIZE, OUTBOUND_ENQUEUE_TIMEOUT = 1, 10*time.Millisecond
+	defer func() { OUTBOUND_QUEUE_SIZE, OUTBOUND_ENQUEUE_TIMEOUT = queueSize, enqueueTimeout }()
+
+	server, client := net.Pipe()
+	defer client.Close()
+	outbound := newOutboundQueue(server)
+
+	// nobody reads from the client side, so the writer blocks and the queue fills up
+	var err error
+	for i := 0; i < 4 && err == nil; i++ {
+		_, err = outbound.Write([]byte("payload"))
+	}
+	if err != errSlowConsumer {
+		t.Fatalf("expected slow consumer error, got %v", err)
+	}
+	if _, err := outbound.Write([]byte("late")); err != net.ErrClosed {
+		t.Errorf("expected writes after disconnect to fail, got %v", err)
+	}
+}
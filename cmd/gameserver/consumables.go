@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// ItemUse describes what a consumable does, Buff lasts for Buff.Lifetime seconds and Cooldown is in seconds
+type ItemUse struct {
+	Heal_health     float64 `json:"heal_health" default:"0" bson:"heal_health"`
+	Heal_mana       float64 `json:"heal_mana" default:"0" bson:"heal_mana"`
+	Cure_effect     string  `json:"cure_effect" default:"" bson:"cure_effect"`
+	Buff            *Effect `json:"buff" default:"" bson:"buff,omitempty"`
+	Teleport_level  string  `json:"teleport_level" default:"" bson:"teleport_level"`
+	Teleport_region string  `json:"teleport_region" default:"" bson:"teleport_region"`
+	Cooldown        int32   `json:"cooldown" default:"0" bson:"cooldown"`
+}
+type Vitals struct {
+	Health float64 `json:"health" default:"0" bson:"health"`
+	Mana   float64 `json:"mana" default:"0" bson:"mana"`
+}
+type ItemUseResult struct {
+	Item_id    string    `json:"item_id" default:""`
+	Vitals     Vitals    `json:"vitals" default:""`
+	Effects    []Effect  `json:"effects" default:""`
+	LastLevel  string    `json:"last_level" default:""`
+	LastRegion string    `json:"last_region" default:""`
+	BattleID   uuid.UUID `json:"battle_id" default:""`
+	Turn       int       `json:"turn" default:"0"`
+}
+
+// last time every player used every item, for cooldowns
+var itemCooldowns = make(map[uuid.UUID]map[string]time.Time)
+var itemCooldownsMutex sync.Mutex
+
+// reserveCooldown starts an item's cooldown unless it is still running, in which case it returns the time left.
+// The previous use is returned so a use that fails afterwards can hand the cooldown back with releaseCooldown.
+func reserveCooldown(accountID uuid.UUID, item Item) (time.Time, time.Duration) {
+	itemCooldownsMutex.Lock()
+	defer itemCooldownsMutex.Unlock()
+	lastUsed, found := itemCooldowns[accountID][item.Item_id]
+	if found {
+		if remaining := time.Until(lastUsed.Add(time.Duration(item.Use.Cooldown) * time.Second)); remaining > 0 {
+			return lastUsed, remaining
+		}
+	} else {
+		itemCooldowns[accountID] = make(map[string]time.Time)
+	}
+	itemCooldowns[accountID][item.Item_id] = time.Now()
+	return lastUsed, 0
+}
+func releaseCooldown(accountID uuid.UUID, itemID string, lastUsed time.Time) {
+	itemCooldownsMutex.Lock()
+	defer itemCooldownsMutex.Unlock()
+	if lastUsed.IsZero() {
+		delete(itemCooldowns[accountID], itemID)
+		return
+	}
+	itemCooldowns[accountID][itemID] = lastUsed
+}
+func pruneExpiredEffects(effects []Effect) []Effect {
+	active := make([]Effect, 0, len(effects))
+	for _, effect := range effects {
+		if effect.Expires_at.IsZero() || time.Now().Before(effect.Expires_at) {
+			active = append(active, effect)
+		}
+	}
+	return active
+}
+
+// profileVitals returns the current vitals of a profile. Health is never saved at zero, so a profile
+// without vitals was created before they existed and is at full health and mana.
+func profileVitals(profile *Profile) Vitals {
+	if profile.Vitals == (Vitals{}) {
+		return Vitals{Health: profile.Stats.Health, Mana: profile.Stats.Mana}
+	}
+	return profile.Vitals
+}
+
+// applyItemUse works out the vitals and effects of a profile after using an item on it.
+// Vitals are only capped by stats the profile actually has.
+func applyItemUse(use *ItemUse, profile *Profile) (Vitals, []Effect) {
+	vitals := profileVitals(profile)
+	vitals.Health += use.Heal_health
+	if profile.Stats.Health > 0 && vitals.Health > profile.Stats.Health {
+		vitals.Health = profile.Stats.Health
+	}
+	vitals.Mana += use.Heal_mana
+	if profile.Stats.Mana > 0 && vitals.Mana > profile.Stats.Mana {
+		vitals.Mana = profile.Stats.Mana
+	}
+	effects := make([]Effect, 0, len(profile.Effects)+1)
+	for _, effect := range pruneExpiredEffects(profile.Effects) {
+		if use.Cure_effect != "" && effect.Effect_id == use.Cure_effect {
+			continue
+		}
+		effects = append(effects, effect)
+	}
+	if use.Buff != nil {
+		buff := *use.Buff
+		buff.Ticks_left = buff.Lifetime
+		buff.Expires_at = time.Now().Add(time.Duration(buff.Lifetime) * time.Second).UTC()
+		effects = append(effects, buff)
+	}
+	return vitals, effects
+}
+
+// useItem consumes one consumable and applies its effects in a single update. Inside a battle it
+// is a battle action and uses the player's turn.
+func useItem(accountID uuid.UUID, itemID string, battleIDSTR string, mongoClient *mongo.Client) string {
+	item, found := currentWorld().Items[itemID]
+	if !found || item.Use == nil {
+		return "ITEM$0;Item cannot be used"
+	}
+	var battleID uuid.UUID
+	if battleIDSTR != "" {
+		battleID, _ = uuid.Parse(battleIDSTR)
+		if item.Use.Teleport_level != "" {
+			return "ITEM$0;Cannot teleport during a battle"
+		}
+	}
+	// the cooldown and battle turn are taken before the update so concurrent uses cannot both pass the checks
+	lastUsed, remaining := reserveCooldown(accountID, item)
+	if remaining > 0 {
+		return "ITEM$0;Cooldown " + remaining.Round(time.Second).String()
+	}
+	used := false
+	defer func() {
+		if !used {
+			releaseCooldown(accountID, itemID, lastUsed)
+		}
+	}()
+	if battleIDSTR != "" {
+		if reason := reserveBattleTurn(battleID, accountID); reason != "" {
+			return "ITEM$0;" + reason
+		}
+		defer func() {
+			if !used {
+				releaseBattleTurn(battleID, accountID)
+			}
+		}()
+	}
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	database := mongoClient.Database("player")
+	profiles := database.Collection("profiles")
+	for attempt := 0; attempt < REWARD_RETRY_LIMIT; attempt++ {
+		profile, _ := getProfile(accountID, mongoClient)
+		if profile == nil {
+			return "ITEM$0;Profile does not exist"
+		}
+		collection, removed := removeItemsFromCollection(profile.Items.Collection, map[string]int{itemID: 1})
+		if !removed {
+			return "ITEM$0;You do not have this item"
+		}
+		result := ItemUseResult{Item_id: itemID, LastLevel: profile.LastLevel, LastRegion: profile.LastRegion, BattleID: battleID}
+		result.Vitals, result.Effects = applyItemUse(item.Use, profile)
+		changes := bson.M{"items.collection": collection, "vitals": result.Vitals, "effects": result.Effects}
+		if item.Use.Teleport_level != "" {
+			result.LastLevel = item.Use.Teleport_level
+			result.LastRegion = item.Use.Teleport_region
+			changes["last_level"] = result.LastLevel
+			changes["last_region"] = result.LastRegion
+			changes["last_position"] = Position{}
+		}
+		match := bson.M{"uuid": accountID, "items.collection": profile.Items.Collection}
+		updateResponse, err := profiles.UpdateOne(cxt, match, bson.M{"$set": changes})
+		if err != nil {
+			fmt.Println(Failure(err))
+			return "ITEM$0;Item use failed"
+		}
+		if updateResponse.MatchedCount == 1 {
+			used = true
+			if battleIDSTR != "" {
+				result.Turn = recordBattleTurn(battleID, accountID, BattleAction{Type: "item", Value: itemID})
+			}
+			if item.Use.Teleport_level != "" {
+				setPlayerPosition(accountID, Position{})
+				setPresenceLevel(accountID, result.LastLevel, mongoClient)
+				broadcastPosition(accountID, result.LastLevel, Position{})
+			}
+			resultJSON, _ := json.Marshal(result)
+			return "ITEM$1;" + string(resultJSON)
+		}
+		fmt.Println(Warn("Profile changed while using item, retrying..."))
+	}
+	return "ITEM$0;Item use failed"
+}
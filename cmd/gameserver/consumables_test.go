@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestApplyItemUse(t *testing.T) {
+	profile := &Profile{}
+	profile.Stats.Health = 100
+	profile.Stats.Mana = 50
+	profile.Vitals = Vitals{Health: 90, Mana: 10}
+	profile.Effects = []Effect{{Effect_id: "Poison"}, {Effect_id: "Burn"}}
+
+	use := &ItemUse{Heal_health: 25, Heal_mana: 15, Cure_effect: "Poison", Buff: &Effect{Effect_id: "Haste", Lifetime: 30}}
+	vitals, effects := applyItemUse(use, profile)
+	if vitals.Health != 100 || vitals.Mana != 25 {
+		t.Errorf("expected health capped at 100 and mana 25, got %v", vitals)
+	}
+	if len(effects) != 2 || effects[0].Effect_id != "Burn" || effects[1].Effect_id != "Haste" {
+		t.Fatalf("expected poison cured and haste applied, got %v", effects)
+	}
+	if effects[1].Expires_at.IsZero() {
+		t.Errorf("expected buff to have an expiry")
+	}
+
+	// profiles saved before vitals existed are full, profiles without stats are not capped at zero
+	legacy := &Profile{Stats: Stats{Health: 100, Mana: 50}}
+	if vitals, _ := applyItemUse(&ItemUse{Heal_health: 25}, legacy); vitals.Health != 100 || vitals.Mana != 50 {
+		t.Errorf("expected a profile without vitals to stay full, got %v", vitals)
+	}
+	unstated := &Profile{Vitals: Vitals{Health: 10}}
+	if vitals, _ := applyItemUse(&ItemUse{Heal_health: 25}, unstated); vitals.Health != 35 {
+		t.Errorf("expected a profile without stats to be healed, got %v", vitals)
+	}
+}
+
+func TestReserveCooldown(t *testing.T) {
+	accountID := uuid.New()
+	item := Item{Item_id: "Potion", Use: &ItemUse{Cooldown: 60}}
+
+	lastUsed, remaining := reserveCooldown(accountID, item)
+	if remaining != 0 || !lastUsed.IsZero() {
+		t.Fatalf("expected the first use to reserve the cooldown, got %v", remaining)
+	}
+	if _, remaining := reserveCooldown(accountID, item); remaining <= 0 {
+		t.Errorf("expected a second use to wait for the cooldown")
+	}
+	releaseCooldown(accountID, item.Item_id, lastUsed)
+	if _, remaining := reserveCooldown(accountID, item); remaining != 0 {
+		t.Errorf("expected a released cooldown to be usable again, got %v", remaining)
+	}
+}
+
+func TestBattleTurns(t *testing.T) {
+	first, second := uuid.New(), uuid.New()
+	battleID := uuid.New()
+	sessionsMutex.Lock()
+	if sessions.Battles == nil {
+		sessions.Battles = make(map[uuid.UUID]BattleSession)
+	}
+	sessions.Battles[battleID] = BattleSession{Participants: []uuid.UUID{first, second}}
+	sessionsMutex.Unlock()
+	defer func() {
+		sessionsMutex.Lock()
+		delete(sessions.Battles, battleID)
+		sessionsMutex.Unlock()
+	}()
+
+	if reason := reserveBattleTurn(battleID, first); reason != "" {
+		t.Fatalf("expected first player to act, got %q", reason)
+	}
+	if reason := reserveBattleTurn(battleID, first); reason != "Already acted this turn" {
+		t.Errorf("expected a second action of the first player to be blocked, got %q", reason)
+	}
+	if turn := recordBattleTurn(battleID, first, BattleAction{Type: "item", Value: "Potion"}); turn != 0 {
+		t.Errorf("expected turn to stay at 0, got %d", turn)
+	}
+	// a failed action hands its reservation back without moving the turn on
+	if reason := reserveBattleTurn(battleID, second); reason != "" {
+		t.Fatalf("expected second player to act, got %q", reason)
+	}
+	releaseBattleTurn(battleID, second)
+	if reason := reserveBattleTurn(battleID, second); reason != "" {
+		t.Fatalf("expected second player to act again after releasing, got %q", reason)
+	}
+	if turn := recordBattleTurn(battleID, second, BattleAction{Type: "item", Value: "Potion"}); turn != 1 {
+		t.Errorf("expected turn to advance to 1, got %d", turn)
+	}
+	if reason := reserveBattleTurn(battleID, first); reason != "" {
+		t.Errorf("expected first player to act on the new turn, got %q", reason)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Success_chance is between 0 and 1, leaving it unset means the recipe always succeeds
+type Recipe struct {
+	ObjectID       primitive.ObjectID `json:"objectID" bson:"_id, omitempty"`
+	Recipe_id      string             `json:"recipe_id" default:"" bson:"recipe_id"`
+	Name           string             `json:"name" default:"" bson:"name"`
+	Inputs         []RecipeIngredient `json:"inputs" default:"" bson:"inputs"`
+	Output         RecipeIngredient   `json:"output" default:"" bson:"output"`
+	Required_level int                `json:"required_level" default:"0" bson:"required_level"`
+	Required_class string             `json:"required_class" default:"" bson:"required_class"`
+	Success_chance float64            `json:"success_chance" default:"1" bson:"success_chance"`
+	Bits_cost      float64            `json:"bits_cost" default:"0" bson:"bits_cost"`
+}
+type RecipeIngredient struct {
+	Item_id  string `json:"item_id" default:"" bson:"item_id"`
+	Quantity int    `json:"quantity" default:"1" bson:"quantity"`
+}
+type RecipeListing struct {
+	Recipe    Recipe `json:"recipe" default:""`
+	Craftable bool   `json:"craftable" default:"false"`
+	Reason    string `json:"reason" default:""`
+}
+type CraftResult struct {
+	Recipe_id string   `json:"recipe_id" default:""`
+	Success   bool     `json:"success" default:"false"`
+	Items     []string `json:"items" default:""`
+	Bits      float64  `json:"bits" default:"0"`
+}
+
+func getRecipeInputs(recipe Recipe) map[string]int {
+	inputs := make(map[string]int)
+	for _, input := range recipe.Inputs {
+		quantity := input.Quantity
+		if quantity < 1 {
+			quantity = 1
+		}
+		inputs[input.Item_id] += quantity
+	}
+	return inputs
+}
+
+// canCraft checks level, class, bits and materials and returns why the recipe cannot be made.
+func canCraft(recipe Recipe, profile *Profile) (bool, string) {
+	if profile.Level < recipe.Required_level {
+		return false, "Level too low"
+	}
+	if recipe.Required_class != "" && recipe.Required_class != profile.Class_id {
+		return false, "Wrong class"
+	}
+	if profile.Purse.Bits < recipe.Bits_cost {
+		return false, "Not enough bits"
+	}
+	for itemID, quantity := range getRecipeInputs(recipe) {
+		if countItem(profile.Items.Collection, itemID) < quantity {
+			return false, "Missing materials"
+		}
+	}
+	return true, ""
+}
+
+// craftItem consumes the inputs and bits and grants the output in one update. A failed success roll
+// still consumes the materials. The update only matches if the inventory and purse are unchanged.
+func craftItem(accountID uuid.UUID, recipeID string, mongoClient *mongo.Client) string {
+	recipe, found := currentWorld().Recipes[recipeID]
+	if !found {
+		return "CRAFT$0;Recipe does not exist"
+	}
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	database := mongoClient.Database("player")
+	profiles := database.Collection("profiles")
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for attempt := 0; attempt < REWARD_RETRY_LIMIT; attempt++ {
+		profile, _ := getProfile(accountID, mongoClient)
+		if profile == nil {
+			return "CRAFT$0;Profile does not exist"
+		}
+		if craftable, reason := canCraft(recipe, profile); !craftable {
+			return "CRAFT$0;" + reason
+		}
+		collection, _ := removeItemsFromCollection(profile.Items.Collection, getRecipeInputs(recipe))
+		result := CraftResult{Recipe_id: recipeID, Items: []string{}, Bits: profile.Purse.Bits - recipe.Bits_cost}
+		result.Success = recipe.Success_chance <= 0 || rng.Float64() < recipe.Success_chance
+		if result.Success {
+			quantity := recipe.Output.Quantity
+			if quantity < 1 {
+				quantity = 1
+			}
+			for i := 0; i < quantity; i++ {
+				result.Items = append(result.Items, recipe.Output.Item_id)
+			}
+			collection = append(collection, result.Items...)
+		}
+		match := bson.M{"uuid": accountID, "items.collection": profile.Items.Collection, "purse.bits": profile.Purse.Bits}
+		change := bson.M{"$set": bson.M{"items.collection": collection, "purse.bits": result.Bits}}
+		updateResponse, err := profiles.UpdateOne(cxt, match, change)
+		if err != nil {
+			fmt.Println(Failure(err))
+			return "CRAFT$0;Crafting failed"
+		}
+		if updateResponse.MatchedCount == 1 {
+			fmt.Println(Success("Crafted ", recipeID, " success : ", result.Success))
+			emitQuestEvent(accountID, QuestEvent{Type: "collect", Target: recipe.Output.Item_id}, mongoClient)
+			resultJSON, _ := json.Marshal(result)
+			return "CRAFT$1;" + string(resultJSON)
+		}
+		fmt.Println(Warn("Profile changed while crafting, retrying..."))
+	}
+	return "CRAFT$0;Crafting failed"
+}
+
+// listRecipes returns every recipe with whether the player can make it now, or only the craftable ones.
+func listRecipes(accountID uuid.UUID, filter string, mongoClient *mongo.Client) string {
+	profile, _ := getProfile(accountID, mongoClient)
+	if profile == nil {
+		return "CRAFT$0;Profile does not exist"
+	}
+	listings := []RecipeListing{}
+	for _, recipe := range currentWorld().Recipes {
+		craftable, reason := canCraft(recipe, profile)
+		if filter != "all" && !craftable {
+			continue
+		}
+		listings = append(listings, RecipeListing{Recipe: recipe, Craftable: craftable, Reason: reason})
+	}
+	sort.Slice(listings, func(i, j int) bool { return listings[i].Recipe.Recipe_id < listings[j].Recipe.Recipe_id })
+	listingsJSON, _ := json.Marshal(listings)
+	return "CRAFT$1;" + string(listingsJSON)
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestCanCraft(t *testing.T) {
+	recipe := Recipe{
+		Recipe_id:      "IronSword",
+		Inputs:         []RecipeIngredient{{Item_id: "IronOre", Quantity: 2}, {Item_id: "Wood"}},
+		Output:         RecipeIngredient{Item_id: "IronSword", Quantity: 1},
+		Required_level: 2,
+		Required_class: "warrior",
+		Bits_cost:      10,
+	}
+	profile := &Profile{Level: 2, Class_id: "warrior"}
+	profile.Purse.Bits = 10
+	profile.Items.Collection = []string{"IronOre", "Wood"}
+
+	if craftable, reason := canCraft(recipe, profile); craftable || reason != "Missing materials" {
+		t.Errorf("expected missing materials, got %v %q", craftable, reason)
+	}
+	profile.Items.Collection = append(profile.Items.Collection, "IronOre")
+	if craftable, reason := canCraft(recipe, profile); !craftable {
+		t.Errorf("expected recipe to be craftable, got %q", reason)
+	}
+	profile.Class_id = "stranger"
+	if craftable, reason := canCraft(recipe, profile); craftable || reason != "Wrong class" {
+		t.Errorf("expected class requirement to fail, got %v %q", craftable, reason)
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type DialogueTree struct {
+	Root  string                  `json:"root" default:"" bson:"root"`
+	Nodes map[string]DialogueNode `json:"nodes" default:"" bson:"nodes"`
+}
+type DialogueNode struct {
+	Text    string           `json:"text" default:"" bson:"text"`
+	Choices []DialogueChoice `json:"choices" default:"" bson:"choices"`
+	Actions []DialogueAction `json:"actions" default:"" bson:"actions"`
+}
+
+// a choice with an empty Next ends the conversation
+type DialogueChoice struct {
+	Text       string              `json:"text" default:"" bson:"text"`
+	Next       string              `json:"next" default:"" bson:"next"`
+	Conditions []DialogueCondition `json:"conditions" default:"" bson:"conditions"`
+}
+
+// Type is one of "level" (Quantity is the minimum level), "item" (own Quantity of item Value)
+// or "quest" (quest Value is in State)
+type DialogueCondition struct {
+	Type     string `json:"type" default:"" bson:"type"`
+	Value    string `json:"value" default:"" bson:"value"`
+	Quantity int    `json:"quantity" default:"0" bson:"quantity"`
+	State    string `json:"state" default:"" bson:"state"`
+}
+
+// Type is one of "give_item", "open_shop", "start_quest" or "start_battle"
+type DialogueAction struct {
+	Type     string `json:"type" default:"" bson:"type"`
+	Value    string `json:"value" default:"" bson:"value"`
+	Quantity int    `json:"quantity" default:"1" bson:"quantity"`
+}
+type DialogueState struct {
+	Npc_id  string `json:"npc_id" default:""`
+	Node_id string `json:"node_id" default:""`
+}
+type DialogueOption struct {
+	Index int    `json:"index" default:"0"`
+	Text  string `json:"text" default:""`
+}
+type DialogueEvent struct {
+	Type    string `json:"type" default:""`
+	Result  string `json:"result" default:""`
+	Payload string `json:"payload" default:""`
+}
+type DialoguePacket struct {
+	Npc_id   string           `json:"npc_id" default:""`
+	Node_id  string           `json:"node_id" default:""`
+	Text     string           `json:"text" default:""`
+	Choices  []DialogueOption `json:"choices" default:""`
+	Events   []DialogueEvent  `json:"events" default:""`
+	Finished bool             `json:"finished" default:"false"`
+}
+
+var DIALOGUE_CONDITIONS = map[string]bool{"level": true, "item": true, "quest": true}
+var DIALOGUE_ACTIONS = map[string]bool{"give_item": true, "open_shop": true, "start_quest": true, "start_battle": true}
+
+// the conversation each player is currently in, so choices are only accepted from the node they were shown
+var dialogueStates = make(map[uuid.UUID]DialogueState)
+var dialogueMutex sync.Mutex
+
+func countItem(collection []string, itemID string) int {
+	count := 0
+	for _, ownedItem := range collection {
+		if ownedItem == itemID {
+			count++
+		}
+	}
+	return count
+}
+func checkDialogueCondition(condition DialogueCondition, profile *Profile) bool {
+	switch condition.Type {
+	case "level":
+		return profile.Level >= condition.Quantity
+	case "item":
+		quantity := condition.Quantity
+		if quantity < 1 {
+			quantity = 1
+		}
+		return countItem(profile.Items.Collection, condition.Value) >= quantity
+	case "quest":
+		return getQuestState(profile, condition.Value) == condition.State
+	}
+	return false
+}
+func isChoiceAvailable(choice DialogueChoice, profile *Profile) bool {
+	for _, condition := range choice.Conditions {
+		if !checkDialogueCondition(condition, profile) {
+			return false
+		}
+	}
+	return true
+}
+
+// buildDialoguePacket shows a node with only the choices the player currently qualifies for.
+func buildDialoguePacket(npcID string, nodeID string, node DialogueNode, profile *Profile) DialoguePacket {
+	dialoguePacket := DialoguePacket{Npc_id: npcID, Node_id: nodeID, Text: node.Text, Choices: []DialogueOption{}, Events: []DialogueEvent{}}
+	for index, choice := range node.Choices {
+		if isChoiceAvailable(choice, profile) {
+			dialoguePacket.Choices = append(dialoguePacket.Choices, DialogueOption{Index: index, Text: choice.Text})
+		}
+	}
+	dialoguePacket.Finished = len(dialoguePacket.Choices) == 0
+	return dialoguePacket
+}
+
+// dialogueFlag names a node's item reward, so revisiting the node cannot hand it out again.
+func dialogueFlag(npcID string, nodeID string, itemID string) string {
+	return npcID + "/" + nodeID + "/" + itemID
+}
+
+// giveDialogueItem grants a node's item reward once per account. The flag is set in the same update
+// that adds the items, so concurrent visits cannot both be granted it.
+func giveDialogueItem(accountID uuid.UUID, flag string, action DialogueAction, mongoClient *mongo.Client) string {
+	item, found := currentWorld().Item(action.Value)
+	if !found {
+		return "Item does not exist!"
+	}
+	quantity := action.Quantity
+	if quantity < 1 {
+		quantity = 1
+	}
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	profiles := mongoClient.Database("player").Collection("profiles")
+	granted := make([]string, quantity)
+	for index := range granted {
+		granted[index] = item.Item_id
+	}
+	match := bson.M{"uuid": accountID, "dialogue_flags": bson.M{"$ne": flag}}
+	change := bson.M{
+		"$push":     bson.M{"items.collection": bson.M{"$each": granted}},
+		"$addToSet": bson.M{"dialogue_flags": flag},
+	}
+	updateResponse, err := profiles.UpdateOne(cxt, match, change)
+	if err != nil {
+		fmt.Println(Failure(err))
+		return "Item addition failed!"
+	}
+	if updateResponse.MatchedCount == 0 {
+		return "Item already received!"
+	}
+	emitQuestEvent(accountID, QuestEvent{Type: "collect", Target: item.Item_id, Quantity: quantity}, mongoClient)
+	return "Item added successfully!"
+}
+func runDialogueAction(accountID uuid.UUID, npcID string, nodeID string, action DialogueAction, mongoClient *mongo.Client) DialogueEvent {
+	event := DialogueEvent{Type: action.Type}
+	switch action.Type {
+	case "give_item":
+		event.Result = giveDialogueItem(accountID, dialogueFlag(npcID, nodeID, action.Value), action, mongoClient)
+	case "open_shop":
+		if shopkeeper, found := getShopkeeper(action.Value); found {
+			shopkeeperJSON, _ := json.Marshal(shopkeeper)
+			event.Payload = string(shopkeeperJSON)
+		} else {
+			event.Result = "Shop does not exist!"
+		}
+	case "start_quest":
+		event.Result = acceptQuest(accountID, action.Value, mongoClient)
+	case "start_battle":
+		monsters := currentWorld().MonsterList(strings.Split(action.Value, ","))
+		if len(*monsters) == 0 {
+			event.Result = "Monster does not exist!"
+			break
+		}
+		battle := createBattle(monsters, len(*monsters), []uuid.UUID{accountID}, DEFAULT_PARTY_SPLIT_RULE)
+		setPresenceStatus(accountID, PRESENCE_IN_BATTLE, mongoClient)
+		var battlePacket BattlePacket
+		battlePacket.BattleID = battle.BattleID
+		battlePacket.PlayerProfile, _ = getProfile(accountID, mongoClient)
+		battlePacket.Monsters = battle.Monsters
+		battlePacket.MonsterQuantity = len(*battle.Monsters)
+		battleJSON, _ := json.Marshal(battlePacket)
+		event.Payload = string(battleJSON)
+	}
+	return event
+}
+
+// enterDialogueNode moves the player to a node, runs its actions and returns what they see.
+func enterDialogueNode(accountID uuid.UUID, npcID string, tree *DialogueTree, nodeID string, mongoClient *mongo.Client) DialoguePacket {
+	node := tree.Nodes[nodeID]
+	var events []DialogueEvent
+	for _, action := range node.Actions {
+		events = append(events, runDialogueAction(accountID, npcID, nodeID, action, mongoClient))
+	}
+	profile, _ := getProfile(accountID, mongoClient)
+	if profile == nil {
+		profile = &Profile{}
+	}
+	dialoguePacket := buildDialoguePacket(npcID, nodeID, node, profile)
+	dialoguePacket.Events = append(dialoguePacket.Events, events...)
+	dialogueMutex.Lock()
+	defer dialogueMutex.Unlock()
+	if dialoguePacket.Finished {
+		delete(dialogueStates, accountID)
+	} else {
+		dialogueStates[accountID] = DialogueState{Npc_id: npcID, Node_id: nodeID}
+	}
+	return dialoguePacket
+}
+func dialogueFailure(reason string) string {
+	return "DIALOGUE$0;" + reason
+}
+func dialogueResponse(dialoguePacket DialoguePacket) string {
+	dialogueJSON, _ := json.Marshal(dialoguePacket)
+	return "DIALOGUE$1;" + string(dialogueJSON)
+}
+
+// startDialogue opens the root node of an NPC standing in the player's current level.
+func startDialogue(accountID uuid.UUID, npcID string, mongoClient *mongo.Client) string {
+	levelID := ""
+	if entry, online := getPlayerConnection(accountID); online {
+		levelID = entry.LevelID
+	}
+	// players who have not requested a level since logging in are still where their profile left them
+	if levelID == "" {
+		if profile, _ := getProfile(accountID, mongoClient); profile != nil {
+			levelID = profile.LastLevel
+		}
+	}
+	if levelID != "" && !containsString(currentWorld().Level(levelID).Residents, npcID) {
+		return dialogueFailure("NPC is not in your level")
+	}
+	npc := getNPC(npcID, mongoClient)
+	if npc.NpcID == "" {
+		return dialogueFailure("NPC does not exist")
+	}
+	emitQuestEvent(accountID, QuestEvent{Type: "talk", Target: npcID, Quantity: 1}, mongoClient)
+	if npc.DialogueTree == nil {
+		return dialogueFailure("NPC has nothing to say")
+	}
+	if _, found := npc.DialogueTree.Nodes[npc.DialogueTree.Root]; !found {
+		return dialogueFailure("NPC dialogue is broken")
+	}
+	return dialogueResponse(enterDialogueNode(accountID, npcID, npc.DialogueTree, npc.DialogueTree.Root, mongoClient))
+}
+
+// chooseDialogueOption follows a choice of the node the player is on, re-checking its conditions.
+func chooseDialogueOption(accountID uuid.UUID, choiceIndex int, mongoClient *mongo.Client) string {
+	dialogueMutex.Lock()
+	state, talking := dialogueStates[accountID]
+	dialogueMutex.Unlock()
+	if !talking {
+		return dialogueFailure("Not in a conversation")
+	}
+	npc := getNPC(state.Npc_id, mongoClient)
+	if npc.DialogueTree == nil {
+		return dialogueFailure("NPC has nothing to say")
+	}
+	node, found := npc.DialogueTree.Nodes[state.Node_id]
+	if !found || choiceIndex < 0 || choiceIndex >= len(node.Choices) {
+		return dialogueFailure("Invalid choice")
+	}
+	choice := node.Choices[choiceIndex]
+	profile, _ := getProfile(accountID, mongoClient)
+	if profile == nil || !isChoiceAvailable(choice, profile) {
+		return dialogueFailure("Choice is not available")
+	}
+	if choice.Next == "" {
+		dialogueMutex.Lock()
+		delete(dialogueStates, accountID)
+		dialogueMutex.Unlock()
+		return dialogueResponse(DialoguePacket{Npc_id: state.Npc_id, Choices: []DialogueOption{}, Events: []DialogueEvent{}, Finished: true})
+	}
+	if _, found := npc.DialogueTree.Nodes[choice.Next]; !found {
+		return dialogueFailure("NPC dialogue is broken")
+	}
+	return dialogueResponse(enterDialogueNode(accountID, state.Npc_id, npc.DialogueTree, choice.Next, mongoClient))
+}
+func parseDialogueChoice(choiceSTR string) int {
+	choiceIndex, err := strconv.Atoi(choiceSTR)
+	if err != nil {
+		return -1
+	}
+	return choiceIndex
+}
+func containsString(values []string, value string) bool {
+	for _, entry := range values {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDialogueTree reports dangling references, unreachable nodes and unknown condition or action types.
+func validateDialogueTree(tree *DialogueTree) []string {
+	var problems []string
+	if tree == nil {
+		return problems
+	}
+	if _, found := tree.Nodes[tree.Root]; !found {
+		problems = append(problems, "root node '"+tree.Root+"' does not exist")
+	}
+	nodeIDs := make([]string, 0, len(tree.Nodes))
+	for nodeID := range tree.Nodes {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+	for _, nodeID := range nodeIDs {
+		node := tree.Nodes[nodeID]
+		for _, action := range node.Actions {
+			if !DIALOGUE_ACTIONS[action.Type] {
+				problems = append(problems, "node '"+nodeID+"' has unknown action '"+action.Type+"'")
+			}
+		}
+		for index, choice := range node.Choices {
+			if choice.Next != "" {
+				if _, found := tree.Nodes[choice.Next]; !found {
+					problems = append(problems, fmt.Sprintf("node '%s' choice %d points to missing node '%s'", nodeID, index, choice.Next))
+				}
+			}
+			for _, condition := range choice.Conditions {
+				if !DIALOGUE_CONDITIONS[condition.Type] {
+					problems = append(problems, fmt.Sprintf("node '%s' choice %d has unknown condition '%s'", nodeID, index, condition.Type))
+				}
+			}
+		}
+	}
+	reachable := map[string]bool{}
+	pending := []string{tree.Root}
+	for len(pending) > 0 {
+		nodeID := pending[0]
+		pending = pending[1:]
+		node, found := tree.Nodes[nodeID]
+		if !found || reachable[nodeID] {
+			continue
+		}
+		reachable[nodeID] = true
+		for _, choice := range node.Choices {
+			if choice.Next != "" {
+				pending = append(pending, choice.Next)
+			}
+		}
+	}
+	for _, nodeID := range nodeIDs {
+		if !reachable[nodeID] {
+			problems = append(problems, "node '"+nodeID+"' is unreachable")
+		}
+	}
+	return problems
+}
+
+// validateResidentDialogues checks every authored dialogue tree in world/npcs and logs the problems found.
+func validateResidentDialogues(mongoClient *mongo.Client) int {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	database := mongoClient.Database("world")
+	npcs := database.Collection("npcs")
+	filterCursor, err := npcs.Find(cxt, bson.M{"dialogueTree": bson.M{"$exists": true}})
+	if err != nil {
+		fmt.Println(Failure(err))
+		panic(err)
+	}
+	var filterResult []Resident
+	if err = filterCursor.All(cxt, &filterResult); err != nil {
+		log.Fatal(err)
+	}
+	problemCount := 0
+	for _, npc := range filterResult {
+		for _, problem := range validateDialogueTree(npc.DialogueTree) {
+			fmt.Println(Warn("Dialogue of ", npc.NpcID, " : ", problem))
+			problemCount++
+		}
+	}
+	return problemCount
+}
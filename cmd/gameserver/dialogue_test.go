@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestValidateDialogueTree(t *testing.T) {
+	tree := &DialogueTree{
+		Root: "greet",
+		Nodes: map[string]DialogueNode{
+			"greet": {Text: "Hello", Choices: []DialogueChoice{
+				{Text: "Shop", Next: "shop"},
+				{Text: "Secret", Next: "vault", Conditions: []DialogueCondition{{Type: "mood"}}},
+				{Text: "Bye"},
+			}},
+			"shop":   {Text: "Take a look", Actions: []DialogueAction{{Type: "open_shop", Value: "NPC1"}, {Type: "dance"}}},
+			"hidden": {Text: "Nobody gets here"},
+		},
+	}
+
+	expected := []string{
+		"node 'greet' choice 1 points to missing node 'vault'",
+		"node 'greet' choice 1 has unknown condition 'mood'",
+		"node 'shop' has unknown action 'dance'",
+		"node 'hidden' is unreachable",
+	}
+	if problems := validateDialogueTree(tree); !reflect.DeepEqual(problems, expected) {
+		t.Errorf("unexpected problems:\n%v", problems)
+	}
+}
+
+func TestBuildDialoguePacketFiltersChoices(t *testing.T) {
+	node := DialogueNode{Text: "What do you want?", Choices: []DialogueChoice{
+		{Text: "Veteran talk", Next: "veteran", Conditions: []DialogueCondition{{Type: "level", Quantity: 10}}},
+		{Text: "Show the key", Next: "door", Conditions: []DialogueCondition{{Type: "item", Value: "RustyKey"}}},
+		{Text: "Leave"},
+	}}
+	profile := &Profile{Level: 3}
+	profile.Items.Collection = []string{"RustyKey"}
+
+	dialoguePacket := buildDialoguePacket("NPC1", "start", node, profile)
+	expected := []DialogueOption{{Index: 1, Text: "Show the key"}, {Index: 2, Text: "Leave"}}
+	if !reflect.DeepEqual(dialoguePacket.Choices, expected) {
+		t.Errorf("unexpected choices %v", dialoguePacket.Choices)
+	}
+}
+
+func TestDialogueItemIsGivenOncePerAccount(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	accountID := uuid.New()
+	createProfile("ana", accountID, mongoClient)
+	elder := bson.M{"npcID": "Elder", "dialogueTree": DialogueTree{Root: "greet", Nodes: map[string]DialogueNode{
+		"greet": {Text: "Take this", Actions: []DialogueAction{{Type: "give_item", Value: "WizardHat", Quantity: 2}},
+			Choices: []DialogueChoice{{Text: "Again", Next: "greet"}, {Text: "Bye"}}},
+	}}}
+	hermit := bson.M{"npcID": "Hermit", "dialogueTree": DialogueTree{Root: "greet", Nodes: map[string]DialogueNode{"greet": {Text: "Go away"}}}}
+	if _, err := mongoClient.Database("world").Collection("npcs").InsertMany(context.Background(), []interface{}{elder, hermit}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the player has not requested a level yet, so the level saved on the profile decides
+	if response := startDialogue(accountID, "Hermit", mongoClient); response != dialogueFailure("NPC is not in your level") {
+		t.Errorf("talked to an NPC outside the profile's level : %q", response)
+	}
+	if response := startDialogue(accountID, "Elder", mongoClient); !strings.Contains(response, "Item added successfully!") {
+		t.Fatalf("the first visit did not give the item : %q", response)
+	}
+	if response := chooseDialogueOption(accountID, 0, mongoClient); !strings.Contains(response, "Item already received!") {
+		t.Errorf("revisiting the node gave the item again : %q", response)
+	}
+	profile, _ := getProfile(accountID, mongoClient)
+	if countItem(profile.Items.Collection, "WizardHat") != 2 || len(profile.DialogueFlags) != 1 {
+		t.Errorf("unexpected profile after the reward : %v %v", profile.Items.Collection, profile.DialogueFlags)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// A chained response is sent as fragments of the form
+//
+//	<base>*#<message id>?<index>?<count>?<crc>?<length>?<data>
+//
+// "*" is never part of a packet code, so fragments cannot be confused with responses like LF#.
+// The server picks the message id, index is 1 based, crc is the CRC-32 (IEEE, 8 hex digits) of the
+// whole reassembled message and length is the byte length of data.
+type Fragment struct {
+	Base       string
+	Message_id uuid.UUID
+	Index      int
+	Count      int
+	CRC        uint32
+	Data       string
+}
+
+// FRAGMENT_MARKER ends the code of every fragment in place of a simple packet's "#"
+const FRAGMENT_MARKER = "*#"
+
+// how many chained messages per account are kept so single fragments can be re-requested
+var RETRANSMIT_BUFFER_MESSAGES = 16
+
+var errFragmentMalformed = errors.New("fragment is malformed")
+var errFragmentMissing = errors.New("message is missing fragments")
+var errFragmentChecksum = errors.New("message checksum does not match")
+
+type retransmitBuffer struct {
+	order    []uuid.UUID
+	messages map[uuid.UUID][]string
+}
+
+var retransmitBuffers = make(map[uuid.UUID]*retransmitBuffer)
+var retransmitBuffersMutex sync.Mutex
+
+func encodeFragment(fragment Fragment) string {
+	return fmt.Sprintf("%s"+FRAGMENT_MARKER+"%s?%d?%d?%08x?%d?%s", fragment.Base, fragment.Message_id, fragment.Index, fragment.Count, fragment.CRC, len(fragment.Data), fragment.Data)
+}
+
+// fragmentMessage splits a payload into encoded fragments of at most fragmentSize data bytes each.
+func fragmentMessage(base string, messageID uuid.UUID, payload []byte, fragmentSize int) []string {
+	checksum := crc32.ChecksumIEEE(payload)
+	count := (len(payload) + fragmentSize - 1) / fragmentSize
+	if count == 0 {
+		count = 1
+	}
+	fragments := make([]string, 0, count)
+	for index := 1; index <= count; index++ {
+		start := (index - 1) * fragmentSize
+		end := start + fragmentSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		fragment := Fragment{Base: base, Message_id: messageID, Index: index, Count: count, CRC: checksum, Data: string(payload[start:end])}
+		fragments = append(fragments, encodeFragment(fragment))
+	}
+	return fragments
+}
+
+// parseFragment reads one fragment off the front of a stream and returns what follows it.
+func parseFragment(stream string) (Fragment, string, error) {
+	var fragment Fragment
+	codeEnd := strings.Index(stream, FRAGMENT_MARKER)
+	if codeEnd < 0 {
+		return fragment, stream, errFragmentMalformed
+	}
+	fragment.Base = stream[:codeEnd]
+	fields := strings.SplitN(stream[codeEnd+len(FRAGMENT_MARKER):], "?", 6)
+	if len(fields) != 6 {
+		return fragment, stream, errFragmentMalformed
+	}
+	var err error
+	if fragment.Message_id, err = uuid.Parse(fields[0]); err != nil {
+		return fragment, stream, errFragmentMalformed
+	}
+	index, indexErr := strconv.Atoi(fields[1])
+	count, countErr := strconv.Atoi(fields[2])
+	checksum, checksumErr := strconv.ParseUint(fields[3], 16, 32)
+	length, lengthErr := strconv.Atoi(fields[4])
+	if indexErr != nil || countErr != nil || checksumErr != nil || lengthErr != nil || length > len(fields[5]) || index < 1 || index > count {
+		return fragment, stream, errFragmentMalformed
+	}
+	fragment.Index, fragment.Count, fragment.CRC = index, count, uint32(checksum)
+	fragment.Data = fields[5][:length]
+	return fragment, fields[5][length:], nil
+}
+
+// reassembleMessage joins the fragments of one message in index order and verifies its checksum.
+func reassembleMessage(fragments []Fragment) ([]byte, error) {
+	if len(fragments) == 0 {
+		return nil, errFragmentMissing
+	}
+	ordered := make([]string, fragments[0].Count)
+	received := 0
+	for _, fragment := range fragments {
+		if fragment.Count != len(ordered) || ordered[fragment.Index-1] != "" {
+			continue
+		}
+		ordered[fragment.Index-1] = fragment.Data
+		received++
+	}
+	if received != len(ordered) {
+		return nil, errFragmentMissing
+	}
+	message := []byte(strings.Join(ordered, ""))
+	if crc32.ChecksumIEEE(message) != fragments[0].CRC {
+		return nil, errFragmentChecksum
+	}
+	return message, nil
+}
+
+// bufferFragments keeps a message's fragments for re-requests, dropping the oldest message when full.
+func bufferFragments(accountID uuid.UUID, messageID uuid.UUID, fragments []string) {
+	retransmitBuffersMutex.Lock()
+	defer retransmitBuffersMutex.Unlock()
+	buffer, found := retransmitBuffers[accountID]
+	if !found {
+		buffer = &retransmitBuffer{messages: make(map[uuid.UUID][]string)}
+		retransmitBuffers[accountID] = buffer
+	}
+	if _, known := buffer.messages[messageID]; !known {
+		buffer.order = append(buffer.order, messageID)
+	}
+	buffer.messages[messageID] = fragments
+	for len(buffer.order) > RETRANSMIT_BUFFER_MESSAGES {
+		delete(buffer.messages, buffer.order[0])
+		buffer.order = buffer.order[1:]
+	}
+}
+
+// getBufferedFragments returns the requested fragments (1 based) of a buffered message, or all of them if none are named.
+func getBufferedFragments(accountID uuid.UUID, messageID uuid.UUID, indices []int) ([]string, bool) {
+	retransmitBuffersMutex.Lock()
+	defer retransmitBuffersMutex.Unlock()
+	buffer, found := retransmitBuffers[accountID]
+	if !found {
+		return nil, false
+	}
+	fragments, found := buffer.messages[messageID]
+	if !found {
+		return nil, false
+	}
+	if len(indices) == 0 {
+		return append([]string(nil), fragments...), true
+	}
+	selected := make([]string, 0, len(indices))
+	for _, index := range indices {
+		if index >= 1 && index <= len(fragments) {
+			selected = append(selected, fragments[index-1])
+		}
+	}
+	return selected, true
+}
+func dropRetransmitBuffer(accountID uuid.UUID) {
+	retransmitBuffersMutex.Lock()
+	defer retransmitBuffersMutex.Unlock()
+	delete(retransmitBuffers, accountID)
+}
+
+// parseFragmentIndices reads a comma separated list of fragment indices, skipping anything that is not a number.
+func parseFragmentIndices(indicesSTR string) []int {
+	var indices []int
+	for _, field := range strings.Split(indicesSTR, ",") {
+		if index, err := strconv.Atoi(strings.TrimSpace(field)); err == nil {
+			indices = append(indices, index)
+		}
+	}
+	return indices
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestFragmentRoundTrip(t *testing.T) {
+	messageID := uuid.New()
+	payload := []byte(strings.Repeat("0123456789", 14) + "?*#tail")
+	fragments := fragmentMessage("LL", messageID, payload, 10)
+	if len(fragments) != 15 {
+		t.Fatalf("expected 15 fragments, got %d", len(fragments))
+	}
+
+	// fragments 1 of 15 and 11 of 15 must not be confused the way the old "base+index+count" codes were
+	stream := strings.Join(fragments, "")
+	var parsed []Fragment
+	for stream != "" {
+		fragment, rest, err := parseFragment(stream)
+		if err != nil {
+			t.Fatalf("unexpected parse error %v", err)
+		}
+		if fragment.Base != "LL" || fragment.Message_id != messageID || fragment.Count != 15 {
+			t.Fatalf("unexpected fragment header %+v", fragment)
+		}
+		parsed = append(parsed, fragment)
+		stream = rest
+	}
+	// deliver out of order
+	parsed[0], parsed[10] = parsed[10], parsed[0]
+	message, err := reassembleMessage(parsed)
+	if err != nil || string(message) != string(payload) {
+		t.Fatalf("expected the message to reassemble, got %q %v", message, err)
+	}
+	if _, err := reassembleMessage(parsed[1:]); err != errFragmentMissing {
+		t.Errorf("expected a missing fragment to be reported, got %v", err)
+	}
+	parsed[3].Data = "corrupted!"
+	if _, err := reassembleMessage(parsed); err != errFragmentChecksum {
+		t.Errorf("expected corruption to fail the checksum, got %v", err)
+	}
+}
+
+func TestRetransmitBuffer(t *testing.T) {
+	accountID := uuid.New()
+	defer dropRetransmitBuffer(accountID)
+	first := uuid.New()
+	bufferFragments(accountID, first, fragmentMessage("SH", first, []byte("abcdefghij"), 3))
+
+	resent, found := getBufferedFragments(accountID, first, parseFragmentIndices("2, 4,x"))
+	if !found || len(resent) != 2 {
+		t.Fatalf("expected two buffered fragments, got %v %v", resent, found)
+	}
+	if fragment, _, _ := parseFragment(resent[1]); fragment.Index != 4 || fragment.Data != "j" {
+		t.Errorf("expected the last fragment, got %+v", fragment)
+	}
+	for i := 0; i < RETRANSMIT_BUFFER_MESSAGES; i++ {
+		messageID := uuid.New()
+		bufferFragments(accountID, messageID, fragmentMessage("SH", messageID, []byte("x"), 3))
+	}
+	if _, found := getBufferedFragments(accountID, first, nil); found {
+		t.Errorf("expected the oldest message to be evicted")
+	}
+}
+
+func TestChainedSendsGetTheirOwnMessageID(t *testing.T) {
+	accountID := uuid.New()
+	defer dropRetransmitBuffer(accountID)
+	defer dropPacketCache(accountID)
+	server, client := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	outbound := newOutboundQueue(server)
+	defer outbound.Close()
+
+	// a request id that is not a UUID parses to uuid.Nil, both sends must still be re-requestable
+	first := createMultiDeliveryPacket("not-a-uuid", "LL#", "LEVEL", []byte(`{"name":"first"}`))
+	second := createMultiDeliveryPacket("not-a-uuid", "LL#", "LEVEL", []byte(`{"name":"second"}`))
+	firstID := chainWriteResponse(accountID, "not-a-uuid", first, 8, outbound, false)
+	secondID := chainWriteResponse(accountID, "not-a-uuid", second, 8, outbound, false)
+	if firstID == uuid.Nil || firstID == secondID {
+		t.Fatalf("expected distinct message ids, got %s and %s", firstID, secondID)
+	}
+	resent, found := getBufferedFragments(accountID, firstID, []int{1})
+	if !found || len(resent) != 1 {
+		t.Fatalf("expected the first message to stay buffered, got %v %v", resent, found)
+	}
+	if fragment, _, err := parseFragment(resent[0]); err != nil || fragment.Base != "LL" || fragment.Message_id != firstID {
+		t.Errorf("unexpected fragment %+v %v", fragment, err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type FriendList struct {
+	Account_id uuid.UUID   `json:"uuid" bson:"uuid,omitempty"`
+	Friends    []uuid.UUID `json:"friends" default:"" bson:"friends"`
+	Incoming   []uuid.UUID `json:"incoming" default:"" bson:"incoming"`
+	Outgoing   []uuid.UUID `json:"outgoing" default:"" bson:"outgoing"`
+}
+type FriendListPacket struct {
+	Friends  []Presence `json:"friends" default:""`
+	Incoming []Presence `json:"incoming" default:""`
+	Outgoing []Presence `json:"outgoing" default:""`
+}
+
+var MAX_FRIENDS = 100
+
+func getFriendList(accountID uuid.UUID, mongoClient *mongo.Client) *FriendList {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	database := mongoClient.Database("player")
+	friends := database.Collection("friends")
+	friendList := &FriendList{Account_id: accountID}
+	if err := friends.FindOne(cxt, bson.M{"uuid": accountID}).Decode(friendList); err != nil && err != mongo.ErrNoDocuments {
+		fmt.Println(Failure(err))
+	}
+	return friendList
+}
+
+// updateFriendList applies one change to an account's friend document, creating it if needed.
+func updateFriendList(accountID uuid.UUID, change bson.M, mongoClient *mongo.Client) bool {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	database := mongoClient.Database("player")
+	friends := database.Collection("friends")
+	match := bson.M{"uuid": accountID}
+	_, err := friends.UpdateOne(cxt, match, change, options.Update().SetUpsert(true))
+	if err != nil {
+		fmt.Println(Failure(err))
+		return false
+	}
+	return true
+}
+func getUsersByAccount(accountIDs []uuid.UUID, mongoClient *mongo.Client) map[uuid.UUID]*User {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	database := mongoClient.Database("player")
+	users := database.Collection("users")
+	filterCursor, err := users.Find(cxt, bson.M{"uuid": bson.M{"$in": accountIDs}})
+	if err != nil {
+		fmt.Println(Failure(err))
+		panic(err)
+	}
+	var filterResult []User
+	if err = filterCursor.All(cxt, &filterResult); err != nil {
+		log.Fatal(err)
+	}
+	usersByAccount := make(map[uuid.UUID]*User)
+	for index := range filterResult {
+		usersByAccount[filterResult[index].Account_id] = &filterResult[index]
+	}
+	return usersByAccount
+}
+func notifyFriendEvent(accountID uuid.UUID, event string, fromID uuid.UUID, mongoClient *mongo.Client) {
+	users := getUsersByAccount([]uuid.UUID{fromID}, mongoClient)
+	presenceJSON, _ := json.Marshal(getPresence(fromID, users[fromID]))
+	packet := createSimpleDeliveryPacket(uuid.New().String(), "FN#", "FRIENDS", "FRIENDS$"+event+";"+string(presenceJSON))
+	Push(accountID, packet)
+}
+func sendFriendRequest(accountID uuid.UUID, targetID uuid.UUID, mongoClient *mongo.Client) string {
+	if accountID == targetID {
+		return "FRIENDS$0;Cannot befriend yourself"
+	}
+	friendList := getFriendList(accountID, mongoClient)
+	if containsAccount(friendList.Friends, targetID) {
+		return "FRIENDS$0;Already friends"
+	}
+	if len(friendList.Friends) >= MAX_FRIENDS {
+		return "FRIENDS$0;Friend list is full"
+	}
+	// a request to someone who already asked us is an accept
+	if containsAccount(friendList.Incoming, targetID) {
+		return acceptFriendRequest(accountID, targetID, mongoClient)
+	}
+	if !updateFriendList(accountID, bson.M{"$addToSet": bson.M{"outgoing": targetID}}, mongoClient) ||
+		!updateFriendList(targetID, bson.M{"$addToSet": bson.M{"incoming": accountID}}, mongoClient) {
+		return "FRIENDS$0;Could not send request"
+	}
+	notifyFriendEvent(targetID, "REQUEST", accountID, mongoClient)
+	return "FRIENDS$1"
+}
+func acceptFriendRequest(accountID uuid.UUID, targetID uuid.UUID, mongoClient *mongo.Client) string {
+	friendList := getFriendList(accountID, mongoClient)
+	if !containsAccount(friendList.Incoming, targetID) {
+		return "FRIENDS$0;No request from this player"
+	}
+	if !updateFriendList(accountID, bson.M{"$pull": bson.M{"incoming": targetID}, "$addToSet": bson.M{"friends": targetID}}, mongoClient) ||
+		!updateFriendList(targetID, bson.M{"$pull": bson.M{"outgoing": accountID}, "$addToSet": bson.M{"friends": accountID}}, mongoClient) {
+		return "FRIENDS$0;Could not accept request"
+	}
+	notifyFriendEvent(targetID, "ACCEPTED", accountID, mongoClient)
+	return "FRIENDS$1"
+}
+func declineFriendRequest(accountID uuid.UUID, targetID uuid.UUID, mongoClient *mongo.Client) string {
+	if !updateFriendList(accountID, bson.M{"$pull": bson.M{"incoming": targetID}}, mongoClient) ||
+		!updateFriendList(targetID, bson.M{"$pull": bson.M{"outgoing": accountID}}, mongoClient) {
+		return "FRIENDS$0;Could not decline request"
+	}
+	return "FRIENDS$1"
+}
+func removeFriend(accountID uuid.UUID, targetID uuid.UUID, mongoClient *mongo.Client) string {
+	if !updateFriendList(accountID, bson.M{"$pull": bson.M{"friends": targetID, "outgoing": targetID}}, mongoClient) ||
+		!updateFriendList(targetID, bson.M{"$pull": bson.M{"friends": accountID, "incoming": accountID}}, mongoClient) {
+		return "FRIENDS$0;Could not remove friend"
+	}
+	notifyFriendEvent(targetID, "REMOVED", accountID, mongoClient)
+	return "FRIENDS$1"
+}
+func listFriends(accountID uuid.UUID, mongoClient *mongo.Client) string {
+	friendList := getFriendList(accountID, mongoClient)
+	var accountIDs []uuid.UUID
+	accountIDs = append(accountIDs, friendList.Friends...)
+	accountIDs = append(accountIDs, friendList.Incoming...)
+	accountIDs = append(accountIDs, friendList.Outgoing...)
+	users := getUsersByAccount(accountIDs, mongoClient)
+	toPresence := func(accounts []uuid.UUID) []Presence {
+		presences := make([]Presence, 0, len(accounts))
+		for _, friendID := range accounts {
+			presences = append(presences, getPresence(friendID, users[friendID]))
+		}
+		return presences
+	}
+	friendListPacket := FriendListPacket{
+		Friends:  toPresence(friendList.Friends),
+		Incoming: toPresence(friendList.Incoming),
+		Outgoing: toPresence(friendList.Outgoing),
+	}
+	friendListJSON, _ := json.Marshal(friendListPacket)
+	return "FRIENDS$1;" + string(friendListJSON)
+}
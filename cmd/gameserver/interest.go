@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// players only hear about others within INTEREST_RADIUS of them on the x/z plane. The grid cell size
+// matches the radius so a lookup only ever visits the 3x3 cells around a player.
+var INTEREST_RADIUS = 40.0
+
+type gridCell struct {
+	X int
+	Z int
+}
+
+// SpatialGrid buckets the players of one level into square cells by position.
+type SpatialGrid struct {
+	cellSize  float64
+	cells     map[gridCell]map[uuid.UUID]Position
+	locations map[uuid.UUID]gridCell
+}
+
+func newSpatialGrid(cellSize float64) *SpatialGrid {
+	return &SpatialGrid{
+		cellSize:  cellSize,
+		cells:     make(map[gridCell]map[uuid.UUID]Position),
+		locations: make(map[uuid.UUID]gridCell),
+	}
+}
+func (grid *SpatialGrid) cellOf(position Position) gridCell {
+	return gridCell{X: int(math.Floor(position.Position_x / grid.cellSize)), Z: int(math.Floor(position.Position_z / grid.cellSize))}
+}
+
+// Move puts a player at position, inserting them if they are not in the grid yet.
+func (grid *SpatialGrid) Move(accountID uuid.UUID, position Position) {
+	cell := grid.cellOf(position)
+	if previous, found := grid.locations[accountID]; found && previous != cell {
+		grid.removeFromCell(previous, accountID)
+	}
+	if grid.cells[cell] == nil {
+		grid.cells[cell] = make(map[uuid.UUID]Position)
+	}
+	grid.cells[cell][accountID] = position
+	grid.locations[accountID] = cell
+}
+func (grid *SpatialGrid) Remove(accountID uuid.UUID) {
+	if cell, found := grid.locations[accountID]; found {
+		grid.removeFromCell(cell, accountID)
+		delete(grid.locations, accountID)
+	}
+}
+func (grid *SpatialGrid) removeFromCell(cell gridCell, accountID uuid.UUID) {
+	delete(grid.cells[cell], accountID)
+	if len(grid.cells[cell]) == 0 {
+		delete(grid.cells, cell)
+	}
+}
+func (grid *SpatialGrid) Len() int {
+	return len(grid.locations)
+}
+
+// Nearby returns every other player within radius of position.
+func (grid *SpatialGrid) Nearby(accountID uuid.UUID, position Position, radius float64) map[uuid.UUID]Position {
+	nearby := make(map[uuid.UUID]Position)
+	center := grid.cellOf(position)
+	reach := int(math.Ceil(radius / grid.cellSize))
+	for x := center.X - reach; x <= center.X+reach; x++ {
+		for z := center.Z - reach; z <= center.Z+reach; z++ {
+			for otherID, otherPosition := range grid.cells[gridCell{X: x, Z: z}] {
+				if otherID != accountID && math.Hypot(otherPosition.Position_x-position.Position_x, otherPosition.Position_z-position.Position_z) <= radius {
+					nearby[otherID] = otherPosition
+				}
+			}
+		}
+	}
+	return nearby
+}
+
+// InterestChanges is what a single position update means for everyone around the player:
+// Entered and Left crossed the area of interest boundary, Observers should get the new position.
+type InterestChanges struct {
+	Entered   map[uuid.UUID]Position
+	Left      []uuid.UUID
+	Observers []uuid.UUID
+}
+
+// InterestManager keeps a grid per level and the set of players every player can currently see.
+// Visibility is symmetric, so it is updated for both sides whenever either player moves.
+type InterestManager struct {
+	mutex   sync.Mutex
+	radius  float64
+	grids   map[string]*SpatialGrid
+	levels  map[uuid.UUID]string
+	visible map[uuid.UUID]map[uuid.UUID]bool
+}
+
+var interest = newInterestManager(INTEREST_RADIUS)
+
+func newInterestManager(radius float64) *InterestManager {
+	return &InterestManager{
+		radius:  radius,
+		grids:   make(map[string]*SpatialGrid),
+		levels:  make(map[uuid.UUID]string),
+		visible: make(map[uuid.UUID]map[uuid.UUID]bool),
+	}
+}
+
+// Update moves a player within a level and works out who entered or left their area of interest.
+// Changing level leaves everyone in the old one first.
+func (manager *InterestManager) Update(accountID uuid.UUID, levelID string, position Position) InterestChanges {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	changes := InterestChanges{Entered: make(map[uuid.UUID]Position)}
+	if previousLevel, found := manager.levels[accountID]; found && previousLevel != levelID {
+		changes.Left = manager.remove(accountID)
+	}
+	grid := manager.grids[levelID]
+	if grid == nil {
+		grid = newSpatialGrid(manager.radius)
+		manager.grids[levelID] = grid
+	}
+	grid.Move(accountID, position)
+	manager.levels[accountID] = levelID
+	previous := manager.visible[accountID]
+	current := make(map[uuid.UUID]bool)
+	for otherID, otherPosition := range grid.Nearby(accountID, position, manager.radius) {
+		current[otherID] = true
+		changes.Observers = append(changes.Observers, otherID)
+		if !previous[otherID] {
+			changes.Entered[otherID] = otherPosition
+			manager.see(otherID, accountID, true)
+		}
+	}
+	for otherID := range previous {
+		if !current[otherID] {
+			changes.Left = append(changes.Left, otherID)
+			manager.see(otherID, accountID, false)
+		}
+	}
+	manager.visible[accountID] = current
+	return changes
+}
+
+// Remove takes a player out of interest management and returns who could see them.
+func (manager *InterestManager) Remove(accountID uuid.UUID) []uuid.UUID {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return manager.remove(accountID)
+}
+func (manager *InterestManager) remove(accountID uuid.UUID) []uuid.UUID {
+	var observers []uuid.UUID
+	for otherID := range manager.visible[accountID] {
+		observers = append(observers, otherID)
+		manager.see(otherID, accountID, false)
+	}
+	if grid, found := manager.grids[manager.levels[accountID]]; found {
+		grid.Remove(accountID)
+		if grid.Len() == 0 {
+			delete(manager.grids, manager.levels[accountID])
+		}
+	}
+	delete(manager.levels, accountID)
+	delete(manager.visible, accountID)
+	return observers
+}
+
+// Visible returns the current position of every player the account can see.
+func (manager *InterestManager) Visible(accountID uuid.UUID) map[uuid.UUID]Position {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	visible := make(map[uuid.UUID]Position, len(manager.visible[accountID]))
+	grid := manager.grids[manager.levels[accountID]]
+	for otherID := range manager.visible[accountID] {
+		if cell, found := grid.locations[otherID]; found {
+			visible[otherID] = grid.cells[cell][otherID]
+		}
+	}
+	return visible
+}
+func (manager *InterestManager) see(observerID uuid.UUID, accountID uuid.UUID, visible bool) {
+	if visible {
+		if manager.visible[observerID] == nil {
+			manager.visible[observerID] = make(map[uuid.UUID]bool)
+		}
+		manager.visible[observerID][accountID] = true
+		return
+	}
+	delete(manager.visible[observerID], accountID)
+}
+func pushInterest(accountID uuid.UUID, content string) {
+	Push(accountID, createSimpleDeliveryPacket(uuid.New().String(), "IN#", "INTEREST", "INTEREST$"+content))
+}
+func formatInterestPosition(accountID uuid.UUID, position Position) string {
+	return fmt.Sprintf("%s;%g;%g;%g", accountID, position.Position_x, position.Position_y, position.Position_z)
+}
+
+// broadcastPosition sends a player's move to the players around them, with enter and leave events
+// for both sides of every pair that crossed the boundary.
+func broadcastPosition(accountID uuid.UUID, levelID string, position Position) {
+	changes := interest.Update(accountID, levelID, position)
+	for otherID, otherPosition := range changes.Entered {
+		pushInterest(accountID, "ENTER;"+formatInterestPosition(otherID, otherPosition))
+		pushInterest(otherID, "ENTER;"+formatInterestPosition(accountID, position))
+	}
+	for _, otherID := range changes.Left {
+		pushInterest(accountID, "LEAVE;"+otherID.String())
+		pushInterest(otherID, "LEAVE;"+accountID.String())
+	}
+	for _, otherID := range changes.Observers {
+		if _, entered := changes.Entered[otherID]; !entered {
+			pushInterest(otherID, "MOVE;"+formatInterestPosition(accountID, position))
+		}
+	}
+}
+
+// dropInterest removes a player who went offline and tells everyone who could see them.
+func dropInterest(accountID uuid.UUID) {
+	for _, otherID := range interest.Remove(accountID) {
+		pushInterest(otherID, "LEAVE;"+accountID.String())
+	}
+}
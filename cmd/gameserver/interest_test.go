@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestInterestEnterAndLeave(t *testing.T) {
+	manager := newInterestManager(10)
+	first, second := uuid.New(), uuid.New()
+
+	manager.Update(first, "Town", Position{})
+	changes := manager.Update(second, "Town", Position{Position_x: 5})
+	if _, entered := changes.Entered[first]; !entered || len(changes.Observers) != 1 {
+		t.Fatalf("expected players in range to see each other, got %+v", changes)
+	}
+	changes = manager.Update(second, "Town", Position{Position_x: 50})
+	if len(changes.Left) != 1 || changes.Left[0] != first || len(changes.Observers) != 0 {
+		t.Errorf("expected leaving the radius to produce a leave event, got %+v", changes)
+	}
+	if manager.visible[first][second] {
+		t.Errorf("expected visibility to be removed on both sides")
+	}
+	manager.Update(second, "Town", Position{Position_x: 3})
+	changes = manager.Update(second, "Cave", Position{Position_x: 3})
+	if len(changes.Left) != 1 || len(changes.Observers) != 0 {
+		t.Errorf("expected changing level to leave everyone in the old one, got %+v", changes)
+	}
+	if observers := manager.Remove(first); len(observers) != 0 {
+		t.Errorf("expected nobody to see a player alone in a level, got %v", observers)
+	}
+}
+
+func TestSpatialGridNearbyCrossesCells(t *testing.T) {
+	grid := newSpatialGrid(10)
+	self, neighbour, far := uuid.New(), uuid.New(), uuid.New()
+	grid.Move(self, Position{Position_x: 9.5})
+	grid.Move(neighbour, Position{Position_x: 10.5})
+	grid.Move(far, Position{Position_x: 35})
+	nearby := grid.Nearby(self, Position{Position_x: 9.5}, 10)
+	if _, found := nearby[neighbour]; !found || len(nearby) != 1 {
+		t.Errorf("expected only the neighbour across the cell border, got %v", nearby)
+	}
+}
+
+func benchmarkInterestUpdate(b *testing.B, players int) {
+	manager := newInterestManager(INTEREST_RADIUS)
+	rng := rand.New(rand.NewSource(1))
+	accounts := make([]uuid.UUID, players)
+	positions := make([]Position, players)
+	// a fixed size level, so crowding grows with the player count
+	size := 2000.0
+	for i := range accounts {
+		accounts[i] = uuid.New()
+		positions[i] = Position{Position_x: rng.Float64() * size, Position_z: rng.Float64() * size}
+		manager.Update(accounts[i], "Bench", positions[i])
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		player := i % players
+		positions[player].Position_x += rng.Float64()*4 - 2
+		positions[player].Position_z += rng.Float64()*4 - 2
+		manager.Update(accounts[player], "Bench", positions[player])
+	}
+}
+
+func BenchmarkInterestUpdate(b *testing.B) {
+	for _, players := range []int{100, 500, 1000} {
+		b.Run(strconv.Itoa(players)+"Players", func(b *testing.B) {
+			benchmarkInterestUpdate(b, players)
+		})
+	}
+}
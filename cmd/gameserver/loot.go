@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type LootDrop struct {
+	Item_id             string  `json:"item_id" default:"" bson:"item_id"`
+	Chance              float64 `json:"chance" default:"0" bson:"chance"`
+	MinQuantity         int     `json:"min_quantity" default:"1" bson:"min_quantity"`
+	MaxQuantity         int     `json:"max_quantity" default:"1" bson:"max_quantity"`
+	FirstKillGuaranteed bool    `json:"first_kill_guaranteed" default:"false" bson:"first_kill_guaranteed"`
+}
+
+// number of times a reward grant is retried when the profile changed underneath it
+var REWARD_RETRY_LIMIT = 3
+
+// rollLoot rolls every entry of a drop table once and returns the item ids won,
+// one entry per unit of quantity so they can be pushed straight into items.collection.
+func rollLoot(dropTable []LootDrop, firstKill bool, rng *rand.Rand) []string {
+	var drops []string
+	for _, drop := range dropTable {
+		guaranteed := firstKill && drop.FirstKillGuaranteed
+		if !guaranteed && rng.Float64() >= drop.Chance {
+			continue
+		}
+		quantity := drop.MinQuantity
+		if quantity < 1 {
+			quantity = 1
+		}
+		if drop.MaxQuantity > quantity {
+			quantity += rng.Intn(drop.MaxQuantity - quantity + 1)
+		}
+		for i := 0; i < quantity; i++ {
+			drops = append(drops, drop.Item_id)
+		}
+	}
+	return drops
+}
+
+// rollBattleLoot rolls the drop tables of every defeated monster in a battle.
+// Monsters the player has never killed before count as a first kill exactly once.
+func rollBattleLoot(monsters []Monster, rewardMatrix []int, profile *Profile) ([]string, map[string]int) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var loot []string
+	kills := make(map[string]int)
+	items := currentWorld().Items
+	for index, reward := range rewardMatrix {
+		if reward != 1 || index >= len(monsters) {
+			continue
+		}
+		monster := monsters[index]
+		firstKill := profile.MonsterKills[monster.MobID] == 0 && kills[monster.MobID] == 0
+		kills[monster.MobID]++
+		for _, itemID := range rollLoot(monster.DropTable, firstKill, rng) {
+			if _, found := items[itemID]; !found {
+				fmt.Println(Warn("Drop table of ", monster.MobID, " references unknown item : ", itemID))
+				continue
+			}
+			loot = append(loot, itemID)
+		}
+	}
+	return loot, kills
+}
+
+// grantBattleRewards writes bits, EXP, loot and kill counts to the profile in a single update.
+// The update only matches if total_exp has not moved since the profile was read so the
+// level calculation is never applied on top of a stale profile.
+func grantBattleRewards(accountID uuid.UUID, gold float64, exp float64, loot []string, kills map[string]int, mongoClient *mongo.Client) (float64, bool) {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	database := mongoClient.Database("player")
+	profiles := database.Collection("profiles")
+	for attempt := 0; attempt < REWARD_RETRY_LIMIT; attempt++ {
+		profile, _ := getProfile(accountID, mongoClient)
+		if profile == nil {
+			fmt.Println(Failure("Did not find player profile to reward!"))
+			return 0, false
+		}
+		progress, newTotalEXP := calculateEXPProgress(profile, exp)
+		progress = append(progress, battleEndVitals(profile))
+		increments := bson.M{"purse.bits": gold}
+		for mobID, count := range kills {
+			increments["monster_kills."+mobID] = count
+		}
+		change := bson.M{"$set": progress, "$inc": increments}
+		if len(loot) > 0 {
+			change["$push"] = bson.M{"items.collection": bson.M{"$each": loot}}
+		}
+		match := bson.M{"uuid": accountID, "total_exp": profile.Total_EXP}
+		updateResponse, err := profiles.UpdateOne(cxt, match, change)
+		if err != nil {
+			fmt.Println(Failure(err))
+			return 0, false
+		}
+		if updateResponse.MatchedCount == 1 {
+			fmt.Println(Success("Battle rewards granted!"))
+			return newTotalEXP, true
+		}
+		fmt.Println(Warn("Profile changed while granting rewards, retrying..."))
+	}
+	fmt.Println(Failure("Gave up granting battle rewards!"))
+	return 0, false
+}
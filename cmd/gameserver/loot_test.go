@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRollLootGuaranteesFirstKill(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	dropTable := []LootDrop{
+		{Item_id: "WizardHat", Chance: 0, MinQuantity: 1, MaxQuantity: 1, FirstKillGuaranteed: true},
+		{Item_id: "WizardRobe", Chance: 0, MinQuantity: 1, MaxQuantity: 1},
+	}
+
+	drops := rollLoot(dropTable, true, rng)
+	if len(drops) != 1 || drops[0] != "WizardHat" {
+		t.Errorf("expected only the guaranteed drop on first kill, got %v", drops)
+	}
+
+	drops = rollLoot(dropTable, false, rng)
+	if len(drops) != 0 {
+		t.Errorf("expected no drops after first kill, got %v", drops)
+	}
+}
+
+func TestRollLootQuantityRange(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	dropTable := []LootDrop{{Item_id: "Slime", Chance: 1, MinQuantity: 2, MaxQuantity: 4}}
+
+	for i := 0; i < 100; i++ {
+		drops := rollLoot(dropTable, false, rng)
+		if len(drops) < 2 || len(drops) > 4 {
+			t.Fatalf("quantity %d outside of range [2, 4]", len(drops))
+		}
+	}
+}
+
+func TestRollBattleLootCountsFirstKillOnce(t *testing.T) {
+	world := newWorldData()
+	world.Items["Fang"] = Item{Item_id: "Fang"}
+	defer setWorldData(currentWorld())
+	setWorldData(world)
+	wolf := Monster{MobID: "Wolf", DropTable: []LootDrop{{Item_id: "Fang", Chance: 0, FirstKillGuaranteed: true}}}
+	profile := &Profile{}
+
+	loot, kills := rollBattleLoot([]Monster{wolf, wolf}, []int{1, 1}, profile)
+	if len(loot) != 1 {
+		t.Errorf("expected one guaranteed drop for two kills, got %v", loot)
+	}
+	if kills["Wolf"] != 2 {
+		t.Errorf("expected 2 kills recorded, got %d", kills["Wolf"])
+	}
+}
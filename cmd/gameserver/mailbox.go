@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// MailMessage delivers items and bits to a player whether they are online or not. The attachments
+// stay in escrow on the message until it is claimed, once, before Expires_at.
+type MailMessage struct {
+	Mail_id    uuid.UUID `json:"mail_id" default:"" bson:"mail_id"`
+	Account_id uuid.UUID `json:"uuid" default:"" bson:"uuid"`
+	Sender     string    `json:"sender" default:"system" bson:"sender"`
+	Subject    string    `json:"subject" default:"" bson:"subject"`
+	Body       string    `json:"body" default:"" bson:"body"`
+	Items      []string  `json:"items" default:"" bson:"items"`
+	Purse      Purse     `json:"purse" default:"" bson:"purse"`
+	Sent_at    time.Time `json:"sent_at" default:"" bson:"sent_at"`
+	Expires_at time.Time `json:"expires_at" default:"" bson:"expires_at"`
+	Claimed    bool      `json:"claimed" default:"false" bson:"claimed"`
+}
+
+var MAIL_LIFETIME = 30 * 24 * time.Hour
+var MAIL_SYSTEM_SENDER = "system"
+var MAIL_PURGE_INTERVAL = time.Hour
+
+func newMail(accountID uuid.UUID, subject string, body string, items []string, bits float64) MailMessage {
+	if items == nil {
+		items = []string{}
+	}
+	now := time.Now().UTC()
+	return MailMessage{
+		Mail_id:    uuid.New(),
+		Account_id: accountID,
+		Sender:     MAIL_SYSTEM_SENDER,
+		Subject:    subject,
+		Body:       body,
+		Items:      items,
+		Purse:      Purse{Bits: bits},
+		Sent_at:    now,
+		Expires_at: now.Add(MAIL_LIFETIME),
+	}
+}
+func hasAttachments(message MailMessage) bool {
+	return len(message.Items) > 0 || message.Purse.Bits > 0
+}
+
+// sendMail writes messages as part of a caller's transaction so the mail only exists if the rest of it commits.
+func sendMail(sessionContext mongo.SessionContext, mongoClient *mongo.Client, messages ...MailMessage) error {
+	mail := mongoClient.Database("player").Collection("mail")
+	for _, message := range messages {
+		if _, err := mail.InsertOne(sessionContext, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliverMail sends messages on their own, e.g. support refunds and event rewards, and tells online recipients.
+func deliverMail(mongoClient *mongo.Client, messages ...MailMessage) error {
+	err := runTransaction(mongoClient, func(sessionContext mongo.SessionContext) error {
+		return sendMail(sessionContext, mongoClient, messages...)
+	})
+	if err != nil {
+		fmt.Println(Failure("Mail delivery failed : ", err))
+		return err
+	}
+	notifyMail(messages...)
+	return nil
+}
+func notifyMail(messages ...MailMessage) {
+	for _, message := range messages {
+		packet := createSimpleDeliveryPacket(uuid.New().String(), "MN#", "MAIL", "MAIL$NEW;"+message.Subject)
+		Push(message.Account_id, packet)
+	}
+}
+func listMail(accountID uuid.UUID, mongoClient *mongo.Client) string {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	filter := bson.M{"uuid": accountID, "claimed": false, "expires_at": bson.M{"$gt": time.Now().UTC()}}
+	findOptions := options.Find().SetSort(bson.D{{Key: "sent_at", Value: -1}})
+	filterCursor, err := mongoClient.Database("player").Collection("mail").Find(cxt, filter, findOptions)
+	if err != nil {
+		fmt.Println(Failure(err))
+		return "MAIL$0;Mailbox unavailable"
+	}
+	messages := []MailMessage{}
+	if err = filterCursor.All(cxt, &messages); err != nil {
+		fmt.Println(Failure(err))
+		return "MAIL$0;Mailbox unavailable"
+	}
+	messagesJSON, _ := json.Marshal(messages)
+	return "MAIL$1;" + string(messagesJSON)
+}
+
+// claimMail moves a message's items and bits into the profile and marks it claimed in one transaction,
+// the claimed flag is part of the match so a message can never pay out twice.
+func claimMail(accountID uuid.UUID, mailID uuid.UUID, mongoClient *mongo.Client) string {
+	mail := mongoClient.Database("player").Collection("mail")
+	profiles := mongoClient.Database("player").Collection("profiles")
+	var message MailMessage
+	err := runTransaction(mongoClient, func(sessionContext mongo.SessionContext) error {
+		match := bson.M{"mail_id": mailID, "uuid": accountID, "claimed": false, "expires_at": bson.M{"$gt": time.Now().UTC()}}
+		if err := mail.FindOne(sessionContext, match).Decode(&message); err != nil {
+			return errors.New("no such unclaimed mail")
+		}
+		if err := updateOneMatched(sessionContext, mail, match, bson.M{"$set": bson.M{"claimed": true}}); err != nil {
+			return err
+		}
+		if !hasAttachments(message) {
+			return nil
+		}
+		change := bson.M{"$push": bson.M{"items.collection": bson.M{"$each": message.Items}}, "$inc": bson.M{"purse.bits": message.Purse.Bits}}
+		return updateOneMatched(sessionContext, profiles, bson.M{"uuid": accountID}, change)
+	})
+	if err != nil {
+		return "MAIL$0;Claim failed: " + err.Error()
+	}
+	for _, itemID := range message.Items {
+		emitQuestEvent(accountID, QuestEvent{Type: "collect", Target: itemID}, mongoClient)
+	}
+	messageJSON, _ := json.Marshal(message)
+	return "MAIL$1;" + string(messageJSON)
+}
+
+// purgeExpiredMail deletes mail past its expiry, unclaimed attachments on it are lost.
+func purgeExpiredMail(mongoClient *mongo.Client) {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		fmt.Println(Failure(err))
+		return
+	}
+	deleteResponse, err := mongoClient.Database("player").Collection("mail").DeleteMany(cxt, bson.M{"expires_at": bson.M{"$lte": time.Now().UTC()}})
+	if err != nil {
+		fmt.Println(Failure(err))
+		return
+	}
+	if deleteResponse.DeletedCount > 0 {
+		fmt.Println(Info("Expired mail purged : ", deleteResponse.DeletedCount))
+	}
+}
+func runMailPurge(mongoClient *mongo.Client) {
+	ticker := time.NewTicker(MAIL_PURGE_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		purgeExpiredMail(mongoClient)
+	}
+}
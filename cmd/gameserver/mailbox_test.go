@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestNewMail(t *testing.T) {
+	message := newMail(uuid.New(), "Event reward", "Thanks for playing", nil, 0)
+	if message.Items == nil || hasAttachments(message) {
+		t.Errorf("expected an empty, attachment free message, got %+v", message)
+	}
+	if message.Sender != MAIL_SYSTEM_SENDER || message.Expires_at.Sub(message.Sent_at) != MAIL_LIFETIME {
+		t.Errorf("expected a system message that expires after %v, got %+v", MAIL_LIFETIME, message)
+	}
+	if !message.Expires_at.After(time.Now()) {
+		t.Errorf("expected expiry in the future")
+	}
+	if refund := newMail(uuid.New(), "Refund", "", nil, 15); !hasAttachments(refund) {
+		t.Errorf("expected bits to count as an attachment")
+	}
+}
@@ -28,31 +28,39 @@ type User struct {
 	Password   string             `json:"password" default:"" bson:"password, omitempty"`
 	Active     int                `json:"active" default:"" bson:"active,omitempty"`
 	Logins     int                `json:"logins" default:"" bson:"logins, omitempty"`
+	LastSeen   time.Time          `json:"last_seen" default:"" bson:"last_seen,omitempty"`
+	Flags      []string           `json:"flags" default:"" bson:"flags,omitempty"`
 }
 type Profile struct {
-	ObjectID     primitive.ObjectID `json:"objectID" bson:"_id, omitempty"`
-	Account_id   uuid.UUID          `json:"uuid" bson:"uuid,omitempty"`
-	Name         string             `json:"name" default:"" bson:"name, omitempty"`
-	Level        int                `json:"level" default:"" bson:"level, omitempty"`
-	Age          int                `json:"age" default:"" bson:"age, omitempty"`
-	Title        string             `json:"title" default:"" bson:"title,omitempty"`
-	Current_EXP  float64            `json:"current_exp" default:"" bson:"current_exp, omitempty"`
-	Total_EXP    float64            `json:"total_exp" default:"" bson:"total_exp, omitempty"`
-	Max_EXP      float64            `json:"max_exp" default:"" bson:"max_exp, omitempty"`
-	Race_id      string             `json:"race_id" default:"" bson:"race_id, omitempty"`
-	Race_name    string             `json:"race_name" default:"" bson:"race_name, omitempty"`
-	Class_id     string             `json:"class_id" default:"" bson:"class_id, omitempty"`
-	Class_name   string             `json:"class_name" default:"" bson:"class_name, omitempty"`
-	LastPosition Position           `json:"last_position" bson:"last_position,omitempty"`
-	LastRegion   string             `json:"last_region" default:"" bson:"last_region,omitempty"`
-	LastLevel    string             `json:"last_level" default:"" bson:"last_level,omitempty"`
-	Items        ItemRange          `json:"items" default:"" bson:"items,omitempty"`
-	Purse        Purse              `json:"purse" default:"" bson:"purse,omitempty"`
-	Loadout      Loadout            `json:"loadout" default:"" bson:"loadout,omitempty"`
-	Stats        Stats              `json:"stats" default:"" bson:"stats,omitempty"`
-	BaseStats    Stats              `json:"base_stats" default:"" bson:"base_stats,omitempty"`
-	SpellIndex   []string           `json:"spell_index" default:"" bson:"spell_index, omitempty"`
-	Description  string             `json:"description" default:"" bson:"description, omitempty"`
+	ObjectID      primitive.ObjectID `json:"objectID" bson:"_id, omitempty"`
+	Account_id    uuid.UUID          `json:"uuid" bson:"uuid,omitempty"`
+	Name          string             `json:"name" default:"" bson:"name, omitempty"`
+	Level         int                `json:"level" default:"" bson:"level, omitempty"`
+	Age           int                `json:"age" default:"" bson:"age, omitempty"`
+	Title         string             `json:"title" default:"" bson:"title,omitempty"`
+	Current_EXP   float64            `json:"current_exp" default:"" bson:"current_exp, omitempty"`
+	Total_EXP     float64            `json:"total_exp" default:"" bson:"total_exp, omitempty"`
+	Max_EXP       float64            `json:"max_exp" default:"" bson:"max_exp, omitempty"`
+	Race_id       string             `json:"race_id" default:"" bson:"race_id, omitempty"`
+	Race_name     string             `json:"race_name" default:"" bson:"race_name, omitempty"`
+	Class_id      string             `json:"class_id" default:"" bson:"class_id, omitempty"`
+	Class_name    string             `json:"class_name" default:"" bson:"class_name, omitempty"`
+	LastPosition  Position           `json:"last_position" bson:"last_position,omitempty"`
+	LastRegion    string             `json:"last_region" default:"" bson:"last_region,omitempty"`
+	LastLevel     string             `json:"last_level" default:"" bson:"last_level,omitempty"`
+	Items         ItemRange          `json:"items" default:"" bson:"items,omitempty"`
+	Purse         Purse              `json:"purse" default:"" bson:"purse,omitempty"`
+	Loadout       Loadout            `json:"loadout" default:"" bson:"loadout,omitempty"`
+	Stats         Stats              `json:"stats" default:"" bson:"stats,omitempty"`
+	BaseStats     Stats              `json:"base_stats" default:"" bson:"base_stats,omitempty"`
+	SpellIndex    []string           `json:"spell_index" default:"" bson:"spell_index, omitempty"`
+	Hotbar        []string           `json:"hotbar" default:"" bson:"hotbar"`
+	Description   string             `json:"description" default:"" bson:"description, omitempty"`
+	MonsterKills  map[string]int     `json:"monster_kills" default:"" bson:"monster_kills,omitempty"`
+	Quests        []QuestProgress    `json:"quests" default:"" bson:"quests,omitempty"`
+	Vitals        Vitals             `json:"vitals" default:"" bson:"vitals,omitempty"`
+	Effects       []Effect           `json:"effects" default:"" bson:"effects,omitempty"`
+	DialogueFlags []string           `json:"dialogue_flags" default:"" bson:"dialogue_flags,omitempty"`
 }
 type Loadout struct {
 	Head        string `json:"head" bson:"head, omitempty"`
@@ -77,6 +85,7 @@ type Item struct {
 	Description  string             `json:"description" default:"" bson:"description, omitempty"`
 	Stats        Stats              `json:"stats" bson:"stats, omitempty"`
 	BaseValue    float64            `json:"base_value" bson:"base_value,omitempty"`
+	Use          *ItemUse           `json:"use" default:"" bson:"use,omitempty"`
 }
 type Stats struct {
 	Strength     float64 `json:"strength" default:"0" bson:"strength, omitempty"`
@@ -130,20 +139,23 @@ type Spell struct {
 	Init_block     int32              `json:"init_block" bson:"init_block, omitempty"`
 	Block_count    int32              `json:"block_count" bson:"block_count, omitempty"`
 	Effect         Effect             `json:"effect" bson:"effect, omitempty"`
+	Classes        []string           `json:"classes" bson:"classes,omitempty"`
+	Prerequisites  []string           `json:"prerequisites" bson:"prerequisites,omitempty"`
 }
 type Effect struct {
-	Name             string `json:"name" bson:"name, omitempty"`
-	Effect_id        string `json:"effect_id" bson:"effect_id, omitempty"`
-	Element          string `json:"element" bson:"element, omitempty"`
-	Effect_type      string `json:"effect_type" bson:"effect_type, omitempty"`
-	Buff_element     string `json:"buff_element" bson:"buff_element, omitempty"`
-	Debuff_element   string `json:"debuff_element" bson:"debuff_element, omitempty"`
-	Damage_per_cycle int32  `json:"damage_per_cycle" bson:"damage_per_cycle, omitempty"`
-	Lifetime         int32  `json:"lifetime" bson:"lifetime, omitempty"`
-	Ticks_left       int32  `json:"ticks_left" bson:"ticks_left, omitempty"`
-	Scalar           int32  `json:"scalar" bson:"scalar, omitempty"`
-	Description      string `json:"description" bson:"description, omitempty"`
-	Effector         string `json:"effector" bson:"effector, omitempty"`
+	Name             string    `json:"name" bson:"name, omitempty"`
+	Effect_id        string    `json:"effect_id" bson:"effect_id, omitempty"`
+	Element          string    `json:"element" bson:"element, omitempty"`
+	Effect_type      string    `json:"effect_type" bson:"effect_type, omitempty"`
+	Buff_element     string    `json:"buff_element" bson:"buff_element, omitempty"`
+	Debuff_element   string    `json:"debuff_element" bson:"debuff_element, omitempty"`
+	Damage_per_cycle int32     `json:"damage_per_cycle" bson:"damage_per_cycle, omitempty"`
+	Lifetime         int32     `json:"lifetime" bson:"lifetime, omitempty"`
+	Ticks_left       int32     `json:"ticks_left" bson:"ticks_left, omitempty"`
+	Scalar           int32     `json:"scalar" bson:"scalar, omitempty"`
+	Description      string    `json:"description" bson:"description, omitempty"`
+	Effector         string    `json:"effector" bson:"effector, omitempty"`
+	Expires_at       time.Time `json:"expires_at" bson:"expires_at,omitempty"`
 }
 type Client struct {
 	Account_id       uuid.UUID    `json:"uuid" bson:"uuid, omitempty"`
@@ -181,12 +193,15 @@ type Level struct {
 	ZIP       string             `json:"zip" default:"" bson:"ZIP,omitempty"`
 	Monsters  []string           `json:"monsters" bson:"monsters,omitempty"`
 	Residents []string           `json:"residents" bson:"residents,omitempty"`
+	Portals   []Portal           `json:"portals" bson:"portals,omitempty"`
+	Bounds    *LevelBounds       `json:"bounds" bson:"bounds,omitempty"`
 }
 type Resident struct {
-	ObjectID primitive.ObjectID `json:"objectID" bson:"_id, omitempty"`
-	NpcID    string             `json:"npc_id" default:"" bson:"npcID, omitempty"`
-	NpcName  string             `json:"npc_name" default:"" bson:"npcName, omitempty"`
-	Dialogue []string           `json:"dialogue" default:"" bson:"dialogue, omitempty"`
+	ObjectID     primitive.ObjectID `json:"objectID" bson:"_id, omitempty"`
+	NpcID        string             `json:"npc_id" default:"" bson:"npcID, omitempty"`
+	NpcName      string             `json:"npc_name" default:"" bson:"npcName, omitempty"`
+	Dialogue     []string           `json:"dialogue" default:"" bson:"dialogue, omitempty"`
+	DialogueTree *DialogueTree      `json:"dialogue_tree" default:"" bson:"dialogueTree,omitempty"`
 }
 type ShopKeeper struct {
 	ObjectID  primitive.ObjectID `json:"objectID" bson:"_id,omitempty"`
@@ -205,6 +220,7 @@ type Monster struct {
 	Actions        *[]Spell           `json:"actions" default:"" bson:"attackActions,omitempty"`
 	Element        string             `json:"element" default:"" bson:"element, omitempty"`
 	Regions        []string           `json:"regions" bson:"regions, omitempty"`
+	DropTable      []LootDrop         `json:"drop_table" default:"" bson:"dropTable,omitempty"`
 }
 type RegionData struct {
 	Region    *Region    `json:"region" default:"" bson:"region"`
@@ -218,16 +234,22 @@ type Sessions struct {
 	Battles map[uuid.UUID]BattleSession `json:"battle_sessions" default:"" bson:"battle_sessions"`
 }
 type BattleSession struct {
-	BattleID     uuid.UUID  `json:"battle_id" default:"" bson:"battle_id"`
-	Status       int        `json:"status" default:"0" bson:"status"`
-	Monsters     *[]Monster `json:"monsters" default:"" bson:"monsters"`
-	RewardMatrix []int      `json:"reward_matrix" default:"" bson:"reward_matrix"`
-	Reward       Reward     `json:"reward" default:"" bson:"reward"`
+	BattleID     uuid.UUID      `json:"battle_id" default:"" bson:"battle_id"`
+	Status       int            `json:"status" default:"0" bson:"status"`
+	Monsters     *[]Monster     `json:"monsters" default:"" bson:"monsters"`
+	RewardMatrix []int          `json:"reward_matrix" default:"" bson:"reward_matrix"`
+	Reward       Reward         `json:"reward" default:"" bson:"reward"`
+	Participants []uuid.UUID    `json:"participants" default:"" bson:"participants"`
+	SplitRule    string         `json:"split_rule" default:"even" bson:"split_rule"`
+	Turn         int            `json:"turn" default:"0" bson:"turn"`
+	Acted        []uuid.UUID    `json:"acted" default:"" bson:"acted"`
+	Actions      []BattleAction `json:"actions" default:"" bson:"actions"`
 }
 type Reward struct {
-	Gold     float64 `json:"gold" default:"0" bson:"gold"`
-	Exp      float64 `json:"exp" default:"0" bson:"exp"`
-	TotalExp float64 `json:"total_exp" default:"0" bson:"total_exp"`
+	Gold     float64  `json:"gold" default:"0" bson:"gold"`
+	Exp      float64  `json:"exp" default:"0" bson:"exp"`
+	TotalExp float64  `json:"total_exp" default:"0" bson:"total_exp"`
+	Items    []string `json:"items" default:"" bson:"items"`
 }
 type Packet struct {
 	PacketID    uuid.UUID `json:"packet_id" default:""`
@@ -243,13 +265,12 @@ type PlayerPacketCache struct {
 var PACKET_SIZE = 10000
 var wg sync.WaitGroup
 var ALLshopkeepers = make(map[string]ShopKeeper)
+var ALLshopkeepersMutex sync.RWMutex
 var playerPacketCache = make(map[uuid.UUID]PlayerPacketCache)
-var MASTER_ITEM_TABLE = make(map[string]Item)
-var MASTER_SPELL_TABLE = make(map[string]Spell)
-var MASTER_MONSTER_TABLE = make(map[string]Monster)
-var MASTER_LEVEL_TABLE = make(map[string]Level)
+var playerPacketCacheMutex sync.Mutex
 
 var sessions Sessions
+var sessionsMutex sync.Mutex
 var (
 	Info           = Teal
 	IncomingPacket = Magenta
@@ -281,28 +302,40 @@ func Color(colorString string) func(...interface{}) string {
 
 func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoClient *mongo.Client) {
 	fmt.Print(".")
+	defer clientConnection.Close()
+	defer disconnectPlayers(clientConnection, mongoClient)
 	clientResponse := "DEFAULT"
 	byteLimiter := PACKET_SIZE
+	//one reader for the whole connection, a fresh one per request would drop pipelined requests it had buffered
+	reader := bufio.NewReader(clientConnection)
 	for {
-		netData, err := bufio.NewReader(clientConnection).ReadString('\n')
+		netData, err := reader.ReadString('\n')
 		if err != nil {
 			fmt.Println(Failure(err))
 			return
 		}
 		packetCode, packetMessage := packetDissect(netData)
-		fmt.Println(packetMessage)
 		if packetCode == "BR#" {
 			clientResponse = packetCode
 			fmt.Println(IncomingPacket("Read for Battle packet received!"))
 			requestIDSTR, accountIDSTR, levelID := processTier3Packet(packetMessage)
 			accountID, _ := uuid.Parse(accountIDSTR)
+			trackPresence(accountID, clientConnection, mongoClient)
+			setPresenceLevel(accountID, levelID, mongoClient)
 			var freshBattlePacket BattlePacket
 			playerProfile, _ := getProfile(accountID, mongoClient)
-			level := getLevel(levelID, mongoClient)
-			monsters := getMonsters(level.Monsters, mongoClient)
+			world := currentWorld()
+			level := world.Level(levelID)
+			monsters := world.MonsterList(level.Monsters)
+			//party members in the same level are pulled into the same battle
+			participants := getBattleParticipants(accountID, levelID)
+			splitRule := DEFAULT_PARTY_SPLIT_RULE
+			if party, inParty := getParty(accountID); inParty {
+				splitRule = party.SplitRule
+			}
 			//create BattleSession out of this information and add to the list of sessions
 			freshBattlePacket.MonsterQuantity = 1
-			battle := createBattle(monsters, freshBattlePacket.MonsterQuantity)
+			battle := createBattle(monsters, freshBattlePacket.MonsterQuantity, participants, splitRule)
 
 			freshBattlePacket.BattleID = battle.BattleID
 			freshBattlePacket.PlayerProfile = playerProfile
@@ -311,6 +344,10 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 			contentJSON, _ := json.Marshal(freshBattlePacket)
 			packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "BATTLE", contentJSON)
 			chainWriteResponse(accountID, requestIDSTR, packet, byteLimiter, clientConnection, false)
+			notifyBattleStart(battle, freshBattlePacket, accountID, mongoClient)
+			for _, participant := range participants {
+				setPresenceStatus(participant, PRESENCE_IN_BATTLE, mongoClient)
+			}
 		}
 		if packetCode == "B0#" {
 			fmt.Println(IncomingPacket("Battle State Confirmation packet received!"))
@@ -332,39 +369,222 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 			exp := 0.0
 			gold := 0.0
 			updateStatus := "False"
-			if entry, ok := sessions.Battles[battleID]; ok {
+			var loot []string
+			sessionsMutex.Lock()
+			entry, ok := sessions.Battles[battleID]
+			claimed := ok && entry.Status == 0 && containsAccount(entry.Participants, accountID)
+			if claimed {
 				entry.RewardMatrix = rewardMatrix
 				entry.Status = 1
+				sessions.Battles[battleID] = entry
+			}
+			sessionsMutex.Unlock()
+			if claimed {
+				for _, participant := range entry.Participants {
+					setPresenceStatus(participant, PRESENCE_ONLINE, mongoClient)
+				}
 				for index, reward := range entry.RewardMatrix {
+					if index >= len(*entry.Monsters) {
+						break
+					}
 					monster := (*entry.Monsters)[index]
 					if reward == 1 {
 						entry.Reward.Exp += float64(monster.ExperienceGain)
 						entry.Reward.Gold += float64(monster.GoldGain)
 					}
 				}
-				exp = entry.Reward.Exp
-				gold = entry.Reward.Gold
-				//calculate exp and return total exp to entry.Reward.Totalexp
-				addBits(accountID, gold, true, mongoClient)
-				entry.Reward.TotalExp = updateProfile_EXP(accountID, entry.Reward.Exp, mongoClient)
+				//split gold and exp between everyone who took part in the battle
+				shares := splitReward(entry.Reward.Gold, entry.Reward.Exp, getSplitWeights(entry.Participants, entry.SplitRule, mongoClient))
+				exp = shares[accountID].Exp
+				gold = shares[accountID].Gold
+				//roll drop tables and grant bits, exp and loot in one update
+				kills := make(map[string]int)
+				if playerProfile, _ := getProfile(accountID, mongoClient); playerProfile != nil {
+					var rolledLoot []string
+					rolledLoot, kills = rollBattleLoot(*entry.Monsters, entry.RewardMatrix, playerProfile)
+					if totalEXP, granted := grantBattleRewards(accountID, gold, exp, rolledLoot, kills, mongoClient); granted {
+						loot = rolledLoot
+						entry.Reward.TotalExp = totalEXP
+						entry.Reward.Items = loot
+						updateStatus = "True"
+					}
+				}
+				var looters []uuid.UUID
+				if len(loot) > 0 {
+					looters = append(looters, accountID)
+				}
+				for _, member := range entry.Participants {
+					if member != accountID && len(grantPartyShare(member, shares[member], *entry.Monsters, entry.RewardMatrix, mongoClient)) > 0 {
+						looters = append(looters, member)
+					}
+				}
+				//advance kill, collect and level objectives of everyone who took part
+				for _, member := range entry.Participants {
+					for mobID, count := range kills {
+						emitQuestEvent(member, QuestEvent{Type: "kill", Target: mobID, Quantity: count}, mongoClient)
+					}
+					emitQuestEvent(member, QuestEvent{Type: "reach_level"}, mongoClient)
+				}
+				for _, member := range looters {
+					emitQuestEvent(member, QuestEvent{Type: "collect"}, mongoClient)
+				}
+				sessionsMutex.Lock()
 				sessions.Battles[battleID] = entry
-				updateStatus = "True"
+				sessionsMutex.Unlock()
 			}
 			profile, _ := getProfile(accountID, mongoClient)
 			profileJSON, _ := json.Marshal(profile)
-			contentJSON := strconv.FormatFloat(exp, 'f', -1, 64) + "|" + strconv.FormatFloat(gold, 'f', -1, 64) + "|" + updateStatus + "|" + string(profileJSON)
+			lootJSON, _ := json.Marshal(loot)
+			contentJSON := strconv.FormatFloat(exp, 'f', -1, 64) + "|" + strconv.FormatFloat(gold, 'f', -1, 64) + "|" + updateStatus + "|" + string(profileJSON) + "|" + string(lootJSON)
 			packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "BATTLEFINISH", []byte(contentJSON))
 			chainWriteResponse(accountID, requestIDSTR, packet, byteLimiter, clientConnection, false)
 		}
+		if packetCode == "BC#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Battle Cast packet received!"))
+			requestIDSTR, accountIDSTR, battleIDSTR, spellID := processTier4Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			content := castSpell(accountID, battleIDSTR, spellID, mongoClient)
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "CAST", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "CB#" || packetCode == "CU#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Chat block list packet received!"))
+			requestIDSTR, accountIDSTR, username := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(requestIDSTR, packetCode, "CHAT", clientConnection)
+				continue
+			}
+			content := "CHAT$0;Player does not exist"
+			if target, found := getUser(username, mongoClient); found {
+				content = setChatBlocked(accountID, target.Account_id, packetCode == "CB#", mongoClient)
+			}
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "CHAT", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "CH#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Chat packet received!"))
+			items := processPaddedPacket(packetMessage, 5)
+			requestIDSTR, accountIDSTR, channel, target, message := items[0], items[1], items[2], items[3], items[4]
+			accountID, _ := uuid.Parse(accountIDSTR)
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(requestIDSTR, packetCode, "CHAT", clientConnection)
+				continue
+			}
+			content := sendChatMessage(accountID, channel, target, message, mongoClient)
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "CHAT", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "CR#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Craft packet received!"))
+			requestIDSTR, accountIDSTR, recipeID := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			content := craftItem(accountID, recipeID, mongoClient)
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "CRAFT", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "CRL#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Recipe list packet received!"))
+			//filter is either "all" or "craftable"
+			requestIDSTR, accountIDSTR, filter := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			content := listRecipes(accountID, filter, mongoClient)
+			packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "CRAFT", []byte(content))
+			chainWriteResponse(accountID, requestIDSTR, packet, byteLimiter, clientConnection, false)
+		}
+		if packetCode == "ZN#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Compression negotiation packet received!"))
+			//e.g: requestID?accountID?zstd,snappy, the reply names the codec used for chained responses from now on
+			requestIDSTR, accountIDSTR, offered := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			codec := negotiateCodec(offered)
+			if !setConnectionCodec(clientConnection, codec) {
+				codec = CODEC_NONE
+			}
+			if codec == CODEC_NONE {
+				codec = "none"
+			}
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "COMPRESSION", "COMPRESSION$1;"+codec)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "DC#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Dialogue choice packet received!"))
+			requestIDSTR, accountIDSTR, choiceSTR := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			content := chooseDialogueOption(accountID, parseDialogueChoice(choiceSTR), mongoClient)
+			packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "DIALOGUE", []byte(content))
+			chainWriteResponse(accountID, requestIDSTR, packet, byteLimiter, clientConnection, false)
+		}
+		if packetCode == "DS#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Dialogue start packet received!"))
+			requestIDSTR, accountIDSTR, npcID := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			trackPresence(accountID, clientConnection, mongoClient)
+			content := startDialogue(accountID, npcID, mongoClient)
+			packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "DIALOGUE", []byte(content))
+			chainWriteResponse(accountID, requestIDSTR, packet, byteLimiter, clientConnection, false)
+		}
+		if packetCode == "FA#" || packetCode == "FD#" || packetCode == "FR#" || packetCode == "FX#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Friend packet received!"))
+			requestIDSTR, accountIDSTR, username := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			trackPresence(accountID, clientConnection, mongoClient)
+			content := "FRIENDS$0;Player does not exist"
+			if target, found := getUser(username, mongoClient); found {
+				switch packetCode {
+				case "FA#":
+					content = acceptFriendRequest(accountID, target.Account_id, mongoClient)
+				case "FD#":
+					content = declineFriendRequest(accountID, target.Account_id, mongoClient)
+				case "FR#":
+					content = sendFriendRequest(accountID, target.Account_id, mongoClient)
+				case "FX#":
+					content = removeFriend(accountID, target.Account_id, mongoClient)
+				}
+			}
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "FRIENDS", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "FL#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Friend list packet received!"))
+			requestIDSTR, accountIDSTR := processTier2Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			trackPresence(accountID, clientConnection, mongoClient)
+			content := listFriends(accountID, mongoClient)
+			packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "FRIENDS", []byte(content))
+			chainWriteResponse(accountID, requestIDSTR, packet, byteLimiter, clientConnection, false)
+		}
 		if packetCode == "HB#" {
 			// fmt.Println("Heartbeat packet received!")
 			accountID, x, y, z := processTier4Packet(packetMessage)
 			target_uuid, _ := uuid.Parse(accountID)
-			var lastPosition Position
-			lastPosition.Position_x, _ = strconv.ParseFloat(x, 64)
-			lastPosition.Position_y, _ = strconv.ParseFloat(y, 64)
-			lastPosition.Position_z, _ = strconv.ParseFloat(z, 64)
-			updateUserLastPosition(target_uuid, &lastPosition, mongoClient)
+			lastPosition, valid := parsePosition(x, y, z)
+			if trackPresence(target_uuid, clientConnection, mongoClient) {
+				if valid {
+					handleHeartbeatPosition(target_uuid, lastPosition, mongoClient)
+				} else {
+					fmt.Println(Warn("Ignoring heartbeat with an invalid position from ", target_uuid))
+				}
+			}
+		}
+		if packetCode == "SA#" {
+			//e.g: accountID?sequence, acknowledges the newest snapshot the client received
+			items := processPaddedPacket(packetMessage, 2)
+			accountIDSTR, sequenceSTR := items[0], items[1]
+			accountID, _ := uuid.Parse(accountIDSTR)
+			if trackPresence(accountID, clientConnection, mongoClient) {
+				ackSnapshot(accountID, sequenceSTR)
+			}
 		}
 		//Inventory add
 		if packetCode == "IA#" {
@@ -394,12 +614,22 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 		if packetCode == "L0#" {
 			clientResponse = packetCode
 			fmt.Println(IncomingPacket("Login packet received!"))
-			fmt.Println(Info(packetMessage))
 			requestIDSTR, username, password := processTier3Packet(packetMessage)
-			loginResponse, valid := handleLogin(username, password, mongoClient)
+			loginResponse, valid := handleLogin(username, password, clientConnection.RemoteAddr().String(), mongoClient)
 			if valid {
 				//Login success
 				packetCode = "LS#"
+				if user, found := getUser(username, mongoClient); found {
+					bindPlayerConnection(user.Account_id, clientConnection)
+					trackPresence(user.Account_id, clientConnection, mongoClient)
+					ensureSpellProfile(user.Account_id, mongoClient)
+					//the account id goes out ahead of the login response so clients can address account scoped requests
+					accountPacket := createSimpleDeliveryPacket(uuid.New().String(), "LA#", "LOGIN", "LOGIN$1;"+user.Account_id.String())
+					writeResponse(user.Account_id, requestIDSTR, accountPacket, clientConnection, true)
+					//clients compare the world data version with the one their caches were filled under
+					versionPacket := createSimpleDeliveryPacket(uuid.New().String(), "WV#", "WORLD", worldVersionContent(currentWorld().Version))
+					writeResponse(user.Account_id, requestIDSTR, versionPacket, clientConnection, true)
+				}
 				/*var LSP LoginSecretPacket
 				User, _ := getUser(username, mongoClient)
 				Profile, _ := getProfile(User.Account_id, mongoClient)
@@ -421,7 +651,7 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 				contentJSON, _ := json.Marshal(LSP)
 				packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "LSP", contentJSON)
 				chainWriteResponse(User.Account_id, requestIDSTR, packet, byteLimiter, clientConnection, false)*/
-				contentJSON, _ := json.Marshal(getLevelFromCache("00001"))
+				contentJSON, _ := json.Marshal(currentWorld().Levels["00001"])
 				packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "LSP", contentJSON)
 				chainWriteResponse(uuid.New(), requestIDSTR, packet, byteLimiter, clientConnection, false)
 			} else if !valid {
@@ -453,8 +683,15 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 			fmt.Println(IncomingPacket("Level packet received!"))
 			requestIDSTR, accountIDSTR, levelID := processTier3Packet(packetMessage)
 			accountID, _ := uuid.Parse(accountIDSTR)
+			trackPresence(accountID, clientConnection, mongoClient)
+			// the level comes from the profile, levels are only changed by travelling through a portal
+			if profile, _ := getProfile(accountID, mongoClient); profile != nil && profile.LastLevel != "" && profile.LastLevel != levelID {
+				fmt.Println(Warn("Level ", levelID, " requested outside of ", profile.LastLevel, " by ", accountID))
+				levelID = profile.LastLevel
+			}
+			setPresenceLevel(accountID, levelID, mongoClient)
 			var freshLevel LevelData
-			level := getLevel(levelID, mongoClient)
+			level := currentWorld().Level(levelID)
 			NPC := getNPCs(level.Residents, mongoClient)
 			freshLevel.Level = level
 			freshLevel.Residents = NPC
@@ -462,11 +699,91 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 			packet := createMultiDeliveryPacket(requestIDSTR, packetCode, ":EVEL", contentJSON)
 			chainWriteResponse(accountID, requestIDSTR, packet, byteLimiter, clientConnection, false)
 		}
+		if packetCode == "PA#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Accept party invite packet received!"))
+			requestIDSTR, accountIDSTR, partyIDSTR := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			partyID, _ := uuid.Parse(partyIDSTR)
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(requestIDSTR, packetCode, "PARTY", clientConnection)
+				continue
+			}
+			content := acceptPartyInvite(accountID, partyID)
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "PARTY", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "PC#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Create party packet received!"))
+			requestIDSTR, accountIDSTR := processTier2Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(requestIDSTR, packetCode, "PARTY", clientConnection)
+				continue
+			}
+			content := createParty(accountID)
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "PARTY", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "PI#" || packetCode == "PK#" || packetCode == "PT#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Party member packet received!"))
+			requestIDSTR, accountIDSTR, username := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(requestIDSTR, packetCode, "PARTY", clientConnection)
+				continue
+			}
+			content := partyFailure("Player does not exist")
+			if target, found := getUser(username, mongoClient); found {
+				switch packetCode {
+				case "PI#":
+					content = inviteToParty(accountID, target.Account_id)
+				case "PK#":
+					content = kickFromParty(accountID, target.Account_id)
+				case "PT#":
+					content = transferPartyLeader(accountID, target.Account_id)
+				}
+			}
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "PARTY", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "PL#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Leave party packet received!"))
+			requestIDSTR, accountIDSTR := processTier2Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(requestIDSTR, packetCode, "PARTY", clientConnection)
+				continue
+			}
+			content := leaveParty(accountID)
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "PARTY", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "PS#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Party split rule packet received!"))
+			requestIDSTR, accountIDSTR, rule := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(requestIDSTR, packetCode, "PARTY", clientConnection)
+				continue
+			}
+			content := setPartySplitRule(accountID, rule)
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "PARTY", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
 		if packetCode == "PR#" {
 			clientResponse = packetCode
 			fmt.Println(IncomingPacket("Read loadout packet received!"))
 			requestIDSTR, accountIDSTR := processTier2Packet(packetMessage)
 			accountID, _ := uuid.Parse(accountIDSTR)
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(requestIDSTR, packetCode, "PROFILE", clientConnection)
+				continue
+			}
 			profile, _ := getProfile(accountID, mongoClient)
 			profileJSON, _ := json.Marshal(profile)
 			packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "PROFILE", profileJSON)
@@ -479,6 +796,7 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 			accountID, _ := uuid.Parse(accountIDSTR)
 			streamedEXP, _ := strconv.ParseFloat(streamedEXPString, 64)
 			newTotalEXP := updateProfile_EXP(accountID, streamedEXP, mongoClient)
+			emitQuestEvent(accountID, QuestEvent{Type: "reach_level"}, mongoClient)
 			content := strconv.FormatFloat(newTotalEXP, 'E', -1, 64)
 			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "EXP", content)
 			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
@@ -492,31 +810,124 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "LOADOUT", content)
 			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
 		}
+		if packetCode == "ML#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Market Listing packet received!"))
+			//e.g: requestID?accountID?itemID?price?fixed|auction?hours
+			items := processPaddedPacket(packetMessage, 6)
+			accountID, _ := uuid.Parse(items[1])
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(items[0], packetCode, "MARKET", clientConnection)
+				continue
+			}
+			content := createListing(accountID, items[2], items[3], items[4], items[5], mongoClient)
+			packet := createSimpleDeliveryPacket(items[0], packetCode, "MARKET", content)
+			writeResponse(accountID, items[0], packet, clientConnection, false)
+		}
+		if packetCode == "MS#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Market Search packet received!"))
+			//e.g: requestID?accountID?itemType?itemSubtype?name, empty filters match anything
+			items := processPaddedPacket(packetMessage, 5)
+			accountID, _ := uuid.Parse(items[1])
+			content := searchMarket(items[2], items[3], items[4], mongoClient)
+			packet := createMultiDeliveryPacket(items[0], packetCode, "MARKET", []byte(content))
+			chainWriteResponse(accountID, items[0], packet, byteLimiter, clientConnection, false)
+		}
+		if packetCode == "MB#" || packetCode == "MC#" || packetCode == "MD#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Market packet received!"))
+			//e.g: requestID?accountID?listingID, MD# bids add ?amount
+			items := processPaddedPacket(packetMessage, 4)
+			accountID, _ := uuid.Parse(items[1])
+			listingID, _ := uuid.Parse(items[2])
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(items[0], packetCode, "MARKET", clientConnection)
+				continue
+			}
+			var content string
+			switch packetCode {
+			case "MB#":
+				content = buyListing(accountID, listingID, mongoClient)
+			case "MC#":
+				content = cancelListing(accountID, listingID, mongoClient)
+			case "MD#":
+				content = bidListing(accountID, listingID, items[3], mongoClient)
+			}
+			packet := createSimpleDeliveryPacket(items[0], packetCode, "MARKET", content)
+			writeResponse(accountID, items[0], packet, clientConnection, false)
+		}
+		if packetCode == "MM#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Mailbox packet received!"))
+			requestIDSTR, accountIDSTR := processTier2Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(requestIDSTR, packetCode, "MAIL", clientConnection)
+				continue
+			}
+			content := listMail(accountID, mongoClient)
+			packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "MAIL", []byte(content))
+			chainWriteResponse(accountID, requestIDSTR, packet, byteLimiter, clientConnection, false)
+		}
+		if packetCode == "MX#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Claim Mail packet received!"))
+			requestIDSTR, accountIDSTR, mailIDSTR := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			mailID, _ := uuid.Parse(mailIDSTR)
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(requestIDSTR, packetCode, "MAIL", clientConnection)
+				continue
+			}
+			content := claimMail(accountID, mailID, mongoClient)
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "MAIL", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
 		if packetCode == "OK#" {
 			fmt.Println(IncomingPacket("OK Packet received!"))
 			requestIDSTR, accountIDSTR := processTier2Packet(packetMessage)
 			accountID, _ := uuid.Parse(accountIDSTR)
 			requestID, _ := uuid.Parse(requestIDSTR)
-			_, found := playerPacketCache[accountID].PacketCache[requestID]
-			if found {
-				delete(playerPacketCache[accountID].PacketCache, requestID)
-				if len(playerPacketCache[accountID].PacketCache) == 0 {
-					delete(playerPacketCache, accountID)
-				}
-			}
+			acknowledgePacket(accountID, requestID)
 		}
 		//Register
+		if packetCode == "QA#" || packetCode == "QT#" || packetCode == "QX#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Quest packet received!"))
+			requestIDSTR, accountIDSTR, questID := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			content := ""
+			switch packetCode {
+			case "QA#":
+				content = acceptQuest(accountID, questID, mongoClient)
+			case "QT#":
+				content = turnInQuest(accountID, questID, mongoClient)
+			case "QX#":
+				content = abandonQuest(accountID, questID, mongoClient)
+			}
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "QUEST", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "QL#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Quest list packet received!"))
+			requestIDSTR, accountIDSTR := processTier2Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			content := listQuests(accountID, mongoClient)
+			packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "QUEST", []byte(content))
+			chainWriteResponse(accountID, requestIDSTR, packet, byteLimiter, clientConnection, false)
+		}
 		if packetCode == "R0#" {
 			clientResponse = packetCode
 			fmt.Println(IncomingPacket("Register packet received!"))
-			fmt.Println(Info(packetMessage))
 			requestIDSTR, username, password := processTier3Packet(packetMessage)
-			registerResponse, valid, accountID := handleRegistration(username, password, mongoClient)
+			registerResponse, valid, accountID := handleRegistration(username, password, clientConnection.RemoteAddr().String(), mongoClient)
 			if valid {
 				//Register success
 				createProfile(username, accountID, mongoClient)
-				addSpell(accountID, "Fireball", mongoClient)
-				addSpell(accountID, "Scorch", mongoClient)
+				grantSpell(accountID, "Fireball", mongoClient)
+				grantSpell(accountID, "Scorch", mongoClient)
 				addInventoryItem(accountID, "WizardRobe", mongoClient)
 				addInventoryItem(accountID, "WizardHat", mongoClient)
 				clientResponse = "RS#"
@@ -533,15 +944,13 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 			fmt.Println(Info(packetMessage))
 			requestIDSTR, accountIDSTR, regionID, levelID := processTier4Packet(packetMessage)
 			accountID, _ := uuid.Parse(accountIDSTR)
-			var FRD RegionData
-			var FLD LevelData
-			region := getRegion(regionID, mongoClient)
-			level := getLevel(levelID, mongoClient)
-			NPC := getNPCs(level.Residents, mongoClient)
-			FRD.Region = region
-			FLD.Level = level
-			FLD.Residents = NPC
-			FRD.LevelData = &FLD
+			trackPresence(accountID, clientConnection, mongoClient)
+			if profile, _ := getProfile(accountID, mongoClient); profile != nil && profile.LastLevel != "" && profile.LastLevel != levelID {
+				fmt.Println(Warn("Level ", levelID, " requested outside of ", profile.LastLevel, " by ", accountID))
+				regionID, levelID = profile.LastRegion, profile.LastLevel
+			}
+			setPresenceLevel(accountID, levelID, mongoClient)
+			FRD := getRegionData(regionID, levelID, mongoClient)
 			contentJSON, _ := json.Marshal(FRD)
 			packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "REGION", contentJSON)
 			chainWriteResponse(accountID, requestIDSTR, packet, byteLimiter, clientConnection, false)
@@ -550,7 +959,7 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 			fmt.Println(IncomingPacket("Shopkeeper Request Packet received"))
 			requestIDSTR, accountIDSTR, npcID := processTier3Packet(packetMessage)
 			accountID, _ := uuid.Parse(accountIDSTR)
-			if entry, found := ALLshopkeepers[npcID]; found {
+			if entry, found := getShopkeeper(npcID); found {
 				shopkeeper := entry
 				contentJSON, _ := json.Marshal(shopkeeper)
 				packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "SHOPKEEPER", contentJSON)
@@ -562,7 +971,7 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 			requestIDSTR, accountIDSTR := processTier2Packet(packetMessage)
 			accountID, _ := uuid.Parse(accountIDSTR)
 			requestID, _ := uuid.Parse(requestIDSTR)
-			SOSPacket, found := playerPacketCache[accountID].PacketCache[requestID]
+			SOSPacket, found := getCachedPacket(accountID, requestID)
 			if found {
 				if SOSPacket.Chain {
 					chainWriteResponse(accountID, requestIDSTR, SOSPacket, byteLimiter, clientConnection, true)
@@ -573,15 +982,100 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 				fmt.Println(Warn("SOS Packet ID : " + requestIDSTR + " is NOT Found!"))
 			}
 		}
+		if packetCode == "FQ#" {
+			fmt.Println(IncomingPacket("Fragment re-request packet received!"))
+			//e.g: requestID?accountID?messageID?2,5 resends fragments 2 and 5, an empty list resends them all
+			items := processPaddedPacket(packetMessage, 4)
+			requestIDSTR, accountIDSTR := items[0], items[1]
+			accountID, _ := uuid.Parse(accountIDSTR)
+			messageID, _ := uuid.Parse(items[2])
+			fragments, found := getBufferedFragments(accountID, messageID, parseFragmentIndices(items[3]))
+			if found {
+				clientConnection.Write([]byte(strings.Join(fragments, "")))
+			} else {
+				fmt.Println(Warn("Fragments of message ID : " + items[2] + " are NOT buffered!"))
+				packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "FRAGMENT", "FRAGMENT$0;Message is no longer buffered")
+				writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+			}
+		}
 		if packetCode == "SU#" {
 			clientResponse = packetCode
 			fmt.Println(IncomingPacket("Update Spell Index packet received!"))
 			requestIDSTR, accountIDSTR, spellID := processTier3Packet(packetMessage)
 			accountID, _ := uuid.Parse(accountIDSTR)
-			content := addSpell(accountID, spellID, mongoClient)
+			content := learnSpell(accountID, spellID, mongoClient)
 			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "SPELL", content)
 			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
 		}
+		if packetCode == "SX#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Forget Spell packet received!"))
+			requestIDSTR, accountIDSTR, spellID := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			content := forgetSpell(accountID, spellID, mongoClient)
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "SPELL", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "SB#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Spell Hotbar packet received!"))
+			//e.g: requestID?accountID?Fireball,,Scorch where an empty entry is an empty slot
+			requestIDSTR, accountIDSTR, slots := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			content := setHotbar(accountID, slots, mongoClient)
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "HOTBAR", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "TR#" || packetCode == "TA#" || packetCode == "TC#" || packetCode == "TX#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Trade packet received!"))
+			//e.g: requestID?accountID?targetID for TR#, requestID?accountID?tradeID for TA#, requestID?accountID? otherwise
+			items := processPaddedPacket(packetMessage, 3)
+			requestIDSTR, accountIDSTR, targetIDSTR := items[0], items[1], items[2]
+			accountID, _ := uuid.Parse(accountIDSTR)
+			targetID, _ := uuid.Parse(targetIDSTR)
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(requestIDSTR, packetCode, "TRADE", clientConnection)
+				continue
+			}
+			var content string
+			switch packetCode {
+			case "TR#":
+				content = requestTrade(accountID, targetID)
+			case "TA#":
+				content = acceptTrade(accountID, targetID)
+			case "TC#":
+				content = confirmTrade(accountID, mongoClient)
+			case "TX#":
+				content = cancelTrade(accountID, "cancelled by player", mongoClient)
+			}
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "TRADE", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "TO#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Trade Offer packet received!"))
+			//e.g: requestID?accountID?WizardHat,Potion?25
+			requestIDSTR, accountIDSTR, itemsSTR, bitsSTR := processTier4Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			if !trackPresence(accountID, clientConnection, mongoClient) {
+				rejectUnboundPacket(requestIDSTR, packetCode, "TRADE", clientConnection)
+				continue
+			}
+			content := setTradeOffer(accountID, itemsSTR, bitsSTR, mongoClient)
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "TRADE", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "TV#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Travel packet received!"))
+			requestIDSTR, accountIDSTR, portalID := processTier3Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			trackPresence(accountID, clientConnection, mongoClient)
+			content := travel(accountID, portalID, mongoClient)
+			packet := createMultiDeliveryPacket(requestIDSTR, packetCode, "TRAVEL", []byte(content))
+			chainWriteResponse(accountID, requestIDSTR, packet, byteLimiter, clientConnection, false)
+		}
 		if packetCode == "TT#" {
 			clientResponse = packetCode
 			fmt.Println(IncomingPacket("TEST MESSAGE received!"))
@@ -589,6 +1083,26 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 			responseMessage := "Server response to : " + testMessageSTR
 			clientConnection.Write([]byte(strings.Trim(strconv.QuoteToASCII(responseMessage), "\"")))
 		}
+		if packetCode == "UI#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("Use item packet received!"))
+			//e.g: requestID?accountID?itemID?battleID, battleID is left empty outside of battles
+			items := processPaddedPacket(packetMessage, 4)
+			requestIDSTR, accountIDSTR, itemID, battleIDSTR := items[0], items[1], items[2], items[3]
+			accountID, _ := uuid.Parse(accountIDSTR)
+			content := useItem(accountID, itemID, battleIDSTR, mongoClient)
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "ITEM", content)
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
+		if packetCode == "WV#" {
+			clientResponse = packetCode
+			fmt.Println(IncomingPacket("World version packet received!"))
+			//e.g: requestID?accountID
+			requestIDSTR, accountIDSTR := processTier2Packet(packetMessage)
+			accountID, _ := uuid.Parse(accountIDSTR)
+			packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, "WORLD", worldVersionContent(currentWorld().Version))
+			writeResponse(accountID, requestIDSTR, packet, clientConnection, false)
+		}
 		if packetCode == "XX#" {
 			clientResponse = packetCode
 			fmt.Println(IncomingPacket("CLIENT WANTS TO SAY HI!"))
@@ -602,7 +1116,6 @@ func handleTCPConnection(clientConnection net.Conn, cxt context.Context, mongoCl
 			break
 		}
 	}
-	clientConnection.Close()
 }
 
 // func toProtoBuf(inputStruct interface{}) proto.Message {
@@ -929,18 +1442,36 @@ func createSimpleDeliveryPacket(requestIDSTR string, packetCode string, serviceT
 	return packet
 }
 func addPacketToCache(accountID uuid.UUID, packet Packet) {
-	if _, ok := playerPacketCache[accountID]; !ok {
-		var newCache PlayerPacketCache
-		newCache.PacketCache = map[uuid.UUID]Packet{}
-		playerPacketCache[accountID] = newCache
-	}
+	playerPacketCacheMutex.Lock()
+	defer playerPacketCacheMutex.Unlock()
 	if playerPacketCache[accountID].PacketCache == nil {
-		b := make(map[uuid.UUID]Packet)
-		b[packet.PacketID] = packet
-		playerPacketCache[accountID].PacketCache[packet.PacketID] = b[packet.PacketID]
+		playerPacketCache[accountID] = PlayerPacketCache{PacketCache: map[uuid.UUID]Packet{}}
 	}
 	playerPacketCache[accountID].PacketCache[packet.PacketID] = packet
 }
+func getCachedPacket(accountID uuid.UUID, requestID uuid.UUID) (Packet, bool) {
+	playerPacketCacheMutex.Lock()
+	defer playerPacketCacheMutex.Unlock()
+	packet, found := playerPacketCache[accountID].PacketCache[requestID]
+	return packet, found
+}
+
+// acknowledgePacket drops a packet the client confirmed, and the account's cache once it is empty.
+func acknowledgePacket(accountID uuid.UUID, requestID uuid.UUID) {
+	playerPacketCacheMutex.Lock()
+	defer playerPacketCacheMutex.Unlock()
+	if _, found := playerPacketCache[accountID].PacketCache[requestID]; found {
+		delete(playerPacketCache[accountID].PacketCache, requestID)
+		if len(playerPacketCache[accountID].PacketCache) == 0 {
+			delete(playerPacketCache, accountID)
+		}
+	}
+}
+func dropPacketCache(accountID uuid.UUID) {
+	playerPacketCacheMutex.Lock()
+	defer playerPacketCacheMutex.Unlock()
+	delete(playerPacketCache, accountID)
+}
 func packetDissect(netData string) (string, string) {
 	data := strings.TrimSpace(string(netData))
 	sep := strings.Index(data, "#")
@@ -978,6 +1509,16 @@ func processTier5Packet(packetMessage string) (string, string, string, string, s
 	item5 := strings.Split(packetMessage, "?")[4]
 	return item1, item2, item3, item4, item5
 }
+
+// processPaddedPacket splits a packet into exactly fields parts, the last one keeps any '?' of free text
+// such as chat messages and missing trailing fields are empty.
+func processPaddedPacket(packetMessage string, fields int) []string {
+	items := strings.SplitN(packetMessage, "?", fields)
+	for len(items) < fields {
+		items = append(items, "")
+	}
+	return items
+}
 func getArrayFromString(packetMessage string) []int {
 	var itemArray []int
 	arrayWithoutBrackets := strings.Split(strings.Split(packetMessage, "[")[1], "]")[0]
@@ -1001,52 +1542,36 @@ func writeResponse(accountID uuid.UUID, requestID string, packet Packet, clientC
 	}
 	clientConnection.Write([]byte(strings.Trim(strconv.QuoteToASCII(clientResponse), "\"")))
 }
-func chainWriteResponse(accountID uuid.UUID, requestID string, packet Packet, byteLimiter int, clientConnection net.Conn, resend bool) {
+
+// chainWriteResponse sends a packet as fragments under a fresh message id and returns that id, the
+// request id is chosen by the client and may not be unique or even a UUID.
+func chainWriteResponse(accountID uuid.UUID, requestID string, packet Packet, byteLimiter int, clientConnection net.Conn, resend bool) uuid.UUID {
 	packetJSON, _ := json.Marshal(packet)
-	packetData := "?" + string(packetJSON)
+	packetData := string(packetJSON)
 	if !resend {
 		addPacketToCache(accountID, packet)
 	}
 	base := strings.Replace(packet.PacketCode, "#", "", -1)
-	totalByteData := []byte(strings.Trim(strconv.QuoteToASCII(packetData), "\""))
-	dataPartitions := len(totalByteData) / byteLimiter
-	fullPartitions := 0
-	remainingBytes := len(totalByteData) % byteLimiter
-	if remainingBytes >= 1 {
-		fullPartitions = dataPartitions
-		dataPartitions++
-	}
-	for i := 1; i <= dataPartitions; i++ {
-		start := 0
-		end := 0
-		currentPartition := strconv.Itoa(i)
-		constructedPacketCode := base + currentPartition + strconv.Itoa(dataPartitions) + "#"
-		//[0 : 1024] -> [1024 : 2048]
-		start = (i - 1) * byteLimiter
-		if i > fullPartitions {
-			end = len(totalByteData)
-		} else {
-			end = (i) * byteLimiter
-		}
-		partitionedInventory := totalByteData[start:end]
-		clientResponse := ""
-		if i > 1 {
-			clientResponse = constructedPacketCode + "?" + string(partitionedInventory)
-		} else {
-			clientResponse = constructedPacketCode + string(partitionedInventory)
-		}
-		fmt.Println(Info("("+packet.ServiceType+") "+"Sent message back to client : ", clientResponse))
-		clientConnection.Write([]byte(clientResponse))
-	}
+	rawByteData := []byte(strings.Trim(strconv.QuoteToASCII(packetData), "\""))
+	totalByteData, compressed := compressPayload(rawByteData, getConnectionCodec(clientConnection))
+	recordCompression(packet.ServiceType, len(rawByteData), len(totalByteData), compressed)
+	messageID := uuid.New()
+	fragments := fragmentMessage(base, messageID, totalByteData, byteLimiter)
+	bufferFragments(accountID, messageID, fragments)
+	//all fragments are written at once so pushes from other goroutines cannot land between them
+	clientConnection.Write([]byte(strings.Join(fragments, "")))
+	fmt.Println(Info("("+packet.ServiceType+") "+"Sent message back to client : ", packet.PacketID))
 	fmt.Println(Info("Size of ", packet.ServiceType, " data in bytes : ", len(totalByteData)))
-	fmt.Println(Info("Size of remaining ", packet.ServiceType, " in bytes : ", len(totalByteData)%byteLimiter))
-	fmt.Println(Info("Size of ", packet.ServiceType, " partitions : ", dataPartitions))
+	fmt.Println(Info("Size of ", packet.ServiceType, " fragments : ", len(fragments)))
+	return messageID
 }
 
-func createBattle(monsters *[]Monster, quantity int) *BattleSession {
+func createBattle(monsters *[]Monster, quantity int, participants []uuid.UUID, splitRule string) *BattleSession {
 	var battle BattleSession
 	battle.BattleID = uuid.New()
 	battle.Status = 0
+	battle.Participants = participants
+	battle.SplitRule = splitRule
 	var createdMonsters []Monster
 	i := 0
 	var reward Reward
@@ -1062,7 +1587,9 @@ func createBattle(monsters *[]Monster, quantity int) *BattleSession {
 		reward.Gold += float64(monster.GoldGain)
 		reward.Exp += float64(monster.ExperienceGain)
 	}
+	sessionsMutex.Lock()
 	sessions.Battles[battle.BattleID] = battle
+	sessionsMutex.Unlock()
 	return &battle
 }
 func tcpListener(PORT string, cxt context.Context, mongoClient *mongo.Client) {
@@ -1078,7 +1605,7 @@ func tcpListener(PORT string, cxt context.Context, mongoClient *mongo.Client) {
 			fmt.Println(Failure(err))
 			return
 		}
-		go handleTCPConnection(clientConnection, cxt, mongoClient)
+		go handleTCPConnection(newOutboundQueue(clientConnection), cxt, mongoClient)
 	}
 }
 func udpListener(PORT string, cxt context.Context, mongoClient *mongo.Client) {
@@ -1105,73 +1632,95 @@ func udpListener(PORT string, cxt context.Context, mongoClient *mongo.Client) {
 		fmt.Println(IncomingPacket(incomingPacket))
 	}
 }
-func handleLogin(username string, password string, mongoClient *mongo.Client) (string, bool) {
+func handleLogin(username string, password string, address string, mongoClient *mongo.Client) (string, bool) {
+	if refusal := loginGuard.CheckLogin(username, remoteIP(address), time.Now()); refusal != "" {
+		fmt.Println(Warn("Login refused for ", username, " from ", remoteIP(address), " : ", refusal))
+		return "Login failed;" + username + ";" + refusal, false
+	}
 	player, playerFound := getUser(username, mongoClient)
+	stored := dummyPasswordHash
 	if playerFound {
-		if validateUser(player, mongoClient) {
-			fmt.Println(Success("Login successful!"))
-			playerJSON, _ := json.Marshal(player)
-			response := fmt.Sprintf("Login successful;%v", string(playerJSON))
-			return response, true
-		} else {
-			fmt.Println(Failure("Login failed!"))
-			return "Login Failed;" + username + ";0", false
-		}
-	} else {
+		stored = player.Password
+	}
+	//unknown users are checked against a dummy hash so response times do not reveal which names exist
+	passwordValid, needsRehash := checkPassword(stored, password)
+	if !playerFound || !passwordValid {
+		loginGuard.Failed(username, remoteIP(address), time.Now())
 		fmt.Println(Failure("Login failed!"))
 		return "Login failed;" + username + ";0", false
 	}
-}
-func handleRegistration(username string, password string, mongoClient *mongo.Client) (string, bool, uuid.UUID) {
-	if !lookForUser(username, mongoClient) {
-		fmt.Println(Info("Password : ", password))
-		accountID := createUser(username, password, mongoClient)
-		return "Account created", true, accountID
+	if sanction, sanctioned := getLoginSanction(player.Account_id, address, mongoClient); sanctioned {
+		fmt.Println(Warn("Sanctioned account tried to log in : ", username, " (", sanction.Sanction_id, ")"))
+		return "Login failed;" + username + ";" + describeSanction(sanction), false
+	}
+	loginGuard.Succeeded(username, remoteIP(address))
+	if needsRehash {
+		updatePasswordHash(player, password, mongoClient)
+	}
+	if validateUser(player, mongoClient) {
+		fmt.Println(Success("Login successful!"))
+		player.Password = ""
+		playerJSON, _ := json.Marshal(player)
+		response := fmt.Sprintf("Login successful;%v", string(playerJSON))
+		return response, true
 	} else {
+		fmt.Println(Failure("Login failed!"))
+		return "Login Failed;" + username + ";0", false
+	}
+}
+func handleRegistration(username string, password string, address string, mongoClient *mongo.Client) (string, bool, uuid.UUID) {
+	if !loginGuard.CheckRegistration(remoteIP(address)) {
+		fmt.Println(Warn("Registration throttled for ", remoteIP(address)))
+		return "Too many registrations, try again later", false, uuid.New()
+	}
+	if reason := validateUsername(username); reason != "" {
+		return reason, false, uuid.New()
+	}
+	if reason := validatePassword(username, password); reason != "" {
+		return reason, false, uuid.New()
+	}
+	if sanction, sanctioned := getLoginSanction(uuid.Nil, address, mongoClient); sanctioned {
+		fmt.Println(Warn("Registration from sanctioned address : ", remoteIP(address)))
+		return "Registration is blocked;" + describeSanction(sanction), false, uuid.New()
+	}
+	accountID, err := createUser(username, password, mongoClient)
+	if err == errUsernameTaken {
 		fmt.Println(Warn(username, " is not available"))
 		return "Username is not available", false, uuid.New()
 	}
+	if err != nil {
+		fmt.Println(Failure(err))
+		return "Registration failed", false, uuid.New()
+	}
+	return "Account created", true, accountID
 }
-func createUser(username string, password string, mongoClient *mongo.Client) uuid.UUID {
+
+// createUser relies on the unique user_id index to settle races between registrations of the same name.
+func createUser(username string, password string, mongoClient *mongo.Client) (uuid.UUID, error) {
 	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	database := mongoClient.Database("player")
 	users := database.Collection("users")
+	hash, err := hashPassword(password)
+	if err != nil {
+		return uuid.Nil, err
+	}
 	var newUser User
 	newUser.ObjectID = primitive.NewObjectID()
 	newUser.Account_id = uuid.New()
 	newUser.User_id = username
-	newUser.Password = password
+	newUser.Password = hash
 	newUser.Active = 0
 	newUser.Logins = 0
 	createResult, err := users.InsertOne(cxt, newUser)
-	if err != nil {
-		log.Fatal(err)
+	if mongo.IsDuplicateKeyError(err) {
+		return uuid.Nil, errUsernameTaken
 	}
-	fmt.Println(Success("New user added to db : ", createResult.InsertedID))
-	return newUser.Account_id
-}
-func lookForUser(username string, mongoClient *mongo.Client) bool {
-	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	database := mongoClient.Database("player")
-	users := database.Collection("users")
-	filterCursor, err := users.Find(cxt, bson.M{"user_id": username})
 	if err != nil {
-		fmt.Println(Failure(err))
-		return false
+		return uuid.Nil, err
 	}
-	var filterResult []bson.M
-	if err = filterCursor.All(cxt, &filterResult); err != nil {
-		log.Fatal(err)
-	}
-	if len(filterResult) == 0 {
-		//no users found
-		return false
-	} else if len(filterResult) == 1 {
-		return true
-	}
-	return true
+	fmt.Println(Success("New user added to db : ", createResult.InsertedID))
+	return newUser.Account_id, nil
 }
 func validateUser(player *User, mongoClient *mongo.Client) bool {
 	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -1241,7 +1790,7 @@ func addCatalogueItem(itemID string, npcID string, price float64, mongoClient *m
 	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
 		panic(err)
 	}
-	catalogueItem, foundItem := getItem(itemID, mongoClient)
+	catalogueItem, foundItem := currentWorld().Item(itemID)
 	if foundItem {
 		var freshShopItem ShopItem
 		freshShopItem.Item_uuid = uuid.New()
@@ -1261,52 +1810,6 @@ func addCatalogueItem(itemID string, npcID string, price float64, mongoClient *m
 		}
 	}
 }
-func getRegion(regionID string, mongoClient *mongo.Client) *Region {
-	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
-		panic(err)
-	}
-	database := mongoClient.Database("world")
-	regions := database.Collection("regions")
-	filterCursor, err := regions.Find(cxt, bson.M{"regionID": regionID})
-	if err != nil {
-		fmt.Println(Failure(err))
-		panic(err)
-	}
-	var filterResult []Region
-	if err = filterCursor.All(cxt, &filterResult); err != nil {
-		log.Fatal(err)
-	}
-	if len(filterResult) == 1 {
-		return &filterResult[0]
-	}
-	var dummyRegion Region
-	return &dummyRegion
-}
-func getLevel(levelID string, mongoClient *mongo.Client) *Level {
-	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
-		panic(err)
-	}
-	database := mongoClient.Database("world")
-	levels := database.Collection("levels")
-	filterCursor, err := levels.Find(cxt, bson.M{"levelID": levelID})
-	if err != nil {
-		fmt.Println(Failure(err))
-		panic(err)
-	}
-	var filterResult []Level
-	if err = filterCursor.All(cxt, &filterResult); err != nil {
-		log.Fatal(err)
-	}
-	if len(filterResult) == 1 {
-		return &filterResult[0]
-	}
-	var dummyLevel Level
-	return &dummyLevel
-}
 func getNPC(npcID string, mongoClient *mongo.Client) *Resident {
 	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -1349,67 +1852,6 @@ func getNPCs(npcIDs []string, mongoClient *mongo.Client) *[]Resident {
 	}
 	return &filterResult
 }
-func getMonster(monsterID string, mongoClient *mongo.Client) *Monster {
-	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
-		panic(err)
-	}
-	database := mongoClient.Database("world")
-	monsters := database.Collection("monsters")
-	filterCursor, err := monsters.Find(cxt, bson.M{"mobID": monsterID})
-	if err != nil {
-		fmt.Println(Failure(err))
-		panic(err)
-	}
-	var filterResult []Monster
-	if err = filterCursor.All(cxt, &filterResult); err != nil {
-		log.Fatal(err)
-	}
-	if len(filterResult) == 1 {
-		return &filterResult[0]
-	}
-	var dummyMonster Monster
-	return &dummyMonster
-}
-func getMonsters(monsterIDs []string, mongoClient *mongo.Client) *[]Monster {
-	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
-		panic(err)
-	}
-	database := mongoClient.Database("world")
-	monsters := database.Collection("monsters")
-	filterCursor, err := monsters.Find(cxt, bson.M{"mobID": bson.M{"$in": monsterIDs}})
-	if err != nil {
-		fmt.Println(Failure(err))
-		panic(err)
-	}
-	var filterResult []Monster
-	if err = filterCursor.All(cxt, &filterResult); err != nil {
-		log.Fatal(err)
-	}
-	return &filterResult
-}
-func getLevels(levelIDs []string, mongoClient *mongo.Client) *[]Level {
-	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
-		panic(err)
-	}
-	database := mongoClient.Database("world")
-	levels := database.Collection("levels")
-	filterCursor, err := levels.Find(cxt, bson.M{"levelID": bson.M{"$in": levelIDs}})
-	if err != nil {
-		fmt.Println(Failure(err))
-		panic(err)
-	}
-	var filterResult []Level
-	if err = filterCursor.All(cxt, &filterResult); err != nil {
-		log.Fatal(err)
-	}
-	return &filterResult
-}
 func getUser(userID string, mongoClient *mongo.Client) (*User, bool) {
 	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -1433,7 +1875,7 @@ func getUser(userID string, mongoClient *mongo.Client) (*User, bool) {
 	var dummyUser User
 	return &dummyUser, false
 }
-func updateUserLastPosition(target_uuid uuid.UUID, lastPosition *Position, mongoClient *mongo.Client) bool {
+func updateProfileLastPosition(target_uuid uuid.UUID, lastPosition *Position, mongoClient *mongo.Client) bool {
 	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
@@ -1505,7 +1947,10 @@ func createProfile(userID string, accountID uuid.UUID, mongoClient *mongo.Client
 	newProfile.Loadout = newLoadout
 
 	newProfile.SpellIndex = make([]string, 0)
+	newProfile.Hotbar = make([]string, 0)
 	newProfile.BaseStats = newProfile.Stats
+	newProfile.Vitals.Health = newProfile.Stats.Health
+	newProfile.Vitals.Mana = newProfile.Stats.Mana
 
 	insertResult, err := profile.InsertOne(cxt, newProfile)
 	if err != nil {
@@ -1544,54 +1989,46 @@ func updateProfile_EXP(accountID uuid.UUID, streamed_exp float64, mongoClient *m
 		panic(err)
 	}
 	profile, profileFound := getProfile(accountID, mongoClient)
-	newTotalExp := float64(profile.Total_EXP) + streamed_exp
-	if profileFound {
+	if profileFound && profile != nil {
 		fmt.Println(Success("Found player profile to update"))
 		database := mongoClient.Database("player")
 		profiles := database.Collection("profiles")
 		match := bson.M{"uuid": accountID}
-		totalEXP := float64(profile.Current_EXP) + streamed_exp
-		fmt.Println(Info(totalEXP, " (TotalEXP) = ", profile.Current_EXP, " (current exp) + ", streamed_exp, " (streamed exp)"))
-		if totalEXP >= float64(profile.Max_EXP) {
-			bufferEXP := 0.0
-			levelUpperLimit := 0
-			levelUpperLimitEXP := profile.Max_EXP
-			bufferEXP = float64(profile.Max_EXP)
-			if totalEXP > bufferEXP {
-				for totalEXP > bufferEXP {
-					levelUpperLimit++
-					levelUpperLimitEXP += 50.0
-					bufferEXP += float64(profile.Max_EXP) + float64(levelUpperLimit*50.0)
-				}
-			}
-			newCurrentEXP := float64(levelUpperLimitEXP) - (bufferEXP - totalEXP)
-			newLevel := int(profile.Level) + levelUpperLimit
-			newMaxEXP := levelUpperLimitEXP
-			change := bson.D{
-				{Key: "$set", Value: bson.D{{Key: "profile.level", Value: newLevel}, {Key: "profile.current_exp", Value: newCurrentEXP}, {Key: "profile.max_exp", Value: newMaxEXP}, {Key: "profile.total_exp", Value: newTotalExp}}},
-			}
-			_, err := profiles.UpdateOne(cxt, match, change)
-			if err != nil {
-				fmt.Println(Failure(err))
-				return 0
-			}
-			return newTotalExp
-		} else if totalEXP < float64(profile.Max_EXP) {
-			newCurrentEXP := totalEXP
-			change := bson.D{
-				{Key: "$set", Value: bson.D{{Key: "profile.current_exp", Value: newCurrentEXP}, {Key: "profile.total_exp", Value: newTotalExp}}},
-			}
-			_, err := profiles.UpdateOne(cxt, match, change)
-			if err != nil {
-				fmt.Println(Failure(err))
-				return 0
-			}
-			return newTotalExp
+		progress, newTotalExp := calculateEXPProgress(profile, streamed_exp)
+		change := bson.D{{Key: "$set", Value: progress}}
+		_, err := profiles.UpdateOne(cxt, match, change)
+		if err != nil {
+			fmt.Println(Failure(err))
+			return 0
 		}
+		return newTotalExp
 	}
 	fmt.Println(Failure("Did not find player profile to update!"))
 	return 0
 }
+func calculateEXPProgress(profile *Profile, streamed_exp float64) (bson.D, float64) {
+	newTotalExp := float64(profile.Total_EXP) + streamed_exp
+	totalEXP := float64(profile.Current_EXP) + streamed_exp
+	fmt.Println(Info(totalEXP, " (TotalEXP) = ", profile.Current_EXP, " (current exp) + ", streamed_exp, " (streamed exp)"))
+	if totalEXP >= float64(profile.Max_EXP) {
+		bufferEXP := 0.0
+		levelUpperLimit := 0
+		levelUpperLimitEXP := profile.Max_EXP
+		bufferEXP = float64(profile.Max_EXP)
+		if totalEXP > bufferEXP {
+			for totalEXP > bufferEXP {
+				levelUpperLimit++
+				levelUpperLimitEXP += 50.0
+				bufferEXP += float64(profile.Max_EXP) + float64(levelUpperLimit*50.0)
+			}
+		}
+		newCurrentEXP := float64(levelUpperLimitEXP) - (bufferEXP - totalEXP)
+		newLevel := int(profile.Level) + levelUpperLimit
+		newMaxEXP := levelUpperLimitEXP
+		return bson.D{{Key: "level", Value: newLevel}, {Key: "current_exp", Value: newCurrentEXP}, {Key: "max_exp", Value: newMaxEXP}, {Key: "total_exp", Value: newTotalExp}}, newTotalExp
+	}
+	return bson.D{{Key: "current_exp", Value: totalEXP}, {Key: "total_exp", Value: newTotalExp}}, newTotalExp
+}
 func addBits(accountID uuid.UUID, streamed_bits float64, add bool, mongoClient *mongo.Client) float64 {
 	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -1630,78 +2067,6 @@ func getBits(accountID uuid.UUID, mongoClient *mongo.Client) float32 {
 	profile, _ := getProfile(accountID, mongoClient)
 	return float32(profile.Purse.Bits)
 }
-func addSpell(accountID uuid.UUID, spellID string, mongoClient *mongo.Client) string {
-	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
-		panic(err)
-	}
-	database := mongoClient.Database("player")
-	profiles := database.Collection("profiles")
-	retrievedSpell, spellFound := getSpell(spellID, mongoClient)
-	if spellFound {
-		entryArea := "spell_index"
-		match := bson.M{"uuid": accountID}
-		change := bson.M{"$push": bson.M{entryArea: retrievedSpell.Spell_id}}
-		updateResponse, err := profiles.UpdateOne(cxt, match, change)
-		fmt.Println(Info(updateResponse))
-		if err != nil {
-			fmt.Println(Failure(err))
-			return "Spell addition failed!"
-		}
-		return "Spell added successfully!"
-	}
-	return "Spell does not exist!"
-}
-func getSpell(spellID string, mongoClient *mongo.Client) (*Spell, bool) {
-	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
-		panic(err)
-	}
-	database := mongoClient.Database("world")
-	spells := database.Collection("spells")
-	filterCursor, err := spells.Find(cxt, bson.M{"spell_id": spellID})
-	if err != nil {
-		fmt.Println(Failure(err))
-		panic(err)
-	}
-	var spellResult []Spell
-	if err = filterCursor.All(cxt, &spellResult); err != nil {
-		log.Fatal(err)
-	}
-	if len(spellResult) == 1 {
-		return &spellResult[0], true
-	}
-	return &spellResult[0], true
-}
-func getItem(itemID string, mongoClient *mongo.Client) (Item, bool) {
-	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
-		panic(err)
-	}
-	database := mongoClient.Database("world")
-	items := database.Collection("items")
-	filterCursor, err := items.Find(cxt, bson.M{"item_id": itemID})
-	if err != nil {
-		fmt.Println(Failure(err))
-		panic(err)
-	}
-	var itemResult []Item
-	if err = filterCursor.All(cxt, &itemResult); err != nil {
-		log.Fatal(err)
-	}
-	if len(itemResult) == 0 {
-		fmt.Println(Warn("Item not found!"))
-		emptyItem := new(Item)
-		return *emptyItem, false
-	} else if len(itemResult) == 0 {
-		fmt.Println(Info("Item retrieved : ", itemResult[0].Item_id))
-		return itemResult[0], true
-	}
-	return itemResult[0], true
-}
 func equipItem(accountID uuid.UUID, itemID string, mongoClient *mongo.Client) string {
 	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -1710,7 +2075,7 @@ func equipItem(accountID uuid.UUID, itemID string, mongoClient *mongo.Client) st
 	}
 	database := mongoClient.Database("player")
 	profile := database.Collection("profiles")
-	retrievedItem, itemFound := getItem(itemID, mongoClient)
+	retrievedItem, itemFound := currentWorld().Item(itemID)
 	if itemFound {
 		entryArea := retrievedItem.Item_type
 		match := bson.M{"uuid": accountID}
@@ -1734,7 +2099,7 @@ func unequipItem(accountID uuid.UUID, itemID string, mongoClient *mongo.Client)
 	}
 	database := mongoClient.Database("player")
 	loadout := database.Collection("profile")
-	retrievedItem, itemFound := getItem(itemID, mongoClient)
+	retrievedItem, itemFound := currentWorld().Item(itemID)
 	if itemFound {
 		entryArea := retrievedItem.Item_type
 		match := bson.M{"uuid": accountID}
@@ -1779,7 +2144,7 @@ func addInventoryItem(accountID uuid.UUID, itemID string, mongoClient *mongo.Cli
 	}
 	database := mongoClient.Database("player")
 	profiles := database.Collection("profiles")
-	retrievedItem, itemFound := getItem(itemID, mongoClient)
+	retrievedItem, itemFound := currentWorld().Item(itemID)
 	if itemFound {
 		entryArea := "items.collection"
 		match := bson.M{"uuid": accountID}
@@ -1790,6 +2155,7 @@ func addInventoryItem(accountID uuid.UUID, itemID string, mongoClient *mongo.Cli
 			fmt.Println(Failure(err))
 			return "Item addition failed!"
 		}
+		emitQuestEvent(accountID, QuestEvent{Type: "collect", Target: retrievedItem.Item_id}, mongoClient)
 		return "Item added successfully!"
 	}
 	return "Item does not exist!"
@@ -1798,7 +2164,7 @@ func performTrade(accountID uuid.UUID, npcID string, playerBasket []Item, shopBa
 	//access player purse
 	//write func getBits()
 	//access shopkeeper purse
-	shopkeeper := ALLshopkeepers[npcID]
+	shopkeeper, _ := getShopkeeper(npcID)
 	shopkeeperBits := shopkeeper.Purse.Bits
 	playerBits := getBits(accountID, mongoClient)
 	//calculate total value of baskets
@@ -1809,7 +2175,7 @@ func performTrade(accountID uuid.UUID, npcID string, playerBasket []Item, shopBa
 		pBasketValue += item.BaseValue
 	}
 	for _, uuid := range shopBasket {
-		for _, item := range ALLshopkeepers[npcID].Catalogue {
+		for _, item := range shopkeeper.Catalogue {
 			if item.Item_uuid == uuid {
 				sBasketValue += item.Price
 			}
@@ -1829,7 +2195,7 @@ func performTrade(accountID uuid.UUID, npcID string, playerBasket []Item, shopBa
 			sBasketValue = 0
 			//update shopkeepers and player
 			shopkeeper.Purse.Bits = shopkeeperBits
-			ALLshopkeepers[npcID] = shopkeeper
+			cacheShopkeeper(shopkeeper)
 			//create setBits function or modify addBits() with extra param
 			addBits(accountID, float64(playerBits), false, mongoClient)
 			return true
@@ -1848,7 +2214,7 @@ func performTrade(accountID uuid.UUID, npcID string, playerBasket []Item, shopBa
 			pBasketValue = 0
 			//update shopkeepers and player
 			shopkeeper.Purse.Bits = shopkeeperBits
-			ALLshopkeepers[npcID] = shopkeeper
+			cacheShopkeeper(shopkeeper)
 			//create setBits function or modify addBits() with extra param
 			addBits(accountID, float64(playerBits), false, mongoClient)
 			return true
@@ -1866,7 +2232,7 @@ func removeInventoryItem(accountID uuid.UUID, itemID string, mongoClient *mongo.
 	}
 	database := mongoClient.Database("player")
 	profiles := database.Collection("profiles")
-	item, itemFound := getItem(itemID, mongoClient)
+	item, itemFound := currentWorld().Item(itemID)
 	if itemFound {
 		entryArea := "items.collection"
 		match := bson.M{"uuid": accountID}
@@ -1877,105 +2243,35 @@ func removeInventoryItem(accountID uuid.UUID, itemID string, mongoClient *mongo.
 			fmt.Println(Failure(err))
 			return "Item deletion failed!"
 		}
+		emitQuestEvent(accountID, QuestEvent{Type: "collect", Target: item.Item_id}, mongoClient)
 		return "Item deletion successfully!"
 	}
 	return "Item does not exist!"
 }
-func getItemsGlobalAndCache(mongoClient *mongo.Client) []Item {
-	//get all items from world/items
+func getShopkeepersGlobalAndCache(mongoClient *mongo.Client) []ShopKeeper {
 	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
 		panic(err)
 	}
 	database := mongoClient.Database("world")
-	items := database.Collection("items")
-	//filterCursor, err := regions.Find(cxt, bson.M{"regionID": regionID})
-	filterCursor, err := items.Find(cxt, bson.D{})
+	shopkeepers := database.Collection("shopkeepers")
+	filterCursor, err := shopkeepers.Find(cxt, bson.D{})
 	if err != nil {
 		fmt.Println(Failure(err))
 		panic(err)
 	}
-	var filterResult []Item
+	var filterResult []ShopKeeper
 	if err = filterCursor.All(cxt, &filterResult); err != nil {
 		log.Fatal(err)
 	}
-	//cache the items to a global map
-	for _, item := range filterResult {
-		MASTER_ITEM_TABLE[item.Item_id] = item
-	}
-	return filterResult
-}
-func getSpellsGlobalAndCache(mongoClient *mongo.Client) []Spell {
-	//get all items from world/items
-	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
-		panic(err)
-	}
-	database := mongoClient.Database("world")
-	items := database.Collection("spells")
-	filterCursor, err := items.Find(cxt, bson.D{})
-	if err != nil {
-		fmt.Println(Failure(err))
-		panic(err)
-	}
-	var filterResult []Spell
-	if err = filterCursor.All(cxt, &filterResult); err != nil {
-		log.Fatal(err)
-	}
-	//cache the items to a global map
-	for _, spell := range filterResult {
-		MASTER_SPELL_TABLE[spell.Spell_id] = spell
-	}
-	return filterResult
-}
-func getMonstersGlobalAndCache(mongoClient *mongo.Client) []Monster {
-	//get all items from world/items
-	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
-		panic(err)
-	}
-	database := mongoClient.Database("world")
-	items := database.Collection("monsters")
-	filterCursor, err := items.Find(cxt, bson.D{})
-	if err != nil {
-		fmt.Println(Failure(err))
-		panic(err)
-	}
-	var filterResult []Monster
-	if err = filterCursor.All(cxt, &filterResult); err != nil {
-		log.Fatal(err)
-	}
-	//cache the items to a global map
-	for _, monster := range filterResult {
-		MASTER_MONSTER_TABLE[monster.MobID] = monster
-	}
-	return filterResult
-}
-func getLevelsGlobalAndCache(mongoClient *mongo.Client) []Level {
-	//get all items from world/items
-	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
-		panic(err)
-	}
-	database := mongoClient.Database("world")
-	items := database.Collection("levels")
-	filterCursor, err := items.Find(cxt, bson.D{})
-	if err != nil {
-		fmt.Println(Failure(err))
-		panic(err)
-	}
-	var filterResult []Level
-	if err = filterCursor.All(cxt, &filterResult); err != nil {
-		log.Fatal(err)
-	}
-	//cache the items to a global map
-	for _, level := range filterResult {
-		MASTER_LEVEL_TABLE[level.LevelID] = level
+	table := make(map[string]ShopKeeper, len(filterResult))
+	for _, shopkeeper := range filterResult {
+		table[shopkeeper.NpcID] = shopkeeper
 	}
+	ALLshopkeepersMutex.Lock()
+	ALLshopkeepers = table
+	ALLshopkeepersMutex.Unlock()
 	return filterResult
 }
 func updateStatsByItem(originalStats *Stats, item *Item, operation string) *Stats {
@@ -2014,14 +2310,6 @@ func updateStatsByItem(originalStats *Stats, item *Item, operation string) *Stat
 
 	return originalStats
 }
-func getSpellFromCache(spell_id string) Spell {
-	fmt.Println(Internal("Spell retrieved from cache!"))
-	return MASTER_SPELL_TABLE[spell_id]
-}
-func getLevelFromCache(level_id string) Level {
-	fmt.Println(Internal("Level retrieved from cache!"))
-	return MASTER_LEVEL_TABLE[level_id]
-}
 
 func main() {
 	//mongoDB specs
@@ -2050,11 +2338,21 @@ func main() {
 	// addInventoryItem("asd", "WizardHat", mongoClient)
 
 	//getShopKeepersForServer(mongoClient)
-	// get all global spell and item data and cache it during server runtime
-	getItemsGlobalAndCache(mongoClient)
-	getSpellsGlobalAndCache(mongoClient)
-	getMonstersGlobalAndCache(mongoClient)
-	getLevelsGlobalAndCache(mongoClient)
+	// get all global world data and serve it from memory during server runtime
+	if _, _, err := reloadWorldData(mongoClient); err != nil {
+		panic(err)
+	}
+	go watchWorldData(mongoClient)
+	getShopkeepersGlobalAndCache(mongoClient)
+	ensureUserIndexes(mongoClient)
+	returnStaleEscrows(mongoClient)
+	go runMarketSettlement(mongoClient)
+	go runMailPurge(mongoClient)
+	go runPositionFlush(mongoClient)
+	go runCompressionMetricsLog()
+	if problems := validateResidentDialogues(mongoClient); problems > 0 {
+		fmt.Println(Warn(problems, " problems found in NPC dialogue trees"))
+	}
 	//disconnect mongoDB client on return
 	defer func() {
 		if err = mongoClient.Disconnect(cxt); err != nil {
@@ -2068,5 +2366,10 @@ func main() {
 	go tcpListener(":20001", cxt, mongoClient)
 	wg.Add(2)
 	go udpListener(":26950", cxt, mongoClient)
+	if len(adminTokens) > 0 {
+		go adminListener(ADMIN_ADDRESS, mongoClient)
+	} else {
+		fmt.Println(Warn("No admins configured in ", ADMIN_TOKENS_ENV, ", admin console is disabled"))
+	}
 	wg.Wait()
 }
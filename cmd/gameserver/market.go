@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Mode is "fixed" for a buyout price or "auction" where Price is the starting bid.
+// Status moves from "active" to "sold", "expired" or "cancelled".
+type MarketListing struct {
+	Listing_id  uuid.UUID `json:"listing_id" default:"" bson:"listing_id"`
+	Seller_id   uuid.UUID `json:"seller_id" default:"" bson:"seller_id"`
+	Item        Item      `json:"item" default:"" bson:"item"`
+	Mode        string    `json:"mode" default:"fixed" bson:"mode"`
+	Price       float64   `json:"price" default:"0" bson:"price"`
+	High_bid    float64   `json:"high_bid" default:"0" bson:"high_bid"`
+	High_bidder uuid.UUID `json:"high_bidder" default:"" bson:"high_bidder"`
+	Fee         float64   `json:"fee" default:"0" bson:"fee"`
+	Status      string    `json:"status" default:"active" bson:"status"`
+	Created_at  time.Time `json:"created_at" default:"" bson:"created_at"`
+	Ends_at     time.Time `json:"ends_at" default:"" bson:"ends_at"`
+}
+
+const (
+	LISTING_FIXED     = "fixed"
+	LISTING_AUCTION   = "auction"
+	LISTING_ACTIVE    = "active"
+	LISTING_SOLD      = "sold"
+	LISTING_EXPIRED   = "expired"
+	LISTING_CANCELLED = "cancelled"
+)
+
+// the listing fee is a bits sink, it is never refunded
+var MARKET_LISTING_FEE_RATE = 0.05
+var MARKET_MIN_LISTING_FEE = 1.0
+var MARKET_DEFAULT_DURATION = 24 * time.Hour
+var MARKET_MAX_DURATION = 72 * time.Hour
+var MARKET_SETTLE_INTERVAL = time.Minute
+var MARKET_SEARCH_LIMIT int64 = 50
+
+func marketFailure(reason string) string {
+	return "MARKET$0;" + reason
+}
+func marketResponse(content interface{}) string {
+	contentJSON, _ := json.Marshal(content)
+	return "MARKET$1;" + string(contentJSON)
+}
+func listingFee(price float64) float64 {
+	return math.Max(MARKET_MIN_LISTING_FEE, math.Ceil(price*MARKET_LISTING_FEE_RATE))
+}
+
+// minimumBid is the smallest bid that beats the current one, the first bid only has to meet the starting price.
+func minimumBid(listing MarketListing) float64 {
+	if listing.High_bidder == uuid.Nil {
+		return listing.Price
+	}
+	return listing.High_bid + 1
+}
+
+// settlementMail works out who gets what when a listing ends: the winner gets the item and the
+// seller the proceeds, or the item goes back to the seller if nobody bought it.
+func settlementMail(listing MarketListing, buyerID uuid.UUID, price float64) []MailMessage {
+	if buyerID == uuid.Nil {
+		return []MailMessage{newMail(listing.Seller_id, "Listing expired: "+listing.Item.Name, "", []string{listing.Item.Item_id}, 0)}
+	}
+	return []MailMessage{
+		newMail(buyerID, "Purchased: "+listing.Item.Name, "", []string{listing.Item.Item_id}, 0),
+		newMail(listing.Seller_id, "Sold: "+listing.Item.Name, "", nil, price),
+	}
+}
+
+// createListing moves the item out of the seller's inventory into the listing and charges the fee.
+func createListing(accountID uuid.UUID, itemID string, priceSTR string, mode string, hoursSTR string, mongoClient *mongo.Client) string {
+	item, found := currentWorld().Items[itemID]
+	if !found {
+		return marketFailure("Item does not exist")
+	}
+	price, err := strconv.ParseFloat(priceSTR, 64)
+	if err != nil || price <= 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+		return marketFailure("Invalid price")
+	}
+	if mode == "" {
+		mode = LISTING_FIXED
+	}
+	if mode != LISTING_FIXED && mode != LISTING_AUCTION {
+		return marketFailure("Invalid listing mode")
+	}
+	duration := MARKET_DEFAULT_DURATION
+	if hours, err := strconv.Atoi(hoursSTR); err == nil && hours > 0 {
+		duration = time.Duration(hours) * time.Hour
+	}
+	if duration > MARKET_MAX_DURATION {
+		duration = MARKET_MAX_DURATION
+	}
+	now := time.Now().UTC()
+	listing := MarketListing{
+		Listing_id: uuid.New(),
+		Seller_id:  accountID,
+		Item:       item,
+		Mode:       mode,
+		Price:      price,
+		Fee:        listingFee(price),
+		Status:     LISTING_ACTIVE,
+		Created_at: now,
+		Ends_at:    now.Add(duration),
+	}
+	profiles := mongoClient.Database("player").Collection("profiles")
+	listings := mongoClient.Database("world").Collection("market")
+	err = runTransaction(mongoClient, func(sessionContext mongo.SessionContext) error {
+		var profile Profile
+		if err := profiles.FindOne(sessionContext, bson.M{"uuid": accountID}).Decode(&profile); err != nil {
+			return err
+		}
+		if profile.Purse.Bits < listing.Fee {
+			return errors.New("not enough bits for the listing fee")
+		}
+		collection, removed := removeItemsFromCollection(profile.Items.Collection, map[string]int{itemID: 1})
+		if !removed {
+			return errors.New("you do not have this item")
+		}
+		match := bson.M{"uuid": accountID, "items.collection": profile.Items.Collection, "purse.bits": profile.Purse.Bits}
+		change := bson.M{"$set": bson.M{"items.collection": collection, "purse.bits": profile.Purse.Bits - listing.Fee}}
+		if err := updateOneMatched(sessionContext, profiles, match, change); err != nil {
+			return err
+		}
+		_, err := listings.InsertOne(sessionContext, listing)
+		return err
+	})
+	if err != nil {
+		fmt.Println(Failure("Listing failed : ", err))
+		return marketFailure("Listing failed: " + err.Error())
+	}
+	fmt.Println(Success("Market listing created : ", listing.Listing_id))
+	return marketResponse(listing)
+}
+
+// searchMarket finds active listings, every empty filter matches anything and name matches case insensitively.
+func searchMarket(itemType string, itemSubtype string, name string, mongoClient *mongo.Client) string {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	filter := bson.M{"status": LISTING_ACTIVE, "ends_at": bson.M{"$gt": time.Now().UTC()}}
+	if itemType != "" {
+		filter["item.item_type"] = itemType
+	}
+	if itemSubtype != "" {
+		filter["item.item_subtype"] = itemSubtype
+	}
+	if name != "" {
+		filter["item.name"] = primitive.Regex{Pattern: regexp.QuoteMeta(name), Options: "i"}
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "price", Value: 1}}).SetLimit(MARKET_SEARCH_LIMIT)
+	filterCursor, err := mongoClient.Database("world").Collection("market").Find(cxt, filter, findOptions)
+	if err != nil {
+		fmt.Println(Failure(err))
+		return marketFailure("Search failed")
+	}
+	listings := []MarketListing{}
+	if err = filterCursor.All(cxt, &listings); err != nil {
+		fmt.Println(Failure(err))
+		return marketFailure("Search failed")
+	}
+	return marketResponse(listings)
+}
+func getListing(sessionContext mongo.SessionContext, listings *mongo.Collection, listingID uuid.UUID) (MarketListing, error) {
+	var listing MarketListing
+	err := listings.FindOne(sessionContext, bson.M{"listing_id": listingID}).Decode(&listing)
+	if err == mongo.ErrNoDocuments {
+		return listing, errors.New("listing does not exist")
+	}
+	return listing, err
+}
+
+// buyListing pays the fixed price and closes the listing, the item and proceeds are sent by mail.
+func buyListing(accountID uuid.UUID, listingID uuid.UUID, mongoClient *mongo.Client) string {
+	profiles := mongoClient.Database("player").Collection("profiles")
+	listings := mongoClient.Database("world").Collection("market")
+	var delivered []MailMessage
+	err := runTransaction(mongoClient, func(sessionContext mongo.SessionContext) error {
+		listing, err := getListing(sessionContext, listings, listingID)
+		if err != nil {
+			return err
+		}
+		if listing.Mode != LISTING_FIXED || listing.Status != LISTING_ACTIVE || time.Now().After(listing.Ends_at) {
+			return errors.New("listing is not for sale")
+		}
+		if listing.Seller_id == accountID {
+			return errors.New("cannot buy your own listing")
+		}
+		match := bson.M{"uuid": accountID, "purse.bits": bson.M{"$gte": listing.Price}}
+		if err := updateOneMatched(sessionContext, profiles, match, bson.M{"$inc": bson.M{"purse.bits": -listing.Price}}); err != nil {
+			return errors.New("not enough bits")
+		}
+		match = bson.M{"listing_id": listingID, "status": LISTING_ACTIVE}
+		if err := updateOneMatched(sessionContext, listings, match, bson.M{"$set": bson.M{"status": LISTING_SOLD}}); err != nil {
+			return err
+		}
+		delivered = settlementMail(listing, accountID, listing.Price)
+		return sendMail(sessionContext, mongoClient, delivered...)
+	})
+	if err != nil {
+		return marketFailure("Purchase failed: " + err.Error())
+	}
+	notifyMail(delivered...)
+	fmt.Println(Success("Market listing sold : ", listingID))
+	return marketResponse(bson.M{"listing_id": listingID, "status": LISTING_SOLD})
+}
+
+// bidListing holds the bid in escrow and returns the previous high bid to its bidder by mail.
+func bidListing(accountID uuid.UUID, listingID uuid.UUID, amountSTR string, mongoClient *mongo.Client) string {
+	amount, err := strconv.ParseFloat(amountSTR, 64)
+	if err != nil || amount <= 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return marketFailure("Invalid bid")
+	}
+	profiles := mongoClient.Database("player").Collection("profiles")
+	listings := mongoClient.Database("world").Collection("market")
+	var delivered []MailMessage
+	err = runTransaction(mongoClient, func(sessionContext mongo.SessionContext) error {
+		delivered = nil
+		listing, err := getListing(sessionContext, listings, listingID)
+		if err != nil {
+			return err
+		}
+		if listing.Mode != LISTING_AUCTION || listing.Status != LISTING_ACTIVE || time.Now().After(listing.Ends_at) {
+			return errors.New("listing is not taking bids")
+		}
+		if listing.Seller_id == accountID {
+			return errors.New("cannot bid on your own listing")
+		}
+		if amount < minimumBid(listing) {
+			return errors.New("bid must be at least " + strconv.FormatFloat(minimumBid(listing), 'f', -1, 64))
+		}
+		match := bson.M{"uuid": accountID, "purse.bits": bson.M{"$gte": amount}}
+		if err := updateOneMatched(sessionContext, profiles, match, bson.M{"$inc": bson.M{"purse.bits": -amount}}); err != nil {
+			return errors.New("not enough bits")
+		}
+		match = bson.M{"listing_id": listingID, "status": LISTING_ACTIVE, "high_bid": listing.High_bid, "high_bidder": listing.High_bidder}
+		change := bson.M{"$set": bson.M{"high_bid": amount, "high_bidder": accountID}}
+		if err := updateOneMatched(sessionContext, listings, match, change); err != nil {
+			return err
+		}
+		if listing.High_bidder == uuid.Nil {
+			return nil
+		}
+		delivered = []MailMessage{newMail(listing.High_bidder, "Outbid: "+listing.Item.Name, "", nil, listing.High_bid)}
+		return sendMail(sessionContext, mongoClient, delivered...)
+	})
+	if err != nil {
+		return marketFailure("Bid failed: " + err.Error())
+	}
+	notifyMail(delivered...)
+	return marketResponse(bson.M{"listing_id": listingID, "high_bid": amount})
+}
+
+// cancelListing lets a seller withdraw a listing nobody has bid on, the item comes back by mail.
+func cancelListing(accountID uuid.UUID, listingID uuid.UUID, mongoClient *mongo.Client) string {
+	listings := mongoClient.Database("world").Collection("market")
+	var delivered []MailMessage
+	err := runTransaction(mongoClient, func(sessionContext mongo.SessionContext) error {
+		listing, err := getListing(sessionContext, listings, listingID)
+		if err != nil {
+			return err
+		}
+		if listing.Seller_id != accountID || listing.Status != LISTING_ACTIVE {
+			return errors.New("not your active listing")
+		}
+		match := bson.M{"listing_id": listingID, "status": LISTING_ACTIVE, "high_bidder": uuid.Nil}
+		if err := updateOneMatched(sessionContext, listings, match, bson.M{"$set": bson.M{"status": LISTING_CANCELLED}}); err != nil {
+			return errors.New("listing already has bids")
+		}
+		delivered = settlementMail(listing, uuid.Nil, 0)
+		return sendMail(sessionContext, mongoClient, delivered...)
+	})
+	if err != nil {
+		return marketFailure("Cancel failed: " + err.Error())
+	}
+	notifyMail(delivered...)
+	return marketResponse(bson.M{"listing_id": listingID, "status": LISTING_CANCELLED})
+}
+
+// settleMarket closes every listing past its end time. Auctions go to the high bidder, everything
+// else returns to its seller.
+func settleMarket(mongoClient *mongo.Client) {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		fmt.Println(Failure(err))
+		return
+	}
+	listings := mongoClient.Database("world").Collection("market")
+	filterCursor, err := listings.Find(cxt, bson.M{"status": LISTING_ACTIVE, "ends_at": bson.M{"$lte": time.Now().UTC()}})
+	if err != nil {
+		fmt.Println(Failure(err))
+		return
+	}
+	var expired []MarketListing
+	if err = filterCursor.All(cxt, &expired); err != nil {
+		fmt.Println(Failure(err))
+		return
+	}
+	for _, listing := range expired {
+		status := LISTING_EXPIRED
+		if listing.High_bidder != uuid.Nil {
+			status = LISTING_SOLD
+		}
+		delivered := settlementMail(listing, listing.High_bidder, listing.High_bid)
+		err := runTransaction(mongoClient, func(sessionContext mongo.SessionContext) error {
+			match := bson.M{"listing_id": listing.Listing_id, "status": LISTING_ACTIVE}
+			if err := updateOneMatched(sessionContext, listings, match, bson.M{"$set": bson.M{"status": status}}); err != nil {
+				return err
+			}
+			return sendMail(sessionContext, mongoClient, delivered...)
+		})
+		if err != nil {
+			fmt.Println(Failure("Settling listing ", listing.Listing_id, " failed : ", err))
+			continue
+		}
+		notifyMail(delivered...)
+		fmt.Println(Info("Market listing settled : ", listing.Listing_id, " ", status))
+	}
+}
+func runMarketSettlement(mongoClient *mongo.Client) {
+	ticker := time.NewTicker(MARKET_SETTLE_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		settleMarket(mongoClient)
+	}
+}
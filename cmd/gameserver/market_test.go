@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestListingFee(t *testing.T) {
+	if fee := listingFee(5); fee != MARKET_MIN_LISTING_FEE {
+		t.Errorf("expected minimum fee, got %v", fee)
+	}
+	if fee := listingFee(1000); fee != 50 {
+		t.Errorf("expected 5%% fee, got %v", fee)
+	}
+}
+
+func TestMinimumBid(t *testing.T) {
+	listing := MarketListing{Mode: LISTING_AUCTION, Price: 20}
+	if bid := minimumBid(listing); bid != 20 {
+		t.Errorf("expected first bid to meet the starting price, got %v", bid)
+	}
+	listing.High_bidder, listing.High_bid = uuid.New(), 35
+	if bid := minimumBid(listing); bid != 36 {
+		t.Errorf("expected next bid to beat the high bid, got %v", bid)
+	}
+}
+
+func TestSettlementMail(t *testing.T) {
+	listing := MarketListing{Seller_id: uuid.New(), Item: Item{Item_id: "WizardHat", Name: "Wizard Hat"}}
+	returned := settlementMail(listing, uuid.Nil, 0)
+	if len(returned) != 1 || returned[0].Account_id != listing.Seller_id || returned[0].Items[0] != "WizardHat" {
+		t.Errorf("expected unsold item to return to the seller, got %+v", returned)
+	}
+	buyerID := uuid.New()
+	sold := settlementMail(listing, buyerID, 40)
+	if len(sold) != 2 || sold[0].Account_id != buyerID || sold[1].Purse.Bits != 40 {
+		t.Errorf("expected item to the buyer and proceeds to the seller, got %+v", sold)
+	}
+}
+
+func TestMarketRejectsNonFiniteAmounts(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	for _, invalid := range []string{"NaN", "Inf", "-Inf", "1e400"} {
+		if response := createListing(uuid.New(), "Fang", invalid, LISTING_FIXED, "", mongoClient); response != marketFailure("Invalid price") {
+			t.Errorf("listed at %s : %q", invalid, response)
+		}
+		if response := bidListing(uuid.New(), uuid.New(), invalid, mongoClient); response != marketFailure("Invalid bid") {
+			t.Errorf("bid %s : %q", invalid, response)
+		}
+	}
+}
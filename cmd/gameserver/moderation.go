@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type AppealNote struct {
+	Author  string    `json:"author" bson:"author"`
+	Note    string    `json:"note" bson:"note"`
+	Created time.Time `json:"created" bson:"created"`
+}
+
+// Sanction is one entry in an account's moderation history. A zero Expires_at never runs out.
+// Account sanctions carry the Account_id, IP sanctions the address in Target instead.
+type Sanction struct {
+	Sanction_id uuid.UUID    `json:"sanction_id" bson:"sanction_id"`
+	Type        string       `json:"type" bson:"type"`
+	Scope       string       `json:"scope" bson:"scope"`
+	Account_id  uuid.UUID    `json:"uuid" bson:"uuid"`
+	Target      string       `json:"target" bson:"target"`
+	Reason      string       `json:"reason" bson:"reason"`
+	Issuer      string       `json:"issuer" bson:"issuer"`
+	Issued_at   time.Time    `json:"issued_at" bson:"issued_at"`
+	Expires_at  time.Time    `json:"expires_at" bson:"expires_at"`
+	Lifted      bool         `json:"lifted" bson:"lifted"`
+	Lifted_by   string       `json:"lifted_by" bson:"lifted_by"`
+	Lifted_at   time.Time    `json:"lifted_at" bson:"lifted_at"`
+	Appeals     []AppealNote `json:"appeals" bson:"appeals"`
+}
+
+const (
+	SANCTION_SUSPENSION = "suspension"
+	SANCTION_BAN        = "ban"
+	SCOPE_ACCOUNT       = "account"
+	SCOPE_IP            = "ip"
+)
+
+// isActive reports whether a sanction still blocks logins at the given time.
+func (sanction Sanction) isActive(now time.Time) bool {
+	return !sanction.Lifted && (sanction.Expires_at.IsZero() || now.Before(sanction.Expires_at))
+}
+
+// strongestSanction picks the sanction a login is refused with: a permanent one over a timed one,
+// otherwise the one that runs the longest.
+func strongestSanction(sanctions []Sanction, now time.Time) (Sanction, bool) {
+	var strongest Sanction
+	found := false
+	for _, sanction := range sanctions {
+		if !sanction.isActive(now) {
+			continue
+		}
+		if !found || outlasts(sanction, strongest) {
+			strongest = sanction
+			found = true
+		}
+	}
+	return strongest, found
+}
+func outlasts(sanction Sanction, other Sanction) bool {
+	if sanction.Expires_at.IsZero() != other.Expires_at.IsZero() {
+		return sanction.Expires_at.IsZero()
+	}
+	return sanction.Expires_at.After(other.Expires_at)
+}
+
+// describeSanction is the reason a refused login is given, "banned;<reason>;permanent" or
+// "suspended;<reason>;<expiry>".
+func describeSanction(sanction Sanction) string {
+	status := "suspended"
+	if sanction.Type == SANCTION_BAN {
+		status = "banned"
+	}
+	expiry := "permanent"
+	if !sanction.Expires_at.IsZero() {
+		expiry = sanction.Expires_at.UTC().Format(time.RFC3339)
+	}
+	return status + ";" + sanction.Reason + ";" + expiry
+}
+
+// remoteIP strips the port off a connection's remote address.
+func remoteIP(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+func getSanctions(filter bson.M, mongoClient *mongo.Client) []Sanction {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	sanctions := mongoClient.Database("player").Collection("sanctions")
+	filterCursor, err := sanctions.Find(cxt, filter, options.Find().SetSort(bson.M{"issued_at": 1}))
+	if err != nil {
+		fmt.Println(Failure(err))
+		return nil
+	}
+	var filterResult []Sanction
+	if err = filterCursor.All(cxt, &filterResult); err != nil {
+		fmt.Println(Failure(err))
+		return nil
+	}
+	return filterResult
+}
+
+// getLoginSanction finds the sanction that keeps an account, or the address it connects from, out.
+func getLoginSanction(accountID uuid.UUID, address string, mongoClient *mongo.Client) (Sanction, bool) {
+	filter := bson.M{"lifted": false, "$or": bson.A{
+		bson.M{"scope": SCOPE_ACCOUNT, "uuid": accountID},
+		bson.M{"scope": SCOPE_IP, "target": remoteIP(address)},
+	}}
+	return strongestSanction(getSanctions(filter, mongoClient), time.Now())
+}
+func getModerationHistory(accountID uuid.UUID, mongoClient *mongo.Client) []Sanction {
+	return getSanctions(bson.M{"uuid": accountID}, mongoClient)
+}
+
+// issueSanction records a suspension (duration > 0) or a permanent ban and ends the sessions it
+// covers straight away.
+func issueSanction(sanction Sanction, duration time.Duration, mongoClient *mongo.Client) (Sanction, error) {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	sanction.Sanction_id = uuid.New()
+	sanction.Issued_at = time.Now().UTC()
+	sanction.Type = SANCTION_BAN
+	if duration > 0 {
+		sanction.Type = SANCTION_SUSPENSION
+		sanction.Expires_at = sanction.Issued_at.Add(duration)
+	}
+	sanction.Appeals = []AppealNote{}
+	if _, err := mongoClient.Database("player").Collection("sanctions").InsertOne(cxt, sanction); err != nil {
+		return sanction, err
+	}
+	fmt.Println(Warn("Sanction ", sanction.Sanction_id, " issued by ", sanction.Issuer, " : ", describeSanction(sanction)))
+	//the kick notice carries the same reason and expiry a login attempt would be refused with
+	for _, accountID := range getSanctionedSessions(sanction) {
+		kickPlayer(accountID, describeSanction(sanction))
+	}
+	return sanction, nil
+}
+
+// getSanctionedSessions lists the online accounts a new sanction covers.
+func getSanctionedSessions(sanction Sanction) []uuid.UUID {
+	var accounts []uuid.UUID
+	for _, entry := range getOnlinePlayers() {
+		if sanction.Scope == SCOPE_ACCOUNT && entry.Account_id == sanction.Account_id ||
+			sanction.Scope == SCOPE_IP && remoteIP(entry.Connection.RemoteAddr().String()) == sanction.Target {
+			accounts = append(accounts, entry.Account_id)
+		}
+	}
+	return accounts
+}
+
+// liftSanctions lifts every active sanction matching the filter and keeps the reason as an appeal note.
+func liftSanctions(filter bson.M, issuer string, note string, mongoClient *mongo.Client) (int, error) {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	now := time.Now().UTC()
+	filter["lifted"] = false
+	change := bson.M{"$set": bson.M{"lifted": true, "lifted_by": issuer, "lifted_at": now}}
+	if note != "" {
+		change["$push"] = bson.M{"appeals": AppealNote{Author: issuer, Note: note, Created: now}}
+	}
+	updateResponse, err := mongoClient.Database("player").Collection("sanctions").UpdateMany(cxt, filter, change)
+	if err != nil {
+		return 0, err
+	}
+	return int(updateResponse.ModifiedCount), nil
+}
+
+// addAppealNote attaches a note to a sanction, e.g. what the player said in their appeal.
+func addAppealNote(sanctionID uuid.UUID, author string, note string, mongoClient *mongo.Client) error {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	change := bson.M{"$push": bson.M{"appeals": AppealNote{Author: author, Note: note, Created: time.Now().UTC()}}}
+	updateResponse, err := mongoClient.Database("player").Collection("sanctions").UpdateOne(cxt, bson.M{"sanction_id": sanctionID}, change)
+	if err != nil {
+		return err
+	}
+	if updateResponse.MatchedCount != 1 {
+		return errors.New("sanction does not exist")
+	}
+	return nil
+}
+
+// parseSanctionDuration reads "permanent" or a duration such as "72h" for admin commands.
+func parseSanctionDuration(durationSTR string) (time.Duration, error) {
+	if durationSTR == "permanent" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(durationSTR)
+	if err != nil || duration <= 0 {
+		return 0, errors.New("invalid duration, use permanent or e.g. 72h")
+	}
+	return duration, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStrongestSanction(t *testing.T) {
+	now := time.Now()
+	expired := Sanction{Type: SANCTION_SUSPENSION, Reason: "expired", Expires_at: now.Add(-time.Hour)}
+	short := Sanction{Type: SANCTION_SUSPENSION, Reason: "short", Expires_at: now.Add(time.Hour)}
+	long := Sanction{Type: SANCTION_SUSPENSION, Reason: "long", Expires_at: now.Add(48 * time.Hour)}
+	permanent := Sanction{Type: SANCTION_BAN, Reason: "permanent"}
+	lifted := Sanction{Type: SANCTION_BAN, Reason: "lifted", Lifted: true}
+
+	if _, found := strongestSanction([]Sanction{expired, lifted}, now); found {
+		t.Errorf("expired and lifted sanctions should not block logins")
+	}
+	if sanction, _ := strongestSanction([]Sanction{short, long, expired}, now); sanction.Reason != "long" {
+		t.Errorf("expected the longest suspension, got %s", sanction.Reason)
+	}
+	if sanction, _ := strongestSanction([]Sanction{long, permanent, short}, now); sanction.Reason != "permanent" {
+		t.Errorf("expected the permanent ban, got %s", sanction.Reason)
+	}
+}
+
+func TestDescribeSanction(t *testing.T) {
+	expiry := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	if description := describeSanction(Sanction{Type: SANCTION_SUSPENSION, Reason: "Spamming", Expires_at: expiry}); description != "suspended;Spamming;2030-01-02T03:04:05Z" {
+		t.Errorf("unexpected description %q", description)
+	}
+	if description := describeSanction(Sanction{Type: SANCTION_BAN, Reason: "Cheating"}); description != "banned;Cheating;permanent" {
+		t.Errorf("unexpected description %q", description)
+	}
+}
+
+func TestParseSanctionDuration(t *testing.T) {
+	if duration, err := parseSanctionDuration("permanent"); err != nil || duration != 0 {
+		t.Errorf("permanent should parse to no expiry")
+	}
+	if duration, err := parseSanctionDuration("72h"); err != nil || duration != 72*time.Hour {
+		t.Errorf("unexpected duration %s (%v)", duration, err)
+	}
+	for _, invalid := range []string{"forever", "-1h", "0s"} {
+		if _, err := parseSanctionDuration(invalid); err == nil {
+			t.Errorf("%q was accepted", invalid)
+		}
+	}
+}
+
+func TestSanctionedSessions(t *testing.T) {
+	if remoteIP("203.0.113.7:51234") != "203.0.113.7" || remoteIP("[2001:db8::1]:80") != "2001:db8::1" {
+		t.Errorf("remote ip did not drop the port")
+	}
+	server, client := net.Pipe()
+	defer client.Close()
+	accountID, otherID := uuid.New(), uuid.New()
+	bindPlayerConnection(accountID, newOutboundQueue(server))
+	defer dropPlayerConnection(server)
+
+	if sessions := getSanctionedSessions(Sanction{Scope: SCOPE_ACCOUNT, Account_id: accountID}); len(sessions) != 1 || sessions[0] != accountID {
+		t.Errorf("account sanction did not cover the session : %v", sessions)
+	}
+	if sessions := getSanctionedSessions(Sanction{Scope: SCOPE_ACCOUNT, Account_id: otherID}); len(sessions) != 0 {
+		t.Errorf("sanction covered another account : %v", sessions)
+	}
+	// pipes report "pipe" as their address, other tests may have left pipe sessions behind too
+	if sessions := getSanctionedSessions(Sanction{Scope: SCOPE_IP, Target: "pipe"}); !containsAccount(sessions, accountID) {
+		t.Errorf("ip sanction did not cover the session : %v", sessions)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// LevelBounds is the box players may move inside of in a level, levels without bounds are not checked.
+type LevelBounds struct {
+	Min Position `json:"min" default:"" bson:"min"`
+	Max Position `json:"max" default:"" bson:"max"`
+}
+
+// how far a player may move per second. Distance left unused is banked up to MOVE_TOLERANCE to absorb
+// latency jitter, so heartbeats sent close together cannot add up to more than MAX_MOVE_SPEED.
+var MAX_MOVE_SPEED = 12.0
+var MOVE_TOLERANCE = 3.0
+
+// how often buffered heartbeat positions are written to the profiles
+var POSITION_FLUSH_INTERVAL = 10 * time.Second
+
+// movement violations on one connection before the account is flagged for review
+var MOVEMENT_FLAG_THRESHOLD = 10
+
+const FLAG_MOVEMENT = "movement"
+
+// parsePosition reads heartbeat coordinates, anything that is not a finite number makes the position invalid.
+func parsePosition(x string, y string, z string) (Position, bool) {
+	var coordinates [3]float64
+	for index, coordinate := range []string{x, y, z} {
+		value, err := strconv.ParseFloat(coordinate, 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return Position{}, false
+		}
+		coordinates[index] = value
+	}
+	return Position{Position_x: coordinates[0], Position_y: coordinates[1], Position_z: coordinates[2]}, true
+}
+
+// moveBudget is how far a heartbeat may move a player: the distance walkable since the last heartbeat
+// plus what was banked from earlier ones.
+func moveBudget(banked float64, elapsed time.Duration) float64 {
+	return math.Min(banked, MOVE_TOLERANCE) + MAX_MOVE_SPEED*elapsed.Seconds()
+}
+
+// isPlausibleMove rejects heartbeat positions further away than the player's move budget.
+func isPlausibleMove(from Position, to Position, budget float64) bool {
+	return distance(from, to) <= budget
+}
+func clampToBounds(position Position, bounds *LevelBounds) (Position, bool) {
+	if bounds == nil {
+		return position, true
+	}
+	clamped := Position{
+		Position_x: math.Min(math.Max(position.Position_x, bounds.Min.Position_x), bounds.Max.Position_x),
+		Position_y: math.Min(math.Max(position.Position_y, bounds.Min.Position_y), bounds.Max.Position_y),
+		Position_z: math.Min(math.Max(position.Position_z, bounds.Min.Position_z), bounds.Max.Position_z),
+	}
+	return clamped, clamped == position
+}
+
+// checkMovement returns the position a heartbeat should be recorded at and whether the reported one was
+// valid. Moves that are too fast keep the previous position and moves out of the level are clamped into it.
+func checkMovement(from Position, to Position, budget float64, bounds *LevelBounds) (Position, bool) {
+	if !isPlausibleMove(from, to, budget) {
+		return from, false
+	}
+	return clampToBounds(to, bounds)
+}
+
+// recordHeartbeatPosition validates a reported position and buffers the accepted one on the connection.
+// It returns the recorded position, whether the report was valid and the violation count so far.
+func recordHeartbeatPosition(accountID uuid.UUID, position Position) (Position, bool, int) {
+	var bounds *LevelBounds
+	if entry, online := getPlayerConnection(accountID); online {
+		bounds = currentWorld().Levels[entry.LevelID].Bounds
+	}
+	playerConnectionsMutex.Lock()
+	defer playerConnectionsMutex.Unlock()
+	entry, found := playerConnections[accountID]
+	if !found {
+		return position, false, 0
+	}
+	recorded, valid := clampToBounds(position, bounds)
+	if !entry.LastHeartbeat.IsZero() {
+		budget := moveBudget(entry.MoveBanked, time.Since(entry.LastHeartbeat))
+		recorded, valid = checkMovement(entry.LastPosition, position, budget, bounds)
+		entry.MoveBanked = budget - distance(entry.LastPosition, recorded)
+	}
+	if !valid {
+		entry.Violations++
+	}
+	entry.PositionDirty = entry.PositionDirty || recorded != entry.LastPosition
+	entry.LastPosition = recorded
+	entry.LastHeartbeat = time.Now()
+	return recorded, valid, entry.Violations
+}
+
+// handleHeartbeatPosition buffers a heartbeat position, sends the client a correction when the report
+// was rejected and flags the account once it keeps happening.
+func handleHeartbeatPosition(accountID uuid.UUID, position Position, mongoClient *mongo.Client) {
+	recorded, valid, violations := recordHeartbeatPosition(accountID, position)
+	if entry, online := getPlayerConnection(accountID); online {
+		broadcastPosition(accountID, entry.LevelID, recorded)
+		pushSnapshot(accountID, recorded)
+	}
+	if valid {
+		return
+	}
+	fmt.Println(Warn("Movement corrected for ", accountID, " violations : ", violations))
+	correction := fmt.Sprintf("POSITION$%g;%g;%g", recorded.Position_x, recorded.Position_y, recorded.Position_z)
+	Push(accountID, createSimpleDeliveryPacket(uuid.New().String(), "HC#", "POSITION", correction))
+	if violations == MOVEMENT_FLAG_THRESHOLD {
+		flagAccount(accountID, FLAG_MOVEMENT, mongoClient)
+	}
+}
+
+// flagAccount marks an account for moderator review.
+func flagAccount(accountID uuid.UUID, flag string, mongoClient *mongo.Client) {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		fmt.Println(Failure(err))
+		return
+	}
+	users := mongoClient.Database("player").Collection("users")
+	if _, err := users.UpdateOne(cxt, bson.M{"uuid": accountID}, bson.M{"$addToSet": bson.M{"flags": flag}}); err != nil {
+		fmt.Println(Failure(err))
+		return
+	}
+	fmt.Println(Warn("Account flagged : ", accountID, " ", flag))
+}
+
+// takeDirtyPositions returns every buffered position not yet written and marks them written.
+func takeDirtyPositions() map[uuid.UUID]Position {
+	playerConnectionsMutex.Lock()
+	defer playerConnectionsMutex.Unlock()
+	dirty := make(map[uuid.UUID]Position)
+	for accountID, entry := range playerConnections {
+		if entry.PositionDirty {
+			dirty[accountID] = entry.LastPosition
+			entry.PositionDirty = false
+		}
+	}
+	return dirty
+}
+
+// flushPositions writes buffered heartbeat positions in one bulk write instead of one write per heartbeat.
+func flushPositions(mongoClient *mongo.Client) {
+	dirty := takeDirtyPositions()
+	if len(dirty) == 0 {
+		return
+	}
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		fmt.Println(Failure(err))
+		return
+	}
+	writes := make([]mongo.WriteModel, 0, len(dirty))
+	for accountID, position := range dirty {
+		update := bson.M{"$set": bson.M{"last_position": position}}
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(bson.M{"uuid": accountID}).SetUpdate(update))
+	}
+	profiles := mongoClient.Database("player").Collection("profiles")
+	if _, err := profiles.BulkWrite(cxt, writes); err != nil {
+		fmt.Println(Failure("Position flush failed : ", err))
+	}
+}
+func runPositionFlush(mongoClient *mongo.Client) {
+	ticker := time.NewTicker(POSITION_FLUSH_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		flushPositions(mongoClient)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckMovement(t *testing.T) {
+	bounds := &LevelBounds{Min: Position{Position_x: -50, Position_y: 0, Position_z: -50}, Max: Position{Position_x: 50, Position_y: 20, Position_z: 50}}
+	from := Position{Position_x: 45, Position_y: 1}
+
+	if recorded, valid := checkMovement(from, Position{Position_x: 49, Position_y: 1}, moveBudget(MOVE_TOLERANCE, time.Second), bounds); !valid || recorded.Position_x != 49 {
+		t.Errorf("expected a normal move to be accepted, got %v %v", recorded, valid)
+	}
+	if recorded, valid := checkMovement(from, Position{Position_x: 400, Position_y: 1}, moveBudget(MOVE_TOLERANCE, time.Second), bounds); valid || recorded != from {
+		t.Errorf("expected a speedhack to keep the previous position, got %v %v", recorded, valid)
+	}
+	if recorded, valid := checkMovement(from, Position{Position_x: 53, Position_y: 1}, moveBudget(MOVE_TOLERANCE, time.Second), bounds); valid || recorded.Position_x != 50 {
+		t.Errorf("expected an out of bounds move to be clamped, got %v %v", recorded, valid)
+	}
+	if _, valid := checkMovement(from, Position{Position_x: 53, Position_y: 1}, moveBudget(MOVE_TOLERANCE, time.Second), nil); !valid {
+		t.Errorf("expected levels without bounds to skip the bounds check")
+	}
+}
+
+func TestRecordHeartbeatPositionBuffersAndCountsViolations(t *testing.T) {
+	accountID := uuid.New()
+	playerConnectionsMutex.Lock()
+	playerConnections[accountID] = &PlayerConnection{Account_id: accountID}
+	playerConnectionsMutex.Unlock()
+	defer func() {
+		playerConnectionsMutex.Lock()
+		delete(playerConnections, accountID)
+		playerConnectionsMutex.Unlock()
+	}()
+
+	recordHeartbeatPosition(accountID, Position{Position_x: 1})
+	if _, valid, violations := recordHeartbeatPosition(accountID, Position{Position_x: 900}); valid || violations != 1 {
+		t.Errorf("expected the jump to be a violation, got %v %d", valid, violations)
+	}
+	dirty := takeDirtyPositions()
+	if position, found := dirty[accountID]; !found || position.Position_x != 1 {
+		t.Errorf("expected the last accepted position to be buffered, got %v %v", position, found)
+	}
+	if _, found := takeDirtyPositions()[accountID]; found {
+		t.Errorf("expected flushed positions to be marked clean")
+	}
+}
+
+func TestCloseHeartbeatsCannotOutrunTheSpeedLimit(t *testing.T) {
+	accountID := uuid.New()
+	playerConnectionsMutex.Lock()
+	playerConnections[accountID] = &PlayerConnection{Account_id: accountID}
+	playerConnectionsMutex.Unlock()
+	defer func() {
+		playerConnectionsMutex.Lock()
+		delete(playerConnections, accountID)
+		playerConnectionsMutex.Unlock()
+	}()
+
+	start := time.Now()
+	recordHeartbeatPosition(accountID, Position{})
+	var recorded Position
+	for step := 1; step <= 100; step++ {
+		// each step alone is within the old flat allowance
+		recorded, _, _ = recordHeartbeatPosition(accountID, Position{Position_x: recorded.Position_x + MOVE_TOLERANCE*0.9})
+	}
+	if limit := MAX_MOVE_SPEED*time.Since(start).Seconds() + MOVE_TOLERANCE; recorded.Position_x > limit {
+		t.Errorf("closely spaced heartbeats moved %v, more than the %v the speed limit allows", recorded.Position_x, limit)
+	}
+}
+
+func TestParsePosition(t *testing.T) {
+	if position, valid := parsePosition("1.5", "-2", "3e2"); !valid || position != (Position{Position_x: 1.5, Position_y: -2, Position_z: 300}) {
+		t.Errorf("unexpected position %v %v", position, valid)
+	}
+	for _, invalid := range [][3]string{{"NaN", "0", "0"}, {"0", "Inf", "0"}, {"0", "0", "-Infinity"}, {"0", "0", "1e400"}, {"0", "", "0"}} {
+		if _, valid := parsePosition(invalid[0], invalid[1], invalid[2]); valid {
+			t.Errorf("expected %v to be rejected", invalid)
+		}
+	}
+}
+
+func TestTrackPresenceSeedsPositionFromProfile(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	accountID := uuid.New()
+	createProfile("ana", accountID, mongoClient)
+	saved := Position{Position_x: 20, Position_y: 1, Position_z: 5}
+	updateProfileLastPosition(accountID, &saved, mongoClient)
+	server, client := net.Pipe()
+	defer client.Close()
+	outbound := newOutboundQueue(server)
+	bindPlayerConnection(accountID, outbound)
+	defer disconnectPlayers(outbound, mongoClient)
+
+	trackPresence(accountID, outbound, mongoClient)
+	if entry, _ := getPlayerConnection(accountID); entry.LastPosition != saved || entry.LastHeartbeat.IsZero() || entry.PositionDirty {
+		t.Fatalf("presence was not seeded from the profile : %+v", entry)
+	}
+	// the first heartbeat is checked against the saved position instead of being taken as is
+	if recorded, valid, _ := recordHeartbeatPosition(accountID, Position{Position_x: 900, Position_y: 1}); valid || recorded != saved {
+		t.Errorf("expected a jump away from the saved position to be rejected, got %v %v", recorded, valid)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Party struct {
+	Party_id  uuid.UUID   `json:"party_id" default:"" bson:"party_id,omitempty"`
+	Leader_id uuid.UUID   `json:"leader_id" default:"" bson:"leader_id,omitempty"`
+	Members   []uuid.UUID `json:"members" default:"" bson:"members,omitempty"`
+	Invites   []uuid.UUID `json:"invites" default:"" bson:"invites,omitempty"`
+	SplitRule string      `json:"split_rule" default:"even" bson:"split_rule,omitempty"`
+}
+
+var MAX_PARTY_SIZE = 4
+
+// how battle gold and exp are divided between party members:
+// "even" gives every participant the same share, "level" weighs shares by player level
+var PARTY_SPLIT_RULES = map[string]bool{"even": true, "level": true}
+var DEFAULT_PARTY_SPLIT_RULE = "even"
+
+var parties = make(map[uuid.UUID]*Party)
+var partyMembership = make(map[uuid.UUID]uuid.UUID)
+var partiesMutex sync.Mutex
+
+func partyResponse(party *Party) string {
+	partyJSON, _ := json.Marshal(party)
+	return "PARTY$1;" + string(partyJSON)
+}
+func partyFailure(reason string) string {
+	return "PARTY$0;" + reason
+}
+func containsAccount(accounts []uuid.UUID, accountID uuid.UUID) bool {
+	for _, member := range accounts {
+		if member == accountID {
+			return true
+		}
+	}
+	return false
+}
+func removePartyMember(members []uuid.UUID, accountID uuid.UUID) []uuid.UUID {
+	remaining := make([]uuid.UUID, 0, len(members))
+	for _, member := range members {
+		if member != accountID {
+			remaining = append(remaining, member)
+		}
+	}
+	return remaining
+}
+func getParty(accountID uuid.UUID) (Party, bool) {
+	partiesMutex.Lock()
+	defer partiesMutex.Unlock()
+	if partyID, found := partyMembership[accountID]; found {
+		party := *parties[partyID]
+		party.Members = append([]uuid.UUID(nil), party.Members...)
+		return party, true
+	}
+	return Party{}, false
+}
+
+// notifyParty pushes a membership event to every online member of the party.
+func notifyParty(party Party, event string, extraRecipients ...uuid.UUID) {
+	partyJSON, _ := json.Marshal(party)
+	recipients := append(append([]uuid.UUID(nil), party.Members...), extraRecipients...)
+	for _, member := range recipients {
+		packet := createSimpleDeliveryPacket(uuid.New().String(), "PN#", "PARTY", "PARTY$"+event+";"+string(partyJSON))
+		Push(member, packet)
+	}
+}
+func createParty(accountID uuid.UUID) string {
+	partiesMutex.Lock()
+	defer partiesMutex.Unlock()
+	if _, found := partyMembership[accountID]; found {
+		return partyFailure("Already in a party")
+	}
+	party := &Party{
+		Party_id:  uuid.New(),
+		Leader_id: accountID,
+		Members:   []uuid.UUID{accountID},
+		Invites:   []uuid.UUID{},
+		SplitRule: DEFAULT_PARTY_SPLIT_RULE,
+	}
+	parties[party.Party_id] = party
+	partyMembership[accountID] = party.Party_id
+	fmt.Println(Success("Party created : ", party.Party_id))
+	return partyResponse(party)
+}
+func inviteToParty(accountID uuid.UUID, targetID uuid.UUID) string {
+	partiesMutex.Lock()
+	partyID, found := partyMembership[accountID]
+	if !found {
+		partiesMutex.Unlock()
+		return partyFailure("Not in a party")
+	}
+	party := parties[partyID]
+	if party.Leader_id != accountID {
+		partiesMutex.Unlock()
+		return partyFailure("Only the leader can invite")
+	}
+	if _, busy := partyMembership[targetID]; busy {
+		partiesMutex.Unlock()
+		return partyFailure("Player is already in a party")
+	}
+	if len(party.Members) >= MAX_PARTY_SIZE {
+		partiesMutex.Unlock()
+		return partyFailure("Party is full")
+	}
+	party.Invites = append(removePartyMember(party.Invites, targetID), targetID)
+	snapshot := *party
+	partiesMutex.Unlock()
+	notifyParty(snapshot, "INVITE", targetID)
+	return partyResponse(&snapshot)
+}
+func acceptPartyInvite(accountID uuid.UUID, partyID uuid.UUID) string {
+	partiesMutex.Lock()
+	if _, busy := partyMembership[accountID]; busy {
+		partiesMutex.Unlock()
+		return partyFailure("Already in a party")
+	}
+	party, found := parties[partyID]
+	if !found || !containsAccount(party.Invites, accountID) {
+		partiesMutex.Unlock()
+		return partyFailure("No invite to this party")
+	}
+	if len(party.Members) >= MAX_PARTY_SIZE {
+		partiesMutex.Unlock()
+		return partyFailure("Party is full")
+	}
+	party.Invites = removePartyMember(party.Invites, accountID)
+	party.Members = append(party.Members, accountID)
+	partyMembership[accountID] = partyID
+	snapshot := *party
+	partiesMutex.Unlock()
+	notifyParty(snapshot, "JOINED")
+	return partyResponse(&snapshot)
+}
+
+// leaveParty removes a member. A leaving leader hands the party to the next member
+// and the last member to leave disbands it.
+func leaveParty(accountID uuid.UUID) string {
+	partiesMutex.Lock()
+	partyID, found := partyMembership[accountID]
+	if !found {
+		partiesMutex.Unlock()
+		return partyFailure("Not in a party")
+	}
+	party := parties[partyID]
+	party.Members = removePartyMember(party.Members, accountID)
+	delete(partyMembership, accountID)
+	event := "LEFT"
+	if len(party.Members) == 0 {
+		delete(parties, partyID)
+		event = "DISBANDED"
+	} else if party.Leader_id == accountID {
+		party.Leader_id = party.Members[0]
+	}
+	snapshot := *party
+	partiesMutex.Unlock()
+	notifyParty(snapshot, event, accountID)
+	return partyResponse(&snapshot)
+}
+func kickFromParty(accountID uuid.UUID, targetID uuid.UUID) string {
+	partiesMutex.Lock()
+	partyID, found := partyMembership[accountID]
+	if !found {
+		partiesMutex.Unlock()
+		return partyFailure("Not in a party")
+	}
+	party := parties[partyID]
+	if party.Leader_id != accountID {
+		partiesMutex.Unlock()
+		return partyFailure("Only the leader can kick")
+	}
+	if targetID == accountID || !containsAccount(party.Members, targetID) {
+		partiesMutex.Unlock()
+		return partyFailure("Player is not a member")
+	}
+	party.Members = removePartyMember(party.Members, targetID)
+	delete(partyMembership, targetID)
+	snapshot := *party
+	partiesMutex.Unlock()
+	notifyParty(snapshot, "KICKED", targetID)
+	return partyResponse(&snapshot)
+}
+func transferPartyLeader(accountID uuid.UUID, targetID uuid.UUID) string {
+	partiesMutex.Lock()
+	partyID, found := partyMembership[accountID]
+	if !found {
+		partiesMutex.Unlock()
+		return partyFailure("Not in a party")
+	}
+	party := parties[partyID]
+	if party.Leader_id != accountID {
+		partiesMutex.Unlock()
+		return partyFailure("Only the leader can transfer leadership")
+	}
+	if !containsAccount(party.Members, targetID) {
+		partiesMutex.Unlock()
+		return partyFailure("Player is not a member")
+	}
+	party.Leader_id = targetID
+	snapshot := *party
+	partiesMutex.Unlock()
+	notifyParty(snapshot, "LEADER")
+	return partyResponse(&snapshot)
+}
+func setPartySplitRule(accountID uuid.UUID, rule string) string {
+	if !PARTY_SPLIT_RULES[rule] {
+		return partyFailure("Unknown split rule")
+	}
+	partiesMutex.Lock()
+	partyID, found := partyMembership[accountID]
+	if !found {
+		partiesMutex.Unlock()
+		return partyFailure("Not in a party")
+	}
+	party := parties[partyID]
+	if party.Leader_id != accountID {
+		partiesMutex.Unlock()
+		return partyFailure("Only the leader can change the split rule")
+	}
+	party.SplitRule = rule
+	snapshot := *party
+	partiesMutex.Unlock()
+	notifyParty(snapshot, "RULE")
+	return partyResponse(&snapshot)
+}
+
+// getBattleParticipants returns the player plus every online party member standing in the same level.
+func getBattleParticipants(accountID uuid.UUID, levelID string) []uuid.UUID {
+	participants := []uuid.UUID{accountID}
+	party, found := getParty(accountID)
+	if !found {
+		return participants
+	}
+	for _, member := range party.Members {
+		if member == accountID {
+			continue
+		}
+		if entry, online := getPlayerConnection(member); online && entry.LevelID == levelID {
+			participants = append(participants, member)
+		}
+	}
+	return participants
+}
+
+// splitReward divides gold and exp between participants proportionally to their weights.
+func splitReward(gold float64, exp float64, weights map[uuid.UUID]float64) map[uuid.UUID]Reward {
+	shares := make(map[uuid.UUID]Reward)
+	totalWeight := 0.0
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+	for accountID, weight := range weights {
+		var share Reward
+		if totalWeight > 0 {
+			share.Gold = gold * weight / totalWeight
+			share.Exp = exp * weight / totalWeight
+		}
+		shares[accountID] = share
+	}
+	return shares
+}
+func getSplitWeights(participants []uuid.UUID, rule string, mongoClient *mongo.Client) map[uuid.UUID]float64 {
+	weights := make(map[uuid.UUID]float64)
+	for _, accountID := range participants {
+		weights[accountID] = 1
+		if rule == "level" {
+			if profile, _ := getProfile(accountID, mongoClient); profile != nil && profile.Level > 0 {
+				weights[accountID] = float64(profile.Level)
+			}
+		}
+	}
+	return weights
+}
+
+// notifyBattleStart sends the freshly created battle to every party member pulled into it.
+func notifyBattleStart(battle *BattleSession, battlePacket BattlePacket, initiator uuid.UUID, mongoClient *mongo.Client) {
+	for _, member := range battle.Participants {
+		if member == initiator {
+			continue
+		}
+		memberPacket := battlePacket
+		memberPacket.PlayerProfile, _ = getProfile(member, mongoClient)
+		contentJSON, _ := json.Marshal(memberPacket)
+		packet := createMultiDeliveryPacket(uuid.New().String(), "BR#", "BATTLE", contentJSON)
+		Push(member, packet)
+	}
+}
+
+// grantPartyShare rewards a party member who did not report the battle finish and tells them about it.
+// Loot and first kills are rolled against the member's own profile, the same way the reporter's are.
+func grantPartyShare(accountID uuid.UUID, share Reward, monsters []Monster, rewardMatrix []int, mongoClient *mongo.Client) []string {
+	profile, _ := getProfile(accountID, mongoClient)
+	if profile == nil {
+		return nil
+	}
+	loot, kills := rollBattleLoot(monsters, rewardMatrix, profile)
+	if _, granted := grantBattleRewards(accountID, share.Gold, share.Exp, loot, kills, mongoClient); !granted {
+		return nil
+	}
+	profile, _ = getProfile(accountID, mongoClient)
+	profileJSON, _ := json.Marshal(profile)
+	lootJSON, _ := json.Marshal(loot)
+	contentJSON := strconv.FormatFloat(share.Exp, 'f', -1, 64) + "|" + strconv.FormatFloat(share.Gold, 'f', -1, 64) + "|True|" + string(profileJSON) + "|" + string(lootJSON)
+	packet := createMultiDeliveryPacket(uuid.New().String(), "BF#", "BATTLEFINISH", []byte(contentJSON))
+	Push(accountID, packet)
+	return loot
+}
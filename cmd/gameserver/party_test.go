@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSplitRewardByWeight(t *testing.T) {
+	leader, member := uuid.New(), uuid.New()
+
+	shares := splitReward(90, 30, map[uuid.UUID]float64{leader: 2, member: 1})
+	if shares[leader].Gold != 60 || shares[member].Gold != 30 {
+		t.Errorf("unexpected gold split: %v", shares)
+	}
+	if shares[leader].Exp != 20 || shares[member].Exp != 10 {
+		t.Errorf("unexpected exp split: %v", shares)
+	}
+}
+
+func TestPartyMembershipLifecycle(t *testing.T) {
+	leader, member := uuid.New(), uuid.New()
+
+	if response := createParty(leader); !strings.HasPrefix(response, "PARTY$1;") {
+		t.Fatalf("create failed: %s", response)
+	}
+	party, _ := getParty(leader)
+	if response := acceptPartyInvite(member, party.Party_id); !strings.HasPrefix(response, "PARTY$0;") {
+		t.Errorf("accepted without an invite: %s", response)
+	}
+	inviteToParty(leader, member)
+	if response := acceptPartyInvite(member, party.Party_id); !strings.HasPrefix(response, "PARTY$1;") {
+		t.Fatalf("accept failed: %s", response)
+	}
+	if response := kickFromParty(member, leader); !strings.HasPrefix(response, "PARTY$0;") {
+		t.Errorf("non-leader was able to kick: %s", response)
+	}
+
+	leaveParty(leader)
+	party, found := getParty(member)
+	if !found || party.Leader_id != member {
+		t.Errorf("leadership was not handed over: %+v", party)
+	}
+	leaveParty(member)
+	if _, found := parties[party.Party_id]; found {
+		t.Errorf("empty party was not disbanded")
+	}
+}
+
+func TestDisconnectLeavesTheParty(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	leader, leaderConnection, _ := connectPlayer(t, "ana", mongoClient)
+	defer disconnectPlayers(leaderConnection, mongoClient)
+	member, memberConnection, _ := connectPlayer(t, "bo", mongoClient)
+	defer leaveParty(leader)
+
+	createParty(leader)
+	inviteToParty(leader, member)
+	party, _ := getParty(leader)
+	acceptPartyInvite(member, party.Party_id)
+	disconnectPlayers(memberConnection, mongoClient)
+
+	if _, found := getParty(member); found {
+		t.Errorf("the disconnected player is still in a party")
+	}
+	if party, _ := getParty(leader); containsAccount(party.Members, member) {
+		t.Errorf("the disconnected player is still a member : %+v", party)
+	}
+	if participants := getBattleParticipants(leader, ""); len(participants) != 1 {
+		t.Errorf("the disconnected player would share rewards : %v", participants)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type Presence struct {
+	Account_id uuid.UUID `json:"uuid" default:""`
+	Name       string    `json:"name" default:""`
+	Status     string    `json:"status" default:"offline"`
+	LevelID    string    `json:"level_id" default:""`
+	LastSeen   time.Time `json:"last_seen" default:""`
+}
+
+const (
+	PRESENCE_ONLINE    = "online"
+	PRESENCE_OFFLINE   = "offline"
+	PRESENCE_IN_BATTLE = "in-battle"
+)
+
+// trackPresence marks the account online the first time it is seen on the connection it logged in on.
+// Account ids that did not log in on this connection are ignored, and it reports whether the id was bound.
+func trackPresence(accountID uuid.UUID, clientConnection net.Conn, mongoClient *mongo.Client) bool {
+	bound, first := markPlayerOnline(accountID, clientConnection)
+	if !bound {
+		fmt.Println(Warn("Ignoring presence of ", accountID, ", it did not log in on this connection"))
+		return false
+	}
+	if first {
+		// movement checks start from where the player logged out, not from their first heartbeat
+		if profile, _ := getProfile(accountID, mongoClient); profile != nil {
+			setPlayerPosition(accountID, profile.LastPosition)
+		}
+		saveUserPresence(accountID, 1, mongoClient)
+		notifyFriendsOfPresence(accountID, mongoClient)
+	}
+	return true
+}
+
+// rejectUnboundPacket answers a packet whose account id did not log in on this connection. The
+// answer is not cached, the account it names belongs to another connection.
+func rejectUnboundPacket(requestIDSTR string, packetCode string, serviceType string, clientConnection net.Conn) {
+	packet := createSimpleDeliveryPacket(requestIDSTR, packetCode, serviceType, serviceType+"$0;Not logged in on this connection")
+	writeResponse(uuid.Nil, requestIDSTR, packet, clientConnection, true)
+}
+func setPresenceStatus(accountID uuid.UUID, status string, mongoClient *mongo.Client) {
+	if setPlayerStatus(accountID, status) {
+		notifyFriendsOfPresence(accountID, mongoClient)
+	}
+}
+func setPresenceLevel(accountID uuid.UUID, levelID string, mongoClient *mongo.Client) {
+	if entry, online := getPlayerConnection(accountID); online && entry.LevelID != levelID {
+		setPlayerLevel(accountID, levelID)
+		notifyFriendsOfPresence(accountID, mongoClient)
+	}
+}
+
+// disconnectPlayers runs when a connection closes and reliably takes every account on it offline.
+func disconnectPlayers(clientConnection net.Conn, mongoClient *mongo.Client) {
+	for _, entry := range dropPlayerConnection(clientConnection) {
+		accountID := entry.Account_id
+		fmt.Println(Info("Player went offline : ", accountID))
+		if entry.PositionDirty {
+			updateProfileLastPosition(accountID, &entry.LastPosition, mongoClient)
+		}
+		cancelTrade(accountID, "disconnect", mongoClient)
+		leaveParty(accountID)
+		dropInterest(accountID)
+		dropSnapshotStream(accountID)
+		dropRetransmitBuffer(accountID)
+		saveUserPresence(accountID, 0, mongoClient)
+		notifyFriendsOfPresence(accountID, mongoClient)
+	}
+}
+func getPresence(accountID uuid.UUID, user *User) Presence {
+	presence := Presence{Account_id: accountID, Status: PRESENCE_OFFLINE}
+	if user != nil {
+		presence.Name = user.User_id
+		presence.LastSeen = user.LastSeen
+	}
+	if entry, online := getPlayerConnection(accountID); online {
+		presence.Status = entry.Status
+		presence.LevelID = entry.LevelID
+		presence.LastSeen = time.Now().UTC()
+	}
+	return presence
+}
+func saveUserPresence(accountID uuid.UUID, active int, mongoClient *mongo.Client) bool {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		fmt.Println(Failure(err))
+		return false
+	}
+	database := mongoClient.Database("player")
+	users := database.Collection("users")
+	match := bson.M{"uuid": accountID}
+	change := bson.M{"$set": bson.D{{Key: "active", Value: active}, {Key: "last_seen", Value: time.Now().UTC()}}}
+	_, err := users.UpdateOne(cxt, match, change)
+	if err != nil {
+		fmt.Println(Failure(err))
+		return false
+	}
+	return true
+}
+func notifyFriendsOfPresence(accountID uuid.UUID, mongoClient *mongo.Client) {
+	friendList := getFriendList(accountID, mongoClient)
+	if len(friendList.Friends) == 0 {
+		return
+	}
+	users := getUsersByAccount([]uuid.UUID{accountID}, mongoClient)
+	presenceJSON, _ := json.Marshal(getPresence(accountID, users[accountID]))
+	for _, friendID := range friendList.Friends {
+		packet := createSimpleDeliveryPacket(uuid.New().String(), "FP#", "PRESENCE", string(presenceJSON))
+		Push(friendID, packet)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// pushRecorder keeps everything written to the client end of a pipe so tests can wait for pushes.
+type pushRecorder struct {
+	mutex    sync.Mutex
+	received strings.Builder
+}
+
+func recordPushes(clientConnection net.Conn) *pushRecorder {
+	recorder := &pushRecorder{}
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			read, err := clientConnection.Read(buffer)
+			recorder.mutex.Lock()
+			recorder.received.Write(buffer[:read])
+			recorder.mutex.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return recorder
+}
+func (recorder *pushRecorder) waitFor(t *testing.T, fragment string) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		recorder.mutex.Lock()
+		received := recorder.received.String()
+		recorder.mutex.Unlock()
+		if strings.Contains(received, fragment) {
+			return
+		}
+	}
+	t.Fatalf("never received %q", fragment)
+}
+
+// connectPlayer logs a stored account in on its own pipe, as a successful L0# does.
+func connectPlayer(t *testing.T, name string, mongoClient *mongo.Client) (uuid.UUID, net.Conn, *pushRecorder) {
+	accountID := uuid.New()
+	if _, err := mongoClient.Database("player").Collection("users").InsertOne(context.Background(), User{ObjectID: primitive.NewObjectID(), Account_id: accountID, User_id: name}); err != nil {
+		t.Fatal(err)
+	}
+	server, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	outbound := newOutboundQueue(server)
+	bindPlayerConnection(accountID, outbound)
+	if !trackPresence(accountID, outbound, mongoClient) {
+		t.Fatalf("%s was not bound to its connection", name)
+	}
+	return accountID, outbound, recordPushes(client)
+}
+
+func TestTrackPresenceIgnoresUnboundAccounts(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	accountID, outbound, _ := connectPlayer(t, "ana", mongoClient)
+	defer disconnectPlayers(outbound, mongoClient)
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// another connection claiming the id must neither steal nor refresh the session
+	if trackPresence(accountID, server, mongoClient) {
+		t.Errorf("presence was tracked on a connection the account did not log in on")
+	}
+	if entry, online := getPlayerConnection(accountID); !online || entry.Connection != outbound {
+		t.Errorf("the session moved to another connection")
+	}
+	if trackPresence(uuid.New(), outbound, mongoClient) {
+		t.Errorf("an account that never logged in was tracked")
+	}
+}
+
+func TestDisconnectPlayersTakesAccountsOffline(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	accountID, outbound, _ := connectPlayer(t, "ana", mongoClient)
+	friendID, friendConnection, friendPushes := connectPlayer(t, "bo", mongoClient)
+	defer disconnectPlayers(friendConnection, mongoClient)
+	createProfile("ana", accountID, mongoClient)
+	updateFriendList(accountID, bson.M{"$addToSet": bson.M{"friends": friendID}}, mongoClient)
+
+	user, _ := getUser("ana", mongoClient)
+	if user.Active != 1 {
+		t.Errorf("login did not mark the user active")
+	}
+	position := Position{Position_x: 4, Position_y: 1, Position_z: -2}
+	recordHeartbeatPosition(accountID, position)
+	disconnectPlayers(outbound, mongoClient)
+
+	if _, online := getPlayerConnection(accountID); online {
+		t.Errorf("the session survived the disconnect")
+	}
+	if _, online := getPlayerConnection(friendID); !online {
+		t.Errorf("the disconnect dropped a session on another connection")
+	}
+	if user, _ := getUser("ana", mongoClient); user.Active != 0 {
+		t.Errorf("the user is still active after disconnecting")
+	}
+	if profile, _ := getProfile(accountID, mongoClient); profile.LastPosition != position {
+		t.Errorf("the dirty position was not flushed : %+v", profile.LastPosition)
+	}
+	friendPushes.waitFor(t, "offline")
+}
+
+func TestFriendRequestAcceptAndRemove(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	accountID, outbound, pushes := connectPlayer(t, "ana", mongoClient)
+	defer disconnectPlayers(outbound, mongoClient)
+	friendID, friendConnection, friendPushes := connectPlayer(t, "bo", mongoClient)
+	defer disconnectPlayers(friendConnection, mongoClient)
+
+	if response := sendFriendRequest(accountID, accountID, mongoClient); response != "FRIENDS$0;Cannot befriend yourself" {
+		t.Errorf("unexpected response %q", response)
+	}
+	if response := sendFriendRequest(accountID, friendID, mongoClient); response != "FRIENDS$1" {
+		t.Fatalf("unexpected response %q", response)
+	}
+	friendPushes.waitFor(t, "FRIENDS$REQUEST")
+	if friendList := getFriendList(friendID, mongoClient); !containsAccount(friendList.Incoming, accountID) {
+		t.Errorf("the request did not reach the target : %+v", friendList)
+	}
+	if response := acceptFriendRequest(accountID, friendID, mongoClient); response != "FRIENDS$0;No request from this player" {
+		t.Errorf("the sender accepted their own request : %q", response)
+	}
+
+	if response := acceptFriendRequest(friendID, accountID, mongoClient); response != "FRIENDS$1" {
+		t.Fatalf("unexpected response %q", response)
+	}
+	pushes.waitFor(t, "FRIENDS$ACCEPTED")
+	friendList, otherList := getFriendList(accountID, mongoClient), getFriendList(friendID, mongoClient)
+	if !containsAccount(friendList.Friends, friendID) || len(friendList.Outgoing) != 0 ||
+		!containsAccount(otherList.Friends, accountID) || len(otherList.Incoming) != 0 {
+		t.Errorf("unexpected lists after accepting : %+v %+v", friendList, otherList)
+	}
+
+	if response := removeFriend(accountID, friendID, mongoClient); response != "FRIENDS$1" {
+		t.Fatalf("unexpected response %q", response)
+	}
+	friendPushes.waitFor(t, "FRIENDS$REMOVED")
+	if friendList, otherList := getFriendList(accountID, mongoClient), getFriendList(friendID, mongoClient); len(friendList.Friends) != 0 || len(otherList.Friends) != 0 {
+		t.Errorf("unexpected lists after removing : %+v %+v", friendList, otherList)
+	}
+}
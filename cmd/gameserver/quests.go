@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type Quest struct {
+	ObjectID      primitive.ObjectID `json:"objectID" bson:"_id, omitempty"`
+	Quest_id      string             `json:"quest_id" default:"" bson:"quest_id"`
+	Name          string             `json:"name" default:"" bson:"name"`
+	Description   string             `json:"description" default:"" bson:"description"`
+	Prerequisites QuestPrerequisites `json:"prerequisites" default:"" bson:"prerequisites"`
+	Objectives    []QuestObjective   `json:"objectives" default:"" bson:"objectives"`
+	Rewards       QuestReward        `json:"rewards" default:"" bson:"rewards"`
+}
+type QuestPrerequisites struct {
+	Level    int      `json:"level" default:"0" bson:"level"`
+	Class_id string   `json:"class_id" default:"" bson:"class_id"`
+	Quests   []string `json:"quests" default:"" bson:"quests"`
+}
+
+// Type is one of "kill" (mob Target), "collect" (item Target), "talk" (npc Target) or "reach_level".
+// Collected items flagged with Consume are taken from the inventory when the quest is turned in.
+type QuestObjective struct {
+	Type     string `json:"type" default:"" bson:"type"`
+	Target   string `json:"target" default:"" bson:"target"`
+	Quantity int    `json:"quantity" default:"1" bson:"quantity"`
+	Consume  bool   `json:"consume" default:"false" bson:"consume"`
+}
+type QuestReward struct {
+	Bits   float64  `json:"bits" default:"0" bson:"bits"`
+	Exp    float64  `json:"exp" default:"0" bson:"exp"`
+	Items  []string `json:"items" default:"" bson:"items"`
+	Spells []string `json:"spells" default:"" bson:"spells"`
+	Title  string   `json:"title" default:"" bson:"title"`
+}
+type QuestProgress struct {
+	Quest_id    string    `json:"quest_id" default:"" bson:"quest_id"`
+	Status      string    `json:"status" default:"" bson:"status"`
+	Progress    []int     `json:"progress" default:"" bson:"progress"`
+	Accepted_at time.Time `json:"accepted_at" default:"" bson:"accepted_at"`
+}
+type QuestEvent struct {
+	Type     string `json:"type" default:""`
+	Target   string `json:"target" default:""`
+	Quantity int    `json:"quantity" default:"1"`
+}
+type QuestListPacket struct {
+	Active    []QuestLogEntry `json:"active" default:""`
+	Available []Quest         `json:"available" default:""`
+}
+type QuestLogEntry struct {
+	Quest    Quest         `json:"quest" default:""`
+	Progress QuestProgress `json:"progress" default:""`
+}
+
+const (
+	QUEST_ACTIVE    = "active"
+	QUEST_COMPLETED = "completed"
+	QUEST_TURNED_IN = "turned_in"
+)
+
+func findQuestProgress(profile *Profile, questID string) (int, bool) {
+	for index, progress := range profile.Quests {
+		if progress.Quest_id == questID {
+			return index, true
+		}
+	}
+	return -1, false
+}
+
+// getQuestState reports the state of a quest for a player, "" when it was never taken.
+func getQuestState(profile *Profile, questID string) string {
+	if index, found := findQuestProgress(profile, questID); found {
+		return profile.Quests[index].Status
+	}
+	return ""
+}
+func meetsQuestPrerequisites(quest Quest, profile *Profile) bool {
+	if profile.Level < quest.Prerequisites.Level {
+		return false
+	}
+	if quest.Prerequisites.Class_id != "" && quest.Prerequisites.Class_id != profile.Class_id {
+		return false
+	}
+	for _, questID := range quest.Prerequisites.Quests {
+		if getQuestState(profile, questID) != QUEST_TURNED_IN {
+			return false
+		}
+	}
+	return true
+}
+
+// measureObjective returns the progress an objective has right now for state based objectives
+// (items held, player level) and -1 for counted ones.
+func measureObjective(objective QuestObjective, profile *Profile) int {
+	switch objective.Type {
+	case "collect":
+		return countItem(profile.Items.Collection, objective.Target)
+	case "reach_level":
+		return profile.Level
+	}
+	return -1
+}
+
+// applyQuestEvent advances every active quest matching the event and reports whether anything moved.
+func applyQuestEvent(profile *Profile, event QuestEvent) bool {
+	changed := false
+	quests := currentWorld().Quests
+	for questIndex := range profile.Quests {
+		progress := &profile.Quests[questIndex]
+		quest, found := quests[progress.Quest_id]
+		if !found || progress.Status == QUEST_TURNED_IN {
+			continue
+		}
+		for objectiveIndex, objective := range quest.Objectives {
+			if objectiveIndex >= len(progress.Progress) {
+				progress.Progress = append(progress.Progress, 0)
+			}
+			updated := progress.Progress[objectiveIndex]
+			if measured := measureObjective(objective, profile); measured >= 0 {
+				if objective.Type == event.Type {
+					updated = measured
+				}
+			} else if objective.Type == event.Type && objective.Target == event.Target {
+				updated += event.Quantity
+			}
+			if updated > objective.Quantity {
+				updated = objective.Quantity
+			}
+			if updated != progress.Progress[objectiveIndex] {
+				progress.Progress[objectiveIndex] = updated
+				changed = true
+			}
+		}
+		status := QUEST_COMPLETED
+		for objectiveIndex, objective := range quest.Objectives {
+			if progress.Progress[objectiveIndex] < objective.Quantity {
+				status = QUEST_ACTIVE
+			}
+		}
+		if progress.Status != status {
+			progress.Status = status
+			changed = true
+		}
+	}
+	return changed
+}
+
+// copyQuestProgress copies a quest log so it can be matched on after applyQuestEvent changed the original.
+func copyQuestProgress(quests []QuestProgress) []QuestProgress {
+	if quests == nil {
+		return nil
+	}
+	copied := make([]QuestProgress, len(quests))
+	for index, progress := range quests {
+		progress.Progress = append([]int(nil), progress.Progress...)
+		copied[index] = progress
+	}
+	return copied
+}
+
+// saveQuestProgress replaces the quest log only while it still holds what was read, and fails with
+// errDocumentChanged otherwise so the caller re-reads the profile instead of overwriting newer progress.
+func saveQuestProgress(accountID uuid.UUID, read []QuestProgress, quests []QuestProgress, mongoClient *mongo.Client) error {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	database := mongoClient.Database("player")
+	profiles := database.Collection("profiles")
+	match := bson.M{"uuid": accountID, "quests": read}
+	change := bson.M{"$set": bson.M{"quests": quests}}
+	updateResponse, err := profiles.UpdateOne(cxt, match, change)
+	if err != nil {
+		fmt.Println(Failure(err))
+		return err
+	}
+	if updateResponse.MatchedCount != 1 {
+		return errDocumentChanged
+	}
+	return nil
+}
+
+// emitQuestEvent is called by battles, inventory changes and NPC interactions to advance quest objectives.
+func emitQuestEvent(accountID uuid.UUID, event QuestEvent, mongoClient *mongo.Client) {
+	for attempt := 0; attempt < REWARD_RETRY_LIMIT; attempt++ {
+		profile, _ := getProfile(accountID, mongoClient)
+		if profile == nil || len(profile.Quests) == 0 {
+			return
+		}
+		read := copyQuestProgress(profile.Quests)
+		if !applyQuestEvent(profile, event) {
+			return
+		}
+		err := saveQuestProgress(accountID, read, profile.Quests, mongoClient)
+		if err == errDocumentChanged {
+			fmt.Println(Warn("Quest log changed while applying ", event.Type, ", retrying..."))
+			continue
+		}
+		if err != nil {
+			return
+		}
+		progressJSON, _ := json.Marshal(profile.Quests)
+		packet := createSimpleDeliveryPacket(uuid.New().String(), "QP#", "QUEST", string(progressJSON))
+		Push(accountID, packet)
+		return
+	}
+	fmt.Println(Failure("Quest event ", event.Type, " dropped for ", accountID, ", the quest log kept changing"))
+}
+func questFailure(reason string) string {
+	return "QUEST$0;" + reason
+}
+func acceptQuest(accountID uuid.UUID, questID string, mongoClient *mongo.Client) string {
+	quest, found := currentWorld().Quests[questID]
+	if !found {
+		return questFailure("Quest does not exist")
+	}
+	for attempt := 0; attempt < REWARD_RETRY_LIMIT; attempt++ {
+		profile, _ := getProfile(accountID, mongoClient)
+		if profile == nil {
+			return questFailure("Profile does not exist")
+		}
+		if getQuestState(profile, questID) != "" {
+			return questFailure("Quest already taken")
+		}
+		if !meetsQuestPrerequisites(quest, profile) {
+			return questFailure("Prerequisites not met")
+		}
+		read := copyQuestProgress(profile.Quests)
+		profile.Quests = append(profile.Quests, QuestProgress{
+			Quest_id:    questID,
+			Status:      QUEST_ACTIVE,
+			Progress:    make([]int, len(quest.Objectives)),
+			Accepted_at: time.Now().UTC(),
+		})
+		// objectives that are already satisfied (items held, level reached) count straight away
+		applyQuestEvent(profile, QuestEvent{Type: "collect"})
+		applyQuestEvent(profile, QuestEvent{Type: "reach_level"})
+		err := saveQuestProgress(accountID, read, profile.Quests, mongoClient)
+		if err == errDocumentChanged {
+			fmt.Println(Warn("Quest log changed while accepting quest, retrying..."))
+			continue
+		}
+		if err != nil {
+			return questFailure("Could not accept quest")
+		}
+		index, _ := findQuestProgress(profile, questID)
+		progressJSON, _ := json.Marshal(profile.Quests[index])
+		return "QUEST$1;" + string(progressJSON)
+	}
+	return questFailure("Could not accept quest")
+}
+func abandonQuest(accountID uuid.UUID, questID string, mongoClient *mongo.Client) string {
+	for attempt := 0; attempt < REWARD_RETRY_LIMIT; attempt++ {
+		profile, _ := getProfile(accountID, mongoClient)
+		if profile == nil {
+			return questFailure("Profile does not exist")
+		}
+		index, found := findQuestProgress(profile, questID)
+		if !found || profile.Quests[index].Status == QUEST_TURNED_IN {
+			return questFailure("Quest is not in progress")
+		}
+		read := copyQuestProgress(profile.Quests)
+		profile.Quests = append(profile.Quests[:index], profile.Quests[index+1:]...)
+		err := saveQuestProgress(accountID, read, profile.Quests, mongoClient)
+		if err == errDocumentChanged {
+			fmt.Println(Warn("Quest log changed while abandoning quest, retrying..."))
+			continue
+		}
+		if err != nil {
+			return questFailure("Could not abandon quest")
+		}
+		return "QUEST$1"
+	}
+	return questFailure("Could not abandon quest")
+}
+func listQuests(accountID uuid.UUID, mongoClient *mongo.Client) string {
+	profile, _ := getProfile(accountID, mongoClient)
+	if profile == nil {
+		return questFailure("Profile does not exist")
+	}
+	questList := QuestListPacket{Active: []QuestLogEntry{}, Available: []Quest{}}
+	quests := currentWorld().Quests
+	for _, progress := range profile.Quests {
+		if progress.Status != QUEST_TURNED_IN {
+			questList.Active = append(questList.Active, QuestLogEntry{Quest: quests[progress.Quest_id], Progress: progress})
+		}
+	}
+	for questID, quest := range quests {
+		if getQuestState(profile, questID) == "" && meetsQuestPrerequisites(quest, profile) {
+			questList.Available = append(questList.Available, quest)
+		}
+	}
+	questListJSON, _ := json.Marshal(questList)
+	return "QUEST$1;" + string(questListJSON)
+}
+
+// turnInQuest hands out every reward in one update. The update only matches while the quest is still
+// completed and the inventory and exp are unchanged, so rewards can never be claimed twice.
+func turnInQuest(accountID uuid.UUID, questID string, mongoClient *mongo.Client) string {
+	quest, found := currentWorld().Quests[questID]
+	if !found {
+		return questFailure("Quest does not exist")
+	}
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	database := mongoClient.Database("player")
+	profiles := database.Collection("profiles")
+	for attempt := 0; attempt < REWARD_RETRY_LIMIT; attempt++ {
+		profile, _ := getProfile(accountID, mongoClient)
+		if profile == nil {
+			return questFailure("Profile does not exist")
+		}
+		if getQuestState(profile, questID) != QUEST_COMPLETED {
+			return questFailure("Quest is not complete")
+		}
+		consumed := make(map[string]int)
+		for _, objective := range quest.Objectives {
+			if objective.Type == "collect" && objective.Consume {
+				consumed[objective.Target] += objective.Quantity
+			}
+		}
+		collection, removed := removeItemsFromCollection(profile.Items.Collection, consumed)
+		if !removed {
+			return questFailure("Quest items are missing")
+		}
+		collection = append(collection, quest.Rewards.Items...)
+		progress, newTotalEXP := calculateEXPProgress(profile, quest.Rewards.Exp)
+		progress = append(progress, bson.E{Key: "items.collection", Value: collection}, bson.E{Key: "quests.$.status", Value: QUEST_TURNED_IN})
+		if quest.Rewards.Title != "" {
+			progress = append(progress, bson.E{Key: "title", Value: quest.Rewards.Title})
+		}
+		change := bson.M{"$set": progress, "$inc": bson.M{"purse.bits": quest.Rewards.Bits}}
+		rewards := quest.Rewards
+		rewards.Spells = learnableRewardSpells(quest.Rewards.Spells, profile)
+		if len(rewards.Spells) > 0 {
+			change["$addToSet"] = bson.M{"spell_index": bson.M{"$each": rewards.Spells}}
+		}
+		match := bson.M{
+			"uuid":             accountID,
+			"total_exp":        profile.Total_EXP,
+			"items.collection": profile.Items.Collection,
+			"quests":           bson.M{"$elemMatch": bson.M{"quest_id": questID, "status": QUEST_COMPLETED}},
+		}
+		updateResponse, err := profiles.UpdateOne(cxt, match, change)
+		if err != nil {
+			fmt.Println(Failure(err))
+			return questFailure("Could not turn in quest")
+		}
+		if updateResponse.MatchedCount == 1 {
+			fmt.Println(Success("Quest turned in : ", questID))
+			rewardJSON, _ := json.Marshal(rewards)
+			emitQuestEvent(accountID, QuestEvent{Type: "reach_level"}, mongoClient)
+			emitQuestEvent(accountID, QuestEvent{Type: "collect"}, mongoClient)
+			return "QUEST$1;" + strconv.FormatFloat(newTotalEXP, 'f', -1, 64) + ";" + string(rewardJSON)
+		}
+		fmt.Println(Warn("Profile changed while turning in quest, retrying..."))
+	}
+	return questFailure("Could not turn in quest")
+}
+
+// learnableRewardSpells keeps the reward spells the player could learn at a trainer, so quests cannot
+// skip level, class or prerequisite requirements. Spells are checked in order, so a reward may hold a
+// spell together with one that builds on it.
+func learnableRewardSpells(spellIDs []string, profile *Profile) []string {
+	learner := *profile
+	learner.SpellIndex = append([]string(nil), profile.SpellIndex...)
+	var learnable []string
+	for _, spellID := range spellIDs {
+		spell, found := currentWorld().Spell(spellID)
+		if !found {
+			fmt.Println(Warn("Quest reward spell ", spellID, " does not exist"))
+			continue
+		}
+		if allowed, reason := canLearnSpell(*spell, &learner); !allowed {
+			fmt.Println(Warn("Quest reward spell ", spellID, " skipped : ", reason))
+			continue
+		}
+		learner.SpellIndex = append(learner.SpellIndex, spell.Spell_id)
+		learnable = append(learnable, spell.Spell_id)
+	}
+	return learnable
+}
+
+// removeItemsFromCollection takes the given quantities of items out of an inventory collection.
+// It reports false, leaving the collection untouched, when any item is short.
+func removeItemsFromCollection(collection []string, quantities map[string]int) ([]string, bool) {
+	remaining := make(map[string]int)
+	for itemID, quantity := range quantities {
+		if countItem(collection, itemID) < quantity {
+			return collection, false
+		}
+		remaining[itemID] = quantity
+	}
+	updated := make([]string, 0, len(collection))
+	for _, itemID := range collection {
+		if remaining[itemID] > 0 {
+			remaining[itemID]--
+			continue
+		}
+		updated = append(updated, itemID)
+	}
+	return updated, true
+}
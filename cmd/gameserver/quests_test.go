@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestApplyQuestEventTracksObjectives(t *testing.T) {
+	world := newWorldData()
+	world.Quests["WolfProblem"] = Quest{Quest_id: "WolfProblem", Objectives: []QuestObjective{
+		{Type: "kill", Target: "Wolf", Quantity: 3},
+		{Type: "collect", Target: "Fang", Quantity: 2},
+	}}
+	defer setWorldData(currentWorld())
+	setWorldData(world)
+	profile := &Profile{Quests: []QuestProgress{{Quest_id: "WolfProblem", Status: QUEST_ACTIVE, Progress: []int{0, 0}}}}
+
+	applyQuestEvent(profile, QuestEvent{Type: "kill", Target: "Slime", Quantity: 1})
+	applyQuestEvent(profile, QuestEvent{Type: "kill", Target: "Wolf", Quantity: 5})
+	if !reflect.DeepEqual(profile.Quests[0].Progress, []int{3, 0}) || profile.Quests[0].Status != QUEST_ACTIVE {
+		t.Fatalf("unexpected progress after kills: %+v", profile.Quests[0])
+	}
+
+	profile.Items.Collection = []string{"Fang", "Fang"}
+	applyQuestEvent(profile, QuestEvent{Type: "collect", Target: "Fang"})
+	if profile.Quests[0].Status != QUEST_COMPLETED {
+		t.Errorf("expected quest to be completed, got %+v", profile.Quests[0])
+	}
+
+	profile.Items.Collection = []string{"Fang"}
+	applyQuestEvent(profile, QuestEvent{Type: "collect", Target: "Fang"})
+	if profile.Quests[0].Status != QUEST_ACTIVE {
+		t.Errorf("dropping a collected item should reopen the quest, got %+v", profile.Quests[0])
+	}
+}
+
+func TestMeetsQuestPrerequisites(t *testing.T) {
+	quest := Quest{Prerequisites: QuestPrerequisites{Level: 5, Quests: []string{"Intro"}}}
+	profile := &Profile{Level: 5, Quests: []QuestProgress{{Quest_id: "Intro", Status: QUEST_COMPLETED}}}
+
+	if meetsQuestPrerequisites(quest, profile) {
+		t.Errorf("prerequisite quest must be turned in first")
+	}
+	profile.Quests[0].Status = QUEST_TURNED_IN
+	if !meetsQuestPrerequisites(quest, profile) {
+		t.Errorf("expected prerequisites to be met")
+	}
+}
+
+func TestRemoveItemsFromCollection(t *testing.T) {
+	collection := []string{"Fang", "Herb", "Fang", "Fang"}
+
+	updated, removed := removeItemsFromCollection(collection, map[string]int{"Fang": 2})
+	if !removed || !reflect.DeepEqual(updated, []string{"Herb", "Fang"}) {
+		t.Errorf("unexpected collection %v", updated)
+	}
+	if _, removed := removeItemsFromCollection(collection, map[string]int{"Herb": 2}); removed {
+		t.Errorf("expected removal of missing items to fail")
+	}
+}
+
+func TestQuestProgressIsNotOverwrittenByStaleReads(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	accountID := uuid.New()
+	createProfile("ana", accountID, mongoClient)
+	world := *currentWorld()
+	world.Quests = map[string]Quest{"WolfProblem": {Quest_id: "WolfProblem", Objectives: []QuestObjective{{Type: "kill", Target: "Wolf", Quantity: 5}}}}
+	setWorldData(&world)
+	if response := acceptQuest(accountID, "WolfProblem", mongoClient); !strings.HasPrefix(response, "QUEST$1;") {
+		t.Fatalf("unexpected response %q", response)
+	}
+
+	stale, _ := getProfile(accountID, mongoClient)
+	emitQuestEvent(accountID, QuestEvent{Type: "kill", Target: "Wolf", Quantity: 2}, mongoClient)
+	read := copyQuestProgress(stale.Quests)
+	applyQuestEvent(stale, QuestEvent{Type: "kill", Target: "Wolf", Quantity: 1})
+	if err := saveQuestProgress(accountID, read, stale.Quests, mongoClient); err != errDocumentChanged {
+		t.Errorf("a stale quest log was saved over newer progress : %v", err)
+	}
+	emitQuestEvent(accountID, QuestEvent{Type: "kill", Target: "Wolf", Quantity: 1}, mongoClient)
+	if profile, _ := getProfile(accountID, mongoClient); !reflect.DeepEqual(profile.Quests[0].Progress, []int{3}) {
+		t.Errorf("unexpected progress %+v", profile.Quests[0])
+	}
+}
+
+func TestQuestRewardSpellsMustBeLearnable(t *testing.T) {
+	world := newWorldData()
+	world.Spells["Fireball"] = Spell{Spell_id: "Fireball"}
+	world.Spells["Inferno"] = Spell{Spell_id: "Inferno", Prerequisites: []string{"Fireball"}}
+	world.Spells["Meteor"] = Spell{Spell_id: "Meteor", Level: 30}
+	world.Spells["Heal"] = Spell{Spell_id: "Heal", Classes: []string{"cleric"}}
+	defer setWorldData(currentWorld())
+	setWorldData(world)
+	profile := &Profile{Level: 3, Class_id: "wizard"}
+
+	rewarded := learnableRewardSpells([]string{"Fireball", "Inferno", "Meteor", "Heal", "Missing"}, profile)
+	if !reflect.DeepEqual(rewarded, []string{"Fireball", "Inferno"}) {
+		t.Errorf("unexpected reward spells %v", rewarded)
+	}
+	if len(profile.SpellIndex) != 0 {
+		t.Errorf("checking rewards changed the profile %v", profile.SpellIndex)
+	}
+	profile.SpellIndex = []string{"Fireball"}
+	if rewarded := learnableRewardSpells([]string{"Fireball"}, profile); len(rewarded) != 0 {
+		t.Errorf("a known spell was rewarded again %v", rewarded)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type tokenBucket struct {
+	Tokens     float64
+	LastRefill time.Time
+}
+
+// RateLimiter is a keyed token bucket: every key may spend Capacity actions per Window,
+// with tokens refilling continuously. A bucket left alone for a Window is full again, so it is
+// dropped on the next sweep rather than kept for every address that ever connected.
+type RateLimiter struct {
+	Capacity  float64
+	Window    time.Duration
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	mutex     sync.Mutex
+}
+
+func newRateLimiter(capacity int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Capacity:  float64(capacity),
+		Window:    window,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+func (limiter *RateLimiter) Allow(key string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(limiter.lastSweep) >= limiter.Window {
+		limiter.sweep(now)
+	}
+	bucket, found := limiter.buckets[key]
+	if !found {
+		bucket = &tokenBucket{Tokens: limiter.Capacity, LastRefill: now}
+		limiter.buckets[key] = bucket
+	}
+	elapsed := now.Sub(bucket.LastRefill)
+	bucket.Tokens += limiter.Capacity * float64(elapsed) / float64(limiter.Window)
+	if bucket.Tokens > limiter.Capacity {
+		bucket.Tokens = limiter.Capacity
+	}
+	bucket.LastRefill = now
+	if bucket.Tokens < 1 {
+		return false
+	}
+	bucket.Tokens--
+	return true
+}
+
+// sweep drops the buckets that have refilled completely, the caller holds the mutex.
+func (limiter *RateLimiter) sweep(now time.Time) {
+	for key, bucket := range limiter.buckets {
+		if now.Sub(bucket.LastRefill) >= limiter.Window {
+			delete(limiter.buckets, key)
+		}
+	}
+	limiter.lastSweep = now
+}
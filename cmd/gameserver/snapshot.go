@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// positions travel as integer multiples of SNAPSHOT_PRECISION world units
+var SNAPSHOT_PRECISION = 0.01
+
+// how many unacknowledged snapshots a stream keeps to delta against
+var SNAPSHOT_HISTORY = 32
+
+// every online player has a stream, a snapshot is pushed to them after each of their heartbeats
+var snapshotStreams = make(map[uuid.UUID]*SnapshotStream)
+var snapshotStreamsMutex sync.Mutex
+
+var errSnapshotTruncated = errors.New("snapshot is truncated")
+var errSnapshotBaseline = errors.New("snapshot baseline is not available")
+
+type QuantizedPosition struct {
+	X int32
+	Y int32
+	Z int32
+}
+
+// Snapshot is the state of every entity a client can see at one tick, keyed by network id.
+type Snapshot struct {
+	Sequence uint32
+	Entities map[uint32]QuantizedPosition
+}
+
+func quantizePosition(position Position) QuantizedPosition {
+	return QuantizedPosition{
+		X: quantizeCoordinate(position.Position_x),
+		Y: quantizeCoordinate(position.Position_y),
+		Z: quantizeCoordinate(position.Position_z),
+	}
+}
+
+// quantizeCoordinate clamps coordinates beyond the int32 range instead of letting them wrap around.
+func quantizeCoordinate(coordinate float64) int32 {
+	scaled := math.Round(coordinate / SNAPSHOT_PRECISION)
+	if math.IsNaN(scaled) {
+		return 0
+	}
+	return int32(math.Max(math.MinInt32, math.Min(math.MaxInt32, scaled)))
+}
+func dequantizePosition(quantized QuantizedPosition) Position {
+	return Position{
+		Position_x: float64(quantized.X) * SNAPSHOT_PRECISION,
+		Position_y: float64(quantized.Y) * SNAPSHOT_PRECISION,
+		Position_z: float64(quantized.Z) * SNAPSHOT_PRECISION,
+	}
+}
+
+// network ids replace 16 byte account ids on the wire, they are handed out once per account for the server's lifetime
+var networkIDs = make(map[uuid.UUID]uint32)
+var networkIDsMutex sync.Mutex
+
+func getNetworkID(accountID uuid.UUID) uint32 {
+	networkIDsMutex.Lock()
+	defer networkIDsMutex.Unlock()
+	if networkID, found := networkIDs[accountID]; found {
+		return networkID
+	}
+	networkID := uint32(len(networkIDs) + 1)
+	networkIDs[accountID] = networkID
+	return networkID
+}
+
+// encodeSnapshot writes current as a delta against baseline, or in full when baseline is nil:
+//
+//	uvarint sequence, uvarint baseline sequence (0 for none)
+//	uvarint changed count, then per entity: uvarint network id, zigzag varint dx, dy, dz
+//	uvarint removed count, then per entity: uvarint network id
+//
+// Entities that did not move since the baseline are left out entirely.
+func encodeSnapshot(current Snapshot, baseline *Snapshot) []byte {
+	var previous map[uint32]QuantizedPosition
+	var baselineSequence uint32
+	if baseline != nil {
+		previous = baseline.Entities
+		baselineSequence = baseline.Sequence
+	}
+	buffer := make([]byte, 0, 8+len(current.Entities)*8)
+	buffer = binary.AppendUvarint(buffer, uint64(current.Sequence))
+	buffer = binary.AppendUvarint(buffer, uint64(baselineSequence))
+	changed := make([]uint32, 0, len(current.Entities))
+	for networkID, position := range current.Entities {
+		if old, found := previous[networkID]; !found || old != position {
+			changed = append(changed, networkID)
+		}
+	}
+	buffer = binary.AppendUvarint(buffer, uint64(len(changed)))
+	for _, networkID := range changed {
+		position, old := current.Entities[networkID], previous[networkID]
+		buffer = binary.AppendUvarint(buffer, uint64(networkID))
+		buffer = binary.AppendVarint(buffer, int64(position.X)-int64(old.X))
+		buffer = binary.AppendVarint(buffer, int64(position.Y)-int64(old.Y))
+		buffer = binary.AppendVarint(buffer, int64(position.Z)-int64(old.Z))
+	}
+	removed := make([]uint32, 0)
+	for networkID := range previous {
+		if _, found := current.Entities[networkID]; !found {
+			removed = append(removed, networkID)
+		}
+	}
+	buffer = binary.AppendUvarint(buffer, uint64(len(removed)))
+	for _, networkID := range removed {
+		buffer = binary.AppendUvarint(buffer, uint64(networkID))
+	}
+	return buffer
+}
+
+// snapshotReader walks an encoded snapshot and remembers the first error it runs into.
+type snapshotReader struct {
+	data []byte
+	err  error
+}
+
+func (reader *snapshotReader) uvarint() uint64 {
+	value, read := binary.Uvarint(reader.data)
+	if read <= 0 {
+		reader.err = errSnapshotTruncated
+		reader.data = nil
+		return 0
+	}
+	reader.data = reader.data[read:]
+	return value
+}
+func (reader *snapshotReader) varint() int64 {
+	value, read := binary.Varint(reader.data)
+	if read <= 0 {
+		reader.err = errSnapshotTruncated
+		reader.data = nil
+		return 0
+	}
+	reader.data = reader.data[read:]
+	return value
+}
+
+// decodeSnapshot rebuilds a snapshot, baselines looks up earlier snapshots the client received by sequence.
+func decodeSnapshot(data []byte, baselines func(sequence uint32) (Snapshot, bool)) (Snapshot, error) {
+	reader := &snapshotReader{data: data}
+	snapshot := Snapshot{Sequence: uint32(reader.uvarint()), Entities: make(map[uint32]QuantizedPosition)}
+	if baselineSequence := uint32(reader.uvarint()); baselineSequence != 0 {
+		baseline, found := baselines(baselineSequence)
+		if !found {
+			return Snapshot{}, errSnapshotBaseline
+		}
+		for networkID, position := range baseline.Entities {
+			snapshot.Entities[networkID] = position
+		}
+	}
+	for changed := reader.uvarint(); changed > 0 && reader.err == nil; changed-- {
+		networkID := uint32(reader.uvarint())
+		old := snapshot.Entities[networkID]
+		snapshot.Entities[networkID] = QuantizedPosition{
+			X: int32(int64(old.X) + reader.varint()),
+			Y: int32(int64(old.Y) + reader.varint()),
+			Z: int32(int64(old.Z) + reader.varint()),
+		}
+	}
+	for removed := reader.uvarint(); removed > 0 && reader.err == nil; removed-- {
+		delete(snapshot.Entities, uint32(reader.uvarint()))
+	}
+	return snapshot, reader.err
+}
+
+// SnapshotStream numbers the snapshots sent to one client and deltas each against the newest one
+// the client acknowledged. Until the first ack every snapshot is sent in full.
+type SnapshotStream struct {
+	mutex    sync.Mutex
+	sequence uint32
+	acked    uint32
+	history  map[uint32]Snapshot
+}
+
+func newSnapshotStream() *SnapshotStream {
+	return &SnapshotStream{history: make(map[uint32]Snapshot)}
+}
+
+// Next builds and encodes the snapshot for the entities the client can see this tick.
+func (stream *SnapshotStream) Next(entities map[uint32]QuantizedPosition) []byte {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	stream.sequence++
+	current := Snapshot{Sequence: stream.sequence, Entities: entities}
+	var baseline *Snapshot
+	if acked, found := stream.history[stream.acked]; found {
+		baseline = &acked
+	}
+	stream.history[current.Sequence] = current
+	delete(stream.history, stream.sequence-uint32(SNAPSHOT_HISTORY))
+	return encodeSnapshot(current, baseline)
+}
+
+// Ack records that the client received a snapshot, older ones can no longer be a baseline.
+func (stream *SnapshotStream) Ack(sequence uint32) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	if sequence <= stream.acked || sequence > stream.sequence {
+		return
+	}
+	for old := range stream.history {
+		if old < sequence {
+			delete(stream.history, old)
+		}
+	}
+	stream.acked = sequence
+}
+
+// snapshotEntities is what a client's snapshot holds: the player and everyone in their area of interest.
+func snapshotEntities(accountID uuid.UUID, position Position) map[uint32]QuantizedPosition {
+	entities := map[uint32]QuantizedPosition{getNetworkID(accountID): quantizePosition(position)}
+	for otherID, otherPosition := range interest.Visible(accountID) {
+		entities[getNetworkID(otherID)] = quantizePosition(otherPosition)
+	}
+	return entities
+}
+
+func getSnapshotStream(accountID uuid.UUID) *SnapshotStream {
+	snapshotStreamsMutex.Lock()
+	defer snapshotStreamsMutex.Unlock()
+	stream, found := snapshotStreams[accountID]
+	if !found {
+		stream = newSnapshotStream()
+		snapshotStreams[accountID] = stream
+	}
+	return stream
+}
+func dropSnapshotStream(accountID uuid.UUID) {
+	snapshotStreamsMutex.Lock()
+	defer snapshotStreamsMutex.Unlock()
+	delete(snapshotStreams, accountID)
+}
+
+// pushSnapshot sends a player the next snapshot of their stream as "SNAPSHOT$<base64>".
+func pushSnapshot(accountID uuid.UUID, position Position) {
+	encoded := getSnapshotStream(accountID).Next(snapshotEntities(accountID, position))
+	content := "SNAPSHOT$" + base64.StdEncoding.EncodeToString(encoded)
+	Push(accountID, createSimpleDeliveryPacket(uuid.New().String(), "SN#", "SNAPSHOT", content))
+}
+
+// ackSnapshot applies an SA# acknowledgement, later snapshots are deltas against the acknowledged one.
+func ackSnapshot(accountID uuid.UUID, sequenceSTR string) {
+	sequence, err := strconv.ParseUint(sequenceSTR, 10, 32)
+	if err != nil {
+		fmt.Println(Warn("Ignoring snapshot ack ", sequenceSTR, " from ", accountID))
+		return
+	}
+	snapshotStreamsMutex.Lock()
+	stream, found := snapshotStreams[accountID]
+	snapshotStreamsMutex.Unlock()
+	if found {
+		stream.Ack(uint32(sequence))
+	}
+}
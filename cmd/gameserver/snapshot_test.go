@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSnapshotDeltaRoundTrip(t *testing.T) {
+	stream := newSnapshotStream()
+	received := make(map[uint32]Snapshot)
+	lookup := func(sequence uint32) (Snapshot, bool) {
+		snapshot, found := received[sequence]
+		return snapshot, found
+	}
+
+	first := map[uint32]QuantizedPosition{1: quantizePosition(Position{Position_x: 10.25, Position_y: 1}), 2: {X: -500, Z: 300}}
+	decoded, err := decodeSnapshot(stream.Next(first), lookup)
+	if err != nil || len(decoded.Entities) != 2 || decoded.Entities[1] != first[1] {
+		t.Fatalf("expected the full snapshot to decode, got %+v %v", decoded, err)
+	}
+	received[decoded.Sequence] = decoded
+	stream.Ack(decoded.Sequence)
+
+	second := map[uint32]QuantizedPosition{1: {X: 1030, Y: 100}, 3: {X: 7}}
+	encoded := stream.Next(second)
+	decoded, err = decodeSnapshot(encoded, lookup)
+	if err != nil {
+		t.Fatalf("expected the delta to decode, got %v", err)
+	}
+	if len(decoded.Entities) != 2 || decoded.Entities[1] != second[1] || decoded.Entities[3] != second[3] {
+		t.Errorf("expected moved, added and removed entities to apply, got %+v", decoded.Entities)
+	}
+	if _, err := decodeSnapshot(encoded[:len(encoded)-2], lookup); err == nil {
+		t.Errorf("expected a truncated snapshot to fail")
+	}
+	if _, err := decodeSnapshot(encoded, func(uint32) (Snapshot, bool) { return Snapshot{}, false }); err != errSnapshotBaseline {
+		t.Errorf("expected a missing baseline to be reported, got %v", err)
+	}
+}
+
+func TestQuantizePosition(t *testing.T) {
+	position := Position{Position_x: 12.345, Position_y: -0.004, Position_z: 1000}
+	restored := dequantizePosition(quantizePosition(position))
+	if distance(position, restored) > SNAPSHOT_PRECISION {
+		t.Errorf("expected quantization error below %v, got %v", SNAPSHOT_PRECISION, restored)
+	}
+}
+
+func TestQuantizePositionClampsOutOfRangeCoordinates(t *testing.T) {
+	quantized := quantizePosition(Position{Position_x: 1e12, Position_y: -1e12, Position_z: math.MaxInt32 * SNAPSHOT_PRECISION * 2})
+	if quantized.X != math.MaxInt32 || quantized.Y != math.MinInt32 || quantized.Z != math.MaxInt32 {
+		t.Errorf("expected coordinates to clamp instead of wrapping, got %+v", quantized)
+	}
+}
+
+// TestSnapshotBytesPerTick is the bandwidth harness: it simulates players wandering and reports what one
+// client receives per tick as JSON positions, as a full binary snapshot and as an acknowledged delta.
+func TestSnapshotBytesPerTick(t *testing.T) {
+	const ticks = 30
+	for _, players := range []int{10, 50, 100, 250} {
+		rng := rand.New(rand.NewSource(int64(players)))
+		positions := make([]Position, players)
+		for i := range positions {
+			positions[i] = Position{Position_x: rng.Float64() * 200, Position_y: 1, Position_z: rng.Float64() * 200}
+		}
+		stream := newSnapshotStream()
+		jsonBytes, fullBytes, deltaBytes := 0, 0, 0
+		for tick := 0; tick < ticks; tick++ {
+			entities := make(map[uint32]QuantizedPosition, players)
+			for i := range positions {
+				// roughly a third of the players are moving on any tick
+				if rng.Intn(3) == 0 {
+					positions[i].Position_x += rng.Float64() - 0.5
+					positions[i].Position_z += rng.Float64() - 0.5
+				}
+				entities[uint32(i+1)] = quantizePosition(positions[i])
+			}
+			positionsJSON, _ := json.Marshal(positions)
+			jsonBytes += len(positionsJSON)
+			fullBytes += len(encodeSnapshot(Snapshot{Sequence: uint32(tick + 1), Entities: entities}, nil))
+			deltaBytes += len(stream.Next(entities))
+			stream.Ack(uint32(tick + 1))
+		}
+		t.Logf("%4d players: json %6d B/tick, full %6d B/tick, delta %6d B/tick", players, jsonBytes/ticks, fullBytes/ticks, deltaBytes/ticks)
+		if deltaBytes >= fullBytes || fullBytes >= jsonBytes {
+			t.Errorf("expected delta < full < json for %d players, got %d %d %d", players, deltaBytes, fullBytes, jsonBytes)
+		}
+	}
+}
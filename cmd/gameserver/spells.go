@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const HOTBAR_SIZE = 8
+
+type SpellCastResult struct {
+	Spell_id string    `json:"spell_id" default:""`
+	BattleID uuid.UUID `json:"battle_id" default:""`
+	Mana     float64   `json:"mana" default:"0"`
+	Turn     int       `json:"turn" default:"0"`
+}
+
+// canLearnSpell checks the level, class and prerequisite spells of a spell against a profile.
+func canLearnSpell(spell Spell, profile *Profile) (bool, string) {
+	if containsString(profile.SpellIndex, spell.Spell_id) {
+		return false, "Spell already known"
+	}
+	if profile.Level < int(spell.Level) {
+		return false, "Level too low"
+	}
+	if len(spell.Classes) > 0 && !containsString(spell.Classes, profile.Class_id) {
+		return false, "Wrong class"
+	}
+	for _, prerequisite := range spell.Prerequisites {
+		if !containsString(profile.SpellIndex, prerequisite) {
+			return false, "Missing prerequisite " + prerequisite
+		}
+	}
+	return true, ""
+}
+
+// validateHotbar checks a requested hotbar layout, empty slots are kept so the client can leave gaps.
+func validateHotbar(slots []string, profile *Profile) (bool, string) {
+	if len(slots) > HOTBAR_SIZE {
+		return false, "Too many slots"
+	}
+	seen := make(map[string]bool)
+	for _, spellID := range slots {
+		if spellID == "" {
+			continue
+		}
+		if !containsString(profile.SpellIndex, spellID) {
+			return false, "Spell not known " + spellID
+		}
+		if seen[spellID] {
+			return false, "Spell slotted twice " + spellID
+		}
+		seen[spellID] = true
+	}
+	return true, ""
+}
+
+// learnSpell adds a spell to the spell index if the player meets its requirements.
+func learnSpell(accountID uuid.UUID, spellID string, mongoClient *mongo.Client) string {
+	spell, found := currentWorld().Spell(spellID)
+	if !found {
+		return "Spell does not exist!"
+	}
+	profile, _ := getProfile(accountID, mongoClient)
+	if profile == nil {
+		return "Profile does not exist!"
+	}
+	if learnable, reason := canLearnSpell(*spell, profile); !learnable {
+		return "Spell cannot be learned: " + reason
+	}
+	return grantSpell(accountID, spell.Spell_id, mongoClient)
+}
+
+// grantSpell adds a spell to the spell index without checking requirements, used for starter spells and rewards.
+func grantSpell(accountID uuid.UUID, spellID string, mongoClient *mongo.Client) string {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	profiles := mongoClient.Database("player").Collection("profiles")
+	match := bson.M{"uuid": accountID}
+	change := bson.M{"$addToSet": bson.M{"spell_index": spellID}}
+	if _, err := profiles.UpdateOne(cxt, match, change); err != nil {
+		fmt.Println(Failure(err))
+		return "Spell addition failed!"
+	}
+	return "Spell added successfully!"
+}
+
+// ensureSpellProfile fills in what spell casting matches on for profiles saved before spells existed.
+// Missing vitals start full, a missing hotbar starts empty and repeated spell_index entries are dropped.
+// It runs at login and only writes fields that are still in the state that was read.
+func ensureSpellProfile(accountID uuid.UUID, mongoClient *mongo.Client) {
+	profile, _ := getProfile(accountID, mongoClient)
+	if profile == nil {
+		return
+	}
+	match := bson.M{"uuid": accountID}
+	changes := bson.M{}
+	if profile.Vitals == (Vitals{}) {
+		match["vitals"] = bson.M{"$exists": false}
+		changes["vitals"] = profileVitals(profile)
+	}
+	if profile.Hotbar == nil {
+		match["hotbar"] = nil
+		changes["hotbar"] = []string{}
+	}
+	if spellIndex := uniqueStrings(profile.SpellIndex); len(spellIndex) != len(profile.SpellIndex) || profile.SpellIndex == nil {
+		match["spell_index"] = profile.SpellIndex
+		changes["spell_index"] = spellIndex
+	}
+	if len(changes) == 0 {
+		return
+	}
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	profiles := mongoClient.Database("player").Collection("profiles")
+	if _, err := profiles.UpdateOne(cxt, match, bson.M{"$set": changes}); err != nil {
+		fmt.Println(Failure(err))
+	}
+}
+
+// battleEndVitals refills mana once a battle is over, casting only ever spends it. Health is kept.
+func battleEndVitals(profile *Profile) bson.E {
+	if profile.Vitals == (Vitals{}) {
+		return bson.E{Key: "vitals", Value: profileVitals(profile)}
+	}
+	if profile.Stats.Mana <= 0 {
+		return bson.E{Key: "vitals.mana", Value: profile.Vitals.Mana}
+	}
+	return bson.E{Key: "vitals.mana", Value: profile.Stats.Mana}
+}
+func uniqueStrings(values []string) []string {
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !containsString(unique, value) {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// forgetSpell removes a spell from the spell index and clears any hotbar slot holding it.
+func forgetSpell(accountID uuid.UUID, spellID string, mongoClient *mongo.Client) string {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	profiles := mongoClient.Database("player").Collection("profiles")
+	for attempt := 0; attempt < REWARD_RETRY_LIMIT; attempt++ {
+		profile, _ := getProfile(accountID, mongoClient)
+		if profile == nil {
+			return "Profile does not exist!"
+		}
+		if !containsString(profile.SpellIndex, spellID) {
+			return "Spell not known!"
+		}
+		hotbar := make([]string, len(profile.Hotbar))
+		for slot, slotted := range profile.Hotbar {
+			if slotted != spellID {
+				hotbar[slot] = slotted
+			}
+		}
+		match := bson.M{"uuid": accountID, "hotbar": profile.Hotbar}
+		change := bson.M{"$pull": bson.M{"spell_index": spellID}, "$set": bson.M{"hotbar": hotbar}}
+		updateResponse, err := profiles.UpdateOne(cxt, match, change)
+		if err != nil {
+			fmt.Println(Failure(err))
+			return "Spell removal failed!"
+		}
+		if updateResponse.MatchedCount == 1 {
+			return "Spell forgotten!"
+		}
+		fmt.Println(Warn("Profile changed while forgetting spell, retrying..."))
+	}
+	return "Spell removal failed!"
+}
+
+// setHotbar stores the ordered hotbar slots, slots is a comma separated list of spell ids.
+func setHotbar(accountID uuid.UUID, slotsSTR string, mongoClient *mongo.Client) string {
+	slots := strings.Split(slotsSTR, ",")
+	for slot := range slots {
+		slots[slot] = strings.TrimSpace(slots[slot])
+	}
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	profiles := mongoClient.Database("player").Collection("profiles")
+	for attempt := 0; attempt < REWARD_RETRY_LIMIT; attempt++ {
+		profile, _ := getProfile(accountID, mongoClient)
+		if profile == nil {
+			return "HOTBAR$0;Profile does not exist"
+		}
+		if valid, reason := validateHotbar(slots, profile); !valid {
+			return "HOTBAR$0;" + reason
+		}
+		match := bson.M{"uuid": accountID, "spell_index": profile.SpellIndex}
+		updateResponse, err := profiles.UpdateOne(cxt, match, bson.M{"$set": bson.M{"hotbar": slots}})
+		if err != nil {
+			fmt.Println(Failure(err))
+			return "HOTBAR$0;Hotbar update failed"
+		}
+		if updateResponse.MatchedCount == 1 {
+			slotsJSON, _ := json.Marshal(slots)
+			return "HOTBAR$1;" + string(slotsJSON)
+		}
+		fmt.Println(Warn("Profile changed while updating hotbar, retrying..."))
+	}
+	return "HOTBAR$0;Hotbar update failed"
+}
+
+// castSpell validates a battle cast against the player's hotbar and mana, spends the mana and uses the turn.
+func castSpell(accountID uuid.UUID, battleIDSTR string, spellID string, mongoClient *mongo.Client) string {
+	battleID, _ := uuid.Parse(battleIDSTR)
+	spell, found := currentWorld().Spell(spellID)
+	if !found {
+		return "CAST$0;Spell does not exist"
+	}
+	if reason := reserveBattleTurn(battleID, accountID); reason != "" {
+		return "CAST$0;" + reason
+	}
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	profiles := mongoClient.Database("player").Collection("profiles")
+	match := bson.M{"uuid": accountID, "hotbar": spellID, "vitals.mana": bson.M{"$gte": float64(spell.Mana_cost)}}
+	change := bson.M{"$inc": bson.M{"vitals.mana": -float64(spell.Mana_cost)}}
+	var profile Profile
+	err := profiles.FindOneAndUpdate(cxt, match, change, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&profile)
+	if err == mongo.ErrNoDocuments {
+		releaseBattleTurn(battleID, accountID)
+		return "CAST$0;Spell is not on the hotbar or not enough mana"
+	}
+	if err != nil {
+		fmt.Println(Failure(err))
+		releaseBattleTurn(battleID, accountID)
+		return "CAST$0;Cast failed"
+	}
+	result := SpellCastResult{Spell_id: spellID, BattleID: battleID, Mana: profile.Vitals.Mana}
+	result.Turn = recordBattleTurn(battleID, accountID, BattleAction{Type: "spell", Value: spellID})
+	resultJSON, _ := json.Marshal(result)
+	return "CAST$1;" + string(resultJSON)
+}
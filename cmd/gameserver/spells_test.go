@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCanLearnSpell(t *testing.T) {
+	spell := Spell{Spell_id: "Inferno", Level: 5, Classes: []string{"wizard"}, Prerequisites: []string{"Fireball"}}
+	profile := &Profile{Level: 4, Class_id: "wizard", SpellIndex: []string{"Fireball"}}
+
+	if learnable, reason := canLearnSpell(spell, profile); learnable || reason != "Level too low" {
+		t.Errorf("expected level requirement to fail, got %v %q", learnable, reason)
+	}
+	profile.Level = 5
+	if learnable, reason := canLearnSpell(spell, profile); !learnable {
+		t.Errorf("expected spell to be learnable, got %q", reason)
+	}
+	profile.SpellIndex = nil
+	if learnable, reason := canLearnSpell(spell, profile); learnable || reason != "Missing prerequisite Fireball" {
+		t.Errorf("expected prerequisite to fail, got %v %q", learnable, reason)
+	}
+	profile.SpellIndex = []string{"Fireball", "Inferno"}
+	if learnable, reason := canLearnSpell(spell, profile); learnable || reason != "Spell already known" {
+		t.Errorf("expected duplicate to be rejected, got %v %q", learnable, reason)
+	}
+}
+
+func TestValidateHotbar(t *testing.T) {
+	profile := &Profile{SpellIndex: []string{"Fireball", "Scorch"}}
+	if valid, reason := validateHotbar([]string{"Fireball", "", "Scorch"}, profile); !valid {
+		t.Errorf("expected hotbar with a gap to be valid, got %q", reason)
+	}
+	if valid, _ := validateHotbar([]string{"Fireball", "Fireball"}, profile); valid {
+		t.Errorf("expected duplicate slots to be rejected")
+	}
+	if valid, _ := validateHotbar([]string{"Inferno"}, profile); valid {
+		t.Errorf("expected unknown spell to be rejected")
+	}
+	if valid, _ := validateHotbar(make([]string, HOTBAR_SIZE+1), profile); valid {
+		t.Errorf("expected oversized hotbar to be rejected")
+	}
+}
+
+func TestLegacyProfilesCanCastAndRefillMana(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	accountID := uuid.New()
+	profiles := mongoClient.Database("player").Collection("profiles")
+	legacy := bson.M{"uuid": accountID, "total_exp": 0.0, "max_exp": 100.0, "stats": bson.M{"health": 100.0, "mana": 50.0}, "spell_index": []string{"Fireball", "Fireball"}}
+	if _, err := profiles.InsertOne(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	ensureSpellProfile(accountID, mongoClient)
+	profile, _ := getProfile(accountID, mongoClient)
+	if profile.Vitals != (Vitals{Health: 100, Mana: 50}) || profile.Hotbar == nil || !reflect.DeepEqual(profile.SpellIndex, []string{"Fireball"}) {
+		t.Fatalf("legacy profile was not filled in : %+v %v %v", profile.Vitals, profile.Hotbar, profile.SpellIndex)
+	}
+	if response := setHotbar(accountID, "Fireball", mongoClient); !strings.HasPrefix(response, "HOTBAR$1") {
+		t.Fatalf("unexpected response %q", response)
+	}
+
+	world := *currentWorld()
+	world.Spells = map[string]Spell{"Fireball": {Spell_id: "Fireball", Mana_cost: 20}}
+	setWorldData(&world)
+	battle := createBattle(&[]Monster{}, 0, []uuid.UUID{accountID}, DEFAULT_PARTY_SPLIT_RULE)
+	if response := castSpell(accountID, battle.BattleID.String(), "Fireball", mongoClient); !strings.Contains(response, `"mana":30`) {
+		t.Fatalf("unexpected response %q", response)
+	}
+	grantBattleRewards(accountID, 0, 0, nil, nil, mongoClient)
+	if profile, _ := getProfile(accountID, mongoClient); profile.Vitals != (Vitals{Health: 100, Mana: 50}) {
+		t.Errorf("mana was not refilled at the end of the battle : %+v", profile.Vitals)
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type TradeOffer struct {
+	Account_id uuid.UUID `json:"uuid" default:"" bson:"uuid"`
+	Items      []string  `json:"items" default:"" bson:"items"`
+	Bits       float64   `json:"bits" default:"0" bson:"bits"`
+	Confirmed  bool      `json:"confirmed" default:"false" bson:"-"`
+}
+
+// Status moves from "requested" to "open" once the partner accepts and to "committing" when both sides confirm.
+// Escrowing marks a side whose new offer is still being moved into escrow.
+type TradeSession struct {
+	Trade_id  uuid.UUID     `json:"trade_id" default:""`
+	Status    string        `json:"status" default:"requested"`
+	Offers    [2]TradeOffer `json:"offers" default:""`
+	Escrowing [2]bool       `json:"-"`
+}
+
+// TradeEscrow holds what a player currently offers in a trade. Offered goods leave the profile as soon
+// as they are offered, so they cannot be spent, sold or offered twice while the trade is open.
+type TradeEscrow struct {
+	Trade_id   uuid.UUID `json:"trade_id" bson:"trade_id"`
+	Account_id uuid.UUID `json:"uuid" bson:"uuid"`
+	Items      []string  `json:"items" bson:"items"`
+	Bits       float64   `json:"bits" bson:"bits"`
+}
+
+// TradeRecord is the audit log entry written with every completed trade.
+type TradeRecord struct {
+	Trade_id     uuid.UUID    `json:"trade_id" bson:"trade_id"`
+	Offers       []TradeOffer `json:"offers" bson:"offers"`
+	Completed_at time.Time    `json:"completed_at" bson:"completed_at"`
+}
+
+const (
+	TRADE_REQUESTED  = "requested"
+	TRADE_OPEN       = "open"
+	TRADE_COMMITTING = "committing"
+)
+
+var trades = make(map[uuid.UUID]*TradeSession)
+var tradeMembership = make(map[uuid.UUID]uuid.UUID)
+var tradesMutex sync.Mutex
+
+func tradeResponse(trade *TradeSession) string {
+	tradeJSON, _ := json.Marshal(trade)
+	return "TRADE$1;" + string(tradeJSON)
+}
+func tradeFailure(reason string) string {
+	return "TRADE$0;" + reason
+}
+func notifyTrade(trade TradeSession, event string) {
+	tradeJSON, _ := json.Marshal(trade)
+	for _, offer := range trade.Offers {
+		packet := createSimpleDeliveryPacket(uuid.New().String(), "TN#", "TRADE", "TRADE$"+event+";"+string(tradeJSON))
+		Push(offer.Account_id, packet)
+	}
+}
+
+// tradeSide returns which of the two offers in the trade belongs to the account.
+func tradeSide(trade *TradeSession, accountID uuid.UUID) int {
+	if trade.Offers[0].Account_id == accountID {
+		return 0
+	}
+	return 1
+}
+func getTrade(accountID uuid.UUID) (*TradeSession, bool) {
+	tradeID, found := tradeMembership[accountID]
+	if !found {
+		return nil, false
+	}
+	return trades[tradeID], true
+}
+func endTrade(trade *TradeSession) {
+	for _, offer := range trade.Offers {
+		delete(tradeMembership, offer.Account_id)
+	}
+	delete(trades, trade.Trade_id)
+}
+
+// applyTradeOffers works out a side's inventory and purse after it gives one offer and receives the other.
+func applyTradeOffers(profile *Profile, give TradeOffer, receive TradeOffer) ([]string, float64, error) {
+	if profile.Purse.Bits < give.Bits {
+		return nil, 0, errors.New("not enough bits")
+	}
+	quantities := make(map[string]int)
+	for _, itemID := range give.Items {
+		quantities[itemID]++
+	}
+	collection, removed := removeItemsFromCollection(profile.Items.Collection, quantities)
+	if !removed {
+		return nil, 0, errors.New("missing offered items")
+	}
+	collection = append(collection, receive.Items...)
+	return collection, profile.Purse.Bits - give.Bits + receive.Bits, nil
+}
+func requestTrade(accountID uuid.UUID, targetID uuid.UUID) string {
+	if accountID == targetID {
+		return tradeFailure("Cannot trade with yourself")
+	}
+	if _, online := getPlayerConnection(accountID); !online {
+		return tradeFailure("Not logged in")
+	}
+	if _, online := getPlayerConnection(targetID); !online {
+		return tradeFailure("Player is not online")
+	}
+	tradesMutex.Lock()
+	if _, busy := tradeMembership[accountID]; busy {
+		tradesMutex.Unlock()
+		return tradeFailure("Already trading")
+	}
+	if _, busy := tradeMembership[targetID]; busy {
+		tradesMutex.Unlock()
+		return tradeFailure("Player is already trading")
+	}
+	trade := &TradeSession{Trade_id: uuid.New(), Status: TRADE_REQUESTED}
+	trade.Offers[0] = TradeOffer{Account_id: accountID, Items: []string{}}
+	trade.Offers[1] = TradeOffer{Account_id: targetID, Items: []string{}}
+	trades[trade.Trade_id] = trade
+	tradeMembership[accountID] = trade.Trade_id
+	tradeMembership[targetID] = trade.Trade_id
+	snapshot := *trade
+	tradesMutex.Unlock()
+	notifyTrade(snapshot, "REQUEST")
+	return tradeResponse(&snapshot)
+}
+func acceptTrade(accountID uuid.UUID, tradeID uuid.UUID) string {
+	tradesMutex.Lock()
+	trade, found := trades[tradeID]
+	if !found || trade.Offers[1].Account_id != accountID || trade.Status != TRADE_REQUESTED {
+		tradesMutex.Unlock()
+		return tradeFailure("No trade request to accept")
+	}
+	trade.Status = TRADE_OPEN
+	snapshot := *trade
+	tradesMutex.Unlock()
+	notifyTrade(snapshot, "OPEN")
+	return tradeResponse(&snapshot)
+}
+
+// setTradeOffer replaces the player's offer. The new offer is moved into escrow and the old one handed
+// back before anyone sees it. A confirmed offer is locked, and changing an offer clears the other side's
+// confirmation so nobody confirms a deal they did not see.
+func setTradeOffer(accountID uuid.UUID, itemsSTR string, bitsSTR string, mongoClient *mongo.Client) string {
+	items := []string{}
+	for _, itemID := range strings.Split(itemsSTR, ",") {
+		if itemID = strings.TrimSpace(itemID); itemID != "" {
+			items = append(items, itemID)
+		}
+	}
+	bits, err := strconv.ParseFloat(bitsSTR, 64)
+	if err != nil || bits < 0 || math.IsNaN(bits) || math.IsInf(bits, 0) {
+		return tradeFailure("Invalid bits")
+	}
+	offer := TradeOffer{Account_id: accountID, Items: items, Bits: bits}
+	tradesMutex.Lock()
+	trade, found := getTrade(accountID)
+	if !found || trade.Status != TRADE_OPEN {
+		tradesMutex.Unlock()
+		return tradeFailure("No open trade")
+	}
+	side := tradeSide(trade, accountID)
+	if trade.Offers[side].Confirmed {
+		tradesMutex.Unlock()
+		return tradeFailure("Offer is locked")
+	}
+	if trade.Escrowing[side] {
+		tradesMutex.Unlock()
+		return tradeFailure("Offer is being updated")
+	}
+	trade.Escrowing[side] = true
+	tradeID := trade.Trade_id
+	tradesMutex.Unlock()
+
+	err = escrowOffer(tradeID, offer, mongoClient)
+	tradesMutex.Lock()
+	trade.Escrowing[side] = false
+	if current, open := getTrade(accountID); !open || current != trade || trade.Status != TRADE_OPEN {
+		// the trade ended while the offer was moved, whatever made it into escrow goes straight back
+		tradesMutex.Unlock()
+		if err == nil {
+			returnEscrow(tradeID, accountID, mongoClient)
+		}
+		return tradeFailure("No open trade")
+	}
+	if err != nil {
+		tradesMutex.Unlock()
+		return tradeFailure("Cannot offer: " + err.Error())
+	}
+	trade.Offers[side] = offer
+	trade.Offers[1-side].Confirmed = false
+	snapshot := *trade
+	tradesMutex.Unlock()
+	notifyTrade(snapshot, "OFFER")
+	return tradeResponse(&snapshot)
+}
+
+// escrowOffer moves a new offer into escrow in one transaction, handing the goods held for the previous
+// offer back to the profile and taking the new ones out of it. An empty offer empties the escrow.
+func escrowOffer(tradeID uuid.UUID, offer TradeOffer, mongoClient *mongo.Client) error {
+	database := mongoClient.Database("player")
+	profiles := database.Collection("profiles")
+	escrows := database.Collection("trade_escrow")
+	return runTransaction(mongoClient, func(sessionContext mongo.SessionContext) error {
+		key := bson.M{"trade_id": tradeID, "uuid": offer.Account_id}
+		var held TradeEscrow
+		if err := escrows.FindOne(sessionContext, key).Decode(&held); err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		var profile Profile
+		if err := profiles.FindOne(sessionContext, bson.M{"uuid": offer.Account_id}).Decode(&profile); err != nil {
+			return err
+		}
+		match := bson.M{"uuid": offer.Account_id, "items.collection": profile.Items.Collection, "purse.bits": profile.Purse.Bits}
+		// the new offer may reuse what is held for the old one, so that is handed back first
+		profile.Items.Collection = append(append([]string{}, profile.Items.Collection...), held.Items...)
+		profile.Purse.Bits += held.Bits
+		collection, bits, err := applyTradeOffers(&profile, offer, TradeOffer{})
+		if err != nil {
+			return err
+		}
+		change := bson.M{"$set": bson.M{"items.collection": collection, "purse.bits": bits}}
+		if err := updateOneMatched(sessionContext, profiles, match, change); err != nil {
+			return err
+		}
+		if len(offer.Items) == 0 && offer.Bits == 0 {
+			_, err = escrows.DeleteOne(sessionContext, key)
+			return err
+		}
+		change = bson.M{"$set": bson.M{"items": offer.Items, "bits": offer.Bits}}
+		_, err = escrows.UpdateOne(sessionContext, key, change, options.Update().SetUpsert(true))
+		return err
+	})
+}
+
+// returnEscrow hands a player back everything they have in escrow for a trade.
+func returnEscrow(tradeID uuid.UUID, accountID uuid.UUID, mongoClient *mongo.Client) {
+	if err := escrowOffer(tradeID, TradeOffer{Account_id: accountID, Items: []string{}}, mongoClient); err != nil {
+		fmt.Println(Failure("Could not return escrow of trade ", tradeID, " to ", accountID, " : ", err))
+	}
+}
+
+// returnStaleEscrows runs at startup, when no trade is open yet, and hands back escrow left behind
+// by trades that were open when the server stopped.
+func returnStaleEscrows(mongoClient *mongo.Client) {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	filterCursor, err := mongoClient.Database("player").Collection("trade_escrow").Find(cxt, bson.M{})
+	if err != nil {
+		fmt.Println(Failure(err))
+		return
+	}
+	var stale []TradeEscrow
+	if err = filterCursor.All(cxt, &stale); err != nil {
+		fmt.Println(Failure(err))
+		return
+	}
+	for _, escrow := range stale {
+		returnEscrow(escrow.Trade_id, escrow.Account_id, mongoClient)
+	}
+	if len(stale) > 0 {
+		fmt.Println(Warn("Returned ", len(stale), " stale trade escrows"))
+	}
+}
+
+// confirmTrade locks the player's offer, once both sides have confirmed the trade is committed.
+func confirmTrade(accountID uuid.UUID, mongoClient *mongo.Client) string {
+	tradesMutex.Lock()
+	trade, found := getTrade(accountID)
+	if !found || trade.Status != TRADE_OPEN {
+		tradesMutex.Unlock()
+		return tradeFailure("No open trade")
+	}
+	if trade.Escrowing[0] || trade.Escrowing[1] {
+		tradesMutex.Unlock()
+		return tradeFailure("Offer is being updated")
+	}
+	trade.Offers[tradeSide(trade, accountID)].Confirmed = true
+	if !trade.Offers[0].Confirmed || !trade.Offers[1].Confirmed {
+		snapshot := *trade
+		tradesMutex.Unlock()
+		notifyTrade(snapshot, "CONFIRM")
+		return tradeResponse(&snapshot)
+	}
+	trade.Status = TRADE_COMMITTING
+	snapshot := *trade
+	tradesMutex.Unlock()
+
+	err := commitTrade(snapshot, mongoClient)
+	tradesMutex.Lock()
+	endTrade(trade)
+	tradesMutex.Unlock()
+	if err != nil {
+		fmt.Println(Failure("Trade ", snapshot.Trade_id, " failed : ", err))
+		for _, offer := range snapshot.Offers {
+			returnEscrow(snapshot.Trade_id, offer.Account_id, mongoClient)
+		}
+		notifyTrade(snapshot, "FAILED")
+		return tradeFailure("Trade failed, nothing was exchanged")
+	}
+	fmt.Println(Success("Trade completed : ", snapshot.Trade_id))
+	notifyTrade(snapshot, "COMPLETE")
+	return tradeResponse(&snapshot)
+}
+
+// commitTrade hands each side's escrow to the other side and writes the audit record in one transaction.
+// Escrow is only released while it still holds exactly the confirmed offer, otherwise the whole
+// transaction aborts and both sides keep their escrow to be returned.
+func commitTrade(trade TradeSession, mongoClient *mongo.Client) error {
+	database := mongoClient.Database("player")
+	profiles := database.Collection("profiles")
+	escrows := database.Collection("trade_escrow")
+	return runTransaction(mongoClient, func(sessionContext mongo.SessionContext) error {
+		for side, offer := range trade.Offers {
+			if len(offer.Items) > 0 || offer.Bits > 0 {
+				match := bson.M{"trade_id": trade.Trade_id, "uuid": offer.Account_id, "items": offer.Items, "bits": offer.Bits}
+				deleteResponse, err := escrows.DeleteOne(sessionContext, match)
+				if err != nil {
+					return err
+				}
+				if deleteResponse.DeletedCount != 1 {
+					return errDocumentChanged
+				}
+			}
+			receive := trade.Offers[1-side]
+			change := bson.M{"$inc": bson.M{"purse.bits": receive.Bits}}
+			if len(receive.Items) > 0 {
+				change["$push"] = bson.M{"items.collection": bson.M{"$each": receive.Items}}
+			}
+			if err := updateOneMatched(sessionContext, profiles, bson.M{"uuid": offer.Account_id}, change); err != nil {
+				return err
+			}
+		}
+		record := TradeRecord{Trade_id: trade.Trade_id, Offers: trade.Offers[:], Completed_at: time.Now().UTC()}
+		_, err := database.Collection("trades").InsertOne(sessionContext, record)
+		return err
+	})
+}
+
+// runTransaction runs body in a transaction, any error it returns rolls back every write it made.
+func runTransaction(mongoClient *mongo.Client, body func(sessionContext mongo.SessionContext) error) error {
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	session, err := mongoClient.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(cxt)
+	_, err = session.WithTransaction(cxt, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		return nil, body(sessionContext)
+	})
+	return err
+}
+
+var errDocumentChanged = errors.New("document changed or did not match")
+
+// updateOneMatched fails with errDocumentChanged when the match finds nothing, so a transaction
+// built from optimistic matches aborts instead of silently skipping a write.
+func updateOneMatched(sessionContext mongo.SessionContext, collection *mongo.Collection, match bson.M, change bson.M) error {
+	updateResponse, err := collection.UpdateOne(sessionContext, match, change)
+	if err != nil {
+		return err
+	}
+	if updateResponse.MatchedCount != 1 {
+		return errDocumentChanged
+	}
+	return nil
+}
+
+// cancelTrade ends the player's trade unless it is already being committed and returns both escrows.
+func cancelTrade(accountID uuid.UUID, reason string, mongoClient *mongo.Client) string {
+	tradesMutex.Lock()
+	trade, found := getTrade(accountID)
+	if !found {
+		tradesMutex.Unlock()
+		return tradeFailure("Not trading")
+	}
+	if trade.Status == TRADE_COMMITTING {
+		tradesMutex.Unlock()
+		return tradeFailure("Trade is already completing")
+	}
+	endTrade(trade)
+	snapshot := *trade
+	tradesMutex.Unlock()
+	fmt.Println(Info("Trade ", snapshot.Trade_id, " cancelled : ", reason))
+	for _, offer := range snapshot.Offers {
+		returnEscrow(snapshot.Trade_id, offer.Account_id, mongoClient)
+	}
+	notifyTrade(snapshot, "CANCELLED")
+	return tradeResponse(&snapshot)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestApplyTradeOffers(t *testing.T) {
+	profile := &Profile{}
+	profile.Purse.Bits = 30
+	profile.Items.Collection = []string{"WizardHat", "Potion", "Potion"}
+	give := TradeOffer{Items: []string{"Potion"}, Bits: 10}
+	receive := TradeOffer{Items: []string{"IronSword"}, Bits: 5}
+
+	collection, bits, err := applyTradeOffers(profile, give, receive)
+	if err != nil {
+		t.Fatalf("expected trade to apply, got %v", err)
+	}
+	if bits != 25 || len(collection) != 3 || countItem(collection, "Potion") != 1 || countItem(collection, "IronSword") != 1 {
+		t.Errorf("unexpected result %v %v", collection, bits)
+	}
+	if _, _, err := applyTradeOffers(profile, TradeOffer{Bits: 31}, receive); err == nil {
+		t.Errorf("expected overspending to fail")
+	}
+	if _, _, err := applyTradeOffers(profile, TradeOffer{Items: []string{"Potion", "Potion", "Potion"}}, receive); err == nil {
+		t.Errorf("expected missing items to fail")
+	}
+}
+
+func TestCancelTradeOnDisconnect(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	first, second := uuid.New(), uuid.New()
+	trade := &TradeSession{Trade_id: uuid.New(), Status: TRADE_OPEN}
+	trade.Offers[0].Account_id = first
+	trade.Offers[1].Account_id = second
+	tradesMutex.Lock()
+	trades[trade.Trade_id] = trade
+	tradeMembership[first] = trade.Trade_id
+	tradeMembership[second] = trade.Trade_id
+	tradesMutex.Unlock()
+
+	cancelTrade(second, "disconnect", mongoClient)
+	tradesMutex.Lock()
+	defer tradesMutex.Unlock()
+	if _, found := tradeMembership[first]; found {
+		t.Errorf("expected partner to be released from the trade")
+	}
+	if _, found := trades[trade.Trade_id]; found {
+		t.Errorf("expected trade session to be removed")
+	}
+}
+
+func TestRequestTradeRequiresBothPlayersOnline(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	target, targetConnection, _ := connectPlayer(t, "bo", mongoClient)
+	defer disconnectPlayers(targetConnection, mongoClient)
+	if response := requestTrade(uuid.New(), target); response != tradeFailure("Not logged in") {
+		t.Errorf("an offline player requested a trade : %q", response)
+	}
+	tradesMutex.Lock()
+	_, found := getTrade(target)
+	tradesMutex.Unlock()
+	if found {
+		t.Errorf("a trade was opened for an offline requester")
+	}
+}
+
+// giveTradeGoods stocks a profile for trading.
+func giveTradeGoods(t *testing.T, accountID uuid.UUID, items []string, bits float64, mongoClient *mongo.Client) {
+	createProfile(accountID.String()[:8], accountID, mongoClient)
+	change := bson.M{"$set": bson.M{"items.collection": items, "purse.bits": bits}}
+	if _, err := mongoClient.Database("player").Collection("profiles").UpdateOne(context.Background(), bson.M{"uuid": accountID}, change); err != nil {
+		t.Fatal(err)
+	}
+}
+func tradeGoods(accountID uuid.UUID, mongoClient *mongo.Client) ([]string, float64) {
+	profile, _ := getProfile(accountID, mongoClient)
+	return profile.Items.Collection, profile.Purse.Bits
+}
+
+func TestTradeOffersAreHeldInEscrow(t *testing.T) {
+	mongoClient := newGameplayDatabase(t)
+	first, firstConnection, _ := connectPlayer(t, "ana", mongoClient)
+	defer disconnectPlayers(firstConnection, mongoClient)
+	second, secondConnection, _ := connectPlayer(t, "bo", mongoClient)
+	defer disconnectPlayers(secondConnection, mongoClient)
+	giveTradeGoods(t, first, []string{"Fang", "WizardHat"}, 30, mongoClient)
+	giveTradeGoods(t, second, []string{"WizardRobe"}, 5, mongoClient)
+
+	requestTrade(first, second)
+	tradesMutex.Lock()
+	tradeID := tradeMembership[first]
+	tradesMutex.Unlock()
+	acceptTrade(second, tradeID)
+	for _, invalid := range []string{"NaN", "+Inf", "-5"} {
+		if response := setTradeOffer(first, "Fang", invalid, mongoClient); response != tradeFailure("Invalid bits") {
+			t.Errorf("%s bits were accepted : %q", invalid, response)
+		}
+	}
+	if response := setTradeOffer(first, "Fang", "10", mongoClient); !strings.HasPrefix(response, "TRADE$1;") {
+		t.Fatalf("unexpected response %q", response)
+	}
+	if items, bits := tradeGoods(first, mongoClient); !reflect.DeepEqual(items, []string{"WizardHat"}) || bits != 20 {
+		t.Errorf("the offer was not taken into escrow : %v %v", items, bits)
+	}
+	if response := setTradeOffer(first, "Fang", "31", mongoClient); !strings.HasPrefix(response, "TRADE$0;") {
+		t.Errorf("more bits than the purse and escrow hold were offered : %q", response)
+	}
+	if response := setTradeOffer(first, "WizardHat", "30", mongoClient); !strings.HasPrefix(response, "TRADE$1;") {
+		t.Fatalf("unexpected response %q", response)
+	}
+	if items, bits := tradeGoods(first, mongoClient); !reflect.DeepEqual(items, []string{"Fang"}) || bits != 0 {
+		t.Errorf("the previous offer was not handed back : %v %v", items, bits)
+	}
+
+	cancelTrade(second, "cancelled by player", mongoClient)
+	if items, bits := tradeGoods(first, mongoClient); len(items) != 2 || bits != 30 {
+		t.Errorf("cancelling did not return the escrow : %v %v", items, bits)
+	}
+
+	requestTrade(first, second)
+	tradesMutex.Lock()
+	tradeID = tradeMembership[first]
+	tradesMutex.Unlock()
+	acceptTrade(second, tradeID)
+	setTradeOffer(first, "Fang", "10", mongoClient)
+	setTradeOffer(second, "WizardRobe", "0", mongoClient)
+	confirmTrade(first, mongoClient)
+	if response := confirmTrade(second, mongoClient); !strings.HasPrefix(response, "TRADE$1;") {
+		t.Fatalf("unexpected response %q", response)
+	}
+	if items, bits := tradeGoods(first, mongoClient); countItem(items, "WizardRobe") != 1 || countItem(items, "Fang") != 0 || bits != 20 {
+		t.Errorf("unexpected goods after trading : %v %v", items, bits)
+	}
+	if items, bits := tradeGoods(second, mongoClient); !reflect.DeepEqual(items, []string{"Fang"}) || bits != 15 {
+		t.Errorf("unexpected goods after trading : %v %v", items, bits)
+	}
+	if count, _ := mongoClient.Database("player").Collection("trade_escrow").CountDocuments(context.Background(), bson.M{}); count != 0 {
+		t.Errorf("%d escrows were left behind", count)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Portal connects a level to another one. Players must stand within Radius of Position to use it
+// and arrive at Arrival in the target level.
+type Portal struct {
+	Portal_id     string             `json:"portal_id" default:"" bson:"portalID"`
+	Position      Position           `json:"position" default:"" bson:"position"`
+	Radius        float64            `json:"radius" default:"5" bson:"radius"`
+	Target_level  string             `json:"target_level" default:"" bson:"targetLevel"`
+	Target_region string             `json:"target_region" default:"" bson:"targetRegion"`
+	Arrival       Position           `json:"arrival" default:"" bson:"arrival"`
+	Requirements  PortalRequirements `json:"requirements" default:"" bson:"requirements"`
+}
+
+// Quest must be turned in and Key_item held (it is not consumed) before the portal opens.
+type PortalRequirements struct {
+	Level    int    `json:"level" default:"0" bson:"level"`
+	Quest    string `json:"quest" default:"" bson:"quest"`
+	Key_item string `json:"key_item" default:"" bson:"keyItem"`
+}
+type TravelResult struct {
+	Portal_id string     `json:"portal_id" default:""`
+	Position  Position   `json:"position" default:""`
+	Region    RegionData `json:"region_data" default:""`
+}
+
+var DEFAULT_PORTAL_RADIUS = 5.0
+
+// validateWorldGraph checks that every portal leads to a cached level inside the region it names.
+func validateWorldGraph(levels map[string]Level, regions map[string]Region) []string {
+	var problems []string
+	for levelID, level := range levels {
+		for _, portal := range level.Portals {
+			target, found := levels[portal.Target_level]
+			if !found {
+				problems = append(problems, "portal "+portal.Portal_id+" in "+levelID+" leads to unknown level "+portal.Target_level)
+				continue
+			}
+			region, found := regions[portal.Target_region]
+			if !found || !containsString(region.Levels, target.LevelID) {
+				problems = append(problems, "portal "+portal.Portal_id+" in "+levelID+" names region "+portal.Target_region+" which does not contain "+target.LevelID)
+			}
+		}
+	}
+	return problems
+}
+func findPortal(level Level, portalID string) (Portal, bool) {
+	for _, portal := range level.Portals {
+		if portal.Portal_id == portalID {
+			return portal, true
+		}
+	}
+	return Portal{}, false
+}
+func distance(from Position, to Position) float64 {
+	dx := from.Position_x - to.Position_x
+	dy := from.Position_y - to.Position_y
+	dz := from.Position_z - to.Position_z
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// canUsePortal checks a player standing at position against a portal's range and requirements.
+func canUsePortal(portal Portal, profile *Profile, position Position) (bool, string) {
+	radius := portal.Radius
+	if radius <= 0 {
+		radius = DEFAULT_PORTAL_RADIUS
+	}
+	// NaN compares false with everything, so only a distance known to be in range passes
+	if reach := distance(position, portal.Position); !(reach <= radius) {
+		return false, "Too far from the portal"
+	}
+	if profile.Level < portal.Requirements.Level {
+		return false, "Level too low"
+	}
+	if portal.Requirements.Quest != "" && getQuestState(profile, portal.Requirements.Quest) != QUEST_TURNED_IN {
+		return false, "Quest not completed"
+	}
+	if portal.Requirements.Key_item != "" && countItem(profile.Items.Collection, portal.Requirements.Key_item) == 0 {
+		return false, "Missing key item"
+	}
+	return true, ""
+}
+
+func getRegionData(regionID string, levelID string, mongoClient *mongo.Client) RegionData {
+	world := currentWorld()
+	level := world.Level(levelID)
+	return RegionData{
+		Region:    world.Region(regionID),
+		LevelData: &LevelData{Level: level, Residents: getNPCs(level.Residents, mongoClient)},
+	}
+}
+
+// notifyLevelRoom tells everyone else in a level that a player left or entered it.
+func notifyLevelRoom(levelID string, accountID uuid.UUID, event string) {
+	for _, entry := range getOnlinePlayers() {
+		if entry.LevelID != levelID || entry.Account_id == accountID {
+			continue
+		}
+		packet := createSimpleDeliveryPacket(uuid.New().String(), "LN#", "LEVEL", "LEVEL$"+event+";"+accountID.String())
+		Push(entry.Account_id, packet)
+	}
+}
+
+// travel moves a player through a portal of their current level. The update only matches if the
+// player is still in the level the portal was checked against.
+func travel(accountID uuid.UUID, portalID string, mongoClient *mongo.Client) string {
+	profile, _ := getProfile(accountID, mongoClient)
+	if profile == nil {
+		return "TRAVEL$0;Profile does not exist"
+	}
+	level, found := currentWorld().Levels[profile.LastLevel]
+	if !found {
+		return "TRAVEL$0;Current level is unknown"
+	}
+	portal, found := findPortal(level, portalID)
+	if !found {
+		return "TRAVEL$0;No such portal here"
+	}
+	position := profile.LastPosition
+	if entry, online := getPlayerConnection(accountID); online && !entry.LastHeartbeat.IsZero() {
+		position = entry.LastPosition
+	}
+	if usable, reason := canUsePortal(portal, profile, position); !usable {
+		return "TRAVEL$0;" + reason
+	}
+	cxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	profiles := mongoClient.Database("player").Collection("profiles")
+	match := bson.M{"uuid": accountID, "last_level": profile.LastLevel}
+	change := bson.M{"$set": bson.M{"last_level": portal.Target_level, "last_region": portal.Target_region, "last_position": portal.Arrival}}
+	updateResponse, err := profiles.UpdateOne(cxt, match, change)
+	if err != nil {
+		fmt.Println(Failure(err))
+		return "TRAVEL$0;Travel failed"
+	}
+	if updateResponse.MatchedCount != 1 {
+		return "TRAVEL$0;Player moved before travelling"
+	}
+	setPlayerPosition(accountID, portal.Arrival)
+	setPresenceLevel(accountID, portal.Target_level, mongoClient)
+	broadcastPosition(accountID, portal.Target_level, portal.Arrival)
+	notifyLevelRoom(profile.LastLevel, accountID, "LEFT")
+	notifyLevelRoom(portal.Target_level, accountID, "ENTERED")
+	fmt.Println(Info("Player ", accountID, " travelled to ", portal.Target_level))
+	result := TravelResult{Portal_id: portalID, Position: portal.Arrival, Region: getRegionData(portal.Target_region, portal.Target_level, mongoClient)}
+	resultJSON, _ := json.Marshal(result)
+	return "TRAVEL$1;" + string(resultJSON)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCanUsePortal(t *testing.T) {
+	portal := Portal{
+		Portal_id:    "CaveGate",
+		Position:     Position{Position_x: 10, Position_z: 10},
+		Radius:       3,
+		Target_level: "Cave",
+		Requirements: PortalRequirements{Level: 3, Quest: "Lanterns", Key_item: "CaveKey"},
+	}
+	profile := &Profile{Level: 3, Quests: []QuestProgress{{Quest_id: "Lanterns", Status: QUEST_TURNED_IN}}}
+	profile.Items.Collection = []string{"CaveKey"}
+
+	if usable, reason := canUsePortal(portal, profile, Position{}); usable || reason != "Too far from the portal" {
+		t.Errorf("expected range check to fail, got %v %q", usable, reason)
+	}
+	for _, invalid := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if usable, reason := canUsePortal(portal, profile, Position{Position_x: invalid, Position_z: 10}); usable || reason != "Too far from the portal" {
+			t.Errorf("expected a position of %v to be out of range, got %v %q", invalid, usable, reason)
+		}
+	}
+	near := Position{Position_x: 11, Position_z: 9}
+	if usable, reason := canUsePortal(portal, profile, near); !usable {
+		t.Errorf("expected portal to be usable, got %q", reason)
+	}
+	profile.Items.Collection = nil
+	if usable, reason := canUsePortal(portal, profile, near); usable || reason != "Missing key item" {
+		t.Errorf("expected key item check to fail, got %v %q", usable, reason)
+	}
+	profile.Quests[0].Status = QUEST_COMPLETED
+	if usable, _ := canUsePortal(portal, profile, near); usable {
+		t.Errorf("expected quest that is not turned in to block the portal")
+	}
+}
+
+func TestIsPlausibleMove(t *testing.T) {
+	from := Position{}
+	if !isPlausibleMove(from, Position{Position_x: MAX_MOVE_SPEED}, moveBudget(0, time.Second)) {
+		t.Errorf("expected a move at max speed to be accepted")
+	}
+	if isPlausibleMove(from, Position{Position_x: 500}, moveBudget(MOVE_TOLERANCE, time.Second)) {
+		t.Errorf("expected a teleport to be rejected")
+	}
+}
+
+func TestValidateWorldGraph(t *testing.T) {
+	levels := map[string]Level{
+		"Town": {LevelID: "Town", Portals: []Portal{{Portal_id: "ToCave", Target_level: "Cave", Target_region: "Hills"}, {Portal_id: "ToVoid", Target_level: "Void"}}},
+		"Cave": {LevelID: "Cave", Portals: []Portal{{Portal_id: "ToTown", Target_level: "Town", Target_region: "Hills"}}},
+	}
+	regions := map[string]Region{"Hills": {RegionID: "Hills", Levels: []string{"Cave"}}}
+	if problems := validateWorldGraph(levels, regions); len(problems) != 2 {
+		t.Errorf("expected an unknown level and a region mismatch, got %v", problems)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// WorldData is one version of the master tables. A snapshot is never written to once it is served,
+// a reload builds a whole new one and swaps it in, so handlers take currentWorld() once per request
+// and see a consistent set of tables for all of it.
+type WorldData struct {
+	Version   string
+	Loaded_at time.Time
+	Items     map[string]Item
+	Spells    map[string]Spell
+	Monsters  map[string]Monster
+	Levels    map[string]Level
+	Regions   map[string]Region
+	Quests    map[string]Quest
+	Recipes   map[string]Recipe
+}
+
+// the world collections the snapshot is built from, a change to any of them triggers a reload
+var WORLD_COLLECTIONS = []string{"items", "spells", "monsters", "levels", "regions", "quests", "recipes"}
+
+// edits to world data tend to come in bursts, wait this long for them to settle before reloading once
+var WORLD_RELOAD_SETTLE = 2 * time.Second
+
+// how long to wait before reopening the change stream after it failed
+var WORLD_WATCH_RETRY = 30 * time.Second
+
+var worldData atomic.Value
+var worldReloadMutex sync.Mutex
+var emptyWorld = newWorldData()
+
+func newWorldData() *WorldData {
+	return &WorldData{
+		Items:    make(map[string]Item),
+		Spells:   make(map[string]Spell),
+		Monsters: make(map[string]Monster),
+		Levels:   make(map[string]Level),
+		Regions:  make(map[string]Region),
+		Quests:   make(map[string]Quest),
+		Recipes:  make(map[string]Recipe),
+	}
+}
+
+// currentWorld is the one accessor for master data, it is an empty snapshot until the first load.
+func currentWorld() *WorldData {
+	if world, ok := worldData.Load().(*WorldData); ok {
+		return world
+	}
+	return emptyWorld
+}
+func setWorldData(world *WorldData) {
+	worldData.Store(world)
+}
+
+// worldVersion hashes the tables, so a reload that changed nothing keeps its version and clients
+// can keep their caches across server restarts.
+func worldVersion(world *WorldData) string {
+	tables, _ := json.Marshal([]interface{}{world.Items, world.Spells, world.Monsters, world.Levels, world.Regions, world.Quests, world.Recipes})
+	checksum := sha256.Sum256(tables)
+	return hex.EncodeToString(checksum[:6])
+}
+
+// Level returns the level, or an empty one when it does not exist.
+func (world *WorldData) Level(levelID string) *Level {
+	level := world.Levels[levelID]
+	return &level
+}
+
+// Region returns the region, or an empty one when it does not exist.
+func (world *WorldData) Region(regionID string) *Region {
+	region := world.Regions[regionID]
+	return &region
+}
+func (world *WorldData) Item(itemID string) (Item, bool) {
+	item, found := world.Items[itemID]
+	return item, found
+}
+func (world *WorldData) Spell(spellID string) (*Spell, bool) {
+	spell, found := world.Spells[spellID]
+	if !found {
+		return nil, false
+	}
+	return &spell, true
+}
+
+// MonsterList looks up each distinct id once and skips the ones that do not exist.
+func (world *WorldData) MonsterList(monsterIDs []string) *[]Monster {
+	monsters := []Monster{}
+	seen := make(map[string]bool, len(monsterIDs))
+	for _, monsterID := range monsterIDs {
+		if monster, found := world.Monsters[monsterID]; found && !seen[monsterID] {
+			monsters = append(monsters, monster)
+			seen[monsterID] = true
+		}
+	}
+	return &monsters
+}
+func (world *WorldData) Summary() string {
+	return fmt.Sprintf("%d items, %d spells, %d monsters, %d levels, %d regions, %d quests, %d recipes",
+		len(world.Items), len(world.Spells), len(world.Monsters), len(world.Levels), len(world.Regions), len(world.Quests), len(world.Recipes))
+}
+
+// loadWorldData reads every world collection into a new snapshot. Unlike the single lookups it
+// returns errors instead of panicking, a failed reload must leave the served snapshot alone.
+func loadWorldData(mongoClient *mongo.Client) (*WorldData, error) {
+	cxt, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		return nil, err
+	}
+	database := mongoClient.Database("world")
+	var items []Item
+	var spells []Spell
+	var monsters []Monster
+	var levels []Level
+	var regions []Region
+	var quests []Quest
+	var recipes []Recipe
+	results := []interface{}{&items, &spells, &monsters, &levels, &regions, &quests, &recipes}
+	for index, collection := range WORLD_COLLECTIONS {
+		filterCursor, err := database.Collection(collection).Find(cxt, bson.D{})
+		if err != nil {
+			return nil, fmt.Errorf("%s : %w", collection, err)
+		}
+		if err = filterCursor.All(cxt, results[index]); err != nil {
+			return nil, fmt.Errorf("%s : %w", collection, err)
+		}
+	}
+	world := newWorldData()
+	world.Loaded_at = time.Now().UTC()
+	for _, item := range items {
+		world.Items[item.Item_id] = item
+	}
+	for _, spell := range spells {
+		world.Spells[spell.Spell_id] = spell
+	}
+	for _, monster := range monsters {
+		world.Monsters[monster.MobID] = monster
+	}
+	for _, level := range levels {
+		world.Levels[level.LevelID] = level
+	}
+	for _, region := range regions {
+		world.Regions[region.RegionID] = region
+	}
+	for _, quest := range quests {
+		world.Quests[quest.Quest_id] = quest
+	}
+	for _, recipe := range recipes {
+		world.Recipes[recipe.Recipe_id] = recipe
+	}
+	world.Version = worldVersion(world)
+	return world, nil
+}
+
+// reloadWorldData loads a fresh snapshot and swaps it in, reporting whether the version changed.
+// Reloads run one at a time so a slow load can never replace a newer snapshot.
+func reloadWorldData(mongoClient *mongo.Client) (*WorldData, bool, error) {
+	worldReloadMutex.Lock()
+	defer worldReloadMutex.Unlock()
+	world, err := loadWorldData(mongoClient)
+	if err != nil {
+		return currentWorld(), false, err
+	}
+	return world, installWorldData(world), nil
+}
+
+// installWorldData serves the snapshot and tells online players about it, unless it is the version
+// already being served.
+func installWorldData(world *WorldData) bool {
+	if world.Version == currentWorld().Version {
+		return false
+	}
+	for _, problem := range validateWorldGraph(world.Levels, world.Regions) {
+		fmt.Println(Warn("World graph : ", problem))
+	}
+	setWorldData(world)
+	fmt.Println(Success("World data version ", world.Version, " loaded : ", world.Summary()))
+	notifyWorldVersion(world.Version)
+	return true
+}
+func worldVersionContent(version string) string {
+	return "WORLD$1;" + version
+}
+
+// notifyWorldVersion pushes the new version to everyone online so clients drop cached world data.
+func notifyWorldVersion(version string) {
+	for _, entry := range getOnlinePlayers() {
+		packet := createSimpleDeliveryPacket(uuid.New().String(), "WV#", "WORLD", worldVersionContent(version))
+		Push(entry.Account_id, packet)
+	}
+}
+
+// watchWorldData reloads whenever a world collection changes. Change streams need a replica set,
+// without one this keeps retrying and the admin reload command is the only way to pick up edits.
+func watchWorldData(mongoClient *mongo.Client) {
+	for {
+		err := followWorldChanges(mongoClient)
+		fmt.Println(Warn("World data change stream stopped, retrying in ", WORLD_WATCH_RETRY, " : ", err))
+		time.Sleep(WORLD_WATCH_RETRY)
+		//changes made while the stream was down were missed, a reload catches up on them
+		if _, _, err := reloadWorldData(mongoClient); err != nil {
+			fmt.Println(Failure("World data reload failed : ", err))
+		}
+	}
+}
+func followWorldChanges(mongoClient *mongo.Client) error {
+	cxt := context.Background()
+	pipeline := mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{"ns.coll": bson.M{"$in": WORLD_COLLECTIONS}}}}}
+	stream, err := mongoClient.Database("world").Watch(cxt, pipeline)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(cxt)
+	for stream.Next(cxt) {
+		time.Sleep(WORLD_RELOAD_SETTLE)
+		for stream.TryNext(cxt) {
+		}
+		if stream.Err() != nil {
+			break
+		}
+		if _, _, err := reloadWorldData(mongoClient); err != nil {
+			fmt.Println(Failure("World data reload failed : ", err))
+		}
+	}
+	return stream.Err()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"CoGo/internal/pkg/client"
+
+	"github.com/google/uuid"
+)
+
+func TestWorldVersionFollowsContent(t *testing.T) {
+	first, second := newWorldData(), newWorldData()
+	for _, world := range []*WorldData{first, second} {
+		world.Items["Fang"] = Item{Item_id: "Fang", Name: "Fang"}
+		world.Levels["00001"] = Level{LevelID: "00001"}
+	}
+	if worldVersion(first) != worldVersion(second) {
+		t.Errorf("equal world data got different versions")
+	}
+	second.Items["Fang"] = Item{Item_id: "Fang", Name: "Sharp Fang"}
+	if worldVersion(first) == worldVersion(second) {
+		t.Errorf("changed world data kept its version")
+	}
+}
+
+func TestMonsterListSkipsUnknownAndDuplicates(t *testing.T) {
+	world := newWorldData()
+	world.Monsters["Wolf"] = Monster{MobID: "Wolf"}
+	world.Monsters["Slime"] = Monster{MobID: "Slime"}
+	monsters := *world.MonsterList([]string{"Wolf", "Ghost", "Slime", "Wolf"})
+	if len(monsters) != 2 || monsters[0].MobID != "Wolf" || monsters[1].MobID != "Slime" {
+		t.Errorf("unexpected monsters %+v", monsters)
+	}
+	if level := world.Level("missing"); level == nil || level.LevelID != "" {
+		t.Errorf("expected an empty level for unknown ids, got %+v", level)
+	}
+}
+
+func TestInstallWorldDataNotifiesOnlyOnChange(t *testing.T) {
+	defer setWorldData(currentWorld())
+	server, connection := net.Pipe()
+	sdk := client.New(connection)
+	defer sdk.Close()
+	accountID := uuid.New()
+	bindPlayerConnection(accountID, server)
+	defer dropPlayerConnection(getConnectionOf(t, accountID))
+
+	world := newWorldData()
+	world.Items["Fang"] = Item{Item_id: "Fang"}
+	world.Version = worldVersion(world)
+	if !installWorldData(world) || currentWorld() != world {
+		t.Fatalf("new world data was not installed")
+	}
+	if push := waitForPush(t, sdk); push.PacketCode != "WV#" || sdk.WorldVersion() != world.Version {
+		t.Fatalf("expected a push of version %s, got %+v", world.Version, push)
+	}
+
+	reloaded := newWorldData()
+	reloaded.Items["Fang"] = Item{Item_id: "Fang"}
+	reloaded.Version = worldVersion(reloaded)
+	if installWorldData(reloaded) || currentWorld() != world {
+		t.Errorf("an unchanged reload replaced the served snapshot")
+	}
+}
+func getConnectionOf(t *testing.T, accountID uuid.UUID) net.Conn {
+	entry, online := getPlayerConnection(accountID)
+	if !online {
+		t.Fatal("player is not online")
+	}
+	return entry.Connection
+}
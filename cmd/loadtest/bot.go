@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"CoGo/internal/pkg/client"
+
+	"github.com/google/uuid"
+)
+
+// bot is one simulated player. It keeps its own position so heartbeats stay within the server's
+// movement checks, corrections the server sends back show up as HC# pushes in the report.
+type bot struct {
+	id       int
+	scenario *Scenario
+	metrics  *Metrics
+	client   *client.Client
+	rng      *rand.Rand
+	position [3]float64
+}
+
+var errFailedResponse = errors.New("failed response")
+
+func runBot(cxt context.Context, id int, scenario *Scenario, metrics *Metrics) {
+	player := &bot{id: id, scenario: scenario, metrics: metrics, rng: rand.New(rand.NewSource(int64(id)))}
+	if err := player.connect(); err != nil {
+		fmt.Println("bot", id, "could not connect :", err)
+		metrics.RecordBot(false)
+		return
+	}
+	metrics.RecordBot(true)
+	defer player.client.Close()
+	go player.drainPushes(cxt)
+	for cxt.Err() == nil {
+		switch scenario.pickAction(player.rng) {
+		case ACTION_WALK:
+			player.walk(cxt)
+		case ACTION_BATTLE:
+			player.battle()
+		case ACTION_SHOP:
+			player.shop()
+		}
+		if player.client.Err() != nil {
+			fmt.Println("bot", id, "lost its connection :", player.client.Err())
+			return
+		}
+		player.sleep(cxt, scenario.ThinkTime.Duration)
+	}
+}
+func (player *bot) sleep(cxt context.Context, duration time.Duration) {
+	select {
+	case <-cxt.Done():
+	case <-time.After(duration):
+	}
+}
+func (player *bot) drainPushes(cxt context.Context) {
+	for {
+		select {
+		case <-cxt.Done():
+			return
+		case packet := <-player.client.Pushes:
+			player.metrics.RecordPush(packet.PacketCode)
+		}
+	}
+}
+
+// request times one request and counts it as an error when check rejects the response.
+func (player *bot) request(code string, check func(client.Packet) error, fields ...string) (client.Packet, error) {
+	start := time.Now()
+	packet, err := player.client.Request(code, fields...)
+	if err == nil && check != nil {
+		err = check(packet)
+	}
+	player.metrics.Record(code, time.Since(start), err)
+	return packet, err
+}
+func checkResult(packet client.Packet) error {
+	if ok, detail := packet.Result(); !ok {
+		return fmt.Errorf("%w : %s", errFailedResponse, detail)
+	}
+	return nil
+}
+
+// connect logs in and registers the bot's account first when it does not exist yet.
+func (player *bot) connect() error {
+	connection, err := client.Dial(player.scenario.Address)
+	if err != nil {
+		return err
+	}
+	player.client = connection
+	connection.Timeout = player.scenario.RequestTimeout.Duration
+	if player.scenario.Compression != "" {
+		player.request("ZN#", checkResult, uuid.Nil.String(), player.scenario.Compression)
+	}
+	username := fmt.Sprintf("%s%d", player.scenario.UsernamePrefix, player.id)
+	login, err := player.request("L0#", nil, username, player.scenario.Password)
+	if err != nil {
+		return err
+	}
+	if login.PacketCode == "LF#" {
+		registered, err := player.request("R0#", nil, username, player.scenario.Password)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(registered.Content, "RS#") {
+			return fmt.Errorf("registration failed : %s", registered.Content)
+		}
+		if login, err = player.request("L0#", nil, username, player.scenario.Password); err != nil {
+			return err
+		}
+	}
+	if login.PacketCode != "LS#" {
+		return fmt.Errorf("login failed : %s", login.Content)
+	}
+	if connection.AccountID == uuid.Nil {
+		return errors.New("server did not send an account id")
+	}
+	return nil
+}
+
+// walk sends a run of heartbeats along a random heading at the scenario's walking speed.
+func (player *bot) walk(cxt context.Context) {
+	heading := player.rng.Float64() * 2 * math.Pi
+	step := player.scenario.WalkSpeed * player.scenario.HeartbeatInterval.Seconds()
+	for i := 0; i < player.scenario.WalkSteps && cxt.Err() == nil; i++ {
+		player.position[0] += step * math.Cos(heading)
+		player.position[2] += step * math.Sin(heading)
+		start := time.Now()
+		err := player.client.Heartbeat(player.position[0], player.position[1], player.position[2])
+		player.metrics.Record("HB#", time.Since(start), err)
+		player.sleep(cxt, player.scenario.HeartbeatInterval.Duration)
+	}
+}
+
+// battle starts a fight in the scenario's level and reports every monster defeated.
+func (player *bot) battle() {
+	var battle struct {
+		BattleID uuid.UUID         `json:"battle_id"`
+		Monsters []json.RawMessage `json:"monsters"`
+	}
+	_, err := player.request("BR#", func(packet client.Packet) error {
+		return packet.Decode(&battle)
+	}, player.client.AccountID.String(), player.scenario.LevelID)
+	if err != nil || battle.BattleID == uuid.Nil {
+		return
+	}
+	defeated := make([]int, len(battle.Monsters))
+	for i := range defeated {
+		defeated[i] = 1
+	}
+	start := time.Now()
+	_, err = player.client.FinishBattle(battle.BattleID, defeated)
+	player.metrics.Record("BF#", time.Since(start), err)
+}
+
+// shop browses a shopkeeper and takes one item off its catalogue.
+func (player *bot) shop() {
+	var shopkeeper struct {
+		Catalogue []struct {
+			Item struct {
+				Item_id string `json:"item_id"`
+			} `json:"shop_item"`
+		} `json:"catalogue"`
+	}
+	npcID := player.scenario.Shopkeepers[player.rng.Intn(len(player.scenario.Shopkeepers))]
+	_, err := player.request("SH#", func(packet client.Packet) error {
+		return packet.Decode(&shopkeeper)
+	}, player.client.AccountID.String(), npcID)
+	if err != nil || len(shopkeeper.Catalogue) == 0 {
+		return
+	}
+	itemID := shopkeeper.Catalogue[player.rng.Intn(len(shopkeeper.Catalogue))].Item.Item_id
+	player.request("IA#", func(packet client.Packet) error {
+		if strings.Contains(packet.Content, "does not exist") {
+			return fmt.Errorf("%w : %s", errFailedResponse, packet.Content)
+		}
+		return nil
+	}, player.client.AccountID.String(), itemID)
+}
@@ -0,0 +1,61 @@
+// Command loadtest runs a swarm of headless bots against a game server, e.g.
+// go run ./cmd/loadtest -scenario cmd/loadtest/scenario.example.json
+// The bots all connect from one address, so list it in COGO_RATE_LIMIT_EXEMPT on the server
+// or the login and registration limits will refuse most of them.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+func main() {
+	scenarioPath := flag.String("scenario", "cmd/loadtest/scenario.example.json", "scenario file to run")
+	flag.Parse()
+	scenario, err := loadScenario(*scenarioPath)
+	if err != nil {
+		fmt.Println("invalid scenario :", err)
+		os.Exit(1)
+	}
+	fmt.Printf("running %s : %d bots against %s for %s\n", scenario.Name, scenario.Bots, scenario.Address, scenario.Duration.Duration)
+
+	cxt, cancel := context.WithTimeout(context.Background(), scenario.RampUp.Duration+scenario.Duration.Duration)
+	defer cancel()
+	cxt, stop := signal.NotifyContext(cxt, os.Interrupt)
+	defer stop()
+
+	var monitor *ResourceMonitor
+	if scenario.ServerPID > 0 {
+		monitor = &ResourceMonitor{pid: scenario.ServerPID}
+		go monitor.run(cxt)
+	}
+	metrics := newMetrics()
+	start := time.Now()
+	var wg sync.WaitGroup
+	//bots join evenly spread over the ramp up so logins do not all land at once
+	spacing := scenario.RampUp.Duration / time.Duration(scenario.Bots)
+	for id := 0; id < scenario.Bots && cxt.Err() == nil; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			runBot(cxt, id, scenario, metrics)
+		}(id)
+		select {
+		case <-cxt.Done():
+		case <-time.After(spacing):
+		}
+	}
+	wg.Wait()
+
+	fmt.Println()
+	metrics.Report(os.Stdout, time.Since(start))
+	if monitor != nil {
+		monitor.sample()
+		monitor.Report(os.Stdout)
+	}
+}
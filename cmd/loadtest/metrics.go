@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type OpcodeStats struct {
+	Count     int
+	Errors    int
+	Latencies []time.Duration
+}
+
+// Metrics collects what every bot saw. HB# has no response, its latency is the time to write it.
+type Metrics struct {
+	mutex       sync.Mutex
+	opcodes     map[string]*OpcodeStats
+	pushes      map[string]int
+	connected   int
+	failedBots  int
+	errorSample map[string]string
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{opcodes: make(map[string]*OpcodeStats), pushes: make(map[string]int), errorSample: make(map[string]string)}
+}
+func (metrics *Metrics) Record(code string, latency time.Duration, err error) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	stats, found := metrics.opcodes[code]
+	if !found {
+		stats = &OpcodeStats{}
+		metrics.opcodes[code] = stats
+	}
+	stats.Count++
+	if err != nil {
+		stats.Errors++
+		metrics.errorSample[code] = err.Error()
+		return
+	}
+	stats.Latencies = append(stats.Latencies, latency)
+}
+func (metrics *Metrics) RecordPush(code string) {
+	metrics.mutex.Lock()
+	metrics.pushes[code]++
+	metrics.mutex.Unlock()
+}
+func (metrics *Metrics) RecordBot(connected bool) {
+	metrics.mutex.Lock()
+	if connected {
+		metrics.connected++
+	} else {
+		metrics.failedBots++
+	}
+	metrics.mutex.Unlock()
+}
+
+// percentile picks the nearest rank out of latencies that are already sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+func (metrics *Metrics) Report(writer io.Writer, elapsed time.Duration) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	fmt.Fprintf(writer, "bots connected : %d, failed : %d, elapsed : %s\n\n", metrics.connected, metrics.failedBots, elapsed.Round(time.Millisecond))
+	codes := make([]string, 0, len(metrics.opcodes))
+	for code := range metrics.opcodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	table := tabwriter.NewWriter(writer, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "opcode\trequests\trate/s\terrors\terror %\tp50\tp90\tp99\tmax\t")
+	for _, code := range codes {
+		stats := metrics.opcodes[code]
+		sort.Slice(stats.Latencies, func(i, j int) bool { return stats.Latencies[i] < stats.Latencies[j] })
+		fmt.Fprintf(table, "%s\t%d\t%.1f\t%d\t%.2f\t%s\t%s\t%s\t%s\t\n", code, stats.Count, float64(stats.Count)/elapsed.Seconds(),
+			stats.Errors, 100*float64(stats.Errors)/float64(stats.Count),
+			formatLatency(percentile(stats.Latencies, 50)), formatLatency(percentile(stats.Latencies, 90)),
+			formatLatency(percentile(stats.Latencies, 99)), formatLatency(percentile(stats.Latencies, 100)))
+	}
+	table.Flush()
+	for _, code := range codes {
+		if sample, found := metrics.errorSample[code]; found {
+			fmt.Fprintf(writer, "last %s error : %s\n", code, sample)
+		}
+	}
+	if len(metrics.pushes) > 0 {
+		fmt.Fprint(writer, "\npushes received :")
+		pushCodes := make([]string, 0, len(metrics.pushes))
+		for code := range metrics.pushes {
+			pushCodes = append(pushCodes, code)
+		}
+		sort.Strings(pushCodes)
+		for _, code := range pushCodes {
+			fmt.Fprintf(writer, " %s %d", code, metrics.pushes[code])
+		}
+		fmt.Fprintln(writer)
+	}
+}
+func formatLatency(latency time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(latency)/float64(time.Millisecond))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, expected := range map[float64]time.Duration{50: 50 * time.Millisecond, 99: 99 * time.Millisecond, 100: 100 * time.Millisecond, 0: time.Millisecond} {
+		if actual := percentile(latencies, p); actual != expected {
+			t.Errorf("p%v was %s, expected %s", p, actual, expected)
+		}
+	}
+	if percentile(nil, 50) != 0 {
+		t.Errorf("empty latencies should report zero")
+	}
+}
+
+func TestMetricsReport(t *testing.T) {
+	metrics := newMetrics()
+	metrics.Record("BR#", 20*time.Millisecond, nil)
+	metrics.Record("BR#", 0, errors.New("timed out"))
+	metrics.RecordPush("HC#")
+	var report strings.Builder
+	metrics.Report(&report, time.Second)
+	for _, expected := range []string{"BR#", "50.00", "20.0ms", "last BR# error : timed out", "HC# 1"} {
+		if !strings.Contains(report.String(), expected) {
+			t.Errorf("report is missing %q:\n%s", expected, report.String())
+		}
+	}
+}
+
+func TestSampleProcess(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource sampling reads /proc")
+	}
+	sample, err := sampleProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sample.RSSBytes <= 0 || sample.Threads < 1 {
+		t.Errorf("unexpected sample %+v", sample)
+	}
+}
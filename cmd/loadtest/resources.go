@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CLOCK_TICKS is the kernel's USER_HZ, which is 100 on every Linux build the server runs on.
+var CLOCK_TICKS = 100.0
+var RESOURCE_SAMPLE_INTERVAL = time.Second
+
+type ProcessSample struct {
+	At         time.Time
+	CPUSeconds float64
+	RSSBytes   int64
+	Threads    int
+}
+
+// sampleProcess reads a process' CPU time, resident memory and thread count from /proc, so
+// resource sampling only works on Linux with the server on the same host.
+func sampleProcess(pid int) (ProcessSample, error) {
+	sample := ProcessSample{At: time.Now()}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return sample, err
+	}
+	//the command name can hold spaces, the fixed fields start after its closing parenthesis
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	if len(fields) < 13 {
+		return sample, fmt.Errorf("unexpected stat format for pid %d", pid)
+	}
+	userTicks, _ := strconv.ParseFloat(fields[11], 64)
+	systemTicks, _ := strconv.ParseFloat(fields[12], 64)
+	sample.CPUSeconds = (userTicks + systemTicks) / CLOCK_TICKS
+	status, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return sample, err
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		key, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "VmRSS":
+			kilobytes, _ := strconv.ParseInt(strings.TrimSuffix(value, " kB"), 10, 64)
+			sample.RSSBytes = kilobytes * 1024
+		case "Threads":
+			sample.Threads, _ = strconv.Atoi(value)
+		}
+	}
+	return sample, nil
+}
+
+type ResourceMonitor struct {
+	mutex   sync.Mutex
+	pid     int
+	first   ProcessSample
+	last    ProcessSample
+	peakRSS int64
+	threads int
+	err     error
+}
+
+// run samples the server until the context ends.
+func (monitor *ResourceMonitor) run(cxt context.Context) {
+	ticker := time.NewTicker(RESOURCE_SAMPLE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		monitor.sample()
+		select {
+		case <-cxt.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+func (monitor *ResourceMonitor) sample() {
+	sample, err := sampleProcess(monitor.pid)
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	if err != nil {
+		monitor.err = err
+		return
+	}
+	if monitor.first.At.IsZero() {
+		monitor.first = sample
+	}
+	monitor.last = sample
+	if sample.RSSBytes > monitor.peakRSS {
+		monitor.peakRSS = sample.RSSBytes
+	}
+	if sample.Threads > monitor.threads {
+		monitor.threads = sample.Threads
+	}
+}
+func (monitor *ResourceMonitor) Report(writer io.Writer) {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	if monitor.first.At.IsZero() {
+		fmt.Fprintf(writer, "\nserver resources unavailable : %v\n", monitor.err)
+		return
+	}
+	cpu := 0.0
+	if elapsed := monitor.last.At.Sub(monitor.first.At).Seconds(); elapsed > 0 {
+		cpu = 100 * (monitor.last.CPUSeconds - monitor.first.CPUSeconds) / elapsed
+	}
+	fmt.Fprintf(writer, "\nserver pid %d : average cpu %.1f%%, peak rss %.1f MiB, peak threads %d\n",
+		monitor.pid, cpu, float64(monitor.peakRSS)/(1<<20), monitor.threads)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+// Duration reads "30s" style strings out of the scenario file.
+type Duration struct {
+	time.Duration
+}
+
+func (duration *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	duration.Duration = parsed
+	return nil
+}
+
+const (
+	ACTION_WALK   = "walk"
+	ACTION_BATTLE = "battle"
+	ACTION_SHOP   = "shop"
+)
+
+// Scenario describes one load test run. Actions weights how often a bot picks each action
+// between think times, e.g. {"walk": 6, "battle": 3, "shop": 1}.
+type Scenario struct {
+	Name              string         `json:"name"`
+	Address           string         `json:"address"`
+	Bots              int            `json:"bots"`
+	RampUp            Duration       `json:"ramp_up"`
+	Duration          Duration       `json:"duration"`
+	ThinkTime         Duration       `json:"think_time"`
+	RequestTimeout    Duration       `json:"request_timeout"`
+	UsernamePrefix    string         `json:"username_prefix"`
+	Password          string         `json:"password"`
+	Compression       string         `json:"compression"`
+	LevelID           string         `json:"level_id"`
+	Shopkeepers       []string       `json:"shopkeepers"`
+	Actions           map[string]int `json:"actions"`
+	WalkSteps         int            `json:"walk_steps"`
+	WalkSpeed         float64        `json:"walk_speed"`
+	HeartbeatInterval Duration       `json:"heartbeat_interval"`
+	// pid of a server on the same host, its CPU, memory and threads are sampled while the test runs
+	ServerPID int `json:"server_pid"`
+}
+
+func defaultScenario() Scenario {
+	return Scenario{
+		Name:              "default",
+		Address:           "localhost:20001",
+		Bots:              10,
+		RampUp:            Duration{10 * time.Second},
+		Duration:          Duration{time.Minute},
+		ThinkTime:         Duration{time.Second},
+		RequestTimeout:    Duration{5 * time.Second},
+		UsernamePrefix:    "loadbot",
+		Password:          "LoadBot-Password-1",
+		LevelID:           "00001",
+		Actions:           map[string]int{ACTION_WALK: 6, ACTION_BATTLE: 3, ACTION_SHOP: 1},
+		WalkSteps:         10,
+		WalkSpeed:         5,
+		HeartbeatInterval: Duration{500 * time.Millisecond},
+	}
+}
+
+// loadScenario reads a scenario file, anything it leaves out keeps its default.
+func loadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scenario := defaultScenario()
+	//json merges into an existing map, the file's actions replace the default mix instead
+	defaultActions := scenario.Actions
+	scenario.Actions = nil
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if scenario.Actions == nil {
+		scenario.Actions = defaultActions
+	}
+	return &scenario, scenario.validate()
+}
+func (scenario *Scenario) validate() error {
+	if scenario.Bots < 1 {
+		return errors.New("bots must be at least 1")
+	}
+	if scenario.Duration.Duration <= 0 {
+		return errors.New("duration must be positive")
+	}
+	total := 0
+	for action, weight := range scenario.Actions {
+		if action != ACTION_WALK && action != ACTION_BATTLE && action != ACTION_SHOP {
+			return fmt.Errorf("unknown action %q", action)
+		}
+		if weight < 0 {
+			return fmt.Errorf("action %q has a negative weight", action)
+		}
+		total += weight
+	}
+	if total == 0 {
+		return errors.New("at least one action needs a weight")
+	}
+	if scenario.Actions[ACTION_SHOP] > 0 && len(scenario.Shopkeepers) == 0 {
+		return errors.New("shop actions need at least one shopkeeper")
+	}
+	return nil
+}
+
+// pickAction draws an action with probability proportional to its weight.
+func (scenario *Scenario) pickAction(rng *rand.Rand) string {
+	actions := make([]string, 0, len(scenario.Actions))
+	total := 0
+	for action, weight := range scenario.Actions {
+		actions = append(actions, action)
+		total += weight
+	}
+	//map order is random, sort so a seeded rng always draws the same sequence
+	sort.Strings(actions)
+	roll := rng.Intn(total)
+	for _, action := range actions {
+		roll -= scenario.Actions[action]
+		if roll < 0 {
+			return action
+		}
+	}
+	return actions[len(actions)-1]
+}
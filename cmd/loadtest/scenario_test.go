@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadScenarioKeepsDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	os.WriteFile(path, []byte(`{"bots": 3, "duration": "90s", "actions": {"walk": 1}}`), 0o644)
+	scenario, err := loadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scenario.Bots != 3 || scenario.Duration.Duration != 90*time.Second || scenario.ThinkTime.Duration != time.Second || scenario.Address != "localhost:20001" {
+		t.Errorf("unexpected scenario %+v", scenario)
+	}
+}
+
+func TestExampleScenarioIsValid(t *testing.T) {
+	if _, err := loadScenario("scenario.example.json"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScenarioValidation(t *testing.T) {
+	for name, change := range map[string]func(*Scenario){
+		"no bots":          func(scenario *Scenario) { scenario.Bots = 0 },
+		"unknown action":   func(scenario *Scenario) { scenario.Actions = map[string]int{"dance": 1} },
+		"no weights":       func(scenario *Scenario) { scenario.Actions = map[string]int{ACTION_WALK: 0} },
+		"shop without npc": func(scenario *Scenario) { scenario.Shopkeepers = nil },
+	} {
+		scenario := defaultScenario()
+		scenario.Shopkeepers = []string{"00001"}
+		change(&scenario)
+		if scenario.validate() == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
+
+func TestPickActionFollowsWeights(t *testing.T) {
+	scenario := defaultScenario()
+	scenario.Actions = map[string]int{ACTION_WALK: 3, ACTION_BATTLE: 1, ACTION_SHOP: 0}
+	rng := rand.New(rand.NewSource(1))
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		counts[scenario.pickAction(rng)]++
+	}
+	if counts[ACTION_SHOP] != 0 || counts[ACTION_WALK] < 2800 || counts[ACTION_WALK] > 3200 {
+		t.Errorf("unexpected action counts %v", counts)
+	}
+}
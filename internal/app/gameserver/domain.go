@@ -185,8 +185,11 @@ type Map struct {
 	ConnectedClients map[uuid.UUID]Client `json:"connected_clients" bson:"connected_clients, omitempty"`
 }
 type Party struct {
-	Party_id uuid.UUID   `json:"party_id" bson:"party_id,omitempty"`
-	Members  []uuid.UUID `json:"members" bson:"members,omitempty"`
+	Party_id  uuid.UUID   `json:"party_id" bson:"party_id,omitempty"`
+	Leader_id uuid.UUID   `json:"leader_id" bson:"leader_id,omitempty"`
+	Members   []uuid.UUID `json:"members" bson:"members,omitempty"`
+	Invites   []uuid.UUID `json:"invites" bson:"invites,omitempty"`
+	SplitRule string      `json:"split_rule" default:"even" bson:"split_rule,omitempty"`
 }
 
 type Region struct {
@@ -0,0 +1,228 @@
+// Package client speaks the game server's TCP protocol. It is meant for bots, load tests and the
+// protocol conformance suite, not for shipping to players.
+package client
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrTimeout = errors.New("timed out waiting for a response")
+var ErrClosed = errors.New("client is closed")
+
+// Client sends requests and matches responses to them by request id. Unsolicited pushes (party,
+// chat, trade, interest events...) are delivered on Pushes. AccountID is filled in from the LA#
+// packet the server sends ahead of a successful login, or can be set directly. The world data
+// version comes with the login too and again on every change, WV# pushes still reach Pushes so
+// callers can drop their cached world data.
+type Client struct {
+	AccountID uuid.UUID
+	// how long Request waits before sending an SOS and then how long it waits again before giving up
+	Timeout time.Duration
+	// when set, every response is acknowledged with OK# so the server drops it from its resend cache
+	AutoAck bool
+	Pushes  chan Packet
+
+	connection net.Conn
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	pending    map[uuid.UUID]chan Packet
+	messages   map[uuid.UUID]*reassembly
+	version    string
+	// the account id announced by LA# during the last login
+	loginAccount uuid.UUID
+	closed       chan struct{}
+	err          error
+}
+
+var DEFAULT_TIMEOUT = 10 * time.Second
+var PUSH_BUFFER = 256
+
+// Dial connects to a server, e.g. Dial("localhost:20001").
+func Dial(address string) (*Client, error) {
+	connection, err := net.DialTimeout("tcp", address, DEFAULT_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	return New(connection), nil
+}
+
+// New wraps an existing connection, which is how the conformance suite talks to an in-process server.
+func New(connection net.Conn) *Client {
+	client := &Client{
+		Timeout:    DEFAULT_TIMEOUT,
+		AutoAck:    true,
+		Pushes:     make(chan Packet, PUSH_BUFFER),
+		connection: connection,
+		pending:    make(map[uuid.UUID]chan Packet),
+		messages:   make(map[uuid.UUID]*reassembly),
+		closed:     make(chan struct{}),
+	}
+	go client.readLoop()
+	return client
+}
+func (client *Client) Close() error {
+	return client.connection.Close()
+}
+
+// WorldVersion is the last world data version the server announced, "" before logging in.
+func (client *Client) WorldVersion() string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.version
+}
+
+// Err is the error that stopped the read loop, nil while the client is running.
+func (client *Client) Err() error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.err
+}
+
+// Send writes a request line without waiting for anything back.
+func (client *Client) Send(code string, fields ...string) error {
+	client.writeMutex.Lock()
+	defer client.writeMutex.Unlock()
+	_, err := client.connection.Write([]byte(EncodeRequest(code, fields...)))
+	return err
+}
+
+// Request sends code with a fresh request id in front of fields and waits for the matching response.
+// If nothing arrives within Timeout an SOS is sent once to have the server resend it.
+func (client *Client) Request(code string, fields ...string) (Packet, error) {
+	requestID := uuid.New()
+	response := make(chan Packet, 1)
+	client.mutex.Lock()
+	client.pending[requestID] = response
+	client.mutex.Unlock()
+	defer func() {
+		client.mutex.Lock()
+		delete(client.pending, requestID)
+		client.mutex.Unlock()
+	}()
+	if err := client.Send(code, append([]string{requestID.String()}, fields...)...); err != nil {
+		return Packet{}, err
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		select {
+		case packet := <-response:
+			if packet.PacketCode == "LS#" {
+				client.mutex.Lock()
+				client.AccountID = client.loginAccount
+				client.mutex.Unlock()
+			}
+			if client.AutoAck {
+				client.Send("OK#", requestID.String(), client.AccountID.String())
+			}
+			return packet, nil
+		case <-client.closed:
+			return Packet{}, ErrClosed
+		case <-time.After(client.Timeout):
+			if attempt == 0 {
+				client.Send("SOS#", requestID.String(), client.AccountID.String())
+			}
+		}
+	}
+	return Packet{}, ErrTimeout
+}
+func (client *Client) readLoop() {
+	reader := bufio.NewReader(client.connection)
+	defer close(client.closed)
+	for {
+		frame, err := ReadFrame(reader)
+		if err != nil {
+			client.mutex.Lock()
+			client.err = err
+			client.mutex.Unlock()
+			client.connection.Close()
+			return
+		}
+		if frame.Fragment != nil {
+			client.addFragment(frame.Fragment)
+			continue
+		}
+		client.deliver(frame.Packet)
+	}
+}
+func (client *Client) addFragment(fragment *Fragment) {
+	client.mutex.Lock()
+	message, found := client.messages[fragment.Message_id]
+	if !found {
+		message = &reassembly{}
+		client.messages[fragment.Message_id] = message
+	}
+	if !message.add(fragment) {
+		client.mutex.Unlock()
+		return
+	}
+	delete(client.messages, fragment.Message_id)
+	client.mutex.Unlock()
+	data, err := message.bytes()
+	if err != nil {
+		// ask for the whole message again rather than guess which fragment was damaged
+		client.Send("FQ#", uuid.New().String(), client.AccountID.String(), fragment.Message_id.String(), "")
+		return
+	}
+	if packet, err := DecodeMessage(data); err == nil {
+		client.deliver(packet)
+	}
+}
+
+// MissingFragments lists the fragment indices still outstanding for a partially received message.
+func (client *Client) MissingFragments(messageID uuid.UUID) []int {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if message, found := client.messages[messageID]; found {
+		return message.missing()
+	}
+	return nil
+}
+
+// RequestFragments asks the server to resend only the listed fragments of a chained message.
+func (client *Client) RequestFragments(messageID uuid.UUID, indices []int) error {
+	fields := make([]string, len(indices))
+	for i, index := range indices {
+		fields[i] = itoa(index)
+	}
+	return client.Send("FQ#", uuid.New().String(), client.AccountID.String(), messageID.String(), strings.Join(fields, ","))
+}
+func (client *Client) deliver(packet Packet) {
+	if packet.PacketCode == "LA#" {
+		//Request copies it to AccountID on the caller's goroutine once LS# arrives, the read loop never writes the field
+		if ok, accountIDSTR := packet.Result(); ok {
+			client.mutex.Lock()
+			client.loginAccount, _ = uuid.Parse(accountIDSTR)
+			client.mutex.Unlock()
+		}
+		return
+	}
+	client.mutex.Lock()
+	if ok, version := packet.Result(); ok && packet.PacketCode == "WV#" {
+		client.version = version
+	}
+	response, found := client.pending[packet.PacketID]
+	client.mutex.Unlock()
+	if found {
+		select {
+		case response <- packet:
+		default:
+		}
+		return
+	}
+	select {
+	case client.Pushes <- packet:
+	default:
+		// nobody is draining pushes, drop the oldest so the read loop never blocks
+		select {
+		case <-client.Pushes:
+		default:
+		}
+		client.Pushes <- packet
+	}
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLoginSetsAccountIDAndWorldVersion(t *testing.T) {
+	server, connection := net.Pipe()
+	defer server.Close()
+	client := New(connection)
+	defer client.Close()
+	accountID := uuid.New()
+
+	go func() {
+		request, _ := bufio.NewReader(server).ReadString('\n')
+		requestID, _ := uuid.Parse(strings.Split(strings.TrimPrefix(request, "L0#"), "?")[0])
+		server.Write([]byte(simpleFrame(Packet{PacketID: uuid.New(), PacketCode: "LA#", ServiceType: "LOGIN", Content: "LOGIN$1;" + accountID.String()})))
+		server.Write([]byte(simpleFrame(Packet{PacketID: uuid.New(), PacketCode: "WV#", ServiceType: "WORLD", Content: "WORLD$1;0a1b2c3d4e5f"})))
+		server.Write([]byte(simpleFrame(Packet{PacketID: requestID, PacketCode: "LS#", ServiceType: "LSP", Content: "{}"})))
+		// drain the acknowledgement
+		bufio.NewReader(server).ReadString('\n')
+	}()
+	response, err := client.Login("wizard", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.PacketCode != "LS#" || client.AccountID != accountID || client.WorldVersion() != "0a1b2c3d4e5f" {
+		t.Errorf("unexpected login %+v for account %s at world version %q", response, client.AccountID, client.WorldVersion())
+	}
+	// the version is still pushed so callers can react to it, the account packet is not
+	if push := <-client.Pushes; push.PacketCode != "WV#" {
+		t.Errorf("expected the world version push, got %+v", push)
+	}
+	select {
+	case push := <-client.Pushes:
+		t.Errorf("account packet leaked to pushes: %+v", push)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
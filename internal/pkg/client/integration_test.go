@@ -0,0 +1,84 @@
+//go:build integration
+
+package client
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Run against a live server and database with
+// COGO_SERVER_ADDR=localhost:20001 go test -tags integration ./internal/pkg/client
+func dialIntegrationServer(t *testing.T) *Client {
+	address := os.Getenv("COGO_SERVER_ADDR")
+	if address == "" {
+		t.Skip("COGO_SERVER_ADDR is not set")
+	}
+	client, err := Dial(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Timeout = 5 * time.Second
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestIntegrationRegisterAndLogin(t *testing.T) {
+	client := dialIntegrationServer(t)
+	username, password := "sdk"+strings.ReplaceAll(uuid.NewString(), "-", "")[:12], uuid.NewString()
+
+	registered, err := client.Register(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered.PacketCode != "R0#" || !strings.HasPrefix(registered.Content, "RS#") {
+		t.Fatalf("registration failed: %+v", registered)
+	}
+	duplicate, err := client.Register(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(duplicate.Content, "RF#") {
+		t.Errorf("duplicate registration was accepted: %+v", duplicate)
+	}
+
+	loggedIn, err := client.Login(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loggedIn.PacketCode != "LS#" || !loggedIn.Chain {
+		t.Fatalf("login failed: %+v", loggedIn)
+	}
+	if client.AccountID == uuid.Nil {
+		t.Errorf("login did not report the account id")
+	}
+	var level struct {
+		Level_id string `json:"level_id"`
+	}
+	if err := loggedIn.Decode(&level); err != nil {
+		t.Errorf("login level did not decode: %v", err)
+	}
+}
+
+func TestIntegrationUnknownLogin(t *testing.T) {
+	client := dialIntegrationServer(t)
+	response, err := client.Login("sdk-"+uuid.NewString(), "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.PacketCode != "LF#" {
+		t.Errorf("unknown user logged in: %+v", response)
+	}
+}
+
+func TestIntegrationCompression(t *testing.T) {
+	client := dialIntegrationServer(t)
+	codec, err := client.NegotiateCompression("zstd,snappy")
+	if err != nil || codec != "zstd" {
+		t.Errorf("unexpected codec %q (%v)", codec, err)
+	}
+}
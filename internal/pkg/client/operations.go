@@ -0,0 +1,123 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+func itoa(value int) string {
+	return strconv.Itoa(value)
+}
+
+// Login answers LS# with the starting level on success and LF# on failure. AccountID is set by
+// the time a successful login returns.
+func (client *Client) Login(username string, password string) (Packet, error) {
+	return client.Request("L0#", username, password)
+}
+
+// Register answers with a packet whose content starts with RS# on success and RF# when the name is taken.
+func (client *Client) Register(username string, password string) (Packet, error) {
+	return client.Request("R0#", username, password)
+}
+func (client *Client) LoadLevel(levelID string) (Packet, error) {
+	return client.Request("LL#", client.AccountID.String(), levelID)
+}
+func (client *Client) LoadRegion(regionID string, levelID string) (Packet, error) {
+	return client.Request("RLL#", client.AccountID.String(), regionID, levelID)
+}
+func (client *Client) Travel(portalID string) (Packet, error) {
+	return client.Request("TV#", client.AccountID.String(), portalID)
+}
+
+// FetchWorldVersion asks for the world data version being served, it also updates WorldVersion.
+func (client *Client) FetchWorldVersion() (string, error) {
+	response, err := client.Request("WV#", client.AccountID.String())
+	if err != nil {
+		return "", err
+	}
+	if ok, version := response.Result(); ok {
+		return version, nil
+	}
+	return "", fmt.Errorf("unexpected world version response %q", response.Content)
+}
+
+// Heartbeat reports the player's position, the server does not answer it.
+func (client *Client) Heartbeat(x float64, y float64, z float64) error {
+	return client.Send("HB#", client.AccountID.String(), formatFloat(x), formatFloat(y), formatFloat(z))
+}
+
+// AckSnapshot acknowledges the newest SN# snapshot received, later ones are deltas against it.
+func (client *Client) AckSnapshot(sequence uint32) error {
+	return client.Send("SA#", client.AccountID.String(), strconv.FormatUint(uint64(sequence), 10))
+}
+func (client *Client) Profile() (Packet, error) {
+	return client.Request("PR#", client.AccountID.String())
+}
+
+// ReadyForBattle starts a battle in a level, the BattlePacket holds the battle id for FinishBattle.
+func (client *Client) ReadyForBattle(levelID string) (Packet, error) {
+	return client.Request("BR#", client.AccountID.String(), levelID)
+}
+
+// FinishBattle reports which monsters were defeated, one 0/1 entry per monster.
+func (client *Client) FinishBattle(battleID uuid.UUID, defeated []int) (Packet, error) {
+	matrix := "["
+	for i, value := range defeated {
+		if i > 0 {
+			matrix += ","
+		}
+		matrix += itoa(value)
+	}
+	return client.Request("BF#", client.AccountID.String(), battleID.String(), matrix+"]")
+}
+func (client *Client) CastSpell(battleID uuid.UUID, spellID string) (Packet, error) {
+	return client.Request("BC#", client.AccountID.String(), battleID.String(), spellID)
+}
+
+// UseItem uses a consumable, battleID is uuid.Nil outside of battles.
+func (client *Client) UseItem(itemID string, battleID uuid.UUID) (Packet, error) {
+	battle := ""
+	if battleID != uuid.Nil {
+		battle = battleID.String()
+	}
+	return client.Request("UI#", client.AccountID.String(), itemID, battle)
+}
+func (client *Client) AddItem(itemID string) (Packet, error) {
+	return client.Request("IA#", client.AccountID.String(), itemID)
+}
+func (client *Client) Equip(itemID string) (Packet, error) {
+	return client.Request("LE#", client.AccountID.String(), itemID)
+}
+func (client *Client) Unequip(itemID string) (Packet, error) {
+	return client.Request("LUE#", client.AccountID.String(), itemID)
+}
+func (client *Client) Shopkeeper(npcID string) (Packet, error) {
+	return client.Request("SH#", client.AccountID.String(), npcID)
+}
+func (client *Client) Craft(recipeID string) (Packet, error) {
+	return client.Request("CR#", client.AccountID.String(), recipeID)
+}
+func (client *Client) SearchMarket(itemType string, itemSubtype string, name string) (Packet, error) {
+	return client.Request("MS#", client.AccountID.String(), itemType, itemSubtype, name)
+}
+func (client *Client) Mailbox() (Packet, error) {
+	return client.Request("MM#", client.AccountID.String())
+}
+
+// NegotiateCompression offers codecs in preference order, e.g. "zstd,snappy", and returns the one chosen.
+func (client *Client) NegotiateCompression(codecs string) (string, error) {
+	packet, err := client.Request("ZN#", client.AccountID.String(), codecs)
+	if err != nil {
+		return "", err
+	}
+	ok, codec := packet.Result()
+	if !ok {
+		return "", fmt.Errorf("compression negotiation failed: %s", packet.Content)
+	}
+	return codec, nil
+}
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,278 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/golang/snappy"
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Packet is the envelope every server response and push arrives in.
+type Packet struct {
+	PacketID    uuid.UUID `json:"packet_id"`
+	PacketCode  string    `json:"packet_code"`
+	Chain       bool      `json:"chain"`
+	ServiceType string    `json:"service_type"`
+	Content     string    `json:"content"`
+}
+
+// Payload returns the content of a chained packet without the @"..."@ wrapping the server puts around JSON.
+func (packet Packet) Payload() []byte {
+	content := packet.Content
+	if strings.HasPrefix(content, "@\"") && strings.HasSuffix(content, "\"@") {
+		content = content[2 : len(content)-2]
+	}
+	return []byte(content)
+}
+
+// Decode unmarshals the JSON payload of a chained packet.
+func (packet Packet) Decode(value interface{}) error {
+	return json.Unmarshal(packet.Payload(), value)
+}
+
+// Result splits simple "SERVICE$status;detail" contents, ok is true for status 1.
+func (packet Packet) Result() (ok bool, detail string) {
+	status, detail, _ := strings.Cut(packet.Content, ";")
+	return strings.HasSuffix(status, "$1"), detail
+}
+
+// Frame is one unit read off the wire: a whole simple packet or one fragment of a chained one.
+type Frame struct {
+	Code     string
+	Packet   Packet
+	Fragment *Fragment
+}
+
+type Fragment struct {
+	Base       string
+	Message_id uuid.UUID
+	Index      int
+	Count      int
+	CRC        uint32
+	Data       []byte
+}
+
+// FragmentMarker ends the code of a fragment, "*" never appears in a packet code.
+const FragmentMarker = "*#"
+
+var ErrMalformedFrame = errors.New("malformed frame")
+var ErrChecksum = errors.New("message checksum does not match")
+var ErrUnknownCodec = errors.New("unknown compression codec")
+
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// ReadFrame reads the next frame. Simple packets are "CODE#?{json}" with the JSON escaped by
+// strconv.QuoteToASCII, fragments are "<base>*#id?index?count?crc?length?data".
+func ReadFrame(reader *bufio.Reader) (Frame, error) {
+	code, err := reader.ReadString('#')
+	if err != nil {
+		return Frame{}, err
+	}
+	code = strings.TrimLeft(code, "\r\n ")
+	if strings.HasSuffix(code, FragmentMarker) {
+		fragment, err := readFragment(reader, strings.TrimSuffix(code, FragmentMarker))
+		return Frame{Code: code, Fragment: fragment}, err
+	}
+	if separator, err := reader.ReadByte(); err != nil || separator != '?' {
+		return Frame{Code: code}, ErrMalformedFrame
+	}
+	packetJSON, err := readEscapedObject(reader)
+	if err != nil {
+		return Frame{Code: code}, err
+	}
+	var packet Packet
+	if err := json.Unmarshal(packetJSON, &packet); err != nil {
+		return Frame{Code: code}, err
+	}
+	return Frame{Code: code, Packet: packet}, nil
+}
+func readFragment(reader *bufio.Reader, base string) (*Fragment, error) {
+	fields := make([]string, 5)
+	for i := range fields {
+		field, err := reader.ReadString('?')
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = strings.TrimSuffix(field, "?")
+	}
+	messageID, idErr := uuid.Parse(fields[0])
+	index, indexErr := strconv.Atoi(fields[1])
+	count, countErr := strconv.Atoi(fields[2])
+	checksum, checksumErr := strconv.ParseUint(fields[3], 16, 32)
+	length, lengthErr := strconv.Atoi(fields[4])
+	if idErr != nil || indexErr != nil || countErr != nil || checksumErr != nil || lengthErr != nil || index < 1 || index > count || length < 0 {
+		return nil, ErrMalformedFrame
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	return &Fragment{Base: base, Message_id: messageID, Index: index, Count: count, CRC: uint32(checksum), Data: data}, nil
+}
+
+// readEscapedObject reads one QuoteToASCII escaped JSON object and returns it unescaped.
+func readEscapedObject(reader *bufio.Reader) ([]byte, error) {
+	var object bytes.Buffer
+	depth, inString, escaped := 0, false, false
+	for {
+		char, err := readUnescapedRune(reader)
+		if err != nil {
+			return nil, err
+		}
+		object.WriteRune(char)
+		switch {
+		case object.Len() == 1 && char != '{':
+			return nil, ErrMalformedFrame
+		case inString && escaped:
+			escaped = false
+		case inString && char == '\\':
+			escaped = true
+		case char == '"':
+			inString = !inString
+		case !inString && (char == '{' || char == '['):
+			depth++
+		case !inString && (char == '}' || char == ']'):
+			depth--
+			if depth == 0 {
+				return object.Bytes(), nil
+			}
+		}
+	}
+}
+
+// readUnescapedRune undoes one strconv.QuoteToASCII escape sequence, or returns the plain byte.
+func readUnescapedRune(reader *bufio.Reader) (rune, error) {
+	char, err := reader.ReadByte()
+	if err != nil || char != '\\' {
+		return rune(char), err
+	}
+	kind, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	digits := 0
+	switch kind {
+	case 'a':
+		return '\a', nil
+	case 'b':
+		return '\b', nil
+	case 'f':
+		return '\f', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'v':
+		return '\v', nil
+	case '\\', '"', '\'':
+		return rune(kind), nil
+	case 'x':
+		digits = 2
+	case 'u':
+		digits = 4
+	case 'U':
+		digits = 8
+	default:
+		return 0, ErrMalformedFrame
+	}
+	hex := make([]byte, digits)
+	if _, err := io.ReadFull(reader, hex); err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseUint(string(hex), 16, 32)
+	if err != nil || (digits > 2 && !utf8.ValidRune(rune(value))) {
+		return 0, ErrMalformedFrame
+	}
+	return rune(value), nil
+}
+
+// DecodeMessage turns a reassembled chained message back into its packet, decompressing it first
+// when it carries a "!codec!" marker.
+func DecodeMessage(message []byte) (Packet, error) {
+	var packet Packet
+	if bytes.HasPrefix(message, []byte("!")) {
+		end := bytes.IndexByte(message[1:], '!')
+		if end < 0 {
+			return packet, ErrMalformedFrame
+		}
+		codec := string(message[1 : end+1])
+		compressed, err := base64.StdEncoding.DecodeString(string(message[end+2:]))
+		if err != nil {
+			return packet, err
+		}
+		switch codec {
+		case "zstd":
+			message, err = zstdDecoder.DecodeAll(compressed, nil)
+		case "snappy":
+			message, err = snappy.Decode(nil, compressed)
+		default:
+			err = ErrUnknownCodec
+		}
+		if err != nil {
+			return packet, err
+		}
+	}
+	packetJSON, err := strconv.Unquote("\"" + string(message) + "\"")
+	if err != nil {
+		return packet, fmt.Errorf("unescaping message: %w", err)
+	}
+	err = json.Unmarshal([]byte(packetJSON), &packet)
+	return packet, err
+}
+
+// reassembly collects the fragments of one chained message.
+type reassembly struct {
+	fragments [][]byte
+	received  int
+	crc       uint32
+}
+
+func (message *reassembly) add(fragment *Fragment) bool {
+	if message.fragments == nil {
+		message.fragments = make([][]byte, fragment.Count)
+		message.crc = fragment.CRC
+	}
+	if fragment.Count != len(message.fragments) || message.fragments[fragment.Index-1] != nil {
+		return message.complete()
+	}
+	message.fragments[fragment.Index-1] = fragment.Data
+	message.received++
+	return message.complete()
+}
+func (message *reassembly) complete() bool {
+	return message.received == len(message.fragments)
+}
+func (message *reassembly) missing() []int {
+	var missing []int
+	for index, data := range message.fragments {
+		if data == nil {
+			missing = append(missing, index+1)
+		}
+	}
+	return missing
+}
+func (message *reassembly) bytes() ([]byte, error) {
+	joined := bytes.Join(message.fragments, nil)
+	if crc32.ChecksumIEEE(joined) != message.crc {
+		return nil, ErrChecksum
+	}
+	return joined, nil
+}
+
+// EncodeRequest builds a request line, fields are joined with "?".
+func EncodeRequest(code string, fields ...string) string {
+	return code + strings.Join(fields, "?") + "\n"
+}
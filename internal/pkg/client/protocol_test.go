@@ -0,0 +1,108 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func escaped(value string) string {
+	return strings.Trim(strconv.QuoteToASCII(value), "\"")
+}
+func simpleFrame(packet Packet) string {
+	packetJSON, _ := json.Marshal(packet)
+	return escaped(packet.PacketCode + "?" + string(packetJSON))
+}
+func fragmentFrames(base string, messageID uuid.UUID, message string, size int) []string {
+	var frames []string
+	count := (len(message) + size - 1) / size
+	checksum := crc32.ChecksumIEEE([]byte(message))
+	for index := 0; index < count; index++ {
+		end := (index + 1) * size
+		if end > len(message) {
+			end = len(message)
+		}
+		data := message[index*size : end]
+		frames = append(frames, fmt.Sprintf("%s*#%s?%d?%d?%08x?%d?%s", base, messageID, index+1, count, checksum, len(data), data))
+	}
+	return frames
+}
+
+func TestReadFrameSimplePacket(t *testing.T) {
+	chat := Packet{PacketID: uuid.New(), PacketCode: "CH#", ServiceType: "CHAT", Content: "CHAT$1;héllo \"quoted\" ?#"}
+	// LF# is a plain login failure response and must not be read as a fragment of "L"
+	login := Packet{PacketID: uuid.New(), PacketCode: "LF#", ServiceType: "LOGIN", Content: "Invalid credentials"}
+	reader := bufio.NewReader(strings.NewReader(simpleFrame(chat) + simpleFrame(login)))
+	for _, packet := range []Packet{chat, login} {
+		frame, err := ReadFrame(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.Code != packet.PacketCode || frame.Fragment != nil || frame.Packet != packet {
+			t.Fatalf("unexpected frame %+v", frame)
+		}
+	}
+}
+
+func TestReadFrameReassemblesFragments(t *testing.T) {
+	packet := Packet{PacketID: uuid.New(), PacketCode: "LL#", Chain: true, ServiceType: "LEVEL", Content: "@\"{\"name\":\"Forest ✓\"}\"@"}
+	packetJSON, _ := json.Marshal(packet)
+	frames := fragmentFrames("LL", packet.PacketID, escaped(string(packetJSON)), 16)
+	reader := bufio.NewReader(strings.NewReader(strings.Join(frames, "")))
+	message := &reassembly{}
+	for range frames {
+		frame, err := ReadFrame(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		message.add(frame.Fragment)
+	}
+	if !message.complete() {
+		t.Fatalf("missing fragments %v", message.missing())
+	}
+	data, err := message.bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var level struct{ Name string }
+	if err := decoded.Decode(&level); err != nil || level.Name != "Forest ✓" {
+		t.Errorf("unexpected payload %q (%v)", decoded.Payload(), err)
+	}
+}
+
+func TestReassemblyDetectsMissingAndCorruptFragments(t *testing.T) {
+	messageID := uuid.New()
+	frames := fragmentFrames("BR", messageID, "abcdefghij", 3)
+	message := &reassembly{}
+	for _, index := range []int{0, 2} {
+		frame, _ := ReadFrame(bufio.NewReader(strings.NewReader(frames[index])))
+		message.add(frame.Fragment)
+	}
+	if missing := message.missing(); len(missing) != 2 || missing[0] != 2 || missing[1] != 4 {
+		t.Errorf("unexpected missing fragments %v", missing)
+	}
+	corrupt := strings.Replace(frames[1], "def", "xyz", 1)
+	for _, frame := range []string{corrupt, frames[3]} {
+		parsed, _ := ReadFrame(bufio.NewReader(strings.NewReader(frame)))
+		message.add(parsed.Fragment)
+	}
+	if _, err := message.bytes(); err != ErrChecksum {
+		t.Errorf("expected checksum error, got %v", err)
+	}
+}
+
+func TestEncodeRequest(t *testing.T) {
+	if request := EncodeRequest("HB#", "id", "1.5", "2", "-3"); request != "HB#id?1.5?2?-3\n" {
+		t.Errorf("unexpected request %q", request)
+	}
+}
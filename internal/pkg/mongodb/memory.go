@@ -0,0 +1,814 @@
+package mongodb
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/address"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+// The memory deployment answers the driver's commands from in-process collections, so code written
+// against *mongo.Client runs unchanged in tests. It covers the commands and operators the game server
+// uses: find, insert, update, delete, findAndModify, counting aggregates, createIndexes and transactions. Writes inside a
+// transaction are applied straight away and undone on abort, there is no isolation between sessions.
+
+const MEMORY_ADDRESS = address.Address("memory:27017")
+
+var memoryDescription = description.Server{
+	Addr:                  MEMORY_ADDRESS,
+	CanonicalAddr:         MEMORY_ADDRESS,
+	Kind:                  description.RSPrimary,
+	MaxDocumentSize:       16777216,
+	MaxMessageSize:        48000000,
+	MaxBatchCount:         100000,
+	SessionTimeoutMinutes: 30,
+	WireVersion:           &description.VersionRange{Min: topology.SupportedWireVersions.Min, Max: topology.SupportedWireVersions.Max},
+}
+
+// NewMemoryClient returns a connected client backed by an empty in-memory database.
+func NewMemoryClient(cxt context.Context) (*mongo.Client, error) {
+	clientOptions := options.Client()
+	clientOptions.Deployment = newMemoryDeployment()
+	return mongo.Connect(cxt, clientOptions)
+}
+
+type memoryIndex struct {
+	name   string
+	keys   []string
+	unique bool
+	// a collation strength of 1 or 2 compares strings regardless of case
+	caseInsensitive bool
+}
+
+// every collection has a unique index on _id
+var ID_INDEX = memoryIndex{name: "_id_", keys: []string{"_id"}, unique: true}
+
+type memoryCollection struct {
+	documents []bson.M
+	indexes   []memoryIndex
+}
+
+// memoryTransaction keeps the documents of every collection a transaction touched as they were before it.
+type memoryTransaction struct {
+	before map[*memoryCollection][]bson.M
+}
+type memoryStore struct {
+	mutex        sync.Mutex
+	databases    map[string]map[string]*memoryCollection
+	transactions map[string]*memoryTransaction
+}
+type memoryDeployment struct {
+	store   *memoryStore
+	updates chan description.Topology
+	once    sync.Once
+}
+type memoryConnection struct {
+	id       uint64
+	store    *memoryStore
+	response []byte
+}
+
+var memoryConnectionCounter uint64
+
+var _ driver.Deployment = &memoryDeployment{}
+var _ driver.Server = &memoryDeployment{}
+var _ driver.Connector = &memoryDeployment{}
+var _ driver.Disconnector = &memoryDeployment{}
+var _ driver.Subscriber = &memoryDeployment{}
+var _ driver.Connection = &memoryConnection{}
+
+func newMemoryDeployment() *memoryDeployment {
+	return &memoryDeployment{store: &memoryStore{
+		databases:    make(map[string]map[string]*memoryCollection),
+		transactions: make(map[string]*memoryTransaction),
+	}}
+}
+func (deployment *memoryDeployment) SelectServer(context.Context, description.ServerSelector) (driver.Server, error) {
+	return deployment, nil
+}
+func (deployment *memoryDeployment) Kind() description.TopologyKind {
+	return description.ReplicaSetWithPrimary
+}
+func (deployment *memoryDeployment) Connection(context.Context) (driver.Connection, error) {
+	return &memoryConnection{id: atomic.AddUint64(&memoryConnectionCounter, 1), store: deployment.store}, nil
+}
+func (deployment *memoryDeployment) RTTMonitor() driver.RTTMonitor {
+	return memoryRTTMonitor{}
+}
+func (deployment *memoryDeployment) Connect() error {
+	return nil
+}
+func (deployment *memoryDeployment) Disconnect(context.Context) error {
+	return nil
+}
+
+// Subscribe hands the client the one topology it will ever see, it only reads the session timeout from it.
+func (deployment *memoryDeployment) Subscribe() (*driver.Subscription, error) {
+	deployment.once.Do(func() {
+		deployment.updates = make(chan description.Topology, 1)
+		deployment.updates <- description.Topology{Kind: description.ReplicaSetWithPrimary, SessionTimeoutMinutes: memoryDescription.SessionTimeoutMinutes}
+	})
+	return &driver.Subscription{Updates: deployment.updates}, nil
+}
+func (deployment *memoryDeployment) Unsubscribe(*driver.Subscription) error {
+	return nil
+}
+
+type memoryRTTMonitor struct{}
+
+func (memoryRTTMonitor) EWMA() time.Duration { return 0 }
+func (memoryRTTMonitor) Min() time.Duration  { return 0 }
+func (memoryRTTMonitor) P90() time.Duration  { return 0 }
+func (memoryRTTMonitor) Stats() string       { return "" }
+
+// WriteWireMessage runs the command straight away, ReadWireMessage then returns its reply.
+func (connection *memoryConnection) WriteWireMessage(_ context.Context, message []byte) error {
+	_, requestID, _, opcode, rem, ok := wiremessage.ReadHeader(message)
+	if !ok || opcode != wiremessage.OpMsg {
+		return fmt.Errorf("memory deployment only speaks OP_MSG, got %v", opcode)
+	}
+	command, err := readMsgCommand(rem)
+	if err != nil {
+		return err
+	}
+	reply, err := bson.Marshal(connection.store.run(command))
+	if err != nil {
+		return err
+	}
+	var response []byte
+	index, response := wiremessage.AppendHeaderStart(response, wiremessage.NextRequestID(), requestID, wiremessage.OpMsg)
+	response = wiremessage.AppendMsgFlags(response, 0)
+	response = wiremessage.AppendMsgSectionType(response, wiremessage.SingleDocument)
+	response = append(response, reply...)
+	connection.response = bsoncore.UpdateLength(response, index, int32(len(response[index:])))
+	return nil
+}
+func (connection *memoryConnection) ReadWireMessage(context.Context) ([]byte, error) {
+	if connection.response == nil {
+		return nil, errors.New("memory deployment has no reply waiting")
+	}
+	response := connection.response
+	connection.response = nil
+	return response, nil
+}
+func (connection *memoryConnection) Description() description.Server {
+	return memoryDescription
+}
+func (connection *memoryConnection) Close() error {
+	return nil
+}
+func (connection *memoryConnection) ID() string {
+	return "memory[" + strconv.FormatUint(connection.id, 10) + "]"
+}
+func (connection *memoryConnection) ServerConnectionID() *int64 {
+	id := int64(connection.id)
+	return &id
+}
+func (connection *memoryConnection) DriverConnectionID() uint64 {
+	return connection.id
+}
+func (connection *memoryConnection) Address() address.Address {
+	return MEMORY_ADDRESS
+}
+func (connection *memoryConnection) Stale() bool {
+	return false
+}
+
+// readMsgCommand folds the document sequences of an OP_MSG (insert documents, update statements...)
+// back into the command document as arrays.
+func readMsgCommand(message []byte) (bson.M, error) {
+	_, message, ok := wiremessage.ReadMsgFlags(message)
+	if !ok {
+		return nil, errors.New("malformed OP_MSG flags")
+	}
+	var command bson.D
+	sequences := bson.M{}
+	for len(message) > 0 {
+		var sectionType wiremessage.SectionType
+		sectionType, message, ok = wiremessage.ReadMsgSectionType(message)
+		if !ok {
+			return nil, errors.New("malformed OP_MSG section")
+		}
+		switch sectionType {
+		case wiremessage.SingleDocument:
+			var document bsoncore.Document
+			if document, message, ok = wiremessage.ReadMsgSectionSingleDocument(message); !ok {
+				return nil, errors.New("malformed OP_MSG body")
+			}
+			if err := bson.Unmarshal(document, &command); err != nil {
+				return nil, err
+			}
+		case wiremessage.DocumentSequence:
+			var identifier string
+			var documents []bsoncore.Document
+			if identifier, documents, message, ok = wiremessage.ReadMsgSectionDocumentSequence(message); !ok {
+				return nil, errors.New("malformed OP_MSG document sequence")
+			}
+			sequence := bson.A{}
+			for _, document := range documents {
+				var decoded bson.D
+				if err := bson.Unmarshal(document, &decoded); err != nil {
+					return nil, err
+				}
+				sequence = append(sequence, decoded)
+			}
+			sequences[identifier] = sequence
+		default:
+			return nil, fmt.Errorf("unknown OP_MSG section type %v", sectionType)
+		}
+	}
+	if len(command) == 0 {
+		return nil, errors.New("OP_MSG without a command")
+	}
+	//the command name has to stay recognisable once the document is a map
+	converted := normalize(command).(bson.M)
+	converted["$command"] = command[0].Key
+	for identifier, sequence := range sequences {
+		converted[identifier] = normalize(sequence)
+	}
+	converted["$order"] = command
+	return converted, nil
+}
+
+type commandError struct {
+	code    int32
+	message string
+}
+
+func (err commandError) Error() string {
+	return err.message
+}
+func failed(code int32, format string, arguments ...interface{}) error {
+	return commandError{code: code, message: fmt.Sprintf(format, arguments...)}
+}
+func errorReply(err error) bson.M {
+	var failure commandError
+	if !errors.As(err, &failure) {
+		failure = commandError{code: 1, message: err.Error()}
+	}
+	return bson.M{"ok": 0.0, "errmsg": failure.message, "code": failure.code}
+}
+
+// run executes one command under the store lock and builds its reply.
+func (store *memoryStore) run(command bson.M) bson.M {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	name, _ := command["$command"].(string)
+	database, _ := command["$db"].(string)
+	transaction := store.transaction(command)
+	var reply bson.M
+	var err error
+	switch name {
+	case "ping", "hello", "isMaster", "ismaster", "buildInfo", "endSessions", "killCursors":
+		reply = bson.M{}
+	case "commitTransaction":
+		delete(store.transactions, transactionKey(command))
+		reply = bson.M{}
+	case "abortTransaction":
+		if transaction != nil {
+			for collection, documents := range transaction.before {
+				collection.documents = documents
+			}
+		}
+		delete(store.transactions, transactionKey(command))
+		reply = bson.M{}
+	case "find":
+		reply, err = store.find(database, command)
+	case "insert":
+		reply, err = store.insert(database, command, transaction)
+	case "update":
+		reply, err = store.update(database, command, transaction)
+	case "delete":
+		reply, err = store.delete(database, command, transaction)
+	case "findAndModify":
+		reply, err = store.findAndModify(database, command, transaction)
+	case "aggregate":
+		reply, err = store.aggregate(database, command)
+	case "createIndexes":
+		reply, err = store.createIndexes(database, command)
+	case "dropIndexes":
+		reply, err = store.dropIndexes(database, command)
+	case "drop":
+		delete(store.databases[database], fmt.Sprint(command["drop"]))
+		reply = bson.M{}
+	case "dropDatabase":
+		delete(store.databases, database)
+		reply = bson.M{}
+	default:
+		err = failed(59, "no such command: '%s'", name)
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+	reply["ok"] = 1.0
+	return reply
+}
+func transactionKey(command bson.M) string {
+	session, _ := command["lsid"].(bson.M)
+	id, _ := session["id"].(primitive.Binary)
+	return hex.EncodeToString(id.Data) + ":" + fmt.Sprint(command["txnNumber"])
+}
+
+// transaction returns the transaction a command runs in, commands outside of one carry no autocommit field.
+func (store *memoryStore) transaction(command bson.M) *memoryTransaction {
+	if _, inTransaction := command["autocommit"]; !inTransaction {
+		return nil
+	}
+	key := transactionKey(command)
+	transaction, found := store.transactions[key]
+	if !found {
+		transaction = &memoryTransaction{before: make(map[*memoryCollection][]bson.M)}
+		store.transactions[key] = transaction
+	}
+	return transaction
+}
+func (store *memoryStore) collection(database string, name string) *memoryCollection {
+	collections, found := store.databases[database]
+	if !found {
+		collections = make(map[string]*memoryCollection)
+		store.databases[database] = collections
+	}
+	collection, found := collections[name]
+	if !found {
+		collection = &memoryCollection{}
+		collections[name] = collection
+	}
+	return collection
+}
+
+// write swaps in a new set of documents, remembering the old ones the first time a transaction changes the collection.
+func (collection *memoryCollection) write(documents []bson.M, transaction *memoryTransaction) {
+	if transaction != nil {
+		if _, saved := transaction.before[collection]; !saved {
+			transaction.before[collection] = collection.documents
+		}
+	}
+	collection.documents = documents
+}
+func (collection *memoryCollection) matching(filter bson.M) ([]int, error) {
+	var indexes []int
+	for index, document := range collection.documents {
+		matched, err := matches(document, filter)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes, nil
+}
+
+func (index memoryIndex) keyEqual(left interface{}, right interface{}) bool {
+	leftString, leftIsString := left.(string)
+	rightString, rightIsString := right.(string)
+	if index.caseInsensitive && leftIsString && rightIsString {
+		return strings.EqualFold(leftString, rightString)
+	}
+	return equal(left, right)
+}
+
+// checkUnique rejects a document that repeats the keys of another one under a unique index, skip is its own position.
+func (collection *memoryCollection) checkUnique(documents []bson.M, document bson.M, skip int) error {
+	for _, index := range append([]memoryIndex{ID_INDEX}, collection.indexes...) {
+		if !index.unique {
+			continue
+		}
+		for position, other := range documents {
+			if position == skip {
+				continue
+			}
+			duplicate := true
+			for _, key := range index.keys {
+				if !index.keyEqual(firstValue(document, key), firstValue(other, key)) {
+					duplicate = false
+					break
+				}
+			}
+			if duplicate {
+				return failed(11000, "E11000 duplicate key error collection index: %s", index.name)
+			}
+		}
+	}
+	return nil
+}
+func (store *memoryStore) find(database string, command bson.M) (bson.M, error) {
+	name := fmt.Sprint(command["find"])
+	collection := store.collection(database, name)
+	filter, _ := command["filter"].(bson.M)
+	indexes, err := collection.matching(filter)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]bson.M, 0, len(indexes))
+	for _, index := range indexes {
+		results = append(results, collection.documents[index])
+	}
+	if order := sortOrder(command["$order"].(bson.D), "sort"); len(order) > 0 {
+		sortDocuments(results, order)
+	}
+	if skip := int(toInt(command["skip"])); skip > 0 {
+		if skip > len(results) {
+			skip = len(results)
+		}
+		results = results[skip:]
+	}
+	if limit := int(toInt(command["limit"])); limit > 0 && limit < len(results) {
+		results = results[:limit]
+	}
+	batch := bson.A{}
+	for _, result := range results {
+		batch = append(batch, deepCopy(result))
+	}
+	return bson.M{"cursor": bson.M{"firstBatch": batch, "id": int64(0), "ns": database + "." + name}}, nil
+}
+
+// sortOrder reads a sort specification from the ordered command, maps lose the order of its keys.
+func sortOrder(command bson.D, field string) bson.D {
+	for _, element := range command {
+		if element.Key == field {
+			if order, ok := element.Value.(bson.D); ok {
+				return order
+			}
+		}
+	}
+	return nil
+}
+func sortDocuments(documents []bson.M, order bson.D) {
+	sort.SliceStable(documents, func(i, j int) bool {
+		for _, key := range order {
+			difference := compareOrder(firstValue(documents[i], key.Key), firstValue(documents[j], key.Key))
+			if difference != 0 {
+				return (difference < 0) == (toInt(key.Value) >= 0)
+			}
+		}
+		return false
+	})
+}
+func (store *memoryStore) insert(database string, command bson.M, transaction *memoryTransaction) (bson.M, error) {
+	collection := store.collection(database, fmt.Sprint(command["insert"]))
+	documents, _ := command["documents"].(bson.A)
+	updated := append([]bson.M(nil), collection.documents...)
+	inserted := 0
+	var writeErrors bson.A
+	for index, value := range documents {
+		document, _ := deepCopy(value).(bson.M)
+		if _, found := document["_id"]; !found {
+			document["_id"] = primitive.NewObjectID()
+		}
+		if err := collection.checkUnique(updated, document, -1); err != nil {
+			writeErrors = append(writeErrors, writeError(index, err))
+			break
+		}
+		updated = append(updated, document)
+		inserted++
+	}
+	collection.write(updated, transaction)
+	reply := bson.M{"n": int32(inserted)}
+	if len(writeErrors) > 0 {
+		reply["writeErrors"] = writeErrors
+	}
+	return reply, nil
+}
+func writeError(index int, err error) bson.M {
+	reply := errorReply(err)
+	return bson.M{"index": int32(index), "code": reply["code"], "errmsg": reply["errmsg"]}
+}
+func (store *memoryStore) update(database string, command bson.M, transaction *memoryTransaction) (bson.M, error) {
+	collection := store.collection(database, fmt.Sprint(command["update"]))
+	statements, _ := command["updates"].(bson.A)
+	matched, modified := 0, 0
+	upserted := bson.A{}
+	var writeErrors bson.A
+	for index, value := range statements {
+		statement, _ := value.(bson.M)
+		filter, _ := statement["q"].(bson.M)
+		change, _ := statement["u"].(bson.M)
+		multi, _ := statement["multi"].(bool)
+		upsert, _ := statement["upsert"].(bool)
+		arrayFilters, _ := statement["arrayFilters"].(bson.A)
+		result, err := collection.apply(filter, change, arrayFilters, multi, upsert, transaction)
+		if err != nil {
+			writeErrors = append(writeErrors, writeError(index, err))
+			break
+		}
+		matched += result.matched
+		modified += result.modified
+		if result.upsertedID != nil {
+			upserted = append(upserted, bson.M{"index": int32(index), "_id": result.upsertedID})
+		}
+	}
+	reply := bson.M{"n": int32(matched + len(upserted)), "nModified": int32(modified)}
+	if len(upserted) > 0 {
+		reply["upserted"] = upserted
+	}
+	if len(writeErrors) > 0 {
+		reply["writeErrors"] = writeErrors
+	}
+	return reply, nil
+}
+
+type applyResult struct {
+	matched    int
+	modified   int
+	upsertedID interface{}
+	before     bson.M
+	after      bson.M
+}
+
+// apply updates the first or every matching document, or inserts one built from the filter for an upsert.
+func (collection *memoryCollection) apply(filter bson.M, change bson.M, arrayFilters bson.A, multi bool, upsert bool, transaction *memoryTransaction) (applyResult, error) {
+	var result applyResult
+	indexes, err := collection.matching(filter)
+	if err != nil {
+		return result, err
+	}
+	if !multi && len(indexes) > 1 {
+		indexes = indexes[:1]
+	}
+	updated := append([]bson.M(nil), collection.documents...)
+	if len(indexes) == 0 {
+		if !upsert {
+			return result, nil
+		}
+		document, err := upsertDocument(filter, change, arrayFilters)
+		if err != nil {
+			return result, err
+		}
+		if err := collection.checkUnique(updated, document, -1); err != nil {
+			return result, err
+		}
+		collection.write(append(updated, document), transaction)
+		result.upsertedID = document["_id"]
+		result.after = document
+		return result, nil
+	}
+	for _, index := range indexes {
+		document, err := applyChange(updated[index], change, arrayFilters, false)
+		if err != nil {
+			return result, err
+		}
+		if err := collection.checkUnique(updated, document, index); err != nil {
+			return result, err
+		}
+		result.matched++
+		if !equal(document, updated[index]) {
+			result.modified++
+		}
+		result.before = updated[index]
+		result.after = document
+		updated[index] = document
+	}
+	collection.write(updated, transaction)
+	return result, nil
+}
+
+// upsertDocument seeds a new document with the equality conditions of the filter before applying the change.
+func upsertDocument(filter bson.M, change bson.M, arrayFilters bson.A) (bson.M, error) {
+	seed := bson.M{}
+	for key, value := range filter {
+		if isOperatorDocument(value) || len(key) > 0 && key[0] == '$' {
+			continue
+		}
+		if _, err := modifyPath(seed, splitPath(key), nil, func(interface{}, bool) (interface{}, bool, error) {
+			return deepCopy(value), true, nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	document, err := applyChange(seed, change, arrayFilters, true)
+	if err != nil {
+		return nil, err
+	}
+	if _, found := document["_id"]; !found {
+		document["_id"] = primitive.NewObjectID()
+	}
+	return document, nil
+}
+func (store *memoryStore) delete(database string, command bson.M, transaction *memoryTransaction) (bson.M, error) {
+	collection := store.collection(database, fmt.Sprint(command["delete"]))
+	statements, _ := command["deletes"].(bson.A)
+	deleted := 0
+	for _, value := range statements {
+		statement, _ := value.(bson.M)
+		filter, _ := statement["q"].(bson.M)
+		indexes, err := collection.matching(filter)
+		if err != nil {
+			return nil, err
+		}
+		if toInt(statement["limit"]) == 1 && len(indexes) > 1 {
+			indexes = indexes[:1]
+		}
+		remove := make(map[int]bool, len(indexes))
+		for _, index := range indexes {
+			remove[index] = true
+		}
+		var kept []bson.M
+		for index, document := range collection.documents {
+			if !remove[index] {
+				kept = append(kept, document)
+			}
+		}
+		collection.write(kept, transaction)
+		deleted += len(indexes)
+	}
+	return bson.M{"n": int32(deleted)}, nil
+}
+func (store *memoryStore) findAndModify(database string, command bson.M, transaction *memoryTransaction) (bson.M, error) {
+	collection := store.collection(database, fmt.Sprint(command["findAndModify"]))
+	filter, _ := command["query"].(bson.M)
+	returnNew, _ := command["new"].(bool)
+	upsert, _ := command["upsert"].(bool)
+	arrayFilters, _ := command["arrayFilters"].(bson.A)
+	if remove, _ := command["remove"].(bool); remove {
+		indexes, err := collection.matching(filter)
+		if err != nil || len(indexes) == 0 {
+			return bson.M{"lastErrorObject": bson.M{"n": int32(0)}, "value": nil}, err
+		}
+		removed := collection.documents[indexes[0]]
+		kept := append(append([]bson.M(nil), collection.documents[:indexes[0]]...), collection.documents[indexes[0]+1:]...)
+		collection.write(kept, transaction)
+		return bson.M{"lastErrorObject": bson.M{"n": int32(1)}, "value": deepCopy(removed)}, nil
+	}
+	change, _ := command["update"].(bson.M)
+	if order := sortOrder(command["$order"].(bson.D), "sort"); len(order) > 0 {
+		if indexes, err := collection.matching(filter); err == nil && len(indexes) > 1 {
+			candidates := make([]bson.M, 0, len(indexes))
+			for _, index := range indexes {
+				candidates = append(candidates, collection.documents[index])
+			}
+			sortDocuments(candidates, order)
+			filter = bson.M{"_id": candidates[0]["_id"]}
+		}
+	}
+	result, err := collection.apply(filter, change, arrayFilters, false, upsert, transaction)
+	if err != nil {
+		return nil, err
+	}
+	lastError := bson.M{"n": int32(result.matched), "updatedExisting": result.matched > 0}
+	var value interface{}
+	if result.upsertedID != nil {
+		lastError["n"] = int32(1)
+		lastError["upserted"] = result.upsertedID
+		if returnNew {
+			value = deepCopy(result.after)
+		}
+	} else if result.matched > 0 {
+		value = deepCopy(result.before)
+		if returnNew {
+			value = deepCopy(result.after)
+		}
+	}
+	return bson.M{"lastErrorObject": lastError, "value": value}, nil
+}
+
+// aggregate covers the stages CountDocuments and simple listings use, a $group may only count.
+func (store *memoryStore) aggregate(database string, command bson.M) (bson.M, error) {
+	name := fmt.Sprint(command["aggregate"])
+	results := append([]bson.M(nil), store.collection(database, name).documents...)
+	var pipeline bson.A
+	for _, element := range command["$order"].(bson.D) {
+		if element.Key == "pipeline" {
+			pipeline, _ = element.Value.(bson.A)
+		}
+	}
+	for _, value := range pipeline {
+		stage, _ := value.(bson.D)
+		if len(stage) != 1 {
+			return nil, failed(40323, "a pipeline stage must have exactly one field")
+		}
+		argument := stage[0].Value
+		switch stage[0].Key {
+		case "$match":
+			filter, _ := normalize(argument).(bson.M)
+			var matched []bson.M
+			for _, document := range results {
+				ok, err := matches(document, filter)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					matched = append(matched, document)
+				}
+			}
+			results = matched
+		case "$sort":
+			order, _ := argument.(bson.D)
+			results = append([]bson.M(nil), results...)
+			sortDocuments(results, order)
+		case "$skip":
+			skip := int(toInt(argument))
+			if skip > len(results) {
+				skip = len(results)
+			}
+			results = results[skip:]
+		case "$limit":
+			if limit := int(toInt(argument)); limit < len(results) {
+				results = results[:limit]
+			}
+		case "$group":
+			group, _ := normalize(argument).(bson.M)
+			counted := bson.M{"_id": group["_id"]}
+			for field, accumulator := range group {
+				if field == "_id" {
+					continue
+				}
+				sum, _ := accumulator.(bson.M)["$sum"]
+				if _, constant := toFloat(sum); !constant {
+					return nil, failed(15952, "memory deployment only groups with a constant $sum")
+				}
+				counted[field] = int32(toInt(sum) * int64(len(results)))
+			}
+			//grouping nothing yields no group at all
+			if len(results) > 0 {
+				results = []bson.M{counted}
+			}
+		default:
+			return nil, failed(40324, "memory deployment does not support the stage %s", stage[0].Key)
+		}
+	}
+	batch := bson.A{}
+	for _, result := range results {
+		batch = append(batch, deepCopy(result))
+	}
+	return bson.M{"cursor": bson.M{"firstBatch": batch, "id": int64(0), "ns": database + "." + name}}, nil
+}
+func (store *memoryStore) createIndexes(database string, command bson.M) (bson.M, error) {
+	collection := store.collection(database, fmt.Sprint(command["createIndexes"]))
+	before := len(collection.indexes)
+	var ordered bson.A
+	for _, element := range command["$order"].(bson.D) {
+		if element.Key == "indexes" {
+			ordered, _ = element.Value.(bson.A)
+		}
+	}
+	for _, value := range ordered {
+		specification, _ := value.(bson.D)
+		index := memoryIndex{}
+		for _, element := range specification {
+			switch element.Key {
+			case "name":
+				index.name = fmt.Sprint(element.Value)
+			case "unique":
+				index.unique, _ = element.Value.(bool)
+			case "collation":
+				collation, _ := element.Value.(bson.D)
+				for _, option := range collation {
+					if option.Key == "strength" {
+						strength := toInt(option.Value)
+						index.caseInsensitive = strength == 1 || strength == 2
+					}
+				}
+			case "key":
+				keys, _ := element.Value.(bson.D)
+				for _, key := range keys {
+					index.keys = append(index.keys, key.Key)
+				}
+			}
+		}
+		exists := false
+		for _, existing := range collection.indexes {
+			exists = exists || existing.name == index.name
+		}
+		if exists {
+			continue
+		}
+		if index.unique {
+			for position, document := range collection.documents {
+				if err := (&memoryCollection{indexes: []memoryIndex{index}}).checkUnique(collection.documents, document, position); err != nil {
+					return nil, err
+				}
+			}
+		}
+		collection.indexes = append(collection.indexes, index)
+	}
+	return bson.M{"numIndexesBefore": int32(before), "numIndexesAfter": int32(len(collection.indexes))}, nil
+}
+func (store *memoryStore) dropIndexes(database string, command bson.M) (bson.M, error) {
+	collection := store.collection(database, fmt.Sprint(command["dropIndexes"]))
+	name := fmt.Sprint(command["index"])
+	for position, index := range collection.indexes {
+		if index.name == name {
+			collection.indexes = append(collection.indexes[:position], collection.indexes[position+1:]...)
+			return bson.M{"nIndexesWas": int32(len(collection.indexes) + 2)}, nil
+		}
+	}
+	return nil, failed(27, "index not found with name [%s]", name)
+}
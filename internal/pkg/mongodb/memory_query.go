@@ -0,0 +1,644 @@
+package mongodb
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// normalize turns every document into a map so the matcher only has to deal with bson.M and bson.A.
+func normalize(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case bson.D:
+		document := make(bson.M, len(typed))
+		for _, element := range typed {
+			document[element.Key] = normalize(element.Value)
+		}
+		return document
+	case bson.M:
+		document := make(bson.M, len(typed))
+		for key, element := range typed {
+			document[key] = normalize(element)
+		}
+		return document
+	case bson.A:
+		array := make(bson.A, len(typed))
+		for index, element := range typed {
+			array[index] = normalize(element)
+		}
+		return array
+	}
+	return value
+}
+func deepCopy(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case bson.M:
+		document := make(bson.M, len(typed))
+		for key, element := range typed {
+			document[key] = deepCopy(element)
+		}
+		return document
+	case bson.A:
+		array := make(bson.A, len(typed))
+		for index, element := range typed {
+			array[index] = deepCopy(element)
+		}
+		return array
+	case primitive.Binary:
+		return primitive.Binary{Subtype: typed.Subtype, Data: append([]byte(nil), typed.Data...)}
+	}
+	return value
+}
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+func isOperatorDocument(value interface{}) bool {
+	document, ok := value.(bson.M)
+	if !ok || len(document) == 0 {
+		return false
+	}
+	for key := range document {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+func toInt(value interface{}) int64 {
+	switch typed := value.(type) {
+	case int32:
+		return int64(typed)
+	case int64:
+		return typed
+	case int:
+		return int64(typed)
+	case float64:
+		return int64(typed)
+	}
+	return 0
+}
+func toFloat(value interface{}) (float64, bool) {
+	switch typed := value.(type) {
+	case int32:
+		return float64(typed), true
+	case int64:
+		return float64(typed), true
+	case int:
+		return float64(typed), true
+	case float64:
+		return typed, true
+	}
+	return 0, false
+}
+
+// lookup resolves a dotted path, stepping into every document of an array on the way like the server does.
+func lookup(document bson.M, path string) []interface{} {
+	values := []interface{}{document}
+	for _, part := range splitPath(path) {
+		var next []interface{}
+		for _, value := range values {
+			switch typed := value.(type) {
+			case bson.M:
+				if child, found := typed[part]; found {
+					next = append(next, child)
+				}
+			case bson.A:
+				if index, err := strconv.Atoi(part); err == nil {
+					if index >= 0 && index < len(typed) {
+						next = append(next, typed[index])
+					}
+					continue
+				}
+				for _, element := range typed {
+					if child, ok := element.(bson.M); ok {
+						if grandchild, found := child[part]; found {
+							next = append(next, grandchild)
+						}
+					}
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+func firstValue(document bson.M, path string) interface{} {
+	if values := lookup(document, path); len(values) > 0 {
+		return values[0]
+	}
+	return nil
+}
+
+// expand adds the elements of array values, a condition on an array field matches the array or any element.
+func expand(values []interface{}) []interface{} {
+	expanded := append([]interface{}(nil), values...)
+	for _, value := range values {
+		if array, ok := value.(bson.A); ok {
+			expanded = append(expanded, array...)
+		}
+	}
+	return expanded
+}
+func matches(document bson.M, filter bson.M) (bool, error) {
+	for key, condition := range filter {
+		switch key {
+		case "$or", "$and", "$nor":
+			clauses, ok := condition.(bson.A)
+			if !ok {
+				return false, failed(2, "%s must be an array", key)
+			}
+			matchedAny, matchedAll := false, true
+			for _, clause := range clauses {
+				clauseFilter, _ := clause.(bson.M)
+				matched, err := matches(document, clauseFilter)
+				if err != nil {
+					return false, err
+				}
+				matchedAny = matchedAny || matched
+				matchedAll = matchedAll && matched
+			}
+			if key == "$or" && !matchedAny || key == "$and" && !matchedAll || key == "$nor" && matchedAny {
+				return false, nil
+			}
+			continue
+		}
+		matched, err := matchCondition(lookup(document, key), condition)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+func matchCondition(values []interface{}, condition interface{}) (bool, error) {
+	if !isOperatorDocument(condition) {
+		if condition == nil && len(values) == 0 {
+			return true, nil
+		}
+		return containsEqual(expand(values), condition), nil
+	}
+	for operator, argument := range condition.(bson.M) {
+		var matched bool
+		switch operator {
+		case "$eq":
+			matched = containsEqual(expand(values), argument)
+		case "$ne":
+			matched = !containsEqual(expand(values), argument)
+		case "$gt", "$gte", "$lt", "$lte":
+			for _, value := range expand(values) {
+				difference, comparable := compare(value, argument)
+				if comparable && (operator == "$gt" && difference > 0 || operator == "$gte" && difference >= 0 ||
+					operator == "$lt" && difference < 0 || operator == "$lte" && difference <= 0) {
+					matched = true
+					break
+				}
+			}
+		case "$in", "$nin":
+			candidates, ok := argument.(bson.A)
+			if !ok {
+				return false, failed(2, "%s needs an array", operator)
+			}
+			expanded := expand(values)
+			for _, candidate := range candidates {
+				if containsEqual(expanded, candidate) || candidate == nil && len(values) == 0 {
+					matched = true
+					break
+				}
+			}
+			if operator == "$nin" {
+				matched = !matched
+			}
+		case "$exists":
+			want, _ := argument.(bool)
+			matched = (len(values) > 0) == want
+		case "$size":
+			for _, value := range values {
+				if array, ok := value.(bson.A); ok && int64(len(array)) == toInt(argument) {
+					matched = true
+				}
+			}
+		case "$elemMatch":
+			elementFilter, _ := argument.(bson.M)
+			for _, value := range values {
+				array, _ := value.(bson.A)
+				for _, element := range array {
+					var err error
+					if isOperatorDocument(elementFilter) {
+						matched, err = matchCondition([]interface{}{element}, elementFilter)
+					} else if child, ok := element.(bson.M); ok {
+						matched, err = matches(child, elementFilter)
+					}
+					if err != nil {
+						return false, err
+					}
+					if matched {
+						break
+					}
+				}
+				if matched {
+					break
+				}
+			}
+		case "$not":
+			inner, err := matchCondition(values, argument)
+			if err != nil {
+				return false, err
+			}
+			matched = !inner
+		default:
+			return false, failed(2, "unknown operator: %s", operator)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+func containsEqual(values []interface{}, target interface{}) bool {
+	for _, value := range values {
+		if equal(value, target) {
+			return true
+		}
+	}
+	return false
+}
+func equal(left interface{}, right interface{}) bool {
+	if leftNumber, ok := toFloat(left); ok {
+		rightNumber, ok := toFloat(right)
+		return ok && leftNumber == rightNumber
+	}
+	switch typed := left.(type) {
+	case bson.M:
+		other, ok := right.(bson.M)
+		if !ok || len(typed) != len(other) {
+			return false
+		}
+		for key, value := range typed {
+			if otherValue, found := other[key]; !found || !equal(value, otherValue) {
+				return false
+			}
+		}
+		return true
+	case bson.A:
+		other, ok := right.(bson.A)
+		if !ok || len(typed) != len(other) {
+			return false
+		}
+		for index := range typed {
+			if !equal(typed[index], other[index]) {
+				return false
+			}
+		}
+		return true
+	case primitive.Binary:
+		other, ok := right.(primitive.Binary)
+		return ok && typed.Subtype == other.Subtype && bytes.Equal(typed.Data, other.Data)
+	case primitive.Null:
+		return right == nil || right == primitive.Null{}
+	case nil:
+		return right == nil || right == primitive.Null{}
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// compare orders two values of the same kind, the range operators never match across kinds.
+func compare(left interface{}, right interface{}) (int, bool) {
+	if leftNumber, ok := toFloat(left); ok {
+		rightNumber, ok := toFloat(right)
+		if !ok {
+			return 0, false
+		}
+		return sign(leftNumber - rightNumber), true
+	}
+	switch typed := left.(type) {
+	case string:
+		if other, ok := right.(string); ok {
+			return strings.Compare(typed, other), true
+		}
+	case primitive.DateTime:
+		if other, ok := right.(primitive.DateTime); ok {
+			return sign(float64(typed) - float64(other)), true
+		}
+	case bool:
+		if other, ok := right.(bool); ok {
+			if typed == other {
+				return 0, true
+			}
+			if other {
+				return -1, true
+			}
+			return 1, true
+		}
+	case primitive.ObjectID:
+		if other, ok := right.(primitive.ObjectID); ok {
+			return bytes.Compare(typed[:], other[:]), true
+		}
+	case primitive.Binary:
+		if other, ok := right.(primitive.Binary); ok {
+			return bytes.Compare(typed.Data, other.Data), true
+		}
+	}
+	if equal(left, right) {
+		return 0, true
+	}
+	return 0, false
+}
+func sign(difference float64) int {
+	if difference < 0 {
+		return -1
+	}
+	if difference > 0 {
+		return 1
+	}
+	return 0
+}
+
+// compareOrder sorts values of different kinds the way the server does: null, numbers, strings, documents, arrays, ...
+func compareOrder(left interface{}, right interface{}) int {
+	leftRank, rightRank := typeRank(left), typeRank(right)
+	if leftRank != rightRank {
+		return leftRank - rightRank
+	}
+	difference, _ := compare(left, right)
+	return difference
+}
+func typeRank(value interface{}) int {
+	if _, ok := toFloat(value); ok {
+		return 2
+	}
+	switch value.(type) {
+	case nil, primitive.Null:
+		return 1
+	case string:
+		return 3
+	case bson.M:
+		return 4
+	case bson.A:
+		return 5
+	case primitive.Binary:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case primitive.DateTime:
+		return 9
+	}
+	return 10
+}
+
+// applyChange returns a changed copy of the document, either replaced or updated through its operators.
+func applyChange(document bson.M, change bson.M, arrayFilters bson.A, inserting bool) (bson.M, error) {
+	updated := deepCopy(document).(bson.M)
+	if len(change) > 0 && !isOperatorDocument(change) {
+		replacement := deepCopy(change).(bson.M)
+		if id, found := updated["_id"]; found {
+			replacement["_id"] = id
+		}
+		return replacement, nil
+	}
+	filters, err := parseArrayFilters(arrayFilters)
+	if err != nil {
+		return nil, err
+	}
+	for operator, argument := range change {
+		fields, ok := argument.(bson.M)
+		if !ok {
+			return nil, failed(9, "modifier %s needs a document", operator)
+		}
+		if operator == "$setOnInsert" && !inserting {
+			continue
+		}
+		for path, value := range fields {
+			if _, err := modifyPath(updated, splitPath(path), filters, updateOperator(operator, value)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return updated, nil
+}
+
+// updateOperator builds the change one update operator makes to the value at the end of its path.
+func updateOperator(operator string, argument interface{}) func(interface{}, bool) (interface{}, bool, error) {
+	return func(current interface{}, exists bool) (interface{}, bool, error) {
+		switch operator {
+		case "$set", "$setOnInsert":
+			return deepCopy(argument), true, nil
+		case "$unset":
+			return nil, false, nil
+		case "$inc":
+			return increment(current, argument)
+		case "$push", "$addToSet":
+			array, ok := current.(bson.A)
+			if exists && !ok {
+				return nil, false, failed(2, "%s needs an array field", operator)
+			}
+			array = append(bson.A(nil), array...)
+			values := bson.A{argument}
+			if modifiers, ok := argument.(bson.M); ok {
+				if each, found := modifiers["$each"]; found {
+					values, _ = each.(bson.A)
+				}
+			}
+			for _, value := range values {
+				if operator == "$push" || !containsEqual(array, value) {
+					array = append(array, deepCopy(value))
+				}
+			}
+			return array, true, nil
+		case "$pull":
+			array, ok := current.(bson.A)
+			if !exists {
+				return nil, false, nil
+			}
+			if !ok {
+				return nil, false, failed(2, "$pull needs an array field")
+			}
+			kept := bson.A{}
+			for _, element := range array {
+				var remove bool
+				var err error
+				if isOperatorDocument(argument) {
+					remove, err = matchCondition([]interface{}{element}, argument)
+				} else if condition, isDocument := argument.(bson.M); isDocument {
+					if child, ok := element.(bson.M); ok {
+						remove, err = matches(child, condition)
+					}
+				} else {
+					remove = equal(element, argument)
+				}
+				if err != nil {
+					return nil, false, err
+				}
+				if !remove {
+					kept = append(kept, element)
+				}
+			}
+			return kept, true, nil
+		case "$min", "$max":
+			if !exists {
+				return deepCopy(argument), true, nil
+			}
+			difference := compareOrder(argument, current)
+			if operator == "$min" && difference < 0 || operator == "$max" && difference > 0 {
+				return deepCopy(argument), true, nil
+			}
+			return current, true, nil
+		}
+		return nil, false, failed(9, "unknown modifier: %s", operator)
+	}
+}
+func increment(current interface{}, argument interface{}) (interface{}, bool, error) {
+	if current == nil {
+		current = int32(0)
+	}
+	if _, ok := toFloat(current); !ok {
+		return nil, false, failed(14, "cannot apply $inc to a non-numeric value")
+	}
+	if _, ok := toFloat(argument); !ok {
+		return nil, false, failed(14, "cannot $inc with a non-numeric argument")
+	}
+	_, leftDouble := current.(float64)
+	_, rightDouble := argument.(float64)
+	if leftDouble || rightDouble {
+		left, _ := toFloat(current)
+		right, _ := toFloat(argument)
+		return left + right, true, nil
+	}
+	_, leftInt32 := current.(int32)
+	_, rightInt32 := argument.(int32)
+	sum := toInt(current) + toInt(argument)
+	if leftInt32 && rightInt32 && sum == int64(int32(sum)) {
+		return int32(sum), true, nil
+	}
+	return sum, true, nil
+}
+
+// parseArrayFilters groups the conditions of each $[identifier] by identifier, without the identifier prefix.
+func parseArrayFilters(arrayFilters bson.A) (map[string]bson.M, error) {
+	filters := make(map[string]bson.M)
+	for _, value := range arrayFilters {
+		filter, ok := value.(bson.M)
+		if !ok {
+			return nil, failed(9, "array filters must be documents")
+		}
+		for key, condition := range filter {
+			identifier, path, _ := strings.Cut(key, ".")
+			if filters[identifier] == nil {
+				filters[identifier] = bson.M{}
+			}
+			filters[identifier][path] = condition
+		}
+	}
+	return filters, nil
+}
+func matchesArrayFilter(element interface{}, filter bson.M) (bool, error) {
+	for path, condition := range filter {
+		if path == "" {
+			matched, err := matchCondition([]interface{}{element}, condition)
+			if err != nil || !matched {
+				return false, err
+			}
+			continue
+		}
+		child, ok := element.(bson.M)
+		if !ok {
+			return false, nil
+		}
+		matched, err := matches(child, bson.M{path: condition})
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// modifyPath walks a path through documents and arrays, creating missing documents on the way, and
+// replaces the value at its end with what change returns. Arrays are copied, so the new value is returned.
+func modifyPath(value interface{}, parts []string, filters map[string]bson.M, change func(interface{}, bool) (interface{}, bool, error)) (interface{}, error) {
+	part := parts[0]
+	switch container := value.(type) {
+	case bson.M:
+		current, exists := container[part]
+		if len(parts) == 1 {
+			updated, keep, err := change(current, exists)
+			if err != nil {
+				return nil, err
+			}
+			if keep {
+				container[part] = updated
+			} else {
+				delete(container, part)
+			}
+			return container, nil
+		}
+		if !exists || current == nil {
+			current = bson.M{}
+		}
+		updated, err := modifyPath(current, parts[1:], filters, change)
+		if err != nil {
+			return nil, err
+		}
+		container[part] = updated
+		return container, nil
+	case bson.A:
+		array := append(bson.A(nil), container...)
+		var positions []int
+		switch {
+		case part == "$[]":
+			for index := range array {
+				positions = append(positions, index)
+			}
+		case strings.HasPrefix(part, "$[") && strings.HasSuffix(part, "]"):
+			filter, found := filters[part[2:len(part)-1]]
+			if !found {
+				return nil, failed(2, "no array filter found for identifier %s", part)
+			}
+			for index, element := range array {
+				matched, err := matchesArrayFilter(element, filter)
+				if err != nil {
+					return nil, err
+				}
+				if matched {
+					positions = append(positions, index)
+				}
+			}
+		default:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 {
+				return nil, failed(2, "cannot use the part %s to traverse an array", part)
+			}
+			for len(array) <= index {
+				array = append(array, nil)
+			}
+			positions = []int{index}
+		}
+		for _, index := range positions {
+			if len(parts) == 1 {
+				updated, keep, err := change(array[index], true)
+				if err != nil {
+					return nil, err
+				}
+				if !keep {
+					updated = nil
+				}
+				array[index] = updated
+				continue
+			}
+			current := array[index]
+			if current == nil {
+				current = bson.M{}
+			}
+			updated, err := modifyPath(current, parts[1:], filters, change)
+			if err != nil {
+				return nil, err
+			}
+			array[index] = updated
+		}
+		return array, nil
+	}
+	return nil, failed(28, "cannot create field '%s' in a non-document value", part)
+}
@@ -0,0 +1,171 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type testProfile struct {
+	Account_id uuid.UUID `bson:"uuid"`
+	Name       string    `bson:"name"`
+	Bits       float64   `bson:"bits"`
+	Items      []string  `bson:"items"`
+	Quests     []bson.M  `bson:"quests"`
+	Seen       time.Time `bson:"seen"`
+}
+
+func newTestClient(t *testing.T) *mongo.Client {
+	cxt, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	mongoClient, err := NewMemoryClient(cxt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mongoClient.Ping(cxt, readpref.Primary()); err != nil {
+		t.Fatal(err)
+	}
+	return mongoClient
+}
+
+func TestMemoryClientFindAndUpdate(t *testing.T) {
+	cxt := context.Background()
+	profiles := newTestClient(t).Database("player").Collection("profiles")
+	accountID, otherID := uuid.New(), uuid.New()
+	seen := time.Now().UTC().Truncate(time.Millisecond)
+	profiles.InsertOne(cxt, testProfile{Account_id: accountID, Name: "ana", Bits: 10, Items: []string{"Fang"}, Seen: seen})
+	profiles.InsertOne(cxt, testProfile{Account_id: otherID, Name: "bo", Bits: 3})
+
+	var profile testProfile
+	if err := profiles.FindOne(cxt, bson.M{"uuid": accountID}).Decode(&profile); err != nil || profile.Name != "ana" || !profile.Seen.Equal(seen) {
+		t.Fatalf("unexpected profile %+v (%v)", profile, err)
+	}
+	change := bson.M{"$inc": bson.M{"bits": -4.0}, "$push": bson.M{"items": bson.M{"$each": []string{"Herb", "Herb"}}}}
+	if response, err := profiles.UpdateOne(cxt, bson.M{"uuid": accountID, "bits": bson.M{"$gte": 4}}, change); err != nil || response.ModifiedCount != 1 {
+		t.Fatalf("guarded update did not apply : %+v %v", response, err)
+	}
+	if response, _ := profiles.UpdateOne(cxt, bson.M{"uuid": otherID, "bits": bson.M{"$gte": 4}}, change); response.MatchedCount != 0 {
+		t.Errorf("guarded update matched a profile that could not afford it")
+	}
+	profiles.UpdateOne(cxt, bson.M{"uuid": accountID}, bson.M{"$pull": bson.M{"items": "Fang"}, "$addToSet": bson.M{"items": "Herb"}})
+	profiles.FindOne(cxt, bson.M{"uuid": accountID}).Decode(&profile)
+	if profile.Bits != 6 || len(profile.Items) != 2 || profile.Items[0] != "Herb" {
+		t.Errorf("unexpected profile after updates %+v", profile)
+	}
+	// an array condition matches the whole array, an element or an element document
+	if count, _ := profiles.CountDocuments(cxt, bson.M{"items": []string{"Herb", "Herb"}}); count != 1 {
+		t.Errorf("whole array equality did not match")
+	}
+
+	cursor, err := profiles.Find(cxt, bson.M{"uuid": bson.M{"$in": []uuid.UUID{accountID, otherID}}}, options.Find().SetSort(bson.M{"bits": 1}))
+	var found []testProfile
+	if err != nil || cursor.All(cxt, &found) != nil || len(found) != 2 || found[0].Name != "bo" {
+		t.Errorf("unexpected sorted find %+v (%v)", found, err)
+	}
+}
+
+func TestMemoryClientArraysOfDocuments(t *testing.T) {
+	cxt := context.Background()
+	profiles := newTestClient(t).Database("player").Collection("profiles")
+	accountID := uuid.New()
+	profiles.InsertOne(cxt, testProfile{Account_id: accountID, Quests: []bson.M{{"quest_id": "q1", "progress": 0}, {"quest_id": "q2", "progress": 0}}})
+
+	match := bson.M{"uuid": accountID, "quests": bson.M{"$elemMatch": bson.M{"quest_id": "q2", "progress": 0}}}
+	filters := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"quest.quest_id": "q2"}}})
+	if response, err := profiles.UpdateOne(cxt, match, bson.M{"$inc": bson.M{"quests.$[quest].progress": 2}}, filters); err != nil || response.ModifiedCount != 1 {
+		t.Fatalf("array filter update did not apply : %+v %v", response, err)
+	}
+	var profile testProfile
+	profiles.FindOne(cxt, bson.M{"quests.quest_id": "q2"}).Decode(&profile)
+	if profile.Quests[0]["progress"] != int32(0) || profile.Quests[1]["progress"] != int32(2) {
+		t.Errorf("unexpected quests %+v", profile.Quests)
+	}
+}
+
+func TestMemoryClientUpsertAndUniqueIndex(t *testing.T) {
+	cxt := context.Background()
+	users := newTestClient(t).Database("player").Collection("users")
+	index := mongo.IndexModel{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)}
+	if _, err := users.Indexes().CreateOne(cxt, index); err != nil {
+		t.Fatal(err)
+	}
+	upsert := options.Update().SetUpsert(true)
+	if response, err := users.UpdateOne(cxt, bson.M{"name": "ana"}, bson.M{"$set": bson.M{"bits": 1}}, upsert); err != nil || response.UpsertedCount != 1 {
+		t.Fatalf("upsert did not insert : %+v %v", response, err)
+	}
+	if _, err := users.InsertOne(cxt, bson.M{"name": "ana"}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("expected a duplicate key error, got %v", err)
+	}
+	var user bson.M
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := users.FindOneAndUpdate(cxt, bson.M{"name": "ana"}, bson.M{"$inc": bson.M{"bits": 2}}, after).Decode(&user); err != nil || user["bits"] != int32(3) {
+		t.Errorf("unexpected document after find and modify %+v (%v)", user, err)
+	}
+	if response, err := users.DeleteMany(cxt, bson.M{"name": bson.M{"$exists": true}}); err != nil || response.DeletedCount != 1 {
+		t.Errorf("unexpected delete %+v (%v)", response, err)
+	}
+}
+
+func TestMemoryClientCaseInsensitiveIndex(t *testing.T) {
+	cxt := context.Background()
+	users := newTestClient(t).Database("player").Collection("users")
+	collation := &options.Collation{Locale: "en", Strength: 2}
+	index := mongo.IndexModel{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true).SetCollation(collation).SetName("name_ci")}
+	if _, err := users.Indexes().CreateOne(cxt, index); err != nil {
+		t.Fatal(err)
+	}
+	users.InsertOne(cxt, bson.M{"name": "Ana"})
+	if _, err := users.InsertOne(cxt, bson.M{"name": "ana"}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("expected names differing in case to collide, got %v", err)
+	}
+	if _, err := users.Indexes().DropOne(cxt, "name_ci"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.InsertOne(cxt, bson.M{"name": "ana"}); err != nil {
+		t.Errorf("the dropped index still applied : %v", err)
+	}
+	if _, err := users.Indexes().DropOne(cxt, "name_ci"); err == nil {
+		t.Errorf("dropping a missing index succeeded")
+	}
+}
+
+func TestMemoryClientTransactionAbortRollsBack(t *testing.T) {
+	cxt := context.Background()
+	mongoClient := newTestClient(t)
+	profiles := mongoClient.Database("player").Collection("profiles")
+	accountID := uuid.New()
+	profiles.InsertOne(cxt, testProfile{Account_id: accountID, Bits: 10})
+
+	session, err := mongoClient.StartSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.EndSession(cxt)
+	failure := errors.New("second write failed")
+	_, err = session.WithTransaction(cxt, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		profiles.UpdateOne(sessionContext, bson.M{"uuid": accountID}, bson.M{"$inc": bson.M{"bits": -10}})
+		return nil, failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the callback error, got %v", err)
+	}
+	var profile testProfile
+	profiles.FindOne(cxt, bson.M{"uuid": accountID}).Decode(&profile)
+	if profile.Bits != 10 {
+		t.Errorf("aborted transaction left its write behind : %+v", profile)
+	}
+	session.WithTransaction(cxt, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		return profiles.UpdateOne(sessionContext, bson.M{"uuid": accountID}, bson.M{"$inc": bson.M{"bits": -10}})
+	})
+	profiles.FindOne(cxt, bson.M{"uuid": accountID}).Decode(&profile)
+	if profile.Bits != 0 {
+		t.Errorf("committed transaction was not applied : %+v", profile)
+	}
+}